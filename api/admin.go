@@ -2,14 +2,18 @@ package api
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"github.com/bmizerany/pat"
 	"github.com/ngerakines/preview/common"
 	"github.com/ngerakines/preview/config"
 	"github.com/ngerakines/preview/render"
 	"github.com/rcrowley/go-metrics"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type adminBlueprint struct {
@@ -19,6 +23,8 @@ type adminBlueprint struct {
 	placeholderManager   common.PlaceholderManager
 	temporaryFileManager common.TemporaryFileManager
 	agentManager         *render.RenderAgentManager
+	templateManager      common.TemplateManager
+	webhookManager       *render.WebhookManager
 }
 
 type placeholdersView struct {
@@ -39,6 +45,45 @@ type renderAgentsView struct {
 	RenderAgents map[string]renderAgentViewElement `json:"renderAgents"`
 }
 
+type reaperView struct {
+	Enabled      bool                  `json:"enabled"`
+	LeaseTimeout int                   `json:"leaseTimeout"`
+	MaxAttempts  int                   `json:"maxAttempts"`
+	Actions      []render.ReaperAction `json:"actions"`
+}
+
+type webhooksView struct {
+	Enabled    bool                     `json:"enabled"`
+	Deliveries []render.WebhookDelivery `json:"deliveries"`
+}
+
+type templateView struct {
+	Id         string              `json:"id"`
+	Renderer   string              `json:"renderer"`
+	Group      string              `json:"group"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+type templatesView struct {
+	Templates []templateView `json:"templates"`
+}
+
+type routeView struct {
+	FileTypes []string `json:"fileTypes"`
+	MimeTypes []string `json:"mimeTypes"`
+	Renderer  string   `json:"renderer"`
+	Templates []string `json:"templates"`
+	MaxSize   int64    `json:"maxSize"`
+}
+
+type routingView struct {
+	Routes []routeView `json:"routes"`
+}
+
+type generatedAssetsView struct {
+	GeneratedAssets []string `json:"generatedAssets"`
+}
+
 type errorViewError struct {
 	Code        string `json:"code"`
 	Description string `json:"description"`
@@ -49,7 +94,7 @@ type errorsView struct {
 }
 
 // NewAdminBlueprint creates a new adminBlueprint object.
-func NewAdminBlueprint(registry metrics.Registry, appConfig config.AppConfig, placeholderManager common.PlaceholderManager, temporaryFileManager common.TemporaryFileManager, agentManager *render.RenderAgentManager) *adminBlueprint {
+func NewAdminBlueprint(registry metrics.Registry, appConfig config.AppConfig, placeholderManager common.PlaceholderManager, temporaryFileManager common.TemporaryFileManager, agentManager *render.RenderAgentManager, templateManager common.TemplateManager, webhookManager *render.WebhookManager) *adminBlueprint {
 	blueprint := new(adminBlueprint)
 	blueprint.base = "/admin"
 	blueprint.registry = registry
@@ -57,16 +102,47 @@ func NewAdminBlueprint(registry metrics.Registry, appConfig config.AppConfig, pl
 	blueprint.placeholderManager = placeholderManager
 	blueprint.temporaryFileManager = temporaryFileManager
 	blueprint.agentManager = agentManager
+	blueprint.templateManager = templateManager
+	blueprint.webhookManager = webhookManager
 	return blueprint
 }
 
 func (blueprint *adminBlueprint) AddRoutes(p *pat.PatternServeMux) {
-	p.Get(blueprint.base+"/config", http.HandlerFunc(blueprint.configHandler))
+	p.Get(blueprint.base+"/config", blueprint.authorized(blueprint.configHandler))
 	p.Get(blueprint.base+"/placeholders", http.HandlerFunc(blueprint.placeholdersHandler))
 	p.Get(blueprint.base+"/temporaryFiles", http.HandlerFunc(blueprint.temporaryFilesHandler))
 	p.Get(blueprint.base+"/errors", http.HandlerFunc(blueprint.errorsHandler))
 	p.Get(blueprint.base+"/renderAgents", http.HandlerFunc(blueprint.renderAgentsHandler))
 	p.Get(blueprint.base+"/metrics", http.HandlerFunc(blueprint.metricsHandler))
+	p.Get(blueprint.base+"/reaper", blueprint.authorized(blueprint.reaperHandler))
+	p.Get(blueprint.base+"/routing", blueprint.authorized(blueprint.routingHandler))
+	p.Get(blueprint.base+"/webhooks", blueprint.authorized(blueprint.webhooksHandler))
+	p.Post(blueprint.base+"/generatedAssets/requeue", blueprint.authorized(blueprint.requeueHandler))
+	p.Post(blueprint.base+"/generatedAssets/cancel", blueprint.authorized(blueprint.cancelHandler))
+	p.Del(blueprint.base+"/sourceAssets/:id", blueprint.authorized(blueprint.purgeHandler))
+	p.Get(blueprint.base+"/templates", blueprint.authorized(blueprint.templatesHandler))
+	p.Get(blueprint.base+"/templates/:id", blueprint.authorized(blueprint.templateHandler))
+	p.Put(blueprint.base+"/templates/:id", blueprint.authorized(blueprint.storeTemplateHandler))
+	p.Del(blueprint.base+"/templates/:id", blueprint.authorized(blueprint.deleteTemplateHandler))
+}
+
+// authorized wraps the handlers of admin resources that change state or expose configuration. Requests must include the configured token in a bearer "Authorization" header. When no token is configured, the resources are disabled.
+func (blueprint *adminBlueprint) authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		token := blueprint.appConfig.AdminApi().Token()
+		if len(token) == 0 {
+			res.Header().Set("Content-Length", "0")
+			res.WriteHeader(403)
+			return
+		}
+		authorization := req.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(token)) != 1 {
+			res.Header().Set("Content-Length", "0")
+			res.WriteHeader(401)
+			return
+		}
+		handler(res, req)
+	}
 }
 
 func (blueprint *adminBlueprint) configHandler(res http.ResponseWriter, req *http.Request) {
@@ -124,6 +200,247 @@ func (blueprint *adminBlueprint) newRenderAgentViewElement(name string) renderAg
 	return renderAgentViewElement{count, enabled, activeWork}
 }
 
+func (blueprint *adminBlueprint) reaperHandler(res http.ResponseWriter, req *http.Request) {
+	reaperConfig := blueprint.appConfig.Reaper()
+	view := reaperView{reaperConfig.Enabled(), reaperConfig.LeaseTimeout(), reaperConfig.MaxAttempts(), blueprint.agentManager.ReaperActions()}
+
+	body, err := json.Marshal(view)
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *adminBlueprint) routingHandler(res http.ResponseWriter, req *http.Request) {
+	view := new(routingView)
+	view.Routes = make([]routeView, 0, 0)
+	for _, route := range blueprint.agentManager.Routes() {
+		view.Routes = append(view.Routes, routeView{route.FileTypes, route.MimeTypes, route.Renderer, route.TemplateIds, route.MaxSize})
+	}
+
+	body, err := json.Marshal(view)
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *adminBlueprint) webhooksHandler(res http.ResponseWriter, req *http.Request) {
+	view := webhooksView{false, []render.WebhookDelivery{}}
+	if blueprint.webhookManager != nil {
+		view.Enabled = true
+		view.Deliveries = blueprint.webhookManager.Deliveries()
+	}
+
+	body, err := json.Marshal(view)
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *adminBlueprint) requeueHandler(res http.ResponseWriter, req *http.Request) {
+	filter, err := newGeneratedAssetFilter(req)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(400)
+		return
+	}
+	ids, err := blueprint.agentManager.Requeue(filter)
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+	blueprint.writeGeneratedAssets(res, ids)
+}
+
+func (blueprint *adminBlueprint) cancelHandler(res http.ResponseWriter, req *http.Request) {
+	filter, err := newGeneratedAssetFilter(req)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(400)
+		return
+	}
+	ids, err := blueprint.agentManager.Cancel(filter)
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+	blueprint.writeGeneratedAssets(res, ids)
+}
+
+func (blueprint *adminBlueprint) purgeHandler(res http.ResponseWriter, req *http.Request) {
+	ids, err := blueprint.agentManager.Purge(req.URL.Query().Get(":id"))
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+	blueprint.writeGeneratedAssets(res, ids)
+}
+
+func (blueprint *adminBlueprint) writeGeneratedAssets(res http.ResponseWriter, ids []string) {
+	body, err := json.Marshal(generatedAssetsView{ids})
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+// newGeneratedAssetFilter creates a filter from the "file_id", "template_id", "error", "since" and "until" query parameters. The "since" and "until" parameters are RFC 3339 times. An error is returned when none of the parameters are given, because an empty filter matches every generated asset.
+func newGeneratedAssetFilter(req *http.Request) (render.GeneratedAssetFilter, error) {
+	query := req.URL.Query()
+	filter := render.GeneratedAssetFilter{
+		SourceAssetId: query.Get("file_id"),
+		TemplateId:    query.Get("template_id"),
+		ErrorCode:     query.Get("error"),
+	}
+	if since := query.Get("since"); len(since) > 0 {
+		value, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return filter, err
+		}
+		filter.Since = value.UnixNano()
+	}
+	if until := query.Get("until"); len(until) > 0 {
+		value, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return filter, err
+		}
+		filter.Until = value.UnixNano()
+	}
+	if filter == (render.GeneratedAssetFilter{}) {
+		return filter, common.ErrorMissingGeneratedAssetFilter
+	}
+	return filter, nil
+}
+
+func (blueprint *adminBlueprint) templatesHandler(res http.ResponseWriter, req *http.Request) {
+	templates, err := blueprint.templateManager.FindAll()
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	view := new(templatesView)
+	view.Templates = make([]templateView, 0, 0)
+	for _, template := range templates {
+		view.Templates = append(view.Templates, newTemplateView(template))
+	}
+
+	body, err := json.Marshal(view)
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *adminBlueprint) templateHandler(res http.ResponseWriter, req *http.Request) {
+	templates, err := blueprint.templateManager.FindByIds([]string{req.URL.Query().Get(":id")})
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+	if len(templates) == 0 {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(404)
+		return
+	}
+
+	body, err := json.Marshal(newTemplateView(templates[0]))
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *adminBlueprint) storeTemplateHandler(res http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(400)
+		return
+	}
+	defer req.Body.Close()
+
+	var view templateView
+	err = json.Unmarshal(body, &view)
+	if err != nil || !isRenderAgent(view.Renderer) || len(view.Group) == 0 {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(400)
+		return
+	}
+	view.Id = req.URL.Query().Get(":id")
+
+	template := &common.Template{Id: view.Id, Renderer: view.Renderer, Group: view.Group, Attributes: make([]common.Attribute, 0, 0)}
+	for key, value := range view.Attributes {
+		template.AddAttribute(key, value)
+	}
+	err = blueprint.templateManager.Store(template)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	body, err = json.Marshal(newTemplateView(template))
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *adminBlueprint) deleteTemplateHandler(res http.ResponseWriter, req *http.Request) {
+	err := blueprint.templateManager.Delete(req.URL.Query().Get(":id"))
+	res.Header().Set("Content-Length", "0")
+	if err == common.ErrorNoTemplateForId {
+		res.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		res.WriteHeader(500)
+		return
+	}
+	res.WriteHeader(204)
+}
+
+func newTemplateView(template *common.Template) templateView {
+	attributes := make(map[string][]string)
+	for _, attribute := range template.Attributes {
+		attributes[attribute.Key] = attribute.Value
+	}
+	return templateView{template.Id, template.Renderer, template.Group, attributes}
+}
+
+func isRenderAgent(name string) bool {
+	for _, renderAgent := range common.RenderAgents {
+		if renderAgent == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (blueprint *adminBlueprint) errorsHandler(res http.ResponseWriter, req *http.Request) {
 	view := new(errorsView)
 	view.Errors = make([]errorViewError, 0, 0)
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"github.com/bmizerany/pat"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/config"
+	"github.com/ngerakines/preview/render"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newAdminTestConfig(t *testing.T, token string) config.AppConfig {
+	appConfig, err := config.NewUserAppConfig([]byte(`{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"adminApi": {"token": "` + token + `"},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false}
+		}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appConfig
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := render.NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	tokens := map[string]string{"": "", "secret": "4B7E1D92"}
+	for label, token := range tokens {
+		blueprint := NewAdminBlueprint(metrics.NewRegistry(), newAdminTestConfig(t, token), nil, common.NewTemporaryFileManager(), rm, tm, nil)
+		p := pat.New()
+		blueprint.AddRoutes(p)
+		server := httptest.NewServer(p)
+
+		requests := []struct {
+			method        string
+			path          string
+			authorization string
+			statusCode    int
+		}{
+			{"POST", "/admin/generatedAssets/cancel?file_id=4AE594A7", "", 401},
+			{"POST", "/admin/generatedAssets/cancel?file_id=4AE594A7", "Bearer wrong", 401},
+			{"POST", "/admin/generatedAssets/cancel?file_id=4AE594A7", "4B7E1D92", 401},
+			{"POST", "/admin/generatedAssets/cancel", "Bearer 4B7E1D92", 400},
+			{"POST", "/admin/generatedAssets/requeue", "Bearer 4B7E1D92", 400},
+			{"POST", "/admin/generatedAssets/cancel?file_id=4AE594A7", "Bearer 4B7E1D92", 200},
+			{"DELETE", "/admin/templates/" + common.DefaultTemplateSmall.Id, "", 401},
+			{"GET", "/admin/config", "", 401},
+			{"GET", "/admin/webhooks", "", 401},
+			{"GET", "/admin/webhooks", "Bearer 4B7E1D92", 200},
+			{"GET", "/admin/templates", "", 401},
+			{"GET", "/admin/templates", "Bearer 4B7E1D92", 200},
+			{"GET", "/admin/templates/" + common.DefaultTemplateSmall.Id, "", 401},
+			{"GET", "/admin/reaper", "", 401},
+			{"GET", "/admin/routing", "", 401},
+			{"GET", "/admin/routing", "Bearer 4B7E1D92", 200},
+			{"GET", "/admin/errors", "", 200},
+		}
+		for _, request := range requests {
+			statusCode := request.statusCode
+			// NKG: Without a token, every protected resource is disabled.
+			if len(token) == 0 && request.path != "/admin/errors" {
+				statusCode = 403
+			}
+			req, err := http.NewRequest(request.method, server.URL+request.path, nil)
+			if err != nil {
+				t.Errorf("Unexpected error returned: %s", err)
+				continue
+			}
+			if len(request.authorization) > 0 {
+				req.Header.Set("Authorization", request.authorization)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("Unexpected error returned: %s", err)
+				continue
+			}
+			res.Body.Close()
+			if res.StatusCode != statusCode {
+				t.Error("Unexpected status code", res.StatusCode, "for", label, request.method, request.path, request.authorization)
+			}
+		}
+		server.Close()
+	}
+}
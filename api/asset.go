@@ -3,17 +3,22 @@ package api
 import (
 	"github.com/bmizerany/pat"
 	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/render"
 	"github.com/ngerakines/preview/util"
 	"github.com/rcrowley/go-metrics"
 	"log"
 	"net/http"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type staticBlueprint struct {
 	base               string
 	placeholderManager common.PlaceholderManager
+	signatureManager   SignatureManager
+	verifySignatures   bool
 }
 
 type assetBlueprint struct {
@@ -24,13 +29,22 @@ type assetBlueprint struct {
 	placeholderManager           common.PlaceholderManager
 	s3Client                     common.S3Client
 	signatureManager             SignatureManager
+	verifySignatures             bool
 	localAssetStoragePath        string
-	templatesBySize              map[string]string
+	renderAgentManager           *render.RenderAgentManager
+	renderOnReadTimeout          time.Duration
+	resizer                      *render.Resizer
 
 	requestsMeter               metrics.Meter
 	malformedRequestsMeter      metrics.Meter
 	emptyRequestsMeter          metrics.Meter
 	unknownGeneratedAssetsMeter metrics.Meter
+	invalidSignaturesMeter      metrics.Meter
+	expiredRequestsMeter        metrics.Meter
+	renderOnReadMeter           metrics.Meter
+	renderOnReadTimeoutsMeter   metrics.Meter
+	resizeRequestsMeter         metrics.Meter
+	resizeErrorsMeter           metrics.Meter
 }
 
 type assetAction int
@@ -40,9 +54,14 @@ var (
 	assetActionServeFile = assetAction(1)
 	assetActionRedirect  = assetAction(2)
 	assetActionS3Proxy   = assetAction(3)
+	assetAction410       = assetAction(4)
+
+	// NKG: The output formats that not all browsers support, in order of
+	// preference.
+	modernOutputs = []string{"avif", "webp"}
 )
 
-// NewAssetBlueprint creates, configures and returns a new blueprint. This structure contains the state and HTTP controllers used to serve assets.
+// NewAssetBlueprint creates, configures and returns a new blueprint. This structure contains the state and HTTP controllers used to serve assets. When the render on read timeout is greater than 0, requests for generated assets that are not complete wait up to the timeout for them to be rendered before the placeholder is served. When a resizer is given, signed requests for images of arbitrary sizes are served.
 func NewAssetBlueprint(
 	registry metrics.Registry,
 	localAssetStoragePath string,
@@ -51,7 +70,11 @@ func NewAssetBlueprint(
 	templateManager common.TemplateManager,
 	placeholderManager common.PlaceholderManager,
 	s3Client common.S3Client,
-	signatureManager SignatureManager) *assetBlueprint {
+	signatureManager SignatureManager,
+	verifySignatures bool,
+	renderAgentManager *render.RenderAgentManager,
+	renderOnReadTimeout time.Duration,
+	resizer *render.Resizer) *assetBlueprint {
 
 	blueprint := new(assetBlueprint)
 	blueprint.base = "/asset"
@@ -62,48 +85,63 @@ func NewAssetBlueprint(
 	blueprint.localAssetStoragePath = localAssetStoragePath
 	blueprint.s3Client = s3Client
 	blueprint.signatureManager = signatureManager
+	blueprint.verifySignatures = verifySignatures
+	blueprint.renderAgentManager = renderAgentManager
+	blueprint.renderOnReadTimeout = renderOnReadTimeout
+	blueprint.resizer = resizer
 
 	blueprint.requestsMeter = metrics.NewMeter()
 	blueprint.malformedRequestsMeter = metrics.NewMeter()
 	blueprint.emptyRequestsMeter = metrics.NewMeter()
 	blueprint.unknownGeneratedAssetsMeter = metrics.NewMeter()
+	blueprint.invalidSignaturesMeter = metrics.NewMeter()
+	blueprint.expiredRequestsMeter = metrics.NewMeter()
+	blueprint.renderOnReadMeter = metrics.NewMeter()
+	blueprint.renderOnReadTimeoutsMeter = metrics.NewMeter()
+	blueprint.resizeRequestsMeter = metrics.NewMeter()
+	blueprint.resizeErrorsMeter = metrics.NewMeter()
 	registry.Register("assetApi.requests", blueprint.requestsMeter)
 	registry.Register("assetApi.malformedRequests", blueprint.malformedRequestsMeter)
 	registry.Register("assetApi.emptyRequests", blueprint.emptyRequestsMeter)
 	registry.Register("assetApi.unknownGeneratedAssets", blueprint.unknownGeneratedAssetsMeter)
-
-	var err error
-	if err != nil {
-		panic(err)
-	}
-
-	blueprint.templatesBySize = make(map[string]string)
-
-	legacyTemplates, err := blueprint.templateManager.FindByIds(common.LegacyDefaultTemplates)
-	if err == nil {
-		for _, legacyTemplate := range legacyTemplates {
-			placeholderSize, err := common.GetFirstAttribute(legacyTemplate, common.TemplateAttributePlaceholderSize)
-			if err == nil {
-				blueprint.templatesBySize[placeholderSize] = legacyTemplate.Id
-			}
-		}
-	}
+	registry.Register("assetApi.invalidSignatures", blueprint.invalidSignaturesMeter)
+	registry.Register("assetApi.expiredRequests", blueprint.expiredRequestsMeter)
+	registry.Register("assetApi.renderOnRead", blueprint.renderOnReadMeter)
+	registry.Register("assetApi.renderOnReadTimeouts", blueprint.renderOnReadTimeoutsMeter)
+	registry.Register("assetApi.resizeRequests", blueprint.resizeRequestsMeter)
+	registry.Register("assetApi.resizeErrors", blueprint.resizeErrorsMeter)
 
 	return blueprint
 }
 
 func (blueprint *assetBlueprint) AddRoutes(p *pat.PatternServeMux) {
 	p.Get(blueprint.base+"/:id/:template/:page", http.HandlerFunc(blueprint.assetHandler))
+	if blueprint.resizer != nil {
+		p.Get("/resize/:id/:page/:width/:height/:fit/:format", http.HandlerFunc(blueprint.resizeHandler))
+	}
 }
 
 func (blueprint *assetBlueprint) assetHandler(res http.ResponseWriter, req *http.Request) {
 	blueprint.requestsMeter.Mark(1)
 
+	if blueprint.verifySignatures && !blueprint.signatureManager.IsValid(req.URL.RequestURI()) {
+		blueprint.invalidSignaturesMeter.Mark(1)
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(403)
+		return
+	}
+
 	assetId := req.URL.Query().Get(":id")
 	templateAlias := req.URL.Query().Get(":template")
 	page := req.URL.Query().Get(":page")
 
-	action, path := blueprint.getAsset(assetId, templateAlias, page)
+	action, path, contentType := blueprint.getAsset(assetId, templateAlias, page, req.Header.Get("Accept"))
+	// NKG: Generated assets rendered in more than one output format are
+	// served in the format that the client accepts.
+	res.Header().Set("Vary", "Accept")
+	if contentType != "" {
+		res.Header().Set("Content-Type", contentType)
+	}
 	switch action {
 	case assetActionServeFile:
 		{
@@ -123,11 +161,69 @@ func (blueprint *assetBlueprint) assetHandler(res http.ResponseWriter, req *http
 				return
 			}
 		}
+	case assetAction410:
+		{
+			blueprint.expiredRequestsMeter.Mark(1)
+			http.Error(res, common.ErrorSourceAssetExpired.Description(), 410)
+			return
+		}
 	}
 	blueprint.emptyRequestsMeter.Mark(1)
 	http.NotFound(res, req)
 }
 
+// resizeHandler serves an image of a file resized to the width, height, fit mode and format in the url, creating it if it hasn't been requested before.
+func (blueprint *assetBlueprint) resizeHandler(res http.ResponseWriter, req *http.Request) {
+	blueprint.resizeRequestsMeter.Mark(1)
+
+	// NKG: Resized images are expensive to create, so resize urls must be
+	// signed even when signatures aren't verified for other assets.
+	if !blueprint.signatureManager.IsValid(req.URL.RequestURI()) {
+		blueprint.invalidSignaturesMeter.Mark(1)
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(403)
+		return
+	}
+
+	query := req.URL.Query()
+	page, pageErr := strconv.Atoi(query.Get(":page"))
+	width, widthErr := strconv.Atoi(query.Get(":width"))
+	height, heightErr := strconv.Atoi(query.Get(":height"))
+	fit := query.Get(":fit")
+	format := query.Get(":format")
+	if pageErr != nil || widthErr != nil || heightErr != nil || page < 0 || !blueprint.resizer.IsValidResize(width, height, fit, format) {
+		blueprint.malformedRequestsMeter.Mark(1)
+		http.Error(res, common.ErrorInvalidResizeParameters.Description(), 400)
+		return
+	}
+
+	location, err := blueprint.resizer.Resize(query.Get(":id"), int32(page), width, height, fit, format)
+	if err != nil {
+		blueprint.resizeErrorsMeter.Mark(1)
+		switch err {
+		case common.ErrorSourceAssetExpired:
+			http.Error(res, common.ErrorSourceAssetExpired.Description(), 410)
+		case common.ErrorNoSourceAssetsFoundForId, common.ErrorNoResizeSource:
+			http.NotFound(res, req)
+		default:
+			res.Header().Set("Content-Length", "0")
+			res.WriteHeader(500)
+		}
+		return
+	}
+
+	if strings.HasPrefix(location, "local://") {
+		http.ServeFile(res, req, filepath.Join(blueprint.localAssetStoragePath, location[8:]))
+		return
+	}
+	if strings.HasPrefix(location, "s3://") {
+		bucket, file := blueprint.splitS3Url(location)
+		blueprint.s3Client.Proxy(bucket, file, res)
+		return
+	}
+	http.NotFound(res, req)
+}
+
 func (blueprint *assetBlueprint) splitS3Url(url string) (string, string) {
 	usableData := url[5:]
 	// NKG: The url will have the following format: `s3://[bucket][path]`
@@ -136,54 +232,192 @@ func (blueprint *assetBlueprint) splitS3Url(url string) (string, string) {
 	return parts[0], parts[1]
 }
 
-func (blueprint *assetBlueprint) getAsset(fileId, placeholderSize, page string) (assetAction, string) {
+func (blueprint *assetBlueprint) getAsset(fileId, placeholderSize, page, accept string) (assetAction, string, string) {
+	// NKG: Expired source assets are deleted by the sweeper, but requests
+	// made before the next sweep shouldn't be served the expired renders.
+	if blueprint.isExpired(fileId) {
+		return assetAction410, "", ""
+	}
 
 	generatedAssets, err := blueprint.generatedAssetStorageManager.FindBySourceAssetId(fileId)
 	if err != nil {
 		blueprint.unknownGeneratedAssetsMeter.Mark(1)
-		return assetAction404, ""
+		return assetAction404, "", ""
 	}
 	if len(generatedAssets) == 0 {
 		blueprint.unknownGeneratedAssetsMeter.Mark(1)
 	}
 
-	templateId, hasTemplateId := blueprint.templatesBySize[placeholderSize]
-	if hasTemplateId {
-		for _, generatedAsset := range generatedAssets {
-			pageVal, _ := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributePage)
-			if len(pageVal) == 0 {
-				pageVal = "0"
+	templatePlaceholderSizes := blueprint.templatePlaceholderSizes(generatedAssets)
+	for _, generatedAsset := range generatedAssets {
+		pageVal, _ := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributePage)
+		if len(pageVal) == 0 {
+			pageVal = "0"
+		}
+		pageMatch := pageVal == page
+		if templatePlaceholderSizes[generatedAsset.TemplateId] == placeholderSize && pageMatch {
+			if generatedAsset.Status != common.GeneratedAssetStatusComplete && blueprint.renderOnReadTimeout > 0 {
+				generatedAsset = blueprint.renderOnRead(generatedAsset)
 			}
-			pageMatch := pageVal == page
-			if generatedAsset.TemplateId == templateId && pageMatch {
-				if strings.HasPrefix(generatedAsset.Location, "local://") {
-					fullPath := filepath.Join(blueprint.localAssetStoragePath, fileId, placeholderSize, page)
-					if util.CanLoadFile(fullPath) {
-						return assetActionServeFile, fullPath
-					}
-					placeholder := blueprint.placeholderManager.Url(fileId, placeholderSize)
-					if util.CanLoadFile(placeholder.Path) {
-						return assetActionServeFile, placeholder.Path
-					}
+			location, output := negotiateOutput(generatedAsset, accept)
+			if strings.HasPrefix(location, "local://") {
+				fullPath := filepath.Join(blueprint.localAssetStoragePath, location[8:])
+				if util.CanLoadFile(fullPath) {
+					return assetActionServeFile, fullPath, output
 				}
-				if strings.HasPrefix(generatedAsset.Location, "s3://") {
-					return assetActionS3Proxy, generatedAsset.Location
+				placeholder := blueprint.placeholderManager.Url(fileId, placeholderSize)
+				if util.CanLoadFile(placeholder.Path) {
+					return assetActionServeFile, placeholder.Path, ""
 				}
 			}
+			if strings.HasPrefix(location, "s3://") {
+				return assetActionS3Proxy, location, output
+			}
 		}
 	}
 	placeholder := blueprint.placeholderManager.Url(fileId, placeholderSize)
 	if util.CanLoadFile(placeholder.Path) {
-		return assetActionServeFile, placeholder.Path
+		return assetActionServeFile, placeholder.Path, ""
 	}
 
-	return assetAction404, ""
+	return assetAction404, "", ""
 }
 
-func NewStaticBlueprint(placeholderManager common.PlaceholderManager) *staticBlueprint {
+// negotiateOutput returns the location and content type of the output format of a generated asset to serve for an Accept header. AVIF and WebP images are only served to clients that explicitly accept them with a quality greater than 0, preferring the higher quality, otherwise the first other output format is served. Generated assets rendered before output formats were recorded are served from their location with an unknown content type.
+func negotiateOutput(generatedAsset *common.GeneratedAsset, accept string) (string, string) {
+	outputs := generatedAsset.GetAttribute(common.GeneratedAssetAttributeOutputs)
+	if len(outputs) == 0 {
+		return generatedAsset.Location, ""
+	}
+	qualities := acceptQualities(accept)
+	bestIndex, bestQuality := -1, 0.0
+	for _, preferred := range modernOutputs {
+		for index, output := range outputs {
+			quality := qualities[common.OutputContentType(output)]
+			if output == preferred && quality > bestQuality {
+				bestIndex, bestQuality = index, quality
+			}
+		}
+	}
+	if bestIndex != -1 {
+		output := outputs[bestIndex]
+		return outputLocation(generatedAsset, bestIndex, output), common.OutputContentType(output)
+	}
+	for index, output := range outputs {
+		if !isModernOutput(output) {
+			return outputLocation(generatedAsset, index, output), common.OutputContentType(output)
+		}
+	}
+	return generatedAsset.Location, common.OutputContentType(outputs[0])
+}
+
+// acceptQualities returns the quality of each media range in an Accept header. Media ranges without a quality have a quality of 1 and those with a quality that can't be parsed are left out.
+func acceptQualities(accept string) map[string]float64 {
+	qualities := make(map[string]float64)
+	for _, mediaRange := range strings.Split(accept, ",") {
+		parts := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(mediaType) == 0 {
+			continue
+		}
+		quality := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(strings.ToLower(param), "q=") {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+			if err != nil || value < 0 || value > 1 {
+				quality = -1
+			} else {
+				quality = value
+			}
+		}
+		if quality >= 0 {
+			qualities[mediaType] = quality
+		}
+	}
+	return qualities
+}
+
+func isModernOutput(output string) bool {
+	for _, modernOutput := range modernOutputs {
+		if output == modernOutput {
+			return true
+		}
+	}
+	return false
+}
+
+// outputLocation returns the location of one of the output formats of a generated asset. The first output format is uploaded to the location of the generated asset.
+func outputLocation(generatedAsset *common.GeneratedAsset, index int, output string) string {
+	if index == 0 {
+		return generatedAsset.Location
+	}
+	return common.OutputLocation(generatedAsset.Location, output)
+}
+
+// renderOnRead waits for a generated asset that is not complete to be rendered. The given generated asset is returned if it doesn't complete before the timeout.
+func (blueprint *assetBlueprint) renderOnRead(generatedAsset *common.GeneratedAsset) *common.GeneratedAsset {
+	if strings.HasPrefix(generatedAsset.Status, common.GeneratedAssetStatusFailed) {
+		return generatedAsset
+	}
+	blueprint.renderOnReadMeter.Mark(1)
+	renderedGeneratedAsset, rendered := blueprint.renderAgentManager.RenderNow(generatedAsset.Id, blueprint.renderOnReadTimeout)
+	if !rendered {
+		blueprint.renderOnReadTimeoutsMeter.Mark(1)
+		return generatedAsset
+	}
+	return renderedGeneratedAsset
+}
+
+// isExpired returns true if the origin source asset of the file has expired.
+func (blueprint *assetBlueprint) isExpired(fileId string) bool {
+	sourceAssets, err := blueprint.sourceAssetStorageManager.FindBySourceAssetId(fileId)
+	if err != nil {
+		return false
+	}
+	now := time.Now().UnixNano()
+	for _, sourceAsset := range sourceAssets {
+		if sourceAsset.IdType == common.SourceAssetTypeOrigin && common.IsSourceAssetExpired(sourceAsset, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// templatePlaceholderSizes returns a map of template ids to the placeholder sizes of the templates used by the given generated assets.
+func (blueprint *assetBlueprint) templatePlaceholderSizes(generatedAssets []*common.GeneratedAsset) map[string]string {
+	results := make(map[string]string)
+	templateIds := make([]string, 0, 0)
+	for _, generatedAsset := range generatedAssets {
+		if _, hasTemplateId := results[generatedAsset.TemplateId]; !hasTemplateId {
+			results[generatedAsset.TemplateId] = ""
+			templateIds = append(templateIds, generatedAsset.TemplateId)
+		}
+	}
+	if len(templateIds) == 0 {
+		return results
+	}
+	templates, err := blueprint.templateManager.FindByIds(templateIds)
+	if err != nil {
+		return results
+	}
+	for _, template := range templates {
+		placeholderSize, err := common.GetFirstAttribute(template, common.TemplateAttributePlaceholderSize)
+		if err == nil {
+			results[template.Id] = placeholderSize
+		}
+	}
+	return results
+}
+
+func NewStaticBlueprint(placeholderManager common.PlaceholderManager, signatureManager SignatureManager, verifySignatures bool) *staticBlueprint {
 	blueprint := new(staticBlueprint)
 	blueprint.base = "/static"
 	blueprint.placeholderManager = placeholderManager
+	blueprint.signatureManager = signatureManager
+	blueprint.verifySignatures = verifySignatures
 	return blueprint
 }
 
@@ -192,6 +426,12 @@ func (blueprint *staticBlueprint) AddRoutes(p *pat.PatternServeMux) {
 }
 
 func (blueprint *staticBlueprint) RequestHandler(res http.ResponseWriter, req *http.Request) {
+	if blueprint.verifySignatures && !blueprint.signatureManager.IsValid(req.URL.RequestURI()) {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(403)
+		return
+	}
+
 	parts := strings.Split(req.URL.Path[len(blueprint.base+"/"):], "/")
 	log.Println("parts", parts)
 
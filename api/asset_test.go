@@ -0,0 +1,59 @@
+package api
+
+import (
+	"github.com/ngerakines/preview/common"
+	"testing"
+)
+
+func TestNegotiateOutput(t *testing.T) {
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+
+	location, contentType := negotiateOutput(generatedAsset, "image/webp,*/*")
+	if location != generatedAsset.Location || contentType != "" {
+		t.Error("Generated asset without outputs expected to be served from its location", location, contentType)
+	}
+
+	generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, []string{"jpg", "webp"})
+	location, contentType = negotiateOutput(generatedAsset, "image/webp,*/*")
+	if location != "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small/0.webp" || contentType != "image/webp" {
+		t.Error("Unexpected output for client that accepts webp", location, contentType)
+	}
+	location, contentType = negotiateOutput(generatedAsset, "image/png,*/*")
+	if location != generatedAsset.Location || contentType != "image/jpeg" {
+		t.Error("Unexpected output for client that doesn't accept webp", location, contentType)
+	}
+
+	accepts := map[string]string{
+		"image/webp;q=0,*/*":            "image/jpeg",
+		"image/webp; q=0.0, */*":        "image/jpeg",
+		"image/webp;q=abc,*/*":          "image/jpeg",
+		"image/webp;q=0.5,image/*":      "image/webp",
+		"text/html, IMAGE/WEBP;Q=0.8":   "image/webp",
+		"image/avif;q=0,image/webp,*/*": "image/webp",
+	}
+	for accept, expected := range accepts {
+		_, contentType = negotiateOutput(generatedAsset, accept)
+		if contentType != expected {
+			t.Error("Unexpected output for", accept, contentType)
+		}
+	}
+
+	generatedAsset.SetAttribute(common.GeneratedAssetAttributeOutputs, []string{"jpg", "webp", "avif"})
+	_, contentType = negotiateOutput(generatedAsset, "image/avif;q=0.5,image/webp;q=0.9")
+	if contentType != "image/webp" {
+		t.Error("Unexpected output for client that prefers webp", contentType)
+	}
+	_, contentType = negotiateOutput(generatedAsset, "image/avif,image/webp")
+	if contentType != "image/avif" {
+		t.Error("Unexpected output for client that accepts avif and webp", contentType)
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/bmizerany/pat"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/render"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type eventsBlueprint struct {
+	base                string
+	broadcaster         *render.StatusBroadcaster
+	keepAlive           time.Duration
+	eventsRequestsMeter metrics.Meter
+}
+
+type renderStatusEvent struct {
+	GeneratedAssetId string `json:"generated_asset_id"`
+	FileId           string `json:"file_id"`
+	Status           string `json:"status"`
+	Error            string `json:"error,omitempty"`
+	Service          string `json:"service"`
+}
+
+// NewEventsBlueprint creates a new eventsBlueprint object. This structure contains the HTTP controllers used to stream render status updates as server-sent events.
+func NewEventsBlueprint(registry metrics.Registry, base string, broadcaster *render.StatusBroadcaster) *eventsBlueprint {
+	blueprint := new(eventsBlueprint)
+	blueprint.base = base
+	blueprint.broadcaster = broadcaster
+	blueprint.keepAlive = 15 * time.Second
+
+	blueprint.eventsRequestsMeter = metrics.NewMeter()
+	registry.Register("eventsApi.eventsRequests", blueprint.eventsRequestsMeter)
+
+	return blueprint
+}
+
+func (blueprint *eventsBlueprint) AddRoutes(p *pat.PatternServeMux) {
+	p.Get(blueprint.base+"/v1/events", http.HandlerFunc(blueprint.eventsHandler))
+}
+
+func (blueprint *eventsBlueprint) eventsHandler(res http.ResponseWriter, req *http.Request) {
+	blueprint.eventsRequestsMeter.Mark(1)
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	var closed <-chan bool
+	if closeNotifier, ok := res.(http.CloseNotifier); ok {
+		closed = closeNotifier.CloseNotify()
+	}
+
+	statuses, unsubscribe := blueprint.broadcaster.Subscribe(newStatusFilter(queryValues(req, "file_id"), queryValues(req, "service")))
+	defer unsubscribe()
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(200)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case renderStatus := <-statuses:
+			body, err := json.Marshal(newRenderStatusEvent(renderStatus))
+			if err != nil {
+				continue
+			}
+			_, err = fmt.Fprintf(res, "event: status\ndata: %s\n\n", body)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-time.After(blueprint.keepAlive):
+			// NKG: Comments are ignored by clients, but keep proxies from
+			// closing idle connections.
+			_, err := fmt.Fprint(res, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func newRenderStatusEvent(renderStatus render.RenderStatus) renderStatusEvent {
+	event := renderStatusEvent{GeneratedAssetId: renderStatus.GeneratedAssetId, FileId: renderStatus.SourceAssetId, Status: renderStatus.Status, Service: renderStatus.Service}
+	if codedError, hasCodedError := common.ParseGeneratedAssetError(renderStatus.Status); hasCodedError {
+		event.Status = common.GeneratedAssetStatusFailed
+		event.Error = codedError.Error()
+	}
+	return event
+}
+
+// newStatusFilter returns a filter that accepts render status updates for any of the given file ids and services. An empty list accepts every value.
+func newStatusFilter(fileIds, services []string) render.StatusFilter {
+	return func(renderStatus render.RenderStatus) bool {
+		return (len(fileIds) == 0 || contains(fileIds, renderStatus.SourceAssetId)) && (len(services) == 0 || contains(services, renderStatus.Service))
+	}
+}
+
+func queryValues(req *http.Request, key string) []string {
+	results := make([]string, 0, 0)
+	for _, value := range req.URL.Query()[key] {
+		for _, part := range strings.Split(value, ",") {
+			if len(part) > 0 {
+				results = append(results, part)
+			}
+		}
+	}
+	return results
+}
+
+func contains(values []string, value string) bool {
+	for _, element := range values {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
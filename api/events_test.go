@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bufio"
+	"github.com/bmizerany/pat"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/render"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsBlueprint(t *testing.T) {
+	broadcaster := render.NewStatusBroadcaster()
+	defer broadcaster.Stop()
+
+	p := pat.New()
+	NewEventsBlueprint(metrics.NewRegistry(), "/api", broadcaster).AddRoutes(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/api/v1/events?file_id=4AE594A7")
+	if err != nil {
+		t.Error("Unexpected error requesting events", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Error("Unexpected content type", res.Header.Get("Content-Type"))
+	}
+
+	for i := 0; i < 100 && broadcaster.SubscriberCount() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	broadcaster.Listener() <- render.RenderStatus{GeneratedAssetId: "A", SourceAssetId: "E876F147", Status: common.GeneratedAssetStatusComplete, Service: common.RenderAgentImageMagick}
+	broadcaster.Listener() <- render.RenderStatus{GeneratedAssetId: "B", SourceAssetId: "4AE594A7", Status: common.NewGeneratedAssetError(common.ErrorFileTooLarge), Service: common.RenderAgentImageMagick}
+
+	lines := make(chan string, 10)
+	go func() {
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	for {
+		select {
+		case line := <-lines:
+			if strings.HasPrefix(line, "data: ") {
+				if line != `data: {"generated_asset_id":"B","file_id":"4AE594A7","status":"failed","error":"PRVCOM4","service":"renderAgentImageMagick"}` {
+					t.Error("Unexpected event", line)
+				}
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("No event was sent")
+			return
+		}
+	}
+}
+
+func TestStatusFilter(t *testing.T) {
+	filter := newStatusFilter([]string{}, []string{common.RenderAgentDocument})
+	if filter(render.RenderStatus{GeneratedAssetId: "A", SourceAssetId: "4AE594A7", Status: common.GeneratedAssetStatusComplete, Service: common.RenderAgentImageMagick}) {
+		t.Error("Filter should not accept other services")
+	}
+	if !filter(render.RenderStatus{GeneratedAssetId: "A", SourceAssetId: "4AE594A7", Status: common.GeneratedAssetStatusComplete, Service: common.RenderAgentDocument}) {
+		t.Error("Filter should accept any file id")
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ngerakines/preview/common"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type jobView struct {
+	FileId          string              `json:"file_id"`
+	State           string              `json:"state"`
+	Final           bool                `json:"final"`
+	Attributes      map[string][]string `json:"attributes"`
+	GeneratedAssets []generatedAssetJob `json:"generated_assets"`
+}
+
+type generatedAssetJob struct {
+	Id         string              `json:"id"`
+	TemplateId string              `json:"template_id"`
+	Page       int32               `json:"page"`
+	Status     string              `json:"status"`
+	Error      *errorViewError     `json:"error,omitempty"`
+	CreatedAt  int64               `json:"created_at"`
+	UpdatedAt  int64               `json:"updated_at"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+type resizeUrlView struct {
+	Url     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
+
+// NKG: The source and callback urls of a file can include credentials, so
+// they aren't included with the attributes of the file.
+var hiddenSourceAssetAttributes = map[string]bool{
+	common.SourceAssetAttributeSource:      true,
+	common.SourceAssetAttributeCallbackUrl: true,
+}
+
+func (blueprint *simpleBlueprint) JobHandler(res http.ResponseWriter, req *http.Request) {
+	blueprint.jobRequestsMeter.Mark(1)
+
+	fileId := req.URL.Query().Get(":fileid")
+	sourceAsset, err := blueprint.getOriginSourceAsset(fileId)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(404)
+		return
+	}
+
+	generatedAssets, err := blueprint.generatedAssetStorageManager.FindBySourceAssetId(fileId)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	body, err := json.Marshal(blueprint.newJobView(sourceAsset, generatedAssets))
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
+
+func (blueprint *simpleBlueprint) newJobView(sourceAsset *common.SourceAsset, generatedAssets []*common.GeneratedAsset) *jobView {
+	// NKG: A partial job may still be rendering or may have finished with
+	// some failures, so the state alone can't tell clients when to stop
+	// polling.
+	view := &jobView{sourceAsset.Id, common.GeneratedAssetsState(generatedAssets), len(generatedAssets) > 0 && common.IsGeneratedAssetsFinal(generatedAssets), make(map[string][]string), make([]generatedAssetJob, 0, 0)}
+	for _, attribute := range sourceAsset.Attributes {
+		if !hiddenSourceAssetAttributes[attribute.Key] {
+			view.Attributes[attribute.Key] = attribute.Value
+		}
+	}
+	for _, generatedAsset := range generatedAssets {
+		attributes := make(map[string][]string)
+		for _, attribute := range generatedAsset.Attributes {
+			attributes[attribute.Key] = attribute.Value
+		}
+		job := generatedAssetJob{
+			Id:         generatedAsset.Id,
+			TemplateId: generatedAsset.TemplateId,
+			Page:       blueprint.getGeneratedAssetPage(generatedAsset),
+			Status:     generatedAsset.Status,
+			CreatedAt:  generatedAsset.CreatedAt,
+			UpdatedAt:  generatedAsset.UpdatedAt,
+			Attributes: attributes,
+		}
+		if strings.HasPrefix(generatedAsset.Status, common.GeneratedAssetStatusFailed) {
+			job.Status = common.GeneratedAssetStatusFailed
+			codedError, hasCodedError := common.ParseGeneratedAssetError(generatedAsset.Status)
+			if !hasCodedError {
+				codedError = common.ErrorUnknownError
+			}
+			job.Error = &errorViewError{codedError.Error(), codedError.Description()}
+		}
+		view.GeneratedAssets = append(view.GeneratedAssets, job)
+	}
+	return view
+}
+
+// ResizeUrlHandler returns a signed url of the resize resource of the asset API for a page of a file, using the "page", "width", "height", "fit" and "format" query string parameters.
+func (blueprint *simpleBlueprint) ResizeUrlHandler(res http.ResponseWriter, req *http.Request) {
+	blueprint.resizeUrlRequestsMeter.Mark(1)
+
+	query := req.URL.Query()
+	fileId := query.Get(":fileid")
+	page, pageErr := strconv.Atoi(query.Get("page"))
+	width, widthErr := strconv.Atoi(query.Get("width"))
+	height, heightErr := strconv.Atoi(query.Get("height"))
+	fit := query.Get("fit")
+	format := query.Get("format")
+	if pageErr != nil || widthErr != nil || heightErr != nil || page < 0 || !blueprint.resizer.IsValidResize(width, height, fit, format) {
+		http.Error(res, common.ErrorInvalidResizeParameters.Description(), 400)
+		return
+	}
+
+	if _, err := blueprint.getOriginSourceAsset(fileId); err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(404)
+		return
+	}
+
+	url, expires, err := blueprint.signatureManager.Sign(fmt.Sprintf("%s/resize/%s/%d/%d/%d/%s/%s", blueprint.edgeContentHost, fileId, page, width, height, fit, format))
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	body, err := json.Marshal(resizeUrlView{url, expires})
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	res.Write(body)
+}
@@ -11,6 +11,9 @@ type generatePreviewRequest struct {
 	requestType string
 	url         string
 	size        int64
+	callbackUrl string
+	// ttl is the number of seconds the preview is kept for. A value of 0 uses the retention of the file type.
+	ttl int64
 }
 
 func newGeneratePreviewRequestFromText(id, body string) ([]*generatePreviewRequest, error) {
@@ -44,6 +47,20 @@ func newGeneratePreviewRequestFromText(id, body string) ([]*generatePreviewReque
 	}
 	gpr.size = sizeValue
 
+	callbackUrl, hasCallbackUrl := vals["callback"]
+	if hasCallbackUrl {
+		gpr.callbackUrl = callbackUrl
+	}
+
+	ttl, hasTtl := vals["ttl"]
+	if hasTtl {
+		ttlValue, err := strconv.ParseInt(ttl, 10, 64)
+		if err != nil || ttlValue < 0 {
+			return nil, common.ErrorInvalidTtl
+		}
+		gpr.ttl = ttlValue
+	}
+
 	gprs := make([]*generatePreviewRequest, 0, 0)
 	gprs = append(gprs, gpr)
 	return gprs, nil
@@ -57,6 +74,8 @@ func newGeneratePreviewRequestFromJson(body string) ([]*generatePreviewRequest,
 			RequestType string `json:"type"`
 			Url         string `json:"url"`
 			Size        string `json:"size"`
+			CallbackUrl string `json:"callback_url"`
+			Ttl         string `json:"ttl"`
 		} `json:"files"`
 	}
 	err := json.Unmarshal([]byte(body), &data)
@@ -75,6 +94,14 @@ func newGeneratePreviewRequestFromJson(body string) ([]*generatePreviewRequest,
 		}
 		gpr.size = sizeValue
 		gpr.url = file.Url
+		gpr.callbackUrl = file.CallbackUrl
+		if len(file.Ttl) > 0 {
+			ttlValue, err := strconv.ParseInt(file.Ttl, 10, 64)
+			if err != nil || ttlValue < 0 {
+				return nil, common.ErrorInvalidTtl
+			}
+			gpr.ttl = ttlValue
+		}
 		gprs = append(gprs, gpr)
 	}
 	return gprs, nil
@@ -88,3 +88,23 @@ func TestJsonParsingExampleA(t *testing.T) {
 		t.Error("Expected one generate preview request but got", len(gprs))
 	}
 }
+
+func TestCallbackUrlParsing(t *testing.T) {
+	gprs, err := newGeneratePreviewRequestFromText("1234", "type: jpg\nurl: http://www.hightail.com/\nsize: 12345\ncallback: http://localhost:9090/callback\n")
+	if err != nil {
+		t.Error("Unexpected error parsing text:", err)
+		return
+	}
+	if len(gprs) != 1 || gprs[0].callbackUrl != "http://localhost:9090/callback" {
+		t.Error("Invalid callback url", gprs)
+	}
+
+	gprs, err = newGeneratePreviewRequestFromJson(`{"version": 1, "files": [{"file_id": "abcd1234", "url": "http://ngerakines.me/resume.pdf", "size": "12345", "type": "pdf", "callback_url": "http://localhost:9090/callback"}]}`)
+	if err != nil {
+		t.Error("Unexpected error parsing json:", err)
+		return
+	}
+	if len(gprs) != 1 || gprs[0].callbackUrl != "http://localhost:9090/callback" {
+		t.Error("Invalid callback url", gprs)
+	}
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"github.com/ngerakines/preview/common"
 	"github.com/ngerakines/preview/util"
 	"log"
 	neturl "net/url"
@@ -15,15 +19,38 @@ type SignatureManager interface {
 	IsValid(url string) bool
 }
 
-// TODO: The key should be set by configuration and should support multiple keys.
+// defaultSignatureManager signs urls with the active key and validates urls signed with any of its keys. The key id is included in signed urls so that keys can be rotated without invalidating urls that have already been handed out.
 type defaultSignatureManager struct {
-	key string
+	keys      map[string]string
+	activeKey string
+	ttl       time.Duration
 }
 
-func NewSignatureManager() SignatureManager {
+// NewSignatureManager creates a new signature manager. If no keys are given, a random key is created, and urls signed by it are only valid on this node until it is restarted.
+func NewSignatureManager(keys map[string]string, activeKey string, ttl time.Duration) (SignatureManager, error) {
 	signatureManager := new(defaultSignatureManager)
-	signatureManager.key = "foo"
-	return signatureManager
+	signatureManager.keys = make(map[string]string)
+	signatureManager.ttl = ttl
+
+	if len(keys) == 0 {
+		log.Println("No signature keys configured, using a random key.")
+		secret, err := randomSignatureKey()
+		if err != nil {
+			return nil, err
+		}
+		signatureManager.keys["random"] = secret
+		signatureManager.activeKey = "random"
+		return signatureManager, nil
+	}
+
+	for kid, secret := range keys {
+		signatureManager.keys[kid] = secret
+	}
+	if _, hasActiveKey := signatureManager.keys[activeKey]; !hasActiveKey {
+		return nil, common.ErrorUnknownSignatureKey
+	}
+	signatureManager.activeKey = activeKey
+	return signatureManager, nil
 }
 
 func (signatureManager *defaultSignatureManager) Sign(url string) (string, int64, error) {
@@ -32,20 +59,20 @@ func (signatureManager *defaultSignatureManager) Sign(url string) (string, int64
 		log.Println("Could not parse url", err)
 		return "", 0, err
 	}
-	expiresValue := time.Now().Add(5 * time.Minute).UnixNano()
+	expiresValue := time.Now().Add(signatureManager.ttl).UnixNano()
 	expires := strconv.FormatInt(expiresValue, 10)
-	signature := signatureManager.createSignature(parseUrl.Path, expires)
+	signature := signatureManager.createSignature(signatureManager.keys[signatureManager.activeKey], parseUrl.Path, expires)
 	q := parseUrl.Query()
 	q.Set("signature", signature)
 	q.Set("expires", expires)
+	q.Set("kid", signatureManager.activeKey)
 
 	parseUrl.RawQuery = q.Encode()
 
-	log.Println("parsed url", parseUrl)
 	return parseUrl.String(), expiresValue, nil
-
 }
 
+// IsValid returns true if the url has a signature created by one of the keys of the signature manager and has not expired. Urls without a key id are checked against the active key.
 func (signatureManager *defaultSignatureManager) IsValid(url string) bool {
 	parseUrl, err := signatureManager.parseUrl(url)
 	if err != nil {
@@ -53,14 +80,32 @@ func (signatureManager *defaultSignatureManager) IsValid(url string) bool {
 		return false
 	}
 
-	expires := parseUrl.Query().Get("expires")
-	signature := parseUrl.Query().Get("signature")
-	log.Println("query string expires", expires, "signature", signature)
+	query := parseUrl.Query()
+	expires := query.Get("expires")
+	signature := query.Get("signature")
+	if expires == "" || signature == "" {
+		return false
+	}
 
-	checkSignature := signatureManager.createSignature(parseUrl.Path, expires)
-	log.Println("check signature", checkSignature)
+	expiresValue, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return false
+	}
+	if time.Now().UnixNano() > expiresValue {
+		return false
+	}
+
+	kid := query.Get("kid")
+	if kid == "" {
+		kid = signatureManager.activeKey
+	}
+	secret, hasSecret := signatureManager.keys[kid]
+	if !hasSecret {
+		return false
+	}
 
-	return signature == checkSignature
+	checkSignature := signatureManager.createSignature(secret, parseUrl.Path, expires)
+	return hmac.Equal([]byte(signature), []byte(checkSignature))
 }
 
 func (signatureManager *defaultSignatureManager) parseUrl(uri string) (*neturl.URL, error) {
@@ -70,8 +115,17 @@ func (signatureManager *defaultSignatureManager) parseUrl(uri string) (*neturl.U
 	return neturl.Parse(uri)
 }
 
-func (signatureManager *defaultSignatureManager) createSignature(path, expires string) string {
+func (signatureManager *defaultSignatureManager) createSignature(secret, path, expires string) string {
 	stringToSign := fmt.Sprintf("%s\n%s", path, expires)
-	signature := util.ComputeHmac256(stringToSign, signatureManager.key)
+	signature := util.ComputeHmac256(stringToSign, secret)
 	return signature
 }
+
+func randomSignatureKey() (string, error) {
+	bytes := make([]byte, 32)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
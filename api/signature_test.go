@@ -0,0 +1,88 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignatureManager(t *testing.T) {
+	signatureManager, err := NewSignatureManager(map[string]string{"a": "foo"}, "a", 5*time.Minute)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	url, _, err := signatureManager.Sign("http://localhost:8080/asset/1234/jumbo/0")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if !strings.Contains(url, "kid=a") {
+		t.Error("Signed url does not include the key id", url)
+	}
+	if !signatureManager.IsValid(url) {
+		t.Error("Signed url should be valid", url)
+	}
+	if !signatureManager.IsValid(url[len("http://localhost:8080"):]) {
+		t.Error("Signed request uri should be valid", url)
+	}
+	if signatureManager.IsValid(strings.Replace(url, "1234", "5678", 1)) {
+		t.Error("Tampered url should not be valid", url)
+	}
+	if signatureManager.IsValid("/asset/1234/jumbo/0") {
+		t.Error("Unsigned url should not be valid")
+	}
+}
+
+func TestSignatureManagerExpires(t *testing.T) {
+	signatureManager, err := NewSignatureManager(map[string]string{"a": "foo"}, "a", -1*time.Minute)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	url, _, err := signatureManager.Sign("/asset/1234/jumbo/0")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if signatureManager.IsValid(url) {
+		t.Error("Expired url should not be valid", url)
+	}
+}
+
+func TestSignatureManagerRotation(t *testing.T) {
+	oldSignatureManager, err := NewSignatureManager(map[string]string{"a": "foo"}, "a", 5*time.Minute)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	signatureManager, err := NewSignatureManager(map[string]string{"a": "foo", "b": "bar"}, "b", 5*time.Minute)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	retiredSignatureManager, err := NewSignatureManager(map[string]string{"b": "bar"}, "b", 5*time.Minute)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	url, _, err := oldSignatureManager.Sign("/asset/1234/jumbo/0")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if !signatureManager.IsValid(url) {
+		t.Error("Url signed with a previous key should be valid", url)
+	}
+	if retiredSignatureManager.IsValid(url) {
+		t.Error("Url signed with a removed key should not be valid", url)
+	}
+
+	_, err = NewSignatureManager(map[string]string{"a": "foo"}, "b", 5*time.Minute)
+	if err == nil {
+		t.Error("Unknown active key should return an error")
+	}
+}
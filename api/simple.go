@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type simpleBlueprint struct {
@@ -23,12 +24,16 @@ type simpleBlueprint struct {
 	templateManager              common.TemplateManager
 	placeholderManager           common.PlaceholderManager
 	signatureManager             SignatureManager
-	supportedFileTypes           map[string]int64
+	callbackHosts                []string
+	resizer                      *render.Resizer
 	generatePreviewRequestsMeter metrics.Meter
 	previewInfoRequestsMeter     metrics.Meter
+	jobRequestsMeter             metrics.Meter
+	deletePreviewRequestsMeter   metrics.Meter
+	resizeUrlRequestsMeter       metrics.Meter
 }
 
-// NewSimpleBlueprint creates a new simpleBlueprint object.
+// NewSimpleBlueprint creates a new simpleBlueprint object. When a resizer is given, signed resize urls are handed out for files.
 func NewSimpleBlueprint(
 	registry metrics.Registry,
 	base string,
@@ -39,7 +44,8 @@ func NewSimpleBlueprint(
 	templateManager common.TemplateManager,
 	placeholderManager common.PlaceholderManager,
 	signatureManager SignatureManager,
-	supportedFileTypes map[string]int64) (*simpleBlueprint, error) {
+	callbackHosts []string,
+	resizer *render.Resizer) (*simpleBlueprint, error) {
 	blueprint := new(simpleBlueprint)
 	blueprint.base = base
 	blueprint.edgeContentHost = edgeContentHost
@@ -48,13 +54,20 @@ func NewSimpleBlueprint(
 	blueprint.generatedAssetStorageManager = generatedAssetStorageManager
 	blueprint.templateManager = templateManager
 	blueprint.placeholderManager = placeholderManager
-	blueprint.supportedFileTypes = supportedFileTypes
 	blueprint.signatureManager = signatureManager
+	blueprint.callbackHosts = callbackHosts
+	blueprint.resizer = resizer
 
 	blueprint.generatePreviewRequestsMeter = metrics.NewMeter()
 	blueprint.previewInfoRequestsMeter = metrics.NewMeter()
+	blueprint.jobRequestsMeter = metrics.NewMeter()
+	blueprint.deletePreviewRequestsMeter = metrics.NewMeter()
+	blueprint.resizeUrlRequestsMeter = metrics.NewMeter()
 	registry.Register("simpleApi.generatePreviewRequests", blueprint.generatePreviewRequestsMeter)
 	registry.Register("simpleApi.previewInfoRequests", blueprint.previewInfoRequestsMeter)
+	registry.Register("simpleApi.jobRequests", blueprint.jobRequestsMeter)
+	registry.Register("simpleApi.deletePreviewRequests", blueprint.deletePreviewRequestsMeter)
+	registry.Register("simpleApi.resizeUrlRequests", blueprint.resizeUrlRequestsMeter)
 
 	return blueprint, nil
 }
@@ -64,6 +77,11 @@ func (blueprint *simpleBlueprint) AddRoutes(p *pat.PatternServeMux) {
 	p.Put(blueprint.buildUrl("/v1/preview/:fileid"), http.HandlerFunc(blueprint.GeneratePreviewHandler))
 	p.Get(blueprint.buildUrl("/v1/preview/"), http.HandlerFunc(blueprint.PreviewInfoHandler))
 	p.Get(blueprint.buildUrl("/v1/preview/:fileid"), http.HandlerFunc(blueprint.PreviewInfoHandler))
+	p.Del(blueprint.buildUrl("/v1/preview/:fileid"), http.HandlerFunc(blueprint.DeletePreviewHandler))
+	if blueprint.resizer != nil {
+		p.Get(blueprint.buildUrl("/v2/jobs/:fileid/resize"), http.HandlerFunc(blueprint.ResizeUrlHandler))
+	}
+	p.Get(blueprint.buildUrl("/v2/jobs/:fileid"), http.HandlerFunc(blueprint.JobHandler))
 }
 
 func (blueprint *simpleBlueprint) buildUrl(path string) string {
@@ -97,6 +115,9 @@ func (blueprint *simpleBlueprint) GeneratePreviewHandler(res http.ResponseWriter
 	id, hasId := blueprint.urlHasFileId(req.URL.Path)
 	if hasId {
 		gprs, err := newGeneratePreviewRequestFromText(id, string(body))
+		if err == nil {
+			err = blueprint.validateCallbackUrls(gprs)
+		}
 		if err != nil {
 			res.Header().Set("Content-Length", "0")
 			res.WriteHeader(400)
@@ -105,6 +126,9 @@ func (blueprint *simpleBlueprint) GeneratePreviewHandler(res http.ResponseWriter
 		blueprint.handleGeneratePreviewRequest(gprs)
 	} else {
 		gprs, err := newGeneratePreviewRequestFromJson(string(body))
+		if err == nil {
+			err = blueprint.validateCallbackUrls(gprs)
+		}
 		if err != nil {
 			res.Header().Set("Content-Length", "0")
 			res.WriteHeader(400)
@@ -144,6 +168,34 @@ func (blueprint *simpleBlueprint) PreviewInfoHandler(res http.ResponseWriter, re
 	res.Write(previewInfo)
 }
 
+// DeletePreviewHandler removes the source assets and generated assets of a file, along with any rendered files. Work for the file that is waiting or being rendered is abandoned.
+func (blueprint *simpleBlueprint) DeletePreviewHandler(res http.ResponseWriter, req *http.Request) {
+	blueprint.deletePreviewRequestsMeter.Mark(1)
+
+	fileId := req.URL.Query().Get(":fileid")
+	sourceAssets, err := blueprint.sourceAssetStorageManager.FindBySourceAssetId(fileId)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+	if len(sourceAssets) == 0 {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(404)
+		return
+	}
+
+	_, err = blueprint.renderAgentManager.Purge(fileId)
+	if err != nil {
+		res.Header().Set("Content-Length", "0")
+		res.WriteHeader(500)
+		return
+	}
+
+	res.Header().Set("Content-Length", "0")
+	res.WriteHeader(204)
+}
+
 func (blueprint *simpleBlueprint) urlHasFileId(url string) (string, bool) {
 	index := len(blueprint.buildUrl("/v1/preview/"))
 	if len(url) > index {
@@ -152,22 +204,30 @@ func (blueprint *simpleBlueprint) urlHasFileId(url string) (string, bool) {
 	return "", false
 }
 
-func (blueprint *simpleBlueprint) handleGeneratePreviewRequest(gprs []*generatePreviewRequest) {
+// validateCallbackUrls returns an error if any of the requests has a callback url that notifications can't be sent to.
+func (blueprint *simpleBlueprint) validateCallbackUrls(gprs []*generatePreviewRequest) error {
 	for _, gpr := range gprs {
-		blueprint.renderAgentManager.CreateWork(gpr.id, gpr.url, gpr.requestType, gpr.size)
+		if len(gpr.callbackUrl) > 0 {
+			if err := render.ValidateCallbackUrl(gpr.callbackUrl, blueprint.callbackHosts); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
-func (blueprint *simpleBlueprint) generatedAssetStatus(fileType string, fileSize int64) string {
-	// TODO: Check the expiration of the file.
-	maxSize, hasEntry := blueprint.supportedFileTypes[fileType]
-	if !hasEntry {
-		return common.NewGeneratedAssetError(common.ErrorNoRenderersSupportFileType)
-	}
-	if fileSize > maxSize {
-		return common.NewGeneratedAssetError(common.ErrorFileTooLarge)
+func (blueprint *simpleBlueprint) handleGeneratePreviewRequest(gprs []*generatePreviewRequest) {
+	for _, gpr := range gprs {
+		attributes := make([]common.Attribute, 0, 0)
+		if len(gpr.callbackUrl) > 0 {
+			attributes = append(attributes, common.Attribute{Key: common.SourceAssetAttributeCallbackUrl, Value: []string{gpr.callbackUrl}})
+		}
+		if gpr.ttl > 0 {
+			expires := time.Now().Add(time.Duration(gpr.ttl) * time.Second).UnixNano()
+			attributes = append(attributes, common.Attribute{Key: common.SourceAssetAttributeExpires, Value: []string{strconv.FormatInt(expires, 10)}})
+		}
+		blueprint.renderAgentManager.CreateWork(gpr.id, gpr.url, gpr.requestType, gpr.size, attributes)
 	}
-	return common.GeneratedAssetStatusWaiting
 }
 
 func (blueprint *simpleBlueprint) parseFileIds(req *http.Request) []string {
@@ -206,18 +266,20 @@ type templateTuple struct {
 func (blueprint *simpleBlueprint) handlePreviewInfoRequest(fileIds []string) ([]byte, error) {
 	collections := make([]*previewInfoCollection, 0, 0)
 
-	legacyTemplates, err := blueprint.templateManager.FindByIds(common.LegacyDefaultTemplates)
+	allTemplates, err := blueprint.templateManager.FindAll()
 	if err != nil {
 		return nil, err
 	}
 
+	// NKG: Only templates that have a placeholder size can be displayed in
+	// a preview info collection.
 	templates := make(map[string]templateTuple)
-	for _, legacyTemplate := range legacyTemplates {
-		placeholderSize, err := blueprint.templatePlaceholderSize(legacyTemplate)
+	for _, template := range allTemplates {
+		placeholderSize, err := blueprint.templatePlaceholderSize(template)
 		if err != nil {
-			return nil, err
+			continue
 		}
-		templates[legacyTemplate.Id] = templateTuple{placeholderSize, legacyTemplate}
+		templates[template.Id] = templateTuple{placeholderSize, template}
 	}
 
 	for _, fileId := range fileIds {
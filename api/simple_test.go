@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/bmizerany/pat"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/render"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeletePreview(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := render.NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	blueprint, err := NewSimpleBlueprint(metrics.NewRegistry(), "/api", "http://localhost:8080", rm, sasm, gasm, tm, nil, nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	p := pat.New()
+	blueprint.AddRoutes(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sasm.Store(sourceAsset)
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	gasm.Store(generatedAsset)
+
+	statusCodes := []int{204, 404}
+	for _, statusCode := range statusCodes {
+		req, err := http.NewRequest("DELETE", server.URL+"/api/v1/preview/"+sourceAsset.Id, nil)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != statusCode {
+			t.Error("Unexpected status code", res.StatusCode, "expected", statusCode)
+		}
+	}
+
+	generatedAssets, _ := gasm.FindBySourceAssetId(sourceAsset.Id)
+	if len(generatedAssets) != 0 {
+		t.Error("No generated assets expected:", generatedAssets)
+	}
+}
+
+func TestJobViewFinal(t *testing.T) {
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	blueprint, err := NewSimpleBlueprint(metrics.NewRegistry(), "/api", "http://localhost:8080", nil, sasm, gasm, tm, nil, nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+
+	sourceAsset, err := common.NewSourceAsset("0B7E2A64-93C1-4D58-8F2A-6E1C5D9B3A70", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAssets := make([]*common.GeneratedAsset, 0, 0)
+	for _, template := range []*common.Template{common.DefaultTemplateSmall, common.DefaultTemplateMedium} {
+		generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, template, "local:///0B7E2A64-93C1-4D58-8F2A-6E1C5D9B3A70/"+template.Id+"/0")
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		generatedAssets = append(generatedAssets, generatedAsset)
+	}
+
+	generatedAssets[0].Status = common.GeneratedAssetStatusComplete
+	view := blueprint.newJobView(sourceAsset, generatedAssets)
+	if view.State != common.GeneratedAssetsStatePartial || view.Final {
+		t.Error("Unexpected job state while rendering:", view.State, view.Final)
+	}
+
+	generatedAssets[1].Status = common.NewGeneratedAssetError(common.ErrorUnknownError)
+	view = blueprint.newJobView(sourceAsset, generatedAssets)
+	if view.State != common.GeneratedAssetsStatePartial || !view.Final {
+		t.Error("Unexpected job state after rendering:", view.State, view.Final)
+	}
+}
+
+func TestGeneratePreviewCallbackUrl(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := render.NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	blueprint, err := NewSimpleBlueprint(metrics.NewRegistry(), "/api", "http://localhost:8080", rm, sasm, gasm, tm, nil, nil, []string{}, nil)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	p := pat.New()
+	blueprint.AddRoutes(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	callbackUrls := map[string]int{
+		"http://169.254.169.254/latest/meta-data": 400,
+		"file:///etc/passwd":                      400,
+		"http://example.com/callback":             202,
+	}
+	for callbackUrl, statusCode := range callbackUrls {
+		body := "type: xyz\nurl: file:///tmp/3D8F\nsize: 1024\ncallback: " + callbackUrl + "\n"
+		req, err := http.NewRequest("PUT", server.URL+"/api/v1/preview/3D8F", strings.NewReader(body))
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != statusCode {
+			t.Error("Unexpected status code", res.StatusCode, "for", callbackUrl)
+		}
+	}
+}
+
+func TestResizeUrl(t *testing.T) {
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	signatureManager, err := NewSignatureManager(map[string]string{"a": "secret"}, "a", time.Hour)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	resizer := render.NewResizer(sasm, gasm, tm, common.NewTemporaryFileManager(), nil, nil, 50000000)
+
+	blueprint, err := NewSimpleBlueprint(metrics.NewRegistry(), "/api", "http://localhost:8080", nil, sasm, gasm, tm, nil, signatureManager, []string{}, resizer)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	p := pat.New()
+	blueprint.AddRoutes(p)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sasm.Store(sourceAsset)
+
+	requests := map[string]int{
+		"/api/v2/jobs/4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/resize?page=0&width=640&height=480&fit=cover&format=jpg":  200,
+		"/api/v2/jobs/4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/resize?page=0&width=0&height=480&fit=cover&format=jpg":    400,
+		"/api/v2/jobs/4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/resize?page=0&width=640&height=480&fit=cover&format=tiff": 400,
+		"/api/v2/jobs/0B7E2A64-93C1-4D58-8F2A-6E1C5D9B3A70/resize?page=0&width=640&height=480&fit=cover&format=jpg":  404,
+	}
+	for path, statusCode := range requests {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		var view resizeUrlView
+		json.NewDecoder(res.Body).Decode(&view)
+		res.Body.Close()
+		if res.StatusCode != statusCode {
+			t.Error("Unexpected status code", res.StatusCode, "for", path)
+			continue
+		}
+		if statusCode != 200 {
+			continue
+		}
+		if !strings.HasPrefix(view.Url, "http://localhost:8080/resize/4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/0/640/480/cover/jpg?") {
+			t.Error("Unexpected resize url", view.Url)
+		}
+		if !signatureManager.IsValid(strings.TrimPrefix(view.Url, "http://localhost:8080")) {
+			t.Error("Resize url expected to be signed", view.Url)
+		}
+	}
+}
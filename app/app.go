@@ -35,6 +35,10 @@ type AppContext struct {
 	listener                     *stoppableListener.StoppableListener
 	negroni                      *negroni.Negroni
 	cassandraManager             *common.CassandraManager
+	boltManager                  *common.BoltManager
+	webhookManager               *render.WebhookManager
+	statusBroadcaster            *render.StatusBroadcaster
+	eventsBlueprint              api.Blueprint
 }
 
 func NewApp(appConfig config.AppConfig) (*AppContext, error) {
@@ -55,6 +59,10 @@ func NewApp(appConfig config.AppConfig) (*AppContext, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = app.initTemplates()
+	if err != nil {
+		return nil, err
+	}
 	err = app.initRenderers()
 	if err != nil {
 		return nil, err
@@ -134,16 +142,15 @@ func (app *AppContext) initTrams() error {
 }
 
 func (app *AppContext) initStorage() error {
-	// NKG: This is where local (in-memory) or cassandra backed storage is
+	// NKG: This is where local (in-memory), bolt or cassandra backed storage is
 	// configured and the SourceAssetStorageManager,
 	// GeneratedAssetStorageManager and TemplateManager objects are created
 	// and placed into the app context.
 
-	app.templateManager = common.NewTemplateManager()
-
 	switch app.appConfig.Storage().Engine() {
 	case "memory":
 		{
+			app.templateManager = common.NewTemplateManager()
 			app.sourceAssetStorageManager = common.NewSourceAssetStorageManager()
 			app.generatedAssetStorageManager = common.NewGeneratedAssetStorageManager(app.templateManager)
 			return nil
@@ -164,6 +171,10 @@ func (app *AppContext) initStorage() error {
 				return err
 			}
 			app.cassandraManager = cm
+			app.templateManager, err = common.NewCassandraTemplateManager(cm, keyspace)
+			if err != nil {
+				return err
+			}
 			app.sourceAssetStorageManager, err = common.NewCassandraSourceAssetStorageManager(cm, app.appConfig.Common().NodeId(), keyspace)
 			if err != nil {
 				return err
@@ -174,58 +185,264 @@ func (app *AppContext) initStorage() error {
 			}
 			return nil
 		}
+	case "bolt":
+		{
+			log.Println("Using bolt!")
+			boltPath, err := app.appConfig.Storage().BoltPath()
+			if err != nil {
+				return err
+			}
+			bm, err := common.NewBoltManager(boltPath)
+			if err != nil {
+				return err
+			}
+			app.boltManager = bm
+			app.templateManager, err = common.NewBoltTemplateManager(bm)
+			if err != nil {
+				return err
+			}
+			app.sourceAssetStorageManager, err = common.NewBoltSourceAssetStorageManager(bm, app.appConfig.Common().NodeId())
+			if err != nil {
+				return err
+			}
+			app.generatedAssetStorageManager, err = common.NewBoltGeneratedAssetStorageManager(bm, app.templateManager, app.appConfig.Common().NodeId())
+			if err != nil {
+				return err
+			}
+			return nil
+		}
 	}
 	return common.ErrorNotImplemented
 }
 
+func (app *AppContext) initTemplates() error {
+	// NKG: Templates defined in configuration are stored through the
+	// configured template manager, replacing any existing template with the
+	// same id, so that every node sharing storage sees the same set.
+	for _, templateConfig := range app.appConfig.Templates() {
+		template := &common.Template{Id: templateConfig.Id(), Renderer: templateConfig.Renderer(), Group: templateConfig.Group(), Attributes: make([]common.Attribute, 0, 0)}
+		for key, value := range templateConfig.Attributes() {
+			template.AddAttribute(key, value)
+		}
+		if len(templateConfig.FileTypes()) > 0 {
+			template.AddAttribute(common.TemplateAttributeFileTypes, templateConfig.FileTypes())
+		}
+		err := app.templateManager.Store(template)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newCommandLimits(commandLimitsAppConfig config.CommandLimitsAppConfig) render.CommandLimits {
+	return render.CommandLimits{
+		Timeout:       time.Duration(commandLimitsAppConfig.Timeout()) * time.Second,
+		CpuSeconds:    commandLimitsAppConfig.CpuLimit(),
+		MemoryBytes:   commandLimitsAppConfig.MemoryLimit(),
+		FileSizeBytes: commandLimitsAppConfig.FileSizeLimit(),
+	}
+}
+
 func (app *AppContext) initRenderers() error {
 	// NKG: This is where the RendererManager is constructed and renderers
 	// are configured and enabled through it.
 	app.agentManager = render.NewRenderAgentManager(app.registry, app.sourceAssetStorageManager, app.generatedAssetStorageManager, app.templateManager, app.temporaryFileManager, app.uploader, app.appConfig.Common().WorkDispatcherEnabled())
+	if app.appConfig.Retry().Enabled() {
+		retryConfig := app.appConfig.Retry()
+		app.agentManager.SetRetryPolicy(render.NewRetryPolicy(retryConfig.MaxAttempts(), time.Duration(retryConfig.Backoff())*time.Second, time.Duration(retryConfig.MaxBackoff())*time.Second, retryConfig.RetryableErrors()))
+	}
 	app.agentManager.SetRenderAgentInfo(common.RenderAgentImageMagick, app.appConfig.ImageMagickRenderAgent().Enabled(), app.appConfig.ImageMagickRenderAgent().Count())
 	app.agentManager.SetRenderAgentInfo(common.RenderAgentDocument, app.appConfig.DocumentRenderAgent().Enabled(), app.appConfig.DocumentRenderAgent().Count())
+	app.agentManager.SetRenderAgentInfo(common.RenderAgentNative, app.appConfig.NativeRenderAgent().Enabled(), app.appConfig.NativeRenderAgent().Count())
+	app.agentManager.SetRenderAgentInfo(common.RenderAgentVideo, app.appConfig.VideoRenderAgent().Enabled(), app.appConfig.VideoRenderAgent().Count())
+	app.agentManager.SetRenderAgentInfo(common.RenderAgentText, app.appConfig.TextRenderAgent().Enabled(), app.appConfig.TextRenderAgent().Count())
+	app.agentManager.SetRenderAgentInfo(common.RenderAgentSvg, app.appConfig.SvgRenderAgent().Enabled(), app.appConfig.SvgRenderAgent().Count())
+	app.agentManager.SetRenderAgentInfo(common.RenderAgentArchive, app.appConfig.ArchiveRenderAgent().Enabled(), app.appConfig.ArchiveRenderAgent().Count())
+	app.agentManager.SetRenderAgentInfo(common.RenderAgentAudio, app.appConfig.AudioRenderAgent().Enabled(), app.appConfig.AudioRenderAgent().Count())
 	if app.appConfig.ImageMagickRenderAgent().Enabled() {
+		limits := newCommandLimits(app.appConfig.ImageMagickRenderAgent())
 		for i := 0; i < app.appConfig.ImageMagickRenderAgent().Count(); i++ {
-			app.agentManager.AddImageMagickRenderAgent(app.downloader, app.uploader, 5)
+			app.agentManager.AddImageMagickRenderAgent(app.downloader, app.uploader, limits, 5)
 		}
 	}
 	if app.appConfig.DocumentRenderAgent().Enabled() {
+		limits := newCommandLimits(app.appConfig.DocumentRenderAgent())
+		pageLimits := make(map[string]int)
+		for fileType, pageLimit := range app.appConfig.DocumentRenderAgent().PageLimits() {
+			pageLimits[fileType] = int(pageLimit)
+		}
 		for i := 0; i < app.appConfig.DocumentRenderAgent().Count(); i++ {
-			app.agentManager.AddDocumentRenderAgent(app.downloader, app.uploader, app.appConfig.DocumentRenderAgent().BasePath(), 5)
+			app.agentManager.AddDocumentRenderAgent(app.downloader, app.uploader, app.appConfig.DocumentRenderAgent().BasePath(), pageLimits, limits, 5)
+		}
+	}
+	if app.appConfig.NativeRenderAgent().Enabled() {
+		for i := 0; i < app.appConfig.NativeRenderAgent().Count(); i++ {
+			app.agentManager.AddNativeRenderAgent(app.downloader, app.uploader, app.appConfig.Common().MaxImagePixels(), 5)
+		}
+	}
+	if app.appConfig.VideoRenderAgent().Enabled() {
+		videoConfig := app.appConfig.VideoRenderAgent()
+		limits := newCommandLimits(videoConfig)
+		for i := 0; i < videoConfig.Count(); i++ {
+			app.agentManager.AddVideoRenderAgent(app.downloader, app.uploader, videoConfig.FfmpegPath(), videoConfig.FfprobePath(), limits, 5)
+		}
+	}
+	if app.appConfig.TextRenderAgent().Enabled() {
+		for i := 0; i < app.appConfig.TextRenderAgent().Count(); i++ {
+			app.agentManager.AddTextRenderAgent(app.downloader, app.uploader, 5)
 		}
 	}
+	if app.appConfig.SvgRenderAgent().Enabled() {
+		svgConfig := app.appConfig.SvgRenderAgent()
+		limits := newCommandLimits(svgConfig)
+		for i := 0; i < svgConfig.Count(); i++ {
+			app.agentManager.AddSvgRenderAgent(app.downloader, app.uploader, svgConfig.RsvgConvertPath(), limits, 5)
+		}
+	}
+	if app.appConfig.ArchiveRenderAgent().Enabled() {
+		for i := 0; i < app.appConfig.ArchiveRenderAgent().Count(); i++ {
+			app.agentManager.AddArchiveRenderAgent(app.downloader, app.uploader, app.appConfig.ArchiveRenderAgent().ImagePreviews(), app.appConfig.Common().MaxImagePixels(), 5)
+		}
+	}
+	if app.appConfig.AudioRenderAgent().Enabled() {
+		audioConfig := app.appConfig.AudioRenderAgent()
+		limits := newCommandLimits(audioConfig)
+		for i := 0; i < audioConfig.Count(); i++ {
+			app.agentManager.AddAudioRenderAgent(app.downloader, app.uploader, audioConfig.FfmpegPath(), audioConfig.FfprobePath(), limits, 5)
+		}
+	}
+	app.initRouting()
+	if app.appConfig.Downloader().DetectFileTypes() {
+		app.agentManager.EnableFileTypeDetection(app.downloader, time.Duration(app.appConfig.Downloader().DetectTimeout())*time.Second)
+	}
+	if app.appConfig.Reaper().Enabled() {
+		reaperConfig := app.appConfig.Reaper()
+		app.agentManager.EnableReaper(app.appConfig.Common().NodeId(), time.Duration(reaperConfig.LeaseTimeout())*time.Second, time.Duration(reaperConfig.Interval())*time.Second, reaperConfig.MaxAttempts())
+	}
+	if app.appConfig.Retention().Enabled() {
+		retentionConfig := app.appConfig.Retention()
+		retention := make(map[string]time.Duration)
+		for fileType, seconds := range retentionConfig.FileTypes() {
+			retention[fileType] = time.Duration(seconds) * time.Second
+		}
+		app.agentManager.SetRetention(retention)
+		app.agentManager.EnableSweeper(time.Duration(retentionConfig.Interval()) * time.Second)
+	}
+	if app.appConfig.Webhooks().Enabled() {
+		webhooksConfig := app.appConfig.Webhooks()
+		app.webhookManager = render.NewWebhookManager(app.sourceAssetStorageManager, app.generatedAssetStorageManager, webhooksConfig.Secret(), webhooksConfig.CallbackHosts(), webhooksConfig.MaxAttempts(), time.Duration(webhooksConfig.Backoff())*time.Second, time.Duration(webhooksConfig.Timeout())*time.Second, webhooksConfig.AssetEvents())
+		app.agentManager.AddListener(app.webhookManager.Listener())
+	}
+	app.statusBroadcaster = render.NewStatusBroadcaster()
+	app.agentManager.AddListener(app.statusBroadcaster.Listener())
 	return nil
 }
 
+func (app *AppContext) initRouting() {
+	// NKG: Routes defined in configuration take precedence over the routes
+	// created from the file types supported by each render agent.
+	for _, routeConfig := range app.appConfig.Routing() {
+		route := &render.Route{
+			FileTypes:   routeConfig.FileTypes(),
+			MimeTypes:   routeConfig.MimeTypes(),
+			Renderer:    routeConfig.Renderer(),
+			TemplateIds: routeConfig.Templates(),
+			MaxSize:     routeConfig.MaxSize(),
+		}
+		app.agentManager.AddRoute(route)
+	}
+	// NKG: When the native render agent is enabled, it takes precedence
+	// over the ImageMagick render agent for the file types it supports.
+	if app.appConfig.NativeRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.NativeRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentNative, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.ImageMagickRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.ImageMagickRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentImageMagick, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.DocumentRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.DocumentRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentDocument, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.VideoRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.VideoRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentVideo, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.TextRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.TextRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentText, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.SvgRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.SvgRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentSvg, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.ArchiveRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.ArchiveRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentArchive, fileType, maxFileSize))
+		}
+	}
+	if app.appConfig.AudioRenderAgent().Enabled() {
+		for fileType, maxFileSize := range app.appConfig.AudioRenderAgent().SupportedFileTypes() {
+			app.agentManager.AddRoute(render.NewRendererRoute(common.RenderAgentAudio, fileType, maxFileSize))
+		}
+	}
+}
+
 func (app *AppContext) initApis() error {
 	// NKG: This is where different APIs are configured and enabled.
 
-	allSupportedFileTypes := make(map[string]int64)
-	for fileType, maxFileSize := range app.appConfig.ImageMagickRenderAgent().SupportedFileTypes() {
-		allSupportedFileTypes[fileType] = maxFileSize
-	}
+	var err error
 
-	app.signatureManager = api.NewSignatureManager()
+	signatureConfig := app.appConfig.Signature()
+	app.signatureManager, err = api.NewSignatureManager(signatureConfig.Keys(), signatureConfig.ActiveKey(), time.Duration(signatureConfig.Ttl())*time.Second)
+	if err != nil {
+		return err
+	}
 
-	var err error
+	// NKG: Resize urls must be signed with a key that every node shares,
+	// otherwise a url handed out by one node is rejected by the others.
+	var resizer *render.Resizer
+	if len(signatureConfig.Keys()) > 0 {
+		resizer = render.NewResizer(app.sourceAssetStorageManager, app.generatedAssetStorageManager, app.templateManager, app.temporaryFileManager, app.downloader, app.uploader, app.appConfig.Common().MaxImagePixels())
+		if app.appConfig.ImageMagickRenderAgent().Enabled() {
+			resizer.EnableImageMagick(newCommandLimits(app.appConfig.ImageMagickRenderAgent()))
+		}
+	} else {
+		log.Println("No signature keys configured, resizing is disabled.")
+	}
 
 	p := pat.New()
 
 	if app.appConfig.SimpleApi().Enabled() {
-		app.simpleBlueprint, err = api.NewSimpleBlueprint(app.registry, app.appConfig.SimpleApi().BaseUrl(), app.appConfig.SimpleApi().EdgeBaseUrl(), app.agentManager, app.sourceAssetStorageManager, app.generatedAssetStorageManager, app.templateManager, app.placeholderManager, app.signatureManager, allSupportedFileTypes)
+		app.simpleBlueprint, err = api.NewSimpleBlueprint(app.registry, app.appConfig.SimpleApi().BaseUrl(), app.appConfig.SimpleApi().EdgeBaseUrl(), app.agentManager, app.sourceAssetStorageManager, app.generatedAssetStorageManager, app.templateManager, app.placeholderManager, app.signatureManager, app.appConfig.Webhooks().CallbackHosts(), resizer)
 		if err != nil {
 			return err
 		}
 		app.simpleBlueprint.AddRoutes(p)
+
+		app.eventsBlueprint = api.NewEventsBlueprint(app.registry, app.appConfig.SimpleApi().BaseUrl(), app.statusBroadcaster)
+		app.eventsBlueprint.AddRoutes(p)
 	}
 
-	app.assetBlueprint = api.NewAssetBlueprint(app.registry, app.appConfig.Common().LocalAssetStoragePath(), app.sourceAssetStorageManager, app.generatedAssetStorageManager, app.templateManager, app.placeholderManager, app.buildS3Client(), app.signatureManager)
+	renderOnReadTimeout := time.Duration(0)
+	if app.appConfig.AssetApi().RenderOnRead() {
+		renderOnReadTimeout = time.Duration(app.appConfig.AssetApi().RenderOnReadTimeout()) * time.Second
+	}
+	app.assetBlueprint = api.NewAssetBlueprint(app.registry, app.appConfig.Common().LocalAssetStoragePath(), app.sourceAssetStorageManager, app.generatedAssetStorageManager, app.templateManager, app.placeholderManager, app.buildS3Client(), app.signatureManager, signatureConfig.Verify(), app.agentManager, renderOnReadTimeout, resizer)
 	app.assetBlueprint.AddRoutes(p)
 
-	app.adminBlueprint = api.NewAdminBlueprint(app.registry, app.appConfig, app.placeholderManager, app.temporaryFileManager, app.agentManager)
+	app.adminBlueprint = api.NewAdminBlueprint(app.registry, app.appConfig, app.placeholderManager, app.temporaryFileManager, app.agentManager, app.templateManager, app.webhookManager)
 	app.adminBlueprint.AddRoutes(p)
 
-	app.staticBlueprint = api.NewStaticBlueprint(app.placeholderManager)
+	app.staticBlueprint = api.NewStaticBlueprint(app.placeholderManager, app.signatureManager, signatureConfig.Verify())
 	app.staticBlueprint.AddRoutes(p)
 
 	app.negroni = negroni.Classic()
@@ -236,9 +453,16 @@ func (app *AppContext) initApis() error {
 
 func (app *AppContext) Stop() {
 	app.agentManager.Stop()
+	if app.webhookManager != nil {
+		app.webhookManager.Stop()
+	}
+	app.statusBroadcaster.Stop()
 	if app.cassandraManager != nil {
 		app.cassandraManager.Stop()
 	}
+	if app.boltManager != nil {
+		app.boltManager.Stop()
+	}
 	app.listener.Stop <- true
 }
 
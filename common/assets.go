@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"github.com/ngerakines/preview/util"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -72,9 +74,58 @@ var (
 	SourceAssetAttributeSize = "size"
 	// SourceAssetAttributePages is a constant for the pages attribute that can be set for source assets.
 	SourceAssetAttributePages = "pages"
+	// SourceAssetAttributeCallbackUrl is a constant for the callbackUrl attribute that can be set for source assets. When set, notifications are sent to the url as generated assets are completed.
+	SourceAssetAttributeCallbackUrl = "callbackUrl"
+	// SourceAssetAttributeExpires is a constant for the expires attribute that records the time, in nanoseconds, after which a source asset and its generated assets are deleted.
+	SourceAssetAttributeExpires = "expires"
+	// SourceAssetAttributeDerivatives is a constant for the derivatives attribute that records the locations of resized images created on request, so that they are deleted with the source asset.
+	SourceAssetAttributeDerivatives = "derivatives"
+	// SourceAssetAttributeDeclaredType is a constant for the declaredType attribute that records the file type given when the preview was requested.
+	SourceAssetAttributeDeclaredType = "declaredType"
+	// SourceAssetAttributeDetectedType is a constant for the detectedType attribute that records the file type detected from the contents of the file.
+	SourceAssetAttributeDetectedType = "detectedType"
+	// SourceAssetAttributeNotified is a constant for the notified attribute that records the completions of a source asset that a notification has been sent for.
+	SourceAssetAttributeNotified = "notified"
+	// SourceAssetAttributeDuration is a constant for the duration attribute that records the length, in seconds, of a video or audio file.
+	SourceAssetAttributeDuration = "duration"
+	// SourceAssetAttributeResolution is a constant for the resolution attribute that records the width and height of a video, i.e. "1920x1080".
+	SourceAssetAttributeResolution = "resolution"
+	// SourceAssetAttributeTruncated is a constant for the truncated attribute that records that only part of a file was previewed, such as the pages of a document up to its page limit or the first entries of a large archive.
+	SourceAssetAttributeTruncated = "truncated"
+	// SourceAssetAttributeRenderedPages is a constant for the renderedPages attribute that records the number of pages of a document that were rendered.
+	SourceAssetAttributeRenderedPages = "renderedPages"
+	// SourceAssetAttributeEntryCount is a constant for the entryCount attribute that records the number of files and directories in an archive.
+	SourceAssetAttributeEntryCount = "entryCount"
+	// SourceAssetAttributeEntries is a constant for the entries attribute that records the names of the files and directories in an archive.
+	SourceAssetAttributeEntries = "entries"
+	// SourceAssetAttributeEntrySizes is a constant for the entrySizes attribute that records the uncompressed size, in bytes, of each of the entries of an archive.
+	SourceAssetAttributeEntrySizes = "entrySizes"
+	// SourceAssetAttributeSampleRate is a constant for the sampleRate attribute that records the number of samples per second of an audio file.
+	SourceAssetAttributeSampleRate = "sampleRate"
+	// SourceAssetAttributeChannels is a constant for the channels attribute that records the number of channels of an audio file.
+	SourceAssetAttributeChannels = "channels"
 
 	// GeneratedAssetAttributePage is a constant for the page attribute that can be set for generated assets.
 	GeneratedAssetAttributePage = "page"
+	// GeneratedAssetAttributeAttempts is a constant for the attempts attribute that records how many times a generated asset has been recovered after its lease expired.
+	GeneratedAssetAttributeAttempts = "attempts"
+	// GeneratedAssetAttributeRetryAttempts is a constant for the retryAttempts attribute that records how many times rendering a generated asset has failed with a retryable error.
+	GeneratedAssetAttributeRetryAttempts = "retryAttempts"
+	// GeneratedAssetAttributeNotBefore is a constant for the notBefore attribute that records the time, in nanoseconds, before which a waiting generated asset should not be rendered.
+	GeneratedAssetAttributeNotBefore = "notBefore"
+	// GeneratedAssetAttributeDeadLetter is a constant for the deadLetter attribute that is set when a generated asset has failed and will not be retried because it has been attempted too many times.
+	GeneratedAssetAttributeDeadLetter = "deadLetter"
+	// GeneratedAssetAttributeOutputs is a constant for the outputs attribute that lists the output formats a generated asset was rendered in. The first output format is uploaded to the location of the generated asset.
+	GeneratedAssetAttributeOutputs = "outputs"
+
+	// GeneratedAssetsStatePending is the state of a set of generated assets when none have completed.
+	GeneratedAssetsStatePending = "pending"
+	// GeneratedAssetsStatePartial is the state of a set of generated assets when some, but not all, have completed.
+	GeneratedAssetsStatePartial = "partial"
+	// GeneratedAssetsStateComplete is the state of a set of generated assets when all have completed.
+	GeneratedAssetsStateComplete = "complete"
+	// GeneratedAssetsStateFailed is the state of a set of generated assets when all have failed.
+	GeneratedAssetsStateFailed = "failed"
 
 	// SourceAssetTypeOrigin is a constant that represents origin types for source assets.
 	SourceAssetTypeOrigin = "origin"
@@ -82,6 +133,66 @@ var (
 	SourceAssetTypePdf = "pdf"
 )
 
+// GeneratedAssetsState returns the aggregate state of a set of generated assets, usually all of the generated assets for a source asset.
+func GeneratedAssetsState(generatedAssets []*GeneratedAsset) string {
+	complete := 0
+	failed := 0
+	for _, generatedAsset := range generatedAssets {
+		if generatedAsset.Status == GeneratedAssetStatusComplete {
+			complete++
+		} else if strings.HasPrefix(generatedAsset.Status, GeneratedAssetStatusFailed) {
+			failed++
+		}
+	}
+	switch {
+	case len(generatedAssets) == 0:
+		return GeneratedAssetsStatePending
+	case complete == len(generatedAssets):
+		return GeneratedAssetsStateComplete
+	case failed == len(generatedAssets):
+		return GeneratedAssetsStateFailed
+	case complete > 0:
+		return GeneratedAssetsStatePartial
+	}
+	return GeneratedAssetsStatePending
+}
+
+// IsGeneratedAssetsFinal returns true if every generated asset has either completed or failed.
+func IsGeneratedAssetsFinal(generatedAssets []*GeneratedAsset) bool {
+	for _, generatedAsset := range generatedAssets {
+		if generatedAsset.Status != GeneratedAssetStatusComplete && !strings.HasPrefix(generatedAsset.Status, GeneratedAssetStatusFailed) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsGeneratedAssetReady returns true if a generated asset does not have a not before time or the not before time is before the given time, in nanoseconds.
+func IsGeneratedAssetReady(generatedAsset *GeneratedAsset, now int64) bool {
+	value, err := GetFirstAttribute(generatedAsset, GeneratedAssetAttributeNotBefore)
+	if err != nil {
+		return true
+	}
+	notBefore, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return true
+	}
+	return notBefore <= now
+}
+
+// IsSourceAssetExpired returns true if a source asset has an expires time that is before the given time, in nanoseconds.
+func IsSourceAssetExpired(sourceAsset *SourceAsset, now int64) bool {
+	value, err := GetFirstAttribute(sourceAsset, SourceAssetAttributeExpires)
+	if err != nil {
+		return false
+	}
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	return expires < now
+}
+
 // NewSourceAsset creates a new source asset, filling in default values for everything but the id, type and location.
 func NewSourceAsset(id, idType string) (*SourceAsset, error) {
 	now := time.Now().UnixNano()
@@ -153,6 +264,19 @@ func (sa *SourceAsset) AddAttribute(name string, value []string) Attribute {
 	return attribute
 }
 
+// SetAttribute replaces the value of an existing attribute or adds it if it does not exist.
+func (sa *SourceAsset) SetAttribute(name string, value []string) Attribute {
+	attribute := Attribute{name, value}
+	for index, existing := range sa.Attributes {
+		if existing.Key == name {
+			sa.Attributes[index] = attribute
+			return attribute
+		}
+	}
+	sa.Attributes = append(sa.Attributes, attribute)
+	return attribute
+}
+
 func (sa *SourceAsset) HasAttribute(name string) bool {
 	for _, attribute := range sa.Attributes {
 		if attribute.Key == name {
@@ -193,6 +317,19 @@ func (ga *GeneratedAsset) AddAttribute(name string, value []string) Attribute {
 	return attribute
 }
 
+// SetAttribute replaces the value of an existing attribute or adds it if it does not exist.
+func (ga *GeneratedAsset) SetAttribute(name string, value []string) Attribute {
+	attribute := Attribute{name, value}
+	for index, existing := range ga.Attributes {
+		if existing.Key == name {
+			ga.Attributes[index] = attribute
+			return attribute
+		}
+	}
+	ga.Attributes = append(ga.Attributes, attribute)
+	return attribute
+}
+
 func (ga *GeneratedAsset) HasAttribute(name string) bool {
 	for _, attribute := range ga.Attributes {
 		if attribute.Key == name {
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"testing"
+)
+
+func newStatusGeneratedAssets(statuses ...string) []*GeneratedAsset {
+	generatedAssets := make([]*GeneratedAsset, 0, 0)
+	for _, status := range statuses {
+		generatedAssets = append(generatedAssets, &GeneratedAsset{Status: status})
+	}
+	return generatedAssets
+}
+
+func TestGeneratedAssetsState(t *testing.T) {
+	failed := NewGeneratedAssetError(ErrorNoDownloadUrlsWork)
+	tests := []struct {
+		statuses []string
+		state    string
+	}{
+		{[]string{}, GeneratedAssetsStatePending},
+		{[]string{"waiting", "processing"}, GeneratedAssetsStatePending},
+		{[]string{"waiting", failed}, GeneratedAssetsStatePending},
+		{[]string{"complete", "waiting"}, GeneratedAssetsStatePartial},
+		{[]string{"complete", failed}, GeneratedAssetsStatePartial},
+		{[]string{"complete", "complete"}, GeneratedAssetsStateComplete},
+		{[]string{failed, failed}, GeneratedAssetsStateFailed},
+	}
+	for _, test := range tests {
+		state := GeneratedAssetsState(newStatusGeneratedAssets(test.statuses...))
+		if state != test.state {
+			t.Errorf("Unexpected state for %v: %s, expected %s", test.statuses, state, test.state)
+		}
+	}
+}
+
+func TestParseGeneratedAssetError(t *testing.T) {
+	codedError, ok := ParseGeneratedAssetError(NewGeneratedAssetError(ErrorFileTooLarge))
+	if !ok || codedError != ErrorFileTooLarge {
+		t.Error("Could not parse generated asset error", codedError)
+	}
+	_, ok = ParseGeneratedAssetError(GeneratedAssetStatusComplete)
+	if ok {
+		t.Error("Complete status should not parse as an error")
+	}
+	_, ok = ParseGeneratedAssetError("failed,PRVCOM9999")
+	if ok {
+		t.Error("Unknown error code should not parse as an error")
+	}
+}
+
+func TestIsGeneratedAssetsFinal(t *testing.T) {
+	failed := NewGeneratedAssetError(ErrorNoDownloadUrlsWork)
+	if !IsGeneratedAssetsFinal(newStatusGeneratedAssets("complete", failed)) {
+		t.Error("Complete and failed generated assets should be final")
+	}
+	if IsGeneratedAssetsFinal(newStatusGeneratedAssets("complete", "processing")) {
+		t.Error("Processing generated assets should not be final")
+	}
+}
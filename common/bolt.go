@@ -0,0 +1,609 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"strings"
+	"time"
+)
+
+// BoltManager owns the single file database used by the "bolt" storage engine.
+type BoltManager struct {
+	db *bolt.DB
+}
+
+type boltSourceAssetStorageManager struct {
+	boltManager *BoltManager
+	nodeId      string
+}
+
+type boltGeneratedAssetStorageManager struct {
+	boltManager     *BoltManager
+	templateManager TemplateManager
+	nodeId          string
+}
+
+type boltTemplateManager struct {
+	boltManager *BoltManager
+}
+
+/*
+The bolt storage engine uses the following buckets:
+
+source_assets               [id, type] -> source asset json
+generated_assets            [id] -> generated asset json
+generated_assets_by_source  [source id, id] -> nil
+waiting_generated_assets    [template group, id] -> nil
+active_generated_assets     [id] -> nil
+templates                   [id] -> template json
+*/
+var (
+	boltBucketSourceAssets            = []byte("source_assets")
+	boltBucketGeneratedAssets         = []byte("generated_assets")
+	boltBucketGeneratedAssetsBySource = []byte("generated_assets_by_source")
+	boltBucketWaitingGeneratedAssets  = []byte("waiting_generated_assets")
+	boltBucketActiveGeneratedAssets   = []byte("active_generated_assets")
+	boltBucketTemplates               = []byte("templates")
+
+	boltBuckets = [][]byte{
+		boltBucketSourceAssets,
+		boltBucketGeneratedAssets,
+		boltBucketGeneratedAssetsBySource,
+		boltBucketWaitingGeneratedAssets,
+		boltBucketActiveGeneratedAssets,
+		boltBucketTemplates,
+	}
+)
+
+// NewBoltManager opens, or creates, the database file at the given path and ensures that all of the buckets exist.
+func NewBoltManager(path string) (*BoltManager, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range boltBuckets {
+			_, err := tx.CreateBucketIfNotExists(bucket)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	bm := new(BoltManager)
+	bm.db = db
+	return bm, nil
+}
+
+func NewBoltSourceAssetStorageManager(bm *BoltManager, nodeId string) (SourceAssetStorageManager, error) {
+	bsasm := new(boltSourceAssetStorageManager)
+	bsasm.boltManager = bm
+	bsasm.nodeId = nodeId
+	return bsasm, nil
+}
+
+func NewBoltGeneratedAssetStorageManager(bm *BoltManager, templateManager TemplateManager, nodeId string) (GeneratedAssetStorageManager, error) {
+	bgasm := new(boltGeneratedAssetStorageManager)
+	bgasm.boltManager = bm
+	bgasm.templateManager = templateManager
+	bgasm.nodeId = nodeId
+	return bgasm, nil
+}
+
+// NewBoltTemplateManager creates a template manager backed by the bolt database. The default templates are stored if they do not already exist.
+func NewBoltTemplateManager(bm *BoltManager) (TemplateManager, error) {
+	tm := new(boltTemplateManager)
+	tm.boltManager = bm
+	for _, template := range DefaultTemplates() {
+		existing, err := tm.FindByIds([]string{template.Id})
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) == 0 {
+			err = tm.Store(template)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tm, nil
+}
+
+// Stop closes the underlying database file.
+func (bm *BoltManager) Stop() {
+	err := bm.db.Close()
+	if err != nil {
+		log.Println("Error closing bolt database:", err)
+	}
+}
+
+// NKG: Keys are composed of parts separated by a null byte so that prefix
+// scans for a given source asset or template group don't match ids that
+// merely start with the same characters.
+func boltKey(parts ...string) []byte {
+	return []byte(strings.Join(parts, "\x00"))
+}
+
+func boltKeyPrefix(parts ...string) []byte {
+	return append(boltKey(parts...), 0)
+}
+
+func boltKeySuffix(key, prefix []byte) string {
+	return string(key[len(prefix):])
+}
+
+func (sasm *boltSourceAssetStorageManager) Store(sourceAsset *SourceAsset) error {
+	sourceAsset.CreatedBy = sasm.nodeId
+	sourceAsset.UpdatedBy = sasm.nodeId
+	payload, err := sourceAsset.Serialize()
+	if err != nil {
+		log.Println("Error serializing source asset:", err)
+		return err
+	}
+	return sasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketSourceAssets).Put(boltKey(sourceAsset.Id, sourceAsset.IdType), payload)
+	})
+}
+
+func (sasm *boltSourceAssetStorageManager) FindBySourceAssetId(id string) ([]*SourceAsset, error) {
+	results := make([]*SourceAsset, 0, 0)
+	err := sasm.boltManager.db.View(func(tx *bolt.Tx) error {
+		prefix := boltKeyPrefix(id)
+		c := tx.Bucket(boltBucketSourceAssets).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			sourceAsset, err := newSourceAssetFromJson(v)
+			if err != nil {
+				return err
+			}
+			results = append(results, sourceAsset)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (sasm *boltSourceAssetStorageManager) FindExpired(now int64) ([]string, error) {
+	results := make([]string, 0, 0)
+	err := sasm.boltManager.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketSourceAssets).ForEach(func(k, v []byte) error {
+			sourceAsset, err := newSourceAssetFromJson(v)
+			if err != nil {
+				return err
+			}
+			if sourceAsset.IdType == SourceAssetTypeOrigin && IsSourceAssetExpired(sourceAsset, now) {
+				results = append(results, sourceAsset.Id)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete removes all of the source assets with the given id.
+func (sasm *boltSourceAssetStorageManager) Delete(id string) error {
+	return sasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketSourceAssets)
+		prefix := boltKeyPrefix(id)
+		keys := make([][]byte, 0, 0)
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, key := range keys {
+			err := bucket.Delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (gasm *boltGeneratedAssetStorageManager) Store(generatedAsset *GeneratedAsset) error {
+	generatedAsset.CreatedBy = gasm.nodeId
+	generatedAsset.UpdatedBy = gasm.nodeId
+	templateGroup, err := gasm.templateGroup(generatedAsset.TemplateId)
+	if err != nil {
+		return err
+	}
+	payload, err := generatedAsset.Serialize()
+	if err != nil {
+		log.Println("Error serializing generated asset:", err)
+		return err
+	}
+	return gasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltBucketGeneratedAssets).Put(boltKey(generatedAsset.Id), payload)
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltBucketGeneratedAssetsBySource).Put(boltKey(generatedAsset.SourceAssetId, generatedAsset.Id), []byte{})
+		if err != nil {
+			return err
+		}
+		return gasm.index(tx, generatedAsset, templateGroup)
+	})
+}
+
+func (gasm *boltGeneratedAssetStorageManager) Update(generatedAsset *GeneratedAsset) error {
+	generatedAsset.UpdatedAt = time.Now().UnixNano()
+	generatedAsset.UpdatedBy = gasm.nodeId
+	templateGroup, err := gasm.templateGroup(generatedAsset.TemplateId)
+	if err != nil {
+		return err
+	}
+	payload, err := generatedAsset.Serialize()
+	if err != nil {
+		log.Println("Error serializing generated asset:", err)
+		return err
+	}
+	return gasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketGeneratedAssets)
+		if bucket.Get(boltKey(generatedAsset.Id)) == nil {
+			return ErrorGeneratedAssetCouldNotBeUpdated
+		}
+		err := bucket.Put(boltKey(generatedAsset.Id), payload)
+		if err != nil {
+			return err
+		}
+		return gasm.index(tx, generatedAsset, templateGroup)
+	})
+}
+
+func (gasm *boltGeneratedAssetStorageManager) UpdateStatus(id, fromStatus, toStatus string) error {
+	generatedAsset, err := gasm.FindById(id)
+	if err != nil {
+		return err
+	}
+	templateGroup, err := gasm.templateGroup(generatedAsset.TemplateId)
+	if err != nil {
+		return err
+	}
+	return gasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketGeneratedAssets)
+		payload := bucket.Get(boltKey(id))
+		if payload == nil {
+			return ErrorGeneratedAssetCouldNotBeUpdated
+		}
+		generatedAsset, err := newGeneratedAssetFromJson(payload)
+		if err != nil {
+			return err
+		}
+		if generatedAsset.Status != fromStatus {
+			return ErrorGeneratedAssetStatusChanged
+		}
+		generatedAsset.Status = toStatus
+		generatedAsset.UpdatedAt = time.Now().UnixNano()
+		generatedAsset.UpdatedBy = gasm.nodeId
+		payload, err = generatedAsset.Serialize()
+		if err != nil {
+			return err
+		}
+		err = bucket.Put(boltKey(id), payload)
+		if err != nil {
+			return err
+		}
+		return gasm.index(tx, generatedAsset, templateGroup)
+	})
+}
+
+// index places the generated asset into the waiting or active bucket based on its status, removing it from the other.
+func (gasm *boltGeneratedAssetStorageManager) index(tx *bolt.Tx, generatedAsset *GeneratedAsset, templateGroup string) error {
+	waiting := tx.Bucket(boltBucketWaitingGeneratedAssets)
+	active := tx.Bucket(boltBucketActiveGeneratedAssets)
+	waitingKey := boltKey(templateGroup, generatedAsset.Id)
+	activeKey := boltKey(generatedAsset.Id)
+
+	switch generatedAsset.Status {
+	case GeneratedAssetStatusWaiting:
+		err := active.Delete(activeKey)
+		if err != nil {
+			return err
+		}
+		return waiting.Put(waitingKey, []byte{})
+	case GeneratedAssetStatusScheduled, GeneratedAssetStatusProcessing:
+		err := waiting.Delete(waitingKey)
+		if err != nil {
+			return err
+		}
+		return active.Put(activeKey, []byte{})
+	}
+	err := waiting.Delete(waitingKey)
+	if err != nil {
+		return err
+	}
+	return active.Delete(activeKey)
+}
+
+func (gasm *boltGeneratedAssetStorageManager) templateGroup(id string) (string, error) {
+	templates, err := gasm.templateManager.FindByIds([]string{id})
+	if err != nil {
+		return "", err
+	}
+	if len(templates) != 1 {
+		return "", ErrorNoTemplateForId
+	}
+	return templates[0].Group, nil
+}
+
+func (gasm *boltGeneratedAssetStorageManager) FindById(id string) (*GeneratedAsset, error) {
+	generatedAssets, err := gasm.FindByIds([]string{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(generatedAssets) == 0 {
+		return nil, ErrorNoGeneratedAssetsFoundForId
+	}
+	return generatedAssets[0], nil
+}
+
+func (gasm *boltGeneratedAssetStorageManager) FindByIds(ids []string) ([]*GeneratedAsset, error) {
+	results := make([]*GeneratedAsset, 0, 0)
+	err := gasm.boltManager.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketGeneratedAssets)
+		for _, id := range ids {
+			payload := bucket.Get(boltKey(id))
+			if payload == nil {
+				continue
+			}
+			generatedAsset, err := newGeneratedAssetFromJson(payload)
+			if err != nil {
+				return err
+			}
+			results = append(results, generatedAsset)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (gasm *boltGeneratedAssetStorageManager) FindBySourceAssetId(id string) ([]*GeneratedAsset, error) {
+	ids := make([]string, 0, 0)
+	err := gasm.boltManager.db.View(func(tx *bolt.Tx) error {
+		prefix := boltKeyPrefix(id)
+		c := tx.Bucket(boltBucketGeneratedAssetsBySource).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			ids = append(ids, boltKeySuffix(k, prefix))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gasm.FindByIds(ids)
+}
+
+// FindWorkForService claims up to workCount waiting generated assets for the templates of a render service, marking them as scheduled in the same transaction.
+func (gasm *boltGeneratedAssetStorageManager) FindWorkForService(serviceName string, workCount int) ([]*GeneratedAsset, error) {
+	templates, err := gasm.templateManager.FindByRenderService(serviceName)
+	if err != nil {
+		log.Println("error executing templateManager.FindByRenderService", err)
+		return nil, err
+	}
+	groups := make(map[string]bool)
+	for _, template := range templates {
+		groups[template.Group] = true
+	}
+
+	now := time.Now().UnixNano()
+	results := make([]*GeneratedAsset, 0, 0)
+	err = gasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		waiting := tx.Bucket(boltBucketWaitingGeneratedAssets)
+		generatedAssets := tx.Bucket(boltBucketGeneratedAssets)
+		for group := range groups {
+			prefix := boltKeyPrefix(group)
+			ids := make([]string, 0, 0)
+			claimed := make([]*GeneratedAsset, 0, 0)
+			c := waiting.Cursor()
+			for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && len(results)+len(claimed) < workCount; k, _ = c.Next() {
+				id := boltKeySuffix(k, prefix)
+				payload := generatedAssets.Get(boltKey(id))
+				if payload == nil {
+					ids = append(ids, id)
+					continue
+				}
+				generatedAsset, err := newGeneratedAssetFromJson(payload)
+				if err != nil {
+					return err
+				}
+				if generatedAsset.Status != GeneratedAssetStatusWaiting {
+					ids = append(ids, id)
+					continue
+				}
+				// NKG: Generated assets that are waiting to be retried stay
+				// in the waiting bucket until their not before time passes.
+				if !IsGeneratedAssetReady(generatedAsset, now) {
+					continue
+				}
+				ids = append(ids, id)
+				claimed = append(claimed, generatedAsset)
+			}
+			for _, id := range ids {
+				err := waiting.Delete(boltKey(group, id))
+				if err != nil {
+					return err
+				}
+			}
+			for _, generatedAsset := range claimed {
+				id := generatedAsset.Id
+				generatedAsset.Status = GeneratedAssetStatusScheduled
+				generatedAsset.UpdatedAt = time.Now().UnixNano()
+				generatedAsset.UpdatedBy = gasm.nodeId
+				payload, err := generatedAsset.Serialize()
+				if err != nil {
+					return err
+				}
+				err = generatedAssets.Put(boltKey(id), payload)
+				if err != nil {
+					return err
+				}
+				err = tx.Bucket(boltBucketActiveGeneratedAssets).Put(boltKey(id), []byte{})
+				if err != nil {
+					return err
+				}
+				results = append(results, generatedAsset)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error finding work for service", serviceName, err)
+		return nil, err
+	}
+	log.Println("generated assets for service", serviceName, ":", buildGeneratedAssetIds(results))
+	return results, nil
+}
+
+func (gasm *boltGeneratedAssetStorageManager) FindActive() ([]*GeneratedAsset, error) {
+	ids := make([]string, 0, 0)
+	err := gasm.boltManager.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketActiveGeneratedAssets).ForEach(func(k, v []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gasm.FindByIds(ids)
+}
+
+func (gasm *boltGeneratedAssetStorageManager) FindByStatus(status string) ([]*GeneratedAsset, error) {
+	results := make([]*GeneratedAsset, 0, 0)
+	err := gasm.boltManager.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketGeneratedAssets).ForEach(func(k, v []byte) error {
+			generatedAsset, err := newGeneratedAssetFromJson(v)
+			if err != nil {
+				return err
+			}
+			if generatedAsset.Status == status {
+				results = append(results, generatedAsset)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete removes the generated asset and its entries in the source, waiting and active buckets.
+func (gasm *boltGeneratedAssetStorageManager) Delete(id string) error {
+	generatedAsset, err := gasm.FindById(id)
+	if err != nil {
+		return err
+	}
+	// NKG: The template group is looked up before the update transaction
+	// is started because the template manager uses the same database.
+	templateGroup, templateErr := gasm.templateGroup(generatedAsset.TemplateId)
+	return gasm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(boltBucketGeneratedAssets).Delete(boltKey(id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltBucketGeneratedAssetsBySource).Delete(boltKey(generatedAsset.SourceAssetId, id))
+		if err != nil {
+			return err
+		}
+		err = tx.Bucket(boltBucketActiveGeneratedAssets).Delete(boltKey(id))
+		if err != nil {
+			return err
+		}
+		if templateErr == nil {
+			return tx.Bucket(boltBucketWaitingGeneratedAssets).Delete(boltKey(templateGroup, id))
+		}
+		return nil
+	})
+}
+
+func (tm *boltTemplateManager) Store(template *Template) error {
+	payload, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	return tm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketTemplates).Put(boltKey(template.Id), payload)
+	})
+}
+
+func (tm *boltTemplateManager) FindAll() ([]*Template, error) {
+	results := make([]*Template, 0, 0)
+	err := tm.boltManager.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucketTemplates).ForEach(func(k, v []byte) error {
+			var template Template
+			err := json.Unmarshal(v, &template)
+			if err != nil {
+				return err
+			}
+			results = append(results, &template)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (tm *boltTemplateManager) Delete(id string) error {
+	return tm.boltManager.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketTemplates)
+		if bucket.Get(boltKey(id)) == nil {
+			return ErrorNoTemplateForId
+		}
+		return bucket.Delete(boltKey(id))
+	})
+}
+
+func (tm *boltTemplateManager) FindByIds(ids []string) ([]*Template, error) {
+	results := make([]*Template, 0, 0)
+	err := tm.boltManager.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucketTemplates)
+		for _, id := range ids {
+			payload := bucket.Get(boltKey(id))
+			if payload == nil {
+				continue
+			}
+			var template Template
+			err := json.Unmarshal(payload, &template)
+			if err != nil {
+				return err
+			}
+			results = append(results, &template)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (tm *boltTemplateManager) FindByRenderService(renderService string) ([]*Template, error) {
+	templates, err := tm.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Template, 0, 0)
+	for _, template := range templates {
+		if template.Renderer == renderService {
+			results = append(results, template)
+		}
+	}
+	return results, nil
+}
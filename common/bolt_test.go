@@ -0,0 +1,262 @@
+package common
+
+import (
+	"github.com/ngerakines/testutils"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBoltStorage(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	bm, err := NewBoltManager(filepath.Join(dm.Path, "preview.db"))
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	defer bm.Stop()
+
+	tm, err := NewBoltTemplateManager(bm)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sasm, err := NewBoltSourceAssetStorageManager(bm, "E876F147E331")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	gasm, err := NewBoltGeneratedAssetStorageManager(bm, tm, "E876F147E331")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+
+	templates, err := tm.FindByIds(LegacyDefaultTemplates)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(templates) != len(LegacyDefaultTemplates) {
+		t.Error("Default templates expected:", len(templates))
+		return
+	}
+
+	sourceAsset, err := NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	err = sasm.Store(sourceAsset)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sourceAssets, err := sasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(sourceAssets) != 1 {
+		t.Error("One result expected:", len(sourceAssets))
+		return
+	}
+
+	expired, err := sasm.FindExpired(time.Now().UnixNano())
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(expired) != 0 {
+		t.Error("No expired source assets expected:", expired)
+		return
+	}
+	sourceAsset.AddAttribute(SourceAssetAttributeExpires, []string{strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano(), 10)})
+	err = sasm.Store(sourceAsset)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	expired, err = sasm.FindExpired(time.Now().UnixNano())
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(expired) != 1 || expired[0] != sourceAsset.Id {
+		t.Error("One expired source asset expected:", expired)
+		return
+	}
+
+	for _, template := range templates {
+		generatedAsset, err := NewGeneratedAssetFromSourceAsset(sourceAsset, template, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		err = gasm.Store(generatedAsset)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+	}
+
+	generatedAssets, err := gasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(generatedAssets) != len(templates) {
+		t.Error("Generated assets expected:", len(generatedAssets))
+		return
+	}
+
+	work, err := gasm.FindWorkForService(RenderAgentImageMagick, 2)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(work) != 2 {
+		t.Error("Two work items expected:", len(work))
+		return
+	}
+	for _, generatedAsset := range work {
+		if generatedAsset.Status != GeneratedAssetStatusScheduled {
+			t.Errorf("Unexpected status for generated asset: (%+v)", generatedAsset)
+			return
+		}
+	}
+
+	work, err = gasm.FindWorkForService(RenderAgentImageMagick, 10)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(work) != len(templates)-2 {
+		t.Error("Remaining work items expected:", len(work))
+		return
+	}
+
+	generatedAsset := work[0]
+	generatedAsset.Status = GeneratedAssetStatusComplete
+	err = gasm.Update(generatedAsset)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	found, err := gasm.FindById(generatedAsset.Id)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if found.Status != GeneratedAssetStatusComplete {
+		t.Errorf("Unexpected status for generated asset: (%+v)", found)
+		return
+	}
+
+	found.Status = GeneratedAssetStatusWaiting
+	found.SetAttribute(GeneratedAssetAttributeNotBefore, []string{strconv.FormatInt(time.Now().Add(time.Hour).UnixNano(), 10)})
+	err = gasm.Update(found)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	work, err = gasm.FindWorkForService(RenderAgentImageMagick, 10)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(work) != 0 {
+		t.Error("No work items expected before the not before time:", len(work))
+		return
+	}
+
+	found.SetAttribute(GeneratedAssetAttributeNotBefore, []string{strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano(), 10)})
+	err = gasm.Update(found)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	work, err = gasm.FindWorkForService(RenderAgentImageMagick, 10)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(work) != 1 || work[0].Id != found.Id {
+		t.Error("One work item expected after the not before time:", len(work))
+		return
+	}
+
+	err = gasm.UpdateStatus(found.Id, GeneratedAssetStatusWaiting, GeneratedAssetStatusProcessing)
+	if err != ErrorGeneratedAssetStatusChanged {
+		t.Error("Status of scheduled generated asset should not change from waiting:", err)
+		return
+	}
+	err = gasm.UpdateStatus(found.Id, GeneratedAssetStatusScheduled, GeneratedAssetStatusProcessing)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	found, err = gasm.FindById(found.Id)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if found.Status != GeneratedAssetStatusProcessing || found.UpdatedBy != "E876F147E331" {
+		t.Errorf("Unexpected status for generated asset: (%+v)", found)
+		return
+	}
+
+	complete, err := gasm.FindByStatus(GeneratedAssetStatusComplete)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(complete) != 0 {
+		t.Error("No complete generated assets expected:", len(complete))
+		return
+	}
+
+	err = gasm.Delete(found.Id)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAssets, err = gasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(generatedAssets) != len(templates)-1 {
+		t.Error("Remaining generated assets expected:", len(generatedAssets))
+		return
+	}
+	active, err := gasm.FindActive()
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	for _, generatedAsset := range active {
+		if generatedAsset.Id == found.Id {
+			t.Error("Deleted generated asset expected to not be active")
+			return
+		}
+	}
+
+	err = sasm.Delete("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sourceAssets, err = sasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(sourceAssets) != 0 {
+		t.Error("No source assets expected:", len(sourceAssets))
+		return
+	}
+}
@@ -1,8 +1,10 @@
 package common
 
 import (
+	"encoding/json"
 	"github.com/gocql/gocql"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,11 +25,14 @@ CREATE INDEX IF NOT EXISTS ON generated_assets (status);
 CREATE INDEX IF NOT EXISTS ON generated_assets (template_id);
 CREATE TABLE IF NOT EXISTS source_assets (id varchar, type varchar, message blob, PRIMARY KEY (id, type));
 CREATE INDEX IF NOT EXISTS ON source_assets (type);
+CREATE TABLE IF NOT EXISTS expiring_source_assets (id varchar PRIMARY KEY, expires bigint);
+CREATE TABLE IF NOT EXISTS templates (id varchar PRIMARY KEY, renderer varchar, message blob);
 
 TRUNCATE source_assets;
 TRUNCATE generated_assets;
 TRUNCATE active_generated_assets;
 TRUNCATE waiting_generated_assets;
+TRUNCATE expiring_source_assets;
 
 */
 
@@ -44,6 +49,11 @@ type cassandraGeneratedAssetStorageManager struct {
 	keyspace         string
 }
 
+type cassandraTemplateManager struct {
+	cassandraManager *CassandraManager
+	keyspace         string
+}
+
 func NewCassandraManager(hosts []string, keyspace string) (*CassandraManager, error) {
 	cm := new(CassandraManager)
 
@@ -72,6 +82,26 @@ func NewCassandraGeneratedAssetStorageManager(cm *CassandraManager, templateMana
 	return cgasm, nil
 }
 
+// NewCassandraTemplateManager creates a template manager backed by the templates table. The default templates are stored if they do not already exist.
+func NewCassandraTemplateManager(cm *CassandraManager, keyspace string) (TemplateManager, error) {
+	ctm := new(cassandraTemplateManager)
+	ctm.cassandraManager = cm
+	ctm.keyspace = keyspace
+	for _, template := range DefaultTemplates() {
+		existing, err := ctm.FindByIds([]string{template.Id})
+		if err != nil {
+			return nil, err
+		}
+		if len(existing) == 0 {
+			err = ctm.Store(template)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ctm, nil
+}
+
 func (cm *CassandraManager) Stop() {
 }
 
@@ -96,6 +126,22 @@ func (sasm *cassandraSourceAssetStorageManager) Store(sourceAsset *SourceAsset)
 		return err
 	}
 
+	// NKG: Source assets that expire are also tracked in their own table
+	// so that the sweeper doesn't need to scan every source asset.
+	if sourceAsset.IdType == SourceAssetTypeOrigin {
+		value, err := GetFirstAttribute(sourceAsset, SourceAssetAttributeExpires)
+		if err == nil {
+			expires, err := strconv.ParseInt(value, 10, 64)
+			if err == nil {
+				err = session.Query(`INSERT INTO `+sasm.keyspace+`.expiring_source_assets (id, expires) VALUES (?, ?)`, sourceAsset.Id, expires).Exec()
+				if err != nil {
+					log.Println("Error persisting source asset expiration:", err)
+					return err
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -126,6 +172,48 @@ func (sasm *cassandraSourceAssetStorageManager) FindBySourceAssetId(id string) (
 	return results, nil
 }
 
+func (sasm *cassandraSourceAssetStorageManager) FindExpired(now int64) ([]string, error) {
+	results := make([]string, 0, 0)
+
+	session, err := sasm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	iter := session.Query(`SELECT id, expires FROM ` + sasm.keyspace + `.expiring_source_assets`).Consistency(gocql.One).Iter()
+	var id string
+	var expires int64
+	for iter.Scan(&id, &expires) {
+		if expires < now {
+			results = append(results, id)
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Delete removes all of the source assets with the given id.
+func (sasm *cassandraSourceAssetStorageManager) Delete(id string) error {
+	session, err := sasm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	batch := session.NewBatch(gocql.UnloggedBatch)
+	batch.Query(`DELETE FROM `+sasm.keyspace+`.source_assets WHERE id = ?`, id)
+	batch.Query(`DELETE FROM `+sasm.keyspace+`.expiring_source_assets WHERE id = ?`, id)
+	err = session.ExecuteBatch(batch)
+	if err != nil {
+		log.Println("Error deleting source asset:", err)
+		return err
+	}
+	return nil
+}
+
 func (gasm *cassandraGeneratedAssetStorageManager) Store(generatedAsset *GeneratedAsset) error {
 	log.Println("About to store generatedAsset", generatedAsset)
 	generatedAsset.CreatedBy = gasm.nodeId
@@ -200,7 +288,66 @@ func (gasm *cassandraGeneratedAssetStorageManager) Update(generatedAsset *Genera
 
 	batch := session.NewBatch(gocql.UnloggedBatch)
 	batch.Query(`UPDATE `+gasm.keyspace+`.generated_assets SET status = ?, message = ? WHERE id = ?`, generatedAsset.Status, payload, generatedAsset.Id)
+	err = gasm.index(batch, generatedAsset)
+	if err != nil {
+		return err
+	}
+	err = session.ExecuteBatch(batch)
+	if err != nil {
+		log.Println("Error executing batch:", err)
+		return err
+	}
+	return nil
+}
 
+func (gasm *cassandraGeneratedAssetStorageManager) UpdateStatus(id, fromStatus, toStatus string) error {
+	generatedAsset, err := gasm.FindById(id)
+	if err != nil {
+		return err
+	}
+	if generatedAsset.Status != fromStatus {
+		return ErrorGeneratedAssetStatusChanged
+	}
+	generatedAsset.Status = toStatus
+	generatedAsset.UpdatedAt = time.Now().UnixNano()
+	generatedAsset.UpdatedBy = gasm.nodeId
+	payload, err := generatedAsset.Serialize()
+	if err != nil {
+		log.Println("Error serializing generated asset:", err)
+		return err
+	}
+	session, err := gasm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// NKG: The status is changed with a lightweight transaction so that
+	// concurrent changes made by other nodes aren't overwritten.
+	var currentStatus string
+	applied, err := session.Query(`UPDATE `+gasm.keyspace+`.generated_assets SET status = ?, message = ? WHERE id = ? IF status = ?`, toStatus, payload, id, fromStatus).ScanCAS(&currentStatus)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrorGeneratedAssetStatusChanged
+	}
+
+	batch := session.NewBatch(gocql.UnloggedBatch)
+	err = gasm.index(batch, generatedAsset)
+	if err != nil {
+		return err
+	}
+	err = session.ExecuteBatch(batch)
+	if err != nil {
+		log.Println("Error executing batch:", err)
+		return err
+	}
+	return nil
+}
+
+// index adds the queries that place the generated asset into the waiting or active table based on its status, removing it from the other, to the batch.
+func (gasm *cassandraGeneratedAssetStorageManager) index(batch *gocql.Batch, generatedAsset *GeneratedAsset) error {
 	if generatedAsset.Status == GeneratedAssetStatusScheduled || generatedAsset.Status == GeneratedAssetStatusProcessing {
 		templateGroup, err := gasm.templateGroup(generatedAsset.TemplateId)
 		if err != nil {
@@ -209,13 +356,17 @@ func (gasm *cassandraGeneratedAssetStorageManager) Update(generatedAsset *Genera
 		batch.Query(`DELETE FROM `+gasm.keyspace+`.waiting_generated_assets WHERE id = ? AND template = ? AND source = ?`, generatedAsset.Id, templateGroup, generatedAsset.SourceAssetId+generatedAsset.SourceAssetType)
 		batch.Query(`INSERT INTO `+gasm.keyspace+`.active_generated_assets (id) VALUES (?)`, generatedAsset.Id)
 	}
-	if generatedAsset.Status == GeneratedAssetStatusComplete || strings.HasPrefix(generatedAsset.Status, GeneratedAssetStatusFailed) {
+	if generatedAsset.Status == GeneratedAssetStatusWaiting {
+		templateGroup, err := gasm.templateGroup(generatedAsset.TemplateId)
+		if err != nil {
+			return err
+		}
 		batch.Query(`DELETE FROM `+gasm.keyspace+`.active_generated_assets WHERE id = ?`, generatedAsset.Id)
+		batch.Query(`INSERT INTO `+gasm.keyspace+`.waiting_generated_assets (id, source, template) VALUES (?, ?, ?)`,
+			generatedAsset.Id, generatedAsset.SourceAssetId+generatedAsset.SourceAssetType, templateGroup)
 	}
-	err = session.ExecuteBatch(batch)
-	if err != nil {
-		log.Println("Error executing batch:", err)
-		return err
+	if generatedAsset.Status == GeneratedAssetStatusComplete || strings.HasPrefix(generatedAsset.Status, GeneratedAssetStatusFailed) {
+		batch.Query(`DELETE FROM `+gasm.keyspace+`.active_generated_assets WHERE id = ?`, generatedAsset.Id)
 	}
 	return nil
 }
@@ -260,23 +411,111 @@ func (gasm *cassandraGeneratedAssetStorageManager) FindBySourceAssetId(id string
 	return results, nil
 }
 
+func (gasm *cassandraGeneratedAssetStorageManager) FindByStatus(status string) ([]*GeneratedAsset, error) {
+	results := make([]*GeneratedAsset, 0, 0)
+
+	session, err := gasm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	iter := session.Query(`SELECT id, message FROM `+gasm.keyspace+`.generated_assets WHERE status = ?`, status).Consistency(gocql.One).Iter()
+	var generatedAssetId string
+	var message []byte
+	for iter.Scan(&generatedAssetId, &message) {
+		generatedAsset, err := newGeneratedAssetFromJson(message)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, generatedAsset)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (gasm *cassandraGeneratedAssetStorageManager) Delete(id string) error {
+	generatedAsset, err := gasm.FindById(id)
+	if err != nil {
+		return err
+	}
+
+	session, err := gasm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	batch := session.NewBatch(gocql.UnloggedBatch)
+	batch.Query(`DELETE FROM `+gasm.keyspace+`.generated_assets WHERE id = ?`, generatedAsset.Id)
+	batch.Query(`DELETE FROM `+gasm.keyspace+`.active_generated_assets WHERE id = ?`, generatedAsset.Id)
+	templateGroup, err := gasm.templateGroup(generatedAsset.TemplateId)
+	if err == nil {
+		batch.Query(`DELETE FROM `+gasm.keyspace+`.waiting_generated_assets WHERE id = ? AND template = ? AND source = ?`, generatedAsset.Id, templateGroup, generatedAsset.SourceAssetId+generatedAsset.SourceAssetType)
+	}
+	err = session.ExecuteBatch(batch)
+	if err != nil {
+		log.Println("Error executing batch:", err)
+		return err
+	}
+	return nil
+}
+
 func (gasm *cassandraGeneratedAssetStorageManager) FindWorkForService(serviceName string, workCount int) ([]*GeneratedAsset, error) {
 	templates, err := gasm.templateManager.FindByRenderService(serviceName)
 	if err != nil {
 		log.Println("error executing templateManager.FindByRenderService", err)
 		return nil, err
 	}
-	generatedAssetIds, err := gasm.getWaitingAssets(templates[0].Group, workCount)
+
+	session, err := gasm.cassandraManager.cluster.CreateSession()
 	if err != nil {
-		log.Println("error executing gasm.getWaitingAssets", err)
 		return nil, err
 	}
+	defer session.Close()
 
-	return gasm.getIds(generatedAssetIds)
+	// NKG: Generated assets that are waiting to be retried stay in the
+	// waiting table until their not before time passes, so waiting ids are
+	// read in pages of workCount until enough ready generated assets are
+	// found. Otherwise a backlog of retries would hide newer work.
+	now := time.Now().UnixNano()
+	results := make([]*GeneratedAsset, 0, 0)
+	iter := session.Query(`SELECT id FROM `+gasm.keyspace+`.waiting_generated_assets WHERE template = ?`, templates[0].Group).Consistency(gocql.One).Iter()
+	page := make([]string, 0, workCount)
+	var generatedAssetId string
+	for len(results) < workCount {
+		hasNext := iter.Scan(&generatedAssetId)
+		if hasNext {
+			page = append(page, generatedAssetId)
+		}
+		if len(page) > 0 && (len(page) >= workCount || !hasNext) {
+			generatedAssets, err := gasm.getIds(page)
+			if err != nil {
+				iter.Close()
+				return nil, err
+			}
+			for _, generatedAsset := range generatedAssets {
+				if len(results) < workCount && IsGeneratedAssetReady(generatedAsset, now) {
+					results = append(results, generatedAsset)
+				}
+			}
+			page = make([]string, 0, workCount)
+		}
+		if !hasNext {
+			break
+		}
+	}
+	if err := iter.Close(); err != nil {
+		log.Println("error reading waiting_generated_assets", err)
+		return nil, err
+	}
+	return results, nil
 }
 
-func (gasm *cassandraGeneratedAssetStorageManager) getWaitingAssets(group string, count int) ([]string, error) {
-	results := make([]string, 0, 0)
+func (gasm *cassandraGeneratedAssetStorageManager) FindActive() ([]*GeneratedAsset, error) {
+	generatedAssetIds := make([]string, 0, 0)
 
 	session, err := gasm.cassandraManager.cluster.CreateSession()
 	if err != nil {
@@ -284,18 +523,18 @@ func (gasm *cassandraGeneratedAssetStorageManager) getWaitingAssets(group string
 	}
 	defer session.Close()
 
-	query := `SELECT id FROM ` + gasm.keyspace + `.waiting_generated_assets WHERE template = ?`
-	log.Println("Executing query", query, "with template", group)
-	iter := session.Query(`SELECT id FROM `+gasm.keyspace+`.waiting_generated_assets WHERE template = ?`, group).Consistency(gocql.One).Iter()
+	iter := session.Query(`SELECT id FROM ` + gasm.keyspace + `.active_generated_assets`).Consistency(gocql.One).Iter()
 	var generatedAssetId string
-	for iter.Scan(&generatedAssetId) && len(results) <= count {
-		results = append(results, generatedAssetId)
-		log.Println("waiting_generated_assets from cassandra", generatedAssetId)
+	for iter.Scan(&generatedAssetId) {
+		generatedAssetIds = append(generatedAssetIds, generatedAssetId)
 	}
 	if err := iter.Close(); err != nil {
 		return nil, err
 	}
-	return results, nil
+	if len(generatedAssetIds) == 0 {
+		return []*GeneratedAsset{}, nil
+	}
+	return gasm.getIds(generatedAssetIds)
 }
 
 func (gasm *cassandraGeneratedAssetStorageManager) getIds(ids []string) ([]*GeneratedAsset, error) {
@@ -326,3 +565,101 @@ func (gasm *cassandraGeneratedAssetStorageManager) getIds(ids []string) ([]*Gene
 	}
 	return results, nil
 }
+
+func (tm *cassandraTemplateManager) Store(template *Template) error {
+	payload, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	session, err := tm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	err = session.Query(`INSERT INTO `+tm.keyspace+`.templates (id, renderer, message) VALUES (?, ?, ?)`, template.Id, template.Renderer, payload).Exec()
+	if err != nil {
+		log.Println("Error persisting template:", err)
+		return err
+	}
+	return nil
+}
+
+func (tm *cassandraTemplateManager) FindAll() ([]*Template, error) {
+	session, err := tm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return tm.scanTemplates(session.Query(`SELECT message FROM ` + tm.keyspace + `.templates`).Consistency(gocql.One).Iter())
+}
+
+func (tm *cassandraTemplateManager) FindByIds(ids []string) ([]*Template, error) {
+	if len(ids) == 0 {
+		return []*Template{}, nil
+	}
+	args := make([]interface{}, len(ids))
+	for i, v := range ids {
+		args[i] = interface{}(v)
+	}
+
+	session, err := tm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	return tm.scanTemplates(session.Query(`SELECT message FROM `+tm.keyspace+`.templates WHERE id in (`+buildIn(len(ids))+`)`, args...).Consistency(gocql.One).Iter())
+}
+
+func (tm *cassandraTemplateManager) FindByRenderService(renderService string) ([]*Template, error) {
+	// NKG: The templates table is small enough that it is cheaper to filter
+	// the results here than to maintain an index on the renderer column.
+	templates, err := tm.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Template, 0, 0)
+	for _, template := range templates {
+		if template.Renderer == renderService {
+			results = append(results, template)
+		}
+	}
+	return results, nil
+}
+
+func (tm *cassandraTemplateManager) Delete(id string) error {
+	templates, err := tm.FindByIds([]string{id})
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return ErrorNoTemplateForId
+	}
+
+	session, err := tm.cassandraManager.cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return session.Query(`DELETE FROM `+tm.keyspace+`.templates WHERE id = ?`, id).Exec()
+}
+
+func (tm *cassandraTemplateManager) scanTemplates(iter *gocql.Iter) ([]*Template, error) {
+	results := make([]*Template, 0, 0)
+	var message []byte
+	for iter.Scan(&message) {
+		var template Template
+		err := json.Unmarshal(message, &template)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, &template)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -12,12 +12,15 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Downloader structures retreive remote files and make them available locally.
 type Downloader interface {
 	// Download attempts to retreive a file with a given url and store it to a temporary file that is managed by a TemporaryFileManager.
 	Download(url, source string) (TemporaryFile, error)
+	// DownloadHeader retreives at most length bytes from the start of a file with a given url, giving up on remote files after timeout.
+	DownloadHeader(url, source string, length int, timeout time.Duration) ([]byte, error)
 }
 
 type defaultDownloader struct {
@@ -67,6 +70,71 @@ func (downloader *defaultDownloader) Download(url, source string) (TemporaryFile
 	return nil, ErrorNotImplemented
 }
 
+// DownloadHeader retreives at most length bytes from the start of a file with a given url, giving up on remote files after timeout. HTTP servers are sent a range request, but the response is limited to length bytes whether or not they honour it.
+func (downloader *defaultDownloader) DownloadHeader(url, source string, length int, timeout time.Duration) ([]byte, error) {
+	if strings.HasPrefix(url, "file://") {
+		return readFileHeader(url[7:], length)
+	}
+	if strings.HasPrefix(url, "local://") {
+		return readFileHeader(filepath.Join(downloader.localStoragePath, url[8:]), length)
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", length-1))
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			return nil, fmt.Errorf("unexpected status code %d downloading %s", resp.StatusCode, url)
+		}
+		return ioutil.ReadAll(io.LimitReader(resp.Body, int64(length)))
+	}
+	// NKG: The S3 client can't fetch part of an object, so files in S3 are
+	// downloaded in full.
+	if downloader.s3Client != nil && strings.HasPrefix(url, "s3://") {
+		parts := strings.SplitN(url[5:], "/", 2)
+		// NKG: The S3 client can't be canceled either, so the download is
+		// abandoned when it takes too long.
+		payloads := make(chan []byte, 1)
+		errs := make(chan error, 1)
+		go func() {
+			s3Object, err := downloader.s3Client.Get(parts[0], parts[1])
+			if err != nil {
+				errs <- err
+				return
+			}
+			payloads <- s3Object.Payload()
+		}()
+		select {
+		case payload := <-payloads:
+			if len(payload) > length {
+				payload = payload[:length]
+			}
+			return payload, nil
+		case err := <-errs:
+			return nil, err
+		case <-time.After(timeout):
+			return nil, ErrorDownloadTimeout
+		}
+	}
+	return nil, ErrorNotImplemented
+}
+
+func readFileHeader(path string, length int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ioutil.ReadAll(io.LimitReader(file, int64(length)))
+}
+
 func (downloader *defaultDownloader) handleLocal(url string) (TemporaryFile, error) {
 	log.Println("Attempting to download file", url[8:])
 	path := filepath.Join(downloader.localStoragePath, url[8:])
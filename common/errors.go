@@ -3,6 +3,7 @@ package common
 import (
 	"github.com/ngerakines/codederror"
 	"log"
+	"strings"
 )
 
 var (
@@ -33,6 +34,25 @@ var (
 	ErrorMissingFieldUrl                 = codederror.NewCodedError([]string{"PRV", "COM"}, 26, "Missing url field.")
 	ErrorMissingFieldSize                = codederror.NewCodedError([]string{"PRV", "COM"}, 27, "Missing size field.")
 	ErrorCouldNotDetermineFileType       = codederror.NewCodedError([]string{"PRV", "COM"}, 28, "Could not determine type of file.")
+	ErrorGeneratedAssetLeaseExpired      = codederror.NewCodedError([]string{"PRV", "COM"}, 29, "Generated asset was not rendered before its lease expired.")
+	ErrorUnknownSignatureKey             = codederror.NewCodedError([]string{"PRV", "COM"}, 30, "The active signature key is not a configured key.")
+	ErrorCouldNotDecodeImage             = codederror.NewCodedError([]string{"PRV", "COM"}, 31, "Could not decode image.")
+	ErrorRenderTimedOut                  = codederror.NewCodedError([]string{"PRV", "COM"}, 32, "Render command did not complete before it timed out.")
+	ErrorGeneratedAssetCanceled          = codederror.NewCodedError([]string{"PRV", "COM"}, 33, "Generated asset was canceled.")
+	ErrorInvalidTtl                      = codederror.NewCodedError([]string{"PRV", "COM"}, 34, "Invalid ttl field.")
+	ErrorInvalidResizeParameters         = codederror.NewCodedError([]string{"PRV", "COM"}, 35, "Invalid resize width, height, fit or format.")
+	ErrorNoResizeSource                  = codederror.NewCodedError([]string{"PRV", "COM"}, 36, "No rendered image or source image can be resized.")
+	ErrorInvalidTemplateFit              = codederror.NewCodedError([]string{"PRV", "COM"}, 37, "Invalid template fit or background.")
+	ErrorUnsupportedOutputFormat         = codederror.NewCodedError([]string{"PRV", "COM"}, 38, "The render agent does not support the output format of the template.")
+	ErrorCouldNotProbeVideo              = codederror.NewCodedError([]string{"PRV", "COM"}, 39, "Could not determine the duration and resolution of the video.")
+	ErrorCouldNotDecodeText              = codederror.NewCodedError([]string{"PRV", "COM"}, 40, "Could not decode the file as text.")
+	ErrorInvalidSvg                      = codederror.NewCodedError([]string{"PRV", "COM"}, 41, "The file is not a valid SVG image.")
+	ErrorCouldNotReadArchive             = codederror.NewCodedError([]string{"PRV", "COM"}, 42, "Could not read the entries of the archive.")
+	ErrorCouldNotDecodeAudio             = codederror.NewCodedError([]string{"PRV", "COM"}, 43, "Could not decode the audio.")
+	ErrorInvalidCallbackUrl              = codederror.NewCodedError([]string{"PRV", "COM"}, 44, "Invalid callback url.")
+	ErrorMissingGeneratedAssetFilter     = codederror.NewCodedError([]string{"PRV", "COM"}, 45, "At least one generated asset filter is required.")
+	ErrorGeneratedAssetStatusChanged     = codederror.NewCodedError([]string{"PRV", "COM"}, 46, "The status of the generated asset has changed.")
+	ErrorDownloadTimeout                 = codederror.NewCodedError([]string{"PRV", "COM"}, 47, "The file could not be downloaded before the timeout.")
 
 	AllErrors = []codederror.CodedError{
 		ErrorNotImplemented,
@@ -61,6 +81,35 @@ var (
 		ErrorMissingFieldType,
 		ErrorMissingFieldUrl,
 		ErrorMissingFieldSize,
+		ErrorCouldNotDetermineFileType,
+		ErrorGeneratedAssetLeaseExpired,
+		ErrorUnknownSignatureKey,
+		ErrorCouldNotDecodeImage,
+		ErrorRenderTimedOut,
+		ErrorGeneratedAssetCanceled,
+		ErrorInvalidTtl,
+		ErrorInvalidResizeParameters,
+		ErrorNoResizeSource,
+		ErrorInvalidTemplateFit,
+		ErrorUnsupportedOutputFormat,
+		ErrorCouldNotProbeVideo,
+		ErrorCouldNotDecodeText,
+		ErrorInvalidSvg,
+		ErrorCouldNotReadArchive,
+		ErrorCouldNotDecodeAudio,
+		ErrorInvalidCallbackUrl,
+		ErrorMissingGeneratedAssetFilter,
+		ErrorGeneratedAssetStatusChanged,
+		ErrorDownloadTimeout,
+	}
+
+	// RetryableErrors are the errors of generated assets that failed for reasons that are likely to be temporary, such as network or storage errors.
+	RetryableErrors = []codederror.CodedError{
+		ErrorNoDownloadUrlsWork,
+		ErrorUnknownError,
+		ErrorUnableToFindSourceAssetsById,
+		ErrorUnableToFindTemplatesById,
+		ErrorCouldNotUploadAsset,
 	}
 )
 
@@ -69,6 +118,21 @@ func NewGeneratedAssetError(err codederror.CodedError) string {
 	return GeneratedAssetStatusFailed + "," + err.Error()
 }
 
+// ParseGeneratedAssetError returns the coded error of a failed generated asset status string created with NewGeneratedAssetError. The second return value is false if the status is not a failed status or the error code is not known.
+func ParseGeneratedAssetError(status string) (codederror.CodedError, bool) {
+	prefix := GeneratedAssetStatusFailed + ","
+	if !strings.HasPrefix(status, prefix) {
+		return nil, false
+	}
+	code := status[len(prefix):]
+	for _, err := range AllErrors {
+		if err.Error() == code {
+			return err, true
+		}
+	}
+	return nil, false
+}
+
 // DumpErrors prints out all of the errors contained in AllErrors.
 func DumpErrors() {
 	for _, bsnError := range AllErrors {
@@ -0,0 +1,184 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+// FileTypeHeaderLength is the number of bytes at the start of a file used to detect its file type.
+const FileTypeHeaderLength = 8192
+
+type fileSignature struct {
+	fileType string
+	offset   int
+	magic    []byte
+}
+
+var (
+	fileSignatures = []fileSignature{
+		{"jpg", 0, []byte{0xFF, 0xD8, 0xFF}},
+		{"png", 0, []byte("\x89PNG\r\n\x1a\n")},
+		{"gif", 0, []byte("GIF87a")},
+		{"gif", 0, []byte("GIF89a")},
+		{"tiff", 0, []byte("II*\x00")},
+		{"tiff", 0, []byte("MM\x00*")},
+		{"webp", 8, []byte("WEBP")},
+		{"pdf", 0, []byte("%PDF-")},
+		{"webm", 0, []byte{0x1A, 0x45, 0xDF, 0xA3}},
+	}
+	zipSignature  = []byte("PK\x03\x04")
+	bmpSignature  = []byte("BM")
+	ftypSignature = []byte("ftyp")
+
+	// NKG: The BITMAPCOREHEADER, BITMAPINFOHEADER, BITMAPV3INFOHEADER,
+	// BITMAPV4HEADER and BITMAPV5HEADER DIB header sizes.
+	bmpHeaderSizes = []uint32{12, 40, 56, 108, 124}
+
+	// NKG: Video, HEIF images and MPEG-4 audio are all ISO base media files
+	// that start with an "ftyp" box, so they are told apart by its brands.
+	ftypBrands = map[string]string{
+		"isom": "mp4",
+		"iso2": "mp4",
+		"iso4": "mp4",
+		"iso5": "mp4",
+		"iso6": "mp4",
+		"mp41": "mp4",
+		"mp42": "mp4",
+		"avc1": "mp4",
+		"dash": "mp4",
+		"M4V ": "m4v",
+		"M4VH": "m4v",
+		"M4VP": "m4v",
+		"qt  ": "mov",
+		"M4A ": "m4a",
+		"M4B ": "m4a",
+		"heic": "heic",
+		"heix": "heic",
+		"heim": "heic",
+		"heis": "heic",
+		"avif": "avif",
+		"avis": "avif",
+		"mif1": "heif",
+		"msf1": "heif",
+	}
+
+	// NKG: OOXML documents are zip files, so they are told apart by the
+	// directory that holds the parts of the main document.
+	ooxmlDirectories = map[string]string{
+		"word/": "docx",
+		"ppt/":  "pptx",
+		"xl/":   "xlsx",
+	}
+
+	fileTypeAliases = map[string]string{
+		"jpeg": "jpg",
+		"tif":  "tiff",
+	}
+)
+
+// DetectFileType returns the file type of a file using the magic bytes in the header, the first FileTypeHeaderLength bytes, of it. The names of the zip entries in the header are inspected to detect OOXML documents, and the brands of ISO base media files are inspected to tell video, image and audio files apart. The ErrorCouldNotDetermineFileType error is returned when the file type isn't recognised.
+func DetectFileType(header []byte) (string, error) {
+	for _, signature := range fileSignatures {
+		if len(header) >= signature.offset+len(signature.magic) && bytes.Equal(header[signature.offset:signature.offset+len(signature.magic)], signature.magic) {
+			return signature.fileType, nil
+		}
+	}
+	if bytes.HasPrefix(header, zipSignature) {
+		return detectZipFileType(header), nil
+	}
+	if isBmp(header) {
+		return "bmp", nil
+	}
+	if len(header) >= 12 && bytes.Equal(header[4:8], ftypSignature) {
+		if fileType, hasFileType := detectFtypFileType(header); hasFileType {
+			return fileType, nil
+		}
+	}
+	return "", ErrorCouldNotDetermineFileType
+}
+
+// IsSameFileType returns true if two file types are the same or aliases of each other, like "jpg" and "jpeg".
+func IsSameFileType(a, b string) bool {
+	return canonicalFileType(a) == canonicalFileType(b)
+}
+
+func canonicalFileType(fileType string) string {
+	fileType = strings.ToLower(fileType)
+	if alias, hasAlias := fileTypeAliases[fileType]; hasAlias {
+		return alias
+	}
+	return fileType
+}
+
+// detectZipFileType reads the names of the local file headers of a zip file that are in its header. The central directory at the end of the file isn't read, so only entries near the start of the file are seen.
+func detectZipFileType(header []byte) string {
+	offset := 0
+	for {
+		index := bytes.Index(header[offset:], zipSignature)
+		if index == -1 {
+			return "zip"
+		}
+		start := offset + index
+		// NKG: The name of an entry follows 30 bytes of fixed size fields,
+		// with its length at byte 26.
+		if start+30 > len(header) {
+			return "zip"
+		}
+		nameLength := int(binary.LittleEndian.Uint16(header[start+26:]))
+		if start+30+nameLength > len(header) {
+			return "zip"
+		}
+		name := string(header[start+30 : start+30+nameLength])
+		for directory, fileType := range ooxmlDirectories {
+			if strings.HasPrefix(name, directory) {
+				return fileType
+			}
+		}
+		offset = start + len(zipSignature)
+	}
+}
+
+// isBmp returns true if the header starts with the "BM" signature, the reserved bytes that follow the file size are zero and the DIB header has the size of a known version.
+func isBmp(header []byte) bool {
+	if len(header) < 18 || !bytes.HasPrefix(header, bmpSignature) {
+		return false
+	}
+	if binary.LittleEndian.Uint32(header[6:]) != 0 {
+		return false
+	}
+	headerSize := binary.LittleEndian.Uint32(header[14:])
+	for _, size := range bmpHeaderSizes {
+		if headerSize == size {
+			return true
+		}
+	}
+	return false
+}
+
+// detectFtypFileType returns the file type of an ISO base media file using the major brand of its "ftyp" box, falling back to its compatible brands when the major brand isn't known. Generic HEIF brands are only used when no more specific brand is found, because AVIF and HEIC images often list them first.
+func detectFtypFileType(header []byte) (string, bool) {
+	// NKG: The box starts with its size, followed by "ftyp", the major
+	// brand, a minor version and the compatible brands.
+	boxSize := int(binary.BigEndian.Uint32(header))
+	if boxSize > len(header) {
+		boxSize = len(header)
+	}
+	brands := []string{string(header[8:12])}
+	for offset := 16; offset+4 <= boxSize; offset += 4 {
+		brands = append(brands, string(header[offset:offset+4]))
+	}
+
+	generic := ""
+	for _, brand := range brands {
+		fileType, hasFileType := ftypBrands[brand]
+		if !hasFileType {
+			continue
+		}
+		if fileType != "heif" {
+			return fileType, true
+		}
+		generic = fileType
+	}
+	return generic, len(generic) > 0
+}
@@ -0,0 +1,111 @@
+package common
+
+import (
+	"archive/zip"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDetectFileType(t *testing.T) {
+	files := map[string][]byte{
+		"png":  []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+		"jpg":  []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10},
+		"gif":  []byte("GIF89a\x01\x00\x01\x00"),
+		"webp": []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+		"pdf":  []byte("%PDF-1.4\n"),
+		"mp4":  []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"),
+		"mov":  []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00"),
+		"m4v":  []byte("\x00\x00\x00\x18ftypM4V \x00\x00\x00\x01isom"),
+		"m4a":  []byte("\x00\x00\x00\x1cftypM4A \x00\x00\x00\x00M4A isommp42"),
+		"heic": []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"),
+		"avif": []byte("\x00\x00\x00\x1cftypmif1\x00\x00\x00\x00mif1avifmiaf"),
+		"heif": []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00mif1miaf"),
+		"bmp":  []byte("BM\x36\x00\x0c\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00"),
+		"webm": []byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F, 0x42, 0x86, 0x81},
+	}
+	for expected, content := range files {
+		fileType, err := DetectFileType(content)
+		if err != nil || fileType != expected {
+			t.Error("Unexpected file type", expected, fileType, err)
+		}
+	}
+
+	unknown := [][]byte{
+		[]byte("hello world"),
+		// NKG: Text that starts with "BM" and an ISO base media file with
+		// unknown brands.
+		[]byte("BMP files are bitmaps"),
+		[]byte("\x00\x00\x00\x14ftypabcd\x00\x00\x00\x00efgh"),
+	}
+	for _, content := range unknown {
+		if _, err := DetectFileType(content); err != ErrorCouldNotDetermineFileType {
+			t.Error("Unknown file type expected to not be detected", string(content), err)
+		}
+	}
+
+	for name, expected := range map[string]string{"word/document.xml": "docx", "xl/workbook.xml": "xlsx", "readme.txt": "zip"} {
+		var file bytes.Buffer
+		writer := zip.NewWriter(&file)
+		for _, entry := range []string{"[Content_Types].xml", "_rels/.rels", name} {
+			part, _ := writer.Create(entry)
+			part.Write([]byte("<xml/>"))
+		}
+		writer.Close()
+
+		fileType, err := DetectFileType(file.Bytes())
+		if err != nil || fileType != expected {
+			t.Error("Unexpected zip file type", expected, fileType, err)
+		}
+	}
+
+	if !IsSameFileType("jpeg", "jpg") || !IsSameFileType("TIF", "tiff") || IsSameFileType("png", "jpg") {
+		t.Error("Unexpected file type aliases")
+	}
+}
+
+func TestDownloadHeader(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 2000)
+	var rangeHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rangeHeader = r.Header.Get("Range")
+		// NKG: The range is ignored, like a server that doesn't support
+		// range requests.
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	downloader := NewDownloader("", "", NewTemporaryFileManager(), false, []string{}, nil)
+	header, err := downloader.DownloadHeader(server.URL+"/file", "", FileTypeHeaderLength, time.Second)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if rangeHeader != "bytes=0-8191" {
+		t.Error("Unexpected range header", rangeHeader)
+	}
+	if !bytes.Equal(header, content[:FileTypeHeaderLength]) {
+		t.Error("Unexpected header length", len(header))
+	}
+}
+
+func TestDownloadHeaderTimeout(t *testing.T) {
+	done := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	downloader := NewDownloader("", "", NewTemporaryFileManager(), false, []string{}, nil)
+	start := time.Now()
+	_, err := downloader.DownloadHeader(server.URL+"/file", "", FileTypeHeaderLength, 50*time.Millisecond)
+	if err == nil {
+		t.Error("Expected an error when the server doesn't respond")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("Reading the header took longer than the timeout", time.Since(start))
+	}
+}
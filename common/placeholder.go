@@ -45,6 +45,8 @@ var (
 	PlaceholderSizeLarge  = "large"
 	PlaceholderSizeMedium = "medium"
 	PlaceholderSizeSmall  = "small"
+	// PlaceholderSizeStoryboard is the placeholder size of the storyboard sprite sheets rendered for videos.
+	PlaceholderSizeStoryboard = "storyboard"
 
 	DefaultPlaceholderSizes = []string{PlaceholderSizeJumbo, PlaceholderSizeLarge, PlaceholderSizeMedium, PlaceholderSizeSmall}
 
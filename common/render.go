@@ -3,5 +3,11 @@ package common
 var (
 	RenderAgentImageMagick = "renderAgentImageMagick"
 	RenderAgentDocument    = "renderAgentDocument"
-	RenderAgents           = []string{RenderAgentImageMagick, RenderAgentDocument}
+	RenderAgentNative      = "renderAgentNative"
+	RenderAgentVideo       = "renderAgentVideo"
+	RenderAgentText        = "renderAgentText"
+	RenderAgentSvg         = "renderAgentSvg"
+	RenderAgentArchive     = "renderAgentArchive"
+	RenderAgentAudio       = "renderAgentAudio"
+	RenderAgents           = []string{RenderAgentImageMagick, RenderAgentDocument, RenderAgentNative, RenderAgentVideo, RenderAgentText, RenderAgentSvg, RenderAgentArchive, RenderAgentAudio}
 )
@@ -6,23 +6,39 @@ import (
 )
 
 type SourceAssetStorageManager interface {
+	// Store creates or replaces the source asset with the same id and type.
 	Store(sourceAsset *SourceAsset) error
 	FindBySourceAssetId(id string) ([]*SourceAsset, error)
+	// FindExpired returns the ids of the origin source assets that expired before the given time, in nanoseconds.
+	FindExpired(now int64) ([]string, error)
+	// Delete removes all of the source assets with the given id.
+	Delete(id string) error
 }
 
 type GeneratedAssetStorageManager interface {
 	Store(generatedAsset *GeneratedAsset) error
 	Update(generatedAsset *GeneratedAsset) error
+	// UpdateStatus changes the status of the generated asset with the given id to toStatus, returning ErrorGeneratedAssetStatusChanged if its status is no longer fromStatus.
+	UpdateStatus(id, fromStatus, toStatus string) error
 	FindById(id string) (*GeneratedAsset, error)
 	FindByIds(ids []string) ([]*GeneratedAsset, error)
 	FindBySourceAssetId(id string) ([]*GeneratedAsset, error)
 	FindWorkForService(serviceName string, workCount int) ([]*GeneratedAsset, error)
+	// FindActive returns all of the generated assets that are scheduled or being processed.
+	FindActive() ([]*GeneratedAsset, error)
+	// FindByStatus returns all of the generated assets with the given status.
+	FindByStatus(status string) ([]*GeneratedAsset, error)
+	// Delete removes the generated asset with the given id.
+	Delete(id string) error
 }
 
 type TemplateManager interface {
+	// Store creates or replaces the template with the same id.
 	Store(template *Template) error
+	FindAll() ([]*Template, error)
 	FindByIds(id []string) ([]*Template, error)
 	FindByRenderService(renderService string) ([]*Template, error)
+	Delete(id string) error
 }
 
 type inMemorySourceAssetStorageManager struct {
@@ -49,15 +65,19 @@ func NewGeneratedAssetStorageManager(templateManager TemplateManager) GeneratedA
 func NewTemplateManager() TemplateManager {
 	tm := new(inMemoryTemplateManager)
 	tm.templates = make([]*Template, 0, 0)
-	tm.Store(DefaultTemplateJumbo)
-	tm.Store(DefaultTemplateLarge)
-	tm.Store(DefaultTemplateMedium)
-	tm.Store(DefaultTemplateSmall)
-	tm.Store(DocumentConversionTemplate)
+	for _, template := range DefaultTemplates() {
+		tm.Store(template)
+	}
 	return tm
 }
 
 func (sasm *inMemorySourceAssetStorageManager) Store(sourceAsset *SourceAsset) error {
+	for index, existing := range sasm.sourceAssets {
+		if existing.Id == sourceAsset.Id && existing.IdType == sourceAsset.IdType {
+			sasm.sourceAssets[index] = sourceAsset
+			return nil
+		}
+	}
 	sasm.sourceAssets = append(sasm.sourceAssets, sourceAsset)
 	return nil
 }
@@ -72,6 +92,27 @@ func (sasm *inMemorySourceAssetStorageManager) FindBySourceAssetId(id string) ([
 	return results, nil
 }
 
+func (sasm *inMemorySourceAssetStorageManager) FindExpired(now int64) ([]string, error) {
+	results := make([]string, 0, 0)
+	for _, sourceAsset := range sasm.sourceAssets {
+		if sourceAsset.IdType == SourceAssetTypeOrigin && IsSourceAssetExpired(sourceAsset, now) {
+			results = append(results, sourceAsset.Id)
+		}
+	}
+	return results, nil
+}
+
+func (sasm *inMemorySourceAssetStorageManager) Delete(id string) error {
+	results := make([]*SourceAsset, 0, 0)
+	for _, sourceAsset := range sasm.sourceAssets {
+		if sourceAsset.Id != id {
+			results = append(results, sourceAsset)
+		}
+	}
+	sasm.sourceAssets = results
+	return nil
+}
+
 func (gasm *inMemoryGeneratedAssetStorageManager) Store(generatedAsset *GeneratedAsset) error {
 	gasm.generatedAssets = append(gasm.generatedAssets, generatedAsset)
 	return nil
@@ -111,11 +152,12 @@ func (gasm *inMemoryGeneratedAssetStorageManager) FindBySourceAssetId(id string)
 func (gasm *inMemoryGeneratedAssetStorageManager) FindWorkForService(serviceName string, workCount int) ([]*GeneratedAsset, error) {
 	templates, _ := gasm.templateManager.FindByRenderService(serviceName)
 	log.Println("templates for", serviceName, ":", templates)
+	now := time.Now().UnixNano()
 	results := make([]*GeneratedAsset, 0, 0)
 	for _, generatedAsset := range gasm.generatedAssets {
 		for _, template := range templates {
 			if generatedAsset.TemplateId == template.Id {
-				if generatedAsset.Status == GeneratedAssetStatusWaiting {
+				if generatedAsset.Status == GeneratedAssetStatusWaiting && IsGeneratedAssetReady(generatedAsset, now) {
 					generatedAsset.Status = GeneratedAssetStatusScheduled
 					generatedAsset.UpdatedAt = time.Now().UnixNano()
 					results = append(results, generatedAsset)
@@ -130,6 +172,16 @@ func (gasm *inMemoryGeneratedAssetStorageManager) FindWorkForService(serviceName
 	return results, nil
 }
 
+func (gasm *inMemoryGeneratedAssetStorageManager) FindActive() ([]*GeneratedAsset, error) {
+	results := make([]*GeneratedAsset, 0, 0)
+	for _, generatedAsset := range gasm.generatedAssets {
+		if generatedAsset.Status == GeneratedAssetStatusScheduled || generatedAsset.Status == GeneratedAssetStatusProcessing {
+			results = append(results, generatedAsset)
+		}
+	}
+	return results, nil
+}
+
 func buildGeneratedAssetIds(generatedAssets []*GeneratedAsset) []string {
 	results := make([]string, len(generatedAssets))
 	for index, generatedAsset := range generatedAssets {
@@ -138,6 +190,26 @@ func buildGeneratedAssetIds(generatedAssets []*GeneratedAsset) []string {
 	return results
 }
 
+func (gasm *inMemoryGeneratedAssetStorageManager) FindByStatus(status string) ([]*GeneratedAsset, error) {
+	results := make([]*GeneratedAsset, 0, 0)
+	for _, generatedAsset := range gasm.generatedAssets {
+		if generatedAsset.Status == status {
+			results = append(results, generatedAsset)
+		}
+	}
+	return results, nil
+}
+
+func (gasm *inMemoryGeneratedAssetStorageManager) Delete(id string) error {
+	for index, generatedAsset := range gasm.generatedAssets {
+		if generatedAsset.Id == id {
+			gasm.generatedAssets = append(gasm.generatedAssets[:index], gasm.generatedAssets[index+1:]...)
+			return nil
+		}
+	}
+	return ErrorNoGeneratedAssetsFoundForId
+}
+
 func (gasm *inMemoryGeneratedAssetStorageManager) Update(givenGeneratedAsset *GeneratedAsset) error {
 	for _, generatedAsset := range gasm.generatedAssets {
 		if generatedAsset.Id == givenGeneratedAsset.Id {
@@ -151,10 +223,49 @@ func (gasm *inMemoryGeneratedAssetStorageManager) Update(givenGeneratedAsset *Ge
 	return ErrorGeneratedAssetCouldNotBeUpdated
 }
 
+func (gasm *inMemoryGeneratedAssetStorageManager) UpdateStatus(id, fromStatus, toStatus string) error {
+	for _, generatedAsset := range gasm.generatedAssets {
+		if generatedAsset.Id == id {
+			if generatedAsset.Status != fromStatus {
+				return ErrorGeneratedAssetStatusChanged
+			}
+			generatedAsset.Status = toStatus
+			generatedAsset.UpdatedAt = time.Now().UnixNano()
+			return nil
+		}
+	}
+	return ErrorGeneratedAssetCouldNotBeUpdated
+}
+
 func (tm *inMemoryTemplateManager) Store(template *Template) error {
+	for index, existing := range tm.templates {
+		if existing.Id == template.Id {
+			tm.templates[index] = template
+			return nil
+		}
+	}
 	tm.templates = append(tm.templates, template)
 	return nil
+}
+
+func (tm *inMemoryTemplateManager) FindAll() ([]*Template, error) {
+	results := make([]*Template, len(tm.templates))
+	copy(results, tm.templates)
+	return results, nil
+}
 
+func (tm *inMemoryTemplateManager) Delete(id string) error {
+	results := make([]*Template, 0, 0)
+	for _, template := range tm.templates {
+		if template.Id != id {
+			results = append(results, template)
+		}
+	}
+	if len(results) == len(tm.templates) {
+		return ErrorNoTemplateForId
+	}
+	tm.templates = results
+	return nil
 }
 
 func (tm *inMemoryTemplateManager) FindByIds(ids []string) ([]*Template, error) {
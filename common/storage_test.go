@@ -33,3 +33,63 @@ func TestInMemorySourceAssetStorage(t *testing.T) {
 		return
 	}
 }
+
+func TestInMemoryTemplateManager(t *testing.T) {
+	tm := NewTemplateManager()
+
+	templates, err := FindTemplatesForFileType(tm, "docx")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(templates) != 1 || templates[0].Id != DocumentConversionTemplateId {
+		t.Errorf("Unexpected templates returned: (%+v)", templates)
+		return
+	}
+
+	template := &Template{"B6B6B3A6-4A4E-4C11-9F41-1A8D6F3C2E10", RenderAgentImageMagick, "4C96", []Attribute{}}
+	template.AddAttribute(TemplateAttributeFileTypes, []string{"docx"})
+	err = tm.Store(template)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	template = &Template{"B6B6B3A6-4A4E-4C11-9F41-1A8D6F3C2E10", RenderAgentImageMagick, "4C96", []Attribute{}}
+	template.AddAttribute(TemplateAttributeFileTypes, []string{"png"})
+	err = tm.Store(template)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+
+	templates, err = FindTemplatesForFileType(tm, "docx")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(templates) != 1 {
+		t.Errorf("Unexpected templates returned: (%+v)", templates)
+		return
+	}
+
+	templates, err = tm.FindAll()
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(templates) != len(DefaultTemplates())+1 {
+		t.Error("Unexpected template count:", len(templates))
+		return
+	}
+
+	err = tm.Delete("B6B6B3A6-4A4E-4C11-9F41-1A8D6F3C2E10")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	err = tm.Delete("B6B6B3A6-4A4E-4C11-9F41-1A8D6F3C2E10")
+	if err != ErrorNoTemplateForId {
+		t.Errorf("Expected error not returned: %s", err)
+		return
+	}
+}
@@ -1,5 +1,9 @@
 package common
 
+import (
+	"strings"
+)
+
 type Template struct {
 	Id         string
 	Renderer   string
@@ -23,6 +27,7 @@ var (
 			Attribute{TemplateAttributeHeight, []string{"780"}},
 			Attribute{TemplateAttributeOutput, []string{"jpg"}},
 			Attribute{TemplateAttributePlaceholderSize, []string{PlaceholderSizeJumbo}},
+			Attribute{TemplateAttributeFileTypes, []string{"jpg", "jpeg", "png", "gif", "pdf"}},
 		},
 	}
 	DefaultTemplateLarge = &Template{
@@ -34,6 +39,7 @@ var (
 			Attribute{TemplateAttributeHeight, []string{"390"}},
 			Attribute{TemplateAttributeOutput, []string{"jpg"}},
 			Attribute{TemplateAttributePlaceholderSize, []string{PlaceholderSizeLarge}},
+			Attribute{TemplateAttributeFileTypes, []string{"jpg", "jpeg", "png", "gif", "pdf"}},
 		},
 	}
 	DefaultTemplateMedium = &Template{
@@ -45,6 +51,7 @@ var (
 			Attribute{TemplateAttributeHeight, []string{"376"}},
 			Attribute{TemplateAttributeOutput, []string{"jpg"}},
 			Attribute{TemplateAttributePlaceholderSize, []string{PlaceholderSizeMedium}},
+			Attribute{TemplateAttributeFileTypes, []string{"jpg", "jpeg", "png", "gif", "pdf"}},
 		},
 	}
 	DefaultTemplateSmall = &Template{
@@ -56,15 +63,85 @@ var (
 			Attribute{TemplateAttributeHeight, []string{"188"}},
 			Attribute{TemplateAttributeOutput, []string{"jpg"}},
 			Attribute{TemplateAttributePlaceholderSize, []string{PlaceholderSizeSmall}},
+			Attribute{TemplateAttributeFileTypes, []string{"jpg", "jpeg", "png", "gif", "pdf"}},
 		},
 	}
 
+	DefaultNativeTemplates = defaultTemplates(RenderAgentNative, "7A1E", []string{"jpg", "jpeg", "png", "gif", "bmp", "tif", "tiff", "webp"}, map[string]string{
+		PlaceholderSizeJumbo:  "6C3E2F0A-1B7D-4E4B-9B53-2E0C5A1D8F41",
+		PlaceholderSizeLarge:  "A1F5C3D2-6E8B-4C0A-8F27-9D4B1E6A3C52",
+		PlaceholderSizeMedium: "3D9B7E14-5A2C-4F86-B1E0-7C8A6D2F4E63",
+		PlaceholderSizeSmall:  "E8A4B6C1-0D3F-4B79-A5C2-1F6E9D8B7A74",
+	})
+
+	// NKG: The video templates render a poster frame of each video in the
+	// default sizes along with a storyboard sprite sheet.
+	DefaultVideoTemplates = append(defaultTemplates(RenderAgentVideo, "5D1B", VideoFileTypes, map[string]string{
+		PlaceholderSizeJumbo:  "0B6E3A52-9C47-4D1F-8E2A-5F3C7B9D1A06",
+		PlaceholderSizeLarge:  "6F2D8B14-3A9E-4C57-B0D6-2E8F4A1C7B93",
+		PlaceholderSizeMedium: "A4C91E37-5B2F-4D80-9E6A-8D1B3F7C2E45",
+		PlaceholderSizeSmall:  "D7E05B29-8F1C-4A36-B4D9-6C2A0E8F3B17",
+	}), DefaultVideoTemplateStoryboard)
+	DefaultVideoTemplateStoryboard = &Template{
+		"3E8A1F60-2D4B-4C93-A7E5-9B0C6D2F8A31",
+		RenderAgentVideo,
+		"5D1B",
+		[]Attribute{
+			Attribute{TemplateAttributeWidth, []string{"160"}},
+			Attribute{TemplateAttributeHeight, []string{"90"}},
+			Attribute{TemplateAttributeFrames, []string{"16"}},
+			Attribute{TemplateAttributeColumns, []string{"4"}},
+			Attribute{TemplateAttributeOutput, []string{"jpg"}},
+			Attribute{TemplateAttributePlaceholderSize, []string{PlaceholderSizeStoryboard}},
+			Attribute{TemplateAttributeFileTypes, VideoFileTypes},
+		},
+	}
+	// VideoFileTypes are the file types that the default video templates apply to.
+	VideoFileTypes = []string{"mp4", "m4v", "mov", "webm"}
+
+	// NKG: The text templates render the first lines of source code and
+	// plain text files in the default sizes.
+	DefaultTextTemplates = defaultTemplates(RenderAgentText, "9C4F", TextFileTypes, map[string]string{
+		PlaceholderSizeJumbo:  "8B2C5E71-4F0A-4D3B-9A6E-1C7D3F5B8E92",
+		PlaceholderSizeLarge:  "F13A7D48-2B6C-4E95-8D0F-6A4E2C9B1D37",
+		PlaceholderSizeMedium: "5C8E0B93-7D1A-4F62-A3B5-9E2D6F4A0C18",
+		PlaceholderSizeSmall:  "2A6D9F05-E8B3-4C71-B9A4-3F1E7C5D8B60",
+	})
+	// TextFileTypes are the file types that the default text templates apply to.
+	TextFileTypes = []string{"go", "py", "json", "txt", "md", "csv"}
+
+	DefaultSvgTemplates = defaultTemplates(RenderAgentSvg, "3B7A", []string{"svg"}, map[string]string{
+		PlaceholderSizeJumbo:  "C4E81A36-7B2D-4F05-9D63-8A1F5E0B2C97",
+		PlaceholderSizeLarge:  "19B7F3D2-4C8E-4A61-B205-6E3D9A7C1F48",
+		PlaceholderSizeMedium: "7E5A0C94-D3B1-4E28-8F76-B2C4E1A9D053",
+		PlaceholderSizeSmall:  "B0D36F87-1E9A-4C54-A3E8-5F7B2D0C6A19",
+	})
+
+	DefaultArchiveTemplates = defaultTemplates(RenderAgentArchive, "8E3C", ArchiveFileTypes, map[string]string{
+		PlaceholderSizeJumbo:  "D58A2E14-6B3F-4C97-A0E1-7F2B9C4D6E83",
+		PlaceholderSizeLarge:  "4F91C7B3-2E5D-4A68-8B1F-0C6E3D9A5B27",
+		PlaceholderSizeMedium: "A2E6D84F-9C1B-4F37-B5A0-E8D3C71F2946",
+		PlaceholderSizeSmall:  "6C3B0E9A-D47F-4E12-9A85-3B1F6D2C8E70",
+	})
+	// ArchiveFileTypes are the file types that the default archive templates apply to.
+	ArchiveFileTypes = []string{"zip", "tar", "tgz"}
+
+	DefaultAudioTemplates = defaultTemplates(RenderAgentAudio, "2F6A", AudioFileTypes, map[string]string{
+		PlaceholderSizeJumbo:  "7E4B2A91-C3D6-4F58-8A07-1D9E6B3C5F24",
+		PlaceholderSizeLarge:  "B81F5D3E-06A9-4C2B-97E4-5A3C8D1F6E09",
+		PlaceholderSizeMedium: "3D6A9C02-F1B8-4E75-A4D3-9C2E7B5F1A86",
+		PlaceholderSizeSmall:  "E05C7B48-9A2D-4F16-B3E8-6D1A4C9F7B35",
+	})
+	// AudioFileTypes are the file types that the default audio templates apply to.
+	AudioFileTypes = []string{"wav", "flac", "mp3"}
+
 	DocumentConversionTemplate = &Template{
 		"9B17C6CE-7B09-4FD5-92AD-D85DD218D6D7",
 		RenderAgentDocument,
 		"A907",
 		[]Attribute{
 			Attribute{TemplateAttributeOutput, []string{"pdf"}},
+			Attribute{TemplateAttributeFileTypes, []string{"doc", "docx", "ppt", "pptx", "xls", "xlsx"}},
 		},
 	}
 	DocumentConversionTemplateId = "9B17C6CE-7B09-4FD5-92AD-D85DD218D6D7"
@@ -77,8 +154,119 @@ var (
 	TemplateAttributeOutput = "output"
 	// TemplateAttributePlaceholderSize is a constant for the placeholderSize attribute that can be set for templates.
 	TemplateAttributePlaceholderSize = "placeholderSize"
+	// TemplateAttributeFileTypes is a constant for the fileTypes attribute that lists the file types a template applies to.
+	TemplateAttributeFileTypes = "fileTypes"
+	// TemplateAttributeFrames is a constant for the frames attribute that sets the number of evenly spaced frames in a video storyboard. The width and height of a storyboard template are the size of each frame.
+	TemplateAttributeFrames = "frames"
+	// TemplateAttributeColumns is a constant for the columns attribute that sets the number of frames in each row of a video storyboard.
+	TemplateAttributeColumns = "columns"
+	// TemplateAttributeLines is a constant for the lines attribute that sets the number of lines of a text file that are rendered.
+	TemplateAttributeLines = "lines"
+	// TemplateAttributeQuality is a constant for the quality attribute that sets the quality, from 1 to 100, of lossy output formats.
+	TemplateAttributeQuality = "quality"
+	// TemplateAttributeProgressive is a constant for the progressive attribute that, when "true", renders progressive JPEG and interlaced PNG images.
+	TemplateAttributeProgressive = "progressive"
+	// TemplateAttributeFit is a constant for the fit attribute that sets how images are scaled to the width and height of a template.
+	TemplateAttributeFit = "fit"
+	// TemplateAttributeBackground is a constant for the background attribute that sets the colour of padded and transparent areas, i.e. "#ffffff".
+	TemplateAttributeBackground = "background"
+
+	// FitModeContain scales an image to fit within a box, keeping its aspect ratio.
+	FitModeContain = "contain"
+	// FitModeCover scales an image to cover a box, keeping its aspect ratio, and crops the parts outside of the box.
+	FitModeCover = "cover"
+	// FitModeFill stretches an image to the size of a box.
+	FitModeFill = "fill"
+	// FitModePad scales an image to fit within a box, keeping its aspect ratio, and centers it on a background the size of the box.
+	FitModePad = "pad"
+	// FitModes are all of the supported fit modes.
+	FitModes = []string{FitModeContain, FitModeCover, FitModeFill, FitModePad}
+
+	// NKG: The first output format of a template is rendered to the
+	// location of the generated asset and any other output formats are
+	// rendered next to it, see OutputLocation.
+	outputContentTypes = map[string]string{
+		"jpg":  "image/jpeg",
+		"jpeg": "image/jpeg",
+		"png":  "image/png",
+		"gif":  "image/gif",
+		"webp": "image/webp",
+		"avif": "image/avif",
+		"pdf":  "application/pdf",
+	}
 )
 
+// DefaultTemplates returns the templates that are created when a template manager is created.
+func DefaultTemplates() []*Template {
+	templates := []*Template{DefaultTemplateJumbo, DefaultTemplateLarge, DefaultTemplateMedium, DefaultTemplateSmall, DocumentConversionTemplate}
+	templates = append(templates, DefaultVideoTemplates...)
+	templates = append(templates, DefaultNativeTemplates...)
+	templates = append(templates, DefaultTextTemplates...)
+	templates = append(templates, DefaultSvgTemplates...)
+	templates = append(templates, DefaultArchiveTemplates...)
+	templates = append(templates, DefaultAudioTemplates...)
+	return templates
+}
+
+// defaultTemplates returns the templates of a render agent that mirror the default templates. A template in the group is returned for each default template whose placeholder size is given an id, rendering the file types with the render agent at the same width, height and output format as the default template.
+func defaultTemplates(renderer, group string, fileTypes []string, ids map[string]string) []*Template {
+	templates := make([]*Template, 0, len(ids))
+	for _, defaultTemplate := range []*Template{DefaultTemplateJumbo, DefaultTemplateLarge, DefaultTemplateMedium, DefaultTemplateSmall} {
+		placeholderSize, _ := GetFirstAttribute(defaultTemplate, TemplateAttributePlaceholderSize)
+		id, hasId := ids[placeholderSize]
+		if !hasId {
+			continue
+		}
+		attributes := make([]Attribute, 0, len(defaultTemplate.Attributes))
+		for _, attribute := range defaultTemplate.Attributes {
+			if attribute.Key != TemplateAttributeFileTypes {
+				attributes = append(attributes, attribute)
+			}
+		}
+		attributes = append(attributes, Attribute{TemplateAttributeFileTypes, fileTypes})
+		templates = append(templates, &Template{id, renderer, group, attributes})
+	}
+	return templates
+}
+
+// TemplateOutputs returns the output formats of a template. Templates without an output attribute render "jpg" images.
+func TemplateOutputs(template *Template) []string {
+	outputs := template.GetAttribute(TemplateAttributeOutput)
+	if len(outputs) == 0 {
+		return []string{"jpg"}
+	}
+	return outputs
+}
+
+// OutputLocation returns the location that an additional output format of a generated asset is uploaded to.
+func OutputLocation(location, output string) string {
+	return location + "." + output
+}
+
+// OutputContentType returns the content type of an output format or file extension, or "application/octet-stream" if it isn't known.
+func OutputContentType(output string) string {
+	contentType, hasContentType := outputContentTypes[strings.ToLower(strings.TrimPrefix(output, "."))]
+	if !hasContentType {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// FindTemplatesForFileType returns all of the templates that apply to the given file type.
+func FindTemplatesForFileType(templateManager TemplateManager, fileType string) ([]*Template, error) {
+	templates, err := templateManager.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*Template, 0, 0)
+	for _, template := range templates {
+		if template.AppliesTo(fileType) {
+			results = append(results, template)
+		}
+	}
+	return results, nil
+}
+
 func (template *Template) AddAttribute(name string, value []string) Attribute {
 	attribute := Attribute{name, value}
 	template.Attributes = append(template.Attributes, attribute)
@@ -94,6 +282,16 @@ func (template *Template) HasAttribute(name string) bool {
 	return false
 }
 
+// AppliesTo returns true if the template lists the given file type in its fileTypes attribute.
+func (template *Template) AppliesTo(fileType string) bool {
+	for _, templateFileType := range template.GetAttribute(TemplateAttributeFileTypes) {
+		if templateFileType == fileType {
+			return true
+		}
+	}
+	return false
+}
+
 func (template *Template) GetAttribute(key string) []string {
 	for _, attribute := range template.Attributes {
 		if attribute.Key == key {
@@ -13,6 +13,8 @@ import (
 type Uploader interface {
 	Upload(destination string, path string) error
 	Url(sourceAssetId, templateId, placeholderSize string, page int32) string
+	// Delete removes a previously uploaded file.
+	Delete(destination string) error
 }
 
 type s3Uploader struct {
@@ -49,7 +51,7 @@ func (uploader *s3Uploader) Upload(destination, path string) error {
 		// where path will begin with a `/` character.
 		parts := strings.SplitN(usableData, "/", 2)
 		log.Println("parts", parts)
-		object, err := uploader.s3Client.NewObject(parts[1], parts[0], "application/octet-stream")
+		object, err := uploader.s3Client.NewObject(parts[1], parts[0], OutputContentType(filepath.Ext(path)))
 		if err != nil {
 			return err
 		}
@@ -68,6 +70,16 @@ func (uploader *s3Uploader) Upload(destination, path string) error {
 	return ErrorUploaderDoesNotSupportUrl
 }
 
+func (uploader *s3Uploader) Delete(destination string) error {
+	log.Println("Deleting", destination)
+	if strings.HasPrefix(destination, "s3://") {
+		usableData := destination[5:]
+		parts := strings.SplitN(usableData, "/", 2)
+		return uploader.s3Client.Delete(parts[0], parts[1])
+	}
+	return ErrorUploaderDoesNotSupportUrl
+}
+
 func (uploader *s3Uploader) Url(sourceAssetId, templateId, placeholderSize string, page int32) string {
 	bucket := uploader.bucketRing.Hash(sourceAssetId)
 	if templateId == DocumentConversionTemplateId {
@@ -93,6 +105,19 @@ func (uploader *localUploader) Upload(destination, existingFile string) error {
 	return ErrorUploaderDoesNotSupportUrl
 }
 
+func (uploader *localUploader) Delete(destination string) error {
+	log.Println("Deleting", destination)
+	if strings.HasPrefix(destination, "local://") {
+		path := filepath.Join(uploader.basePath, destination[8:])
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ErrorUploaderDoesNotSupportUrl
+}
+
 func (uploader *localUploader) Url(sourceAssetId, templateId, placeholderSize string, page int32) string {
 	if templateId == DocumentConversionTemplateId {
 		return fmt.Sprintf("local:///%s/pdf", sourceAssetId)
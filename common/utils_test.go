@@ -11,6 +11,10 @@ func (uploader *mockUploader) Url(sourceAssetId, templateId, placeholderSize str
 	return "mock://" + sourceAssetId
 }
 
+func (uploader *mockUploader) Delete(destination string) error {
+	return nil
+}
+
 func newMockUploader() Uploader {
 	return new(mockUploader)
 }
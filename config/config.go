@@ -24,11 +24,39 @@ type AppConfig interface {
 	ImageMagickRenderAgent() ImageMagickRenderAgentAppConfig
 	// DocumentRenderAgent returns Document render agent configuration.
 	DocumentRenderAgent() DocumentRenderAgentAppConfig
+	// NativeRenderAgent returns native image render agent configuration.
+	NativeRenderAgent() NativeRenderAgentAppConfig
+	// VideoRenderAgent returns video render agent configuration.
+	VideoRenderAgent() VideoRenderAgentAppConfig
+	// TextRenderAgent returns text render agent configuration.
+	TextRenderAgent() TextRenderAgentAppConfig
+	// SvgRenderAgent returns SVG render agent configuration.
+	SvgRenderAgent() SvgRenderAgentAppConfig
+	// ArchiveRenderAgent returns archive render agent configuration.
+	ArchiveRenderAgent() ArchiveRenderAgentAppConfig
+	// AudioRenderAgent returns audio render agent configuration.
+	AudioRenderAgent() AudioRenderAgentAppConfig
 	// SimpleApi returns SimpleBlueprint configuration.
 	SimpleApi() SimpleApiAppConfig
 	AssetApi() AssetApiAppConfig
+	// AdminApi returns admin API configuration.
+	AdminApi() AdminApiAppConfig
 	Uploader() UploaderAppConfig
 	Downloader() DownloaderAppConfig
+	// Reaper returns stale generated asset recovery configuration.
+	Reaper() ReaperAppConfig
+	// Retry returns failed generated asset retry configuration.
+	Retry() RetryAppConfig
+	// Retention returns source asset expiration configuration.
+	Retention() RetentionAppConfig
+	// Signature returns signed url configuration.
+	Signature() SignatureAppConfig
+	// Webhooks returns callback notification configuration.
+	Webhooks() WebhooksAppConfig
+	// Templates returns the templates defined in configuration.
+	Templates() []TemplateAppConfig
+	// Routing returns the routes defined in configuration.
+	Routing() []RouteAppConfig
 	Source() string
 }
 
@@ -38,6 +66,8 @@ type CommonAppConfig interface {
 	LocalAssetStoragePath() string
 	NodeId() string
 	WorkDispatcherEnabled() bool
+	// MaxImagePixels is the largest number of pixels, the width multiplied by the height, of an image that is decoded in process.
+	MaxImagePixels() int
 }
 
 type HttpAppConfig interface {
@@ -48,18 +78,87 @@ type StorageAppConfig interface {
 	Engine() string
 	CassandraNodes() ([]string, error)
 	CassandraKeyspace() (string, error)
+	BoltPath() (string, error)
 }
 
 type ImageMagickRenderAgentAppConfig interface {
+	CommandLimitsAppConfig
 	Enabled() bool
 	Count() int
 	SupportedFileTypes() map[string]int64
 }
 
 type DocumentRenderAgentAppConfig interface {
+	CommandLimitsAppConfig
 	Enabled() bool
 	Count() int
 	BasePath() string
+	SupportedFileTypes() map[string]int64
+	// PageLimits returns the number of pages rendered for documents of each file type. File types that aren't listed, or have a limit of 0, have every page rendered.
+	PageLimits() map[string]int64
+}
+
+// CommandLimitsAppConfig describes the limits placed on the external commands run by a render agent. A value of 0 disables the limit.
+type CommandLimitsAppConfig interface {
+	// Timeout is the number of seconds a command can run before it is killed.
+	Timeout() int
+	// CpuLimit is the number of seconds of CPU time a command can use.
+	CpuLimit() int
+	// MemoryLimit is the number of bytes of virtual memory a command can use.
+	MemoryLimit() int64
+	// FileSizeLimit is the size, in bytes, of the largest file a command can write.
+	FileSizeLimit() int64
+}
+
+type NativeRenderAgentAppConfig interface {
+	Enabled() bool
+	Count() int
+	SupportedFileTypes() map[string]int64
+}
+
+type VideoRenderAgentAppConfig interface {
+	CommandLimitsAppConfig
+	Enabled() bool
+	Count() int
+	// FfmpegPath is the path of the ffmpeg binary used to extract frames.
+	FfmpegPath() string
+	// FfprobePath is the path of the ffprobe binary used to read the duration and resolution of videos.
+	FfprobePath() string
+	SupportedFileTypes() map[string]int64
+}
+
+type TextRenderAgentAppConfig interface {
+	Enabled() bool
+	Count() int
+	SupportedFileTypes() map[string]int64
+}
+
+type SvgRenderAgentAppConfig interface {
+	CommandLimitsAppConfig
+	Enabled() bool
+	Count() int
+	// RsvgConvertPath is the path of the rsvg-convert binary used to rasterize SVG images. When empty, images are rasterized in process.
+	RsvgConvertPath() string
+	SupportedFileTypes() map[string]int64
+}
+
+type ArchiveRenderAgentAppConfig interface {
+	Enabled() bool
+	Count() int
+	// ImagePreviews is true when archives that contain an image are previewed with the first image instead of a listing of their entries.
+	ImagePreviews() bool
+	SupportedFileTypes() map[string]int64
+}
+
+type AudioRenderAgentAppConfig interface {
+	CommandLimitsAppConfig
+	Enabled() bool
+	Count() int
+	// FfmpegPath is the path of the ffmpeg binary used to decode audio files that aren't WAV files.
+	FfmpegPath() string
+	// FfprobePath is the path of the ffprobe binary used to read the duration, sample rate and channels of audio files that aren't WAV files.
+	FfprobePath() string
+	SupportedFileTypes() map[string]int64
 }
 
 type SimpleApiAppConfig interface {
@@ -70,6 +169,15 @@ type SimpleApiAppConfig interface {
 
 type AssetApiAppConfig interface {
 	Enabled() bool
+	// RenderOnRead is true when requests for generated assets that are not yet complete wait for them to be rendered.
+	RenderOnRead() bool
+	// RenderOnReadTimeout is the number of seconds a request waits for a generated asset to be rendered before the placeholder is served.
+	RenderOnReadTimeout() int
+}
+
+type AdminApiAppConfig interface {
+	// Token is the bearer token required by admin resources that change state or expose configuration. When empty, those resources are disabled.
+	Token() string
 }
 
 type UploaderAppConfig interface {
@@ -84,6 +192,84 @@ type DownloaderAppConfig interface {
 	BasePath() string
 	TramEnabled() bool
 	TramHosts() ([]string, error)
+	// DetectFileTypes is true if files are routed using the file type detected from their contents.
+	DetectFileTypes() bool
+	// DetectTimeout is the number of seconds to wait for the start of a file when detecting its file type.
+	DetectTimeout() int
+}
+
+type ReaperAppConfig interface {
+	Enabled() bool
+	// LeaseTimeout is the number of seconds a generated asset can be scheduled or processing before it is recovered.
+	LeaseTimeout() int
+	// MaxAttempts is the number of times a generated asset is recovered before it is marked as failed.
+	MaxAttempts() int
+	// Interval is the number of seconds between reaper passes.
+	Interval() int
+}
+
+type RetryAppConfig interface {
+	Enabled() bool
+	// MaxAttempts is the number of times a generated asset is attempted before it is left failed as a dead letter.
+	MaxAttempts() int
+	// Backoff is the number of seconds to wait before the first retry. Each following retry waits twice as long.
+	Backoff() int
+	// MaxBackoff is the largest number of seconds to wait before a retry.
+	MaxBackoff() int
+	// RetryableErrors returns the error codes of failures that are retried. When empty, the default set of retryable errors is used.
+	RetryableErrors() []string
+}
+
+type RetentionAppConfig interface {
+	Enabled() bool
+	// Interval is the number of seconds between sweeps for expired source assets.
+	Interval() int
+	// FileTypes returns the number of seconds source assets of each file type are kept for when a preview request doesn't include an expiration.
+	FileTypes() map[string]int64
+}
+
+type SignatureAppConfig interface {
+	// Verify is true when the asset and static APIs reject requests without a valid signature.
+	Verify() bool
+	// Keys returns the signing secrets by key id.
+	Keys() map[string]string
+	// ActiveKey is the id of the key used to sign new urls.
+	ActiveKey() string
+	// Ttl is the number of seconds a signed url is valid for.
+	Ttl() int
+}
+
+type WebhooksAppConfig interface {
+	Enabled() bool
+	// Secret is used to sign notification bodies.
+	Secret() string
+	// CallbackHosts are the hosts that callback urls may use. When empty, callback urls may use any host that isn't a loopback or private address.
+	CallbackHosts() []string
+	// MaxAttempts is the number of times a notification is sent before it is dropped.
+	MaxAttempts() int
+	// Backoff is the number of seconds to wait before the first retry. Each following retry waits twice as long.
+	Backoff() int
+	// Timeout is the number of seconds to wait for a callback url to respond.
+	Timeout() int
+	// AssetEvents is true when a notification is sent for each generated asset.
+	AssetEvents() bool
+}
+
+type TemplateAppConfig interface {
+	Id() string
+	Renderer() string
+	Group() string
+	Attributes() map[string][]string
+	FileTypes() []string
+}
+
+type RouteAppConfig interface {
+	FileTypes() []string
+	MimeTypes() []string
+	Renderer() string
+	Templates() []string
+	// MaxSize is the largest file, in bytes, that can be rendered. A value of 0 allows files of any size.
+	MaxSize() int64
 }
 
 func LoadAppConfig(givenPath string) (AppConfig, error) {
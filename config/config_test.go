@@ -46,6 +46,89 @@ func initTempFileManager(path string) *tempFileManager {
 		"uploader": {"engine": "s3", "s3Key": "foo", "s3Secret": "bar", "s3Host": "baz", "s3Buckets": ["previewa", "previewb"]},
 		"downloader": {"basePath": "./", "tramEnabled": false}
 		}`)
+	fm.initFile("bolt", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "bolt", "boltPath": "./preview.db"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false}
+		}`)
+	fm.initFile("templates", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false},
+		"templates": [
+			{"id": "B6B6B3A6-4A4E-4C11-9F41-1A8D6F3C2E10", "renderer": "renderAgentImageMagick", "group": "4C96", "fileTypes": ["jpg", "png"], "attributes": {"width": 2048, "height": "1536", "output": ["jpg"], "placeholderSize": "huge"}}
+		]
+		}`)
+	fm.initFile("signature", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false},
+		"signature": {"verify": true, "ttl": 60, "activeKey": "2014b", "keys": {"2014a": "foo", "2014b": "bar"}}
+		}`)
+	fm.initFile("limits", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}, "timeout": 30, "cpuLimit": 20, "memoryLimit": 1073741824, "fileSizeLimit": 104857600},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false}
+		}`)
+	fm.initFile("pageLimits", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./", "pageLimits": {"pptx": 5, "xlsx": 0}},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false}
+		}`)
+	fm.initFile("webhooks", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false},
+		"webhooks": {"enabled": true, "secret": "foo", "callbackHosts": ["hooks.example.com"], "maxAttempts": 3, "backoff": 5, "timeout": 5, "assetEvents": false}
+		}`)
+	fm.initFile("webhooksNoSecret", `{
+		"http": {"listen": ":8081"},
+		"common": {"nodeId": "9D7DB7FC75B4", "placeholderBasePath": "./", "placeholderGroups": {"image": ["jpg"]}, "localAssetStoragePath":"./", "workDispatcherEnabled":true},
+		"storage": {"engine": "memory"},
+		"imageMagickRenderAgent": {"enabled": true, "count": 16, "supportedFileTypes":{"jpg": 123456}},
+		"documentRenderAgent": {"enabled": true, "count": 16, "basePath": "./"},
+		"simpleApi": {"enabled": true, "baseUrl":"/api", "edgeBaseUrl": "http://localhost:8080"},
+		"assetApi": {"basePath": "./", "enabled": true},
+		"uploader": {"engine": "local"},
+		"downloader": {"basePath": "./", "tramEnabled": false},
+		"webhooks": {"enabled": true, "secret": "", "maxAttempts": 3, "backoff": 5, "timeout": 5, "assetEvents": false}
+		}`)
 	return fm
 }
 
@@ -111,3 +194,191 @@ func TestBasicConfig(t *testing.T) {
 		t.Error("Invalid default for appConfig.SimpleApi().Enabled()", appConfig.SimpleApi().Enabled())
 	}
 }
+
+func TestBoltConfig(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+	fm := initTempFileManager(dm.Path)
+
+	path, err := fm.get("bolt")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err := LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	if appConfig.Storage().Engine() != "bolt" {
+		t.Error("appConfig.Storage().Engine()", appConfig.Storage().Engine())
+	}
+	boltPath, err := appConfig.Storage().BoltPath()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if boltPath != "./preview.db" {
+		t.Error("appConfig.Storage().BoltPath()", boltPath)
+	}
+	_, err = appConfig.Storage().CassandraNodes()
+	if err == nil {
+		t.Error("appConfig.Storage().CassandraNodes() should return an error when cassandra is not enabled.")
+	}
+}
+
+func TestTemplatesConfig(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+	fm := initTempFileManager(dm.Path)
+
+	path, err := fm.get("templates")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err := LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	templates := appConfig.Templates()
+	if len(templates) != 1 {
+		t.Error("appConfig.Templates()", templates)
+		return
+	}
+	template := templates[0]
+	if template.Id() != "B6B6B3A6-4A4E-4C11-9F41-1A8D6F3C2E10" || template.Renderer() != "renderAgentImageMagick" || template.Group() != "4C96" {
+		t.Error("Invalid template", template)
+	}
+	if strings.Join(template.FileTypes(), ",") != "jpg,png" {
+		t.Error("Invalid template file types", template.FileTypes())
+	}
+	attributes := template.Attributes()
+	if strings.Join(attributes["width"], ",") != "2048" || strings.Join(attributes["height"], ",") != "1536" || strings.Join(attributes["output"], ",") != "jpg" || strings.Join(attributes["placeholderSize"], ",") != "huge" {
+		t.Error("Invalid template attributes", attributes)
+	}
+}
+
+func TestSignatureConfig(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+	fm := initTempFileManager(dm.Path)
+
+	path, err := fm.get("signature")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err := LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	signature := appConfig.Signature()
+	if !signature.Verify() || signature.Ttl() != 60 || signature.ActiveKey() != "2014b" {
+		t.Error("Invalid signature config", signature)
+	}
+	keys := signature.Keys()
+	if len(keys) != 2 || keys["2014a"] != "foo" || keys["2014b"] != "bar" {
+		t.Error("Invalid signature keys", keys)
+	}
+}
+
+func TestCommandLimitsConfig(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+	fm := initTempFileManager(dm.Path)
+
+	path, err := fm.get("limits")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err := LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	imageMagick := appConfig.ImageMagickRenderAgent()
+	if imageMagick.Timeout() != 30 || imageMagick.CpuLimit() != 20 || imageMagick.MemoryLimit() != 1073741824 || imageMagick.FileSizeLimit() != 104857600 {
+		t.Error("Invalid imageMagickRenderAgent limits", imageMagick.Timeout(), imageMagick.CpuLimit(), imageMagick.MemoryLimit(), imageMagick.FileSizeLimit())
+	}
+
+	document := appConfig.DocumentRenderAgent()
+	if document.Timeout() != 300 || document.CpuLimit() != 0 || document.MemoryLimit() != 0 || document.FileSizeLimit() != 0 {
+		t.Error("Invalid default documentRenderAgent limits", document.Timeout(), document.CpuLimit(), document.MemoryLimit(), document.FileSizeLimit())
+	}
+}
+
+func TestPageLimitsConfig(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+	fm := initTempFileManager(dm.Path)
+
+	path, err := fm.get("basic")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err := LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	pageLimits := appConfig.DocumentRenderAgent().PageLimits()
+	if len(pageLimits) != 6 || pageLimits["docx"] != 50 || pageLimits["pptx"] != 20 || pageLimits["xlsx"] != 1 {
+		t.Error("Invalid default documentRenderAgent page limits", pageLimits)
+	}
+
+	path, err = fm.get("pageLimits")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err = LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	pageLimits = appConfig.DocumentRenderAgent().PageLimits()
+	if len(pageLimits) != 2 || pageLimits["pptx"] != 5 || pageLimits["xlsx"] != 0 {
+		t.Error("Invalid documentRenderAgent page limits", pageLimits)
+	}
+}
+
+func TestWebhooksConfig(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+	fm := initTempFileManager(dm.Path)
+
+	path, err := fm.get("webhooks")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	appConfig, err := LoadAppConfig(path)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	webhooks := appConfig.Webhooks()
+	if !webhooks.Enabled() || webhooks.Secret() != "foo" || len(webhooks.CallbackHosts()) != 1 || webhooks.CallbackHosts()[0] != "hooks.example.com" {
+		t.Error("Invalid webhooks config", webhooks)
+	}
+
+	path, err = fm.get("webhooksNoSecret")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, err = LoadAppConfig(path); err == nil {
+		t.Error("Expected an error enabling webhooks without a secret")
+	}
+}
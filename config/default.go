@@ -29,7 +29,8 @@ func buildDefaultConfig(basePathFunc basePath) (AppConfig, error) {
       },
       "localAssetStoragePath":"` + basePathFunc("assets") + `",
       "nodeId":"E876F147E331",
-      "workDispatcherEnabled":true
+      "workDispatcherEnabled":true,
+      "maxImagePixels":50000000
    },
    "http":{
       "listen":":8080"
@@ -40,7 +41,15 @@ func buildDefaultConfig(basePathFunc basePath) (AppConfig, error) {
    "documentRenderAgent":{
       "enabled":true,
       "count":16,
-      "basePath":"` + basePathFunc("documentRenderAgentTmp") + `"
+      "basePath":"` + basePathFunc("documentRenderAgentTmp") + `",
+      "supportedFileTypes":{
+         "doc":33554432,
+         "docx":33554432,
+         "ppt":33554432,
+         "pptx":33554432,
+         "xls":33554432,
+         "xlsx":33554432
+      }
    },
    "imageMagickRenderAgent":{
       "enabled":true,
@@ -61,12 +70,42 @@ func buildDefaultConfig(basePathFunc basePath) (AppConfig, error) {
    "assetApi":{
       "enabled":true
    },
+   "adminApi":{
+      "token":""
+   },
    "uploader":{
       "engine":"local"
    },
    "downloader":{
       "basePath":"` + basePathFunc("cache") + `",
-      "tramEnabled": false
+      "tramEnabled": false,
+      "detectFileTypes": false,
+      "detectTimeout": 2
+   },
+   "reaper":{
+      "enabled":true,
+      "leaseTimeout":600,
+      "maxAttempts":3,
+      "interval":60
+   },
+   "retry":{
+      "enabled":true,
+      "maxAttempts":5,
+      "backoff":30,
+      "maxBackoff":3600
+   },
+   "retention":{
+      "enabled":true,
+      "interval":3600
+   },
+   "webhooks":{
+      "enabled":false,
+      "secret":"",
+      "callbackHosts":[],
+      "maxAttempts":5,
+      "backoff":10,
+      "timeout":10,
+      "assetEvents":false
    }
 }`
 	log.Println(config)
@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"log"
 	"math"
 	"strconv"
 )
@@ -73,6 +74,47 @@ func parseStringArray(group, key string, data map[string]interface{}) ([]string,
 	return results, nil
 }
 
+func parseStringMap(group, key string, data map[string]interface{}) (map[string]string, error) {
+	keyValue, hasKey := data[key]
+	if !hasKey {
+		return nil, appConfigError{"Invalid " + group + " config: " + key + " attribute missing"}
+	}
+	values, ok := keyValue.(map[string]interface{})
+	if !ok {
+		return nil, appConfigError{"Invalid " + group + " config: " + key + " attribute not a map of strings to strings"}
+	}
+	results := make(map[string]string)
+	for name, value := range values {
+		valueValue, ok := value.(string)
+		if !ok {
+			return nil, appConfigError{"Invalid " + group + " config: " + key + "." + name + " attribute not a string"}
+		}
+		results[name] = valueValue
+	}
+	return results, nil
+}
+
+func parseFileSizeMap(group, key string, data map[string]interface{}) (map[string]int64, error) {
+	keyValue, hasKey := data[key]
+	if !hasKey {
+		return nil, appConfigError{"Invalid " + group + " config: " + key + " attribute missing"}
+	}
+	fileSizes, ok := keyValue.(map[string]interface{})
+	if !ok {
+		return nil, appConfigError{"Invalid " + group + " config: " + key + " attribute not a map of strings to ints"}
+	}
+	results := make(map[string]int64)
+	for fileType, fileSize := range fileSizes {
+		val, err := getFloat(fileSize)
+		if err != nil {
+			log.Println(err.Error())
+		} else {
+			results[fileType] = int64(val)
+		}
+	}
+	return results, nil
+}
+
 func getFloat(unk interface{}) (float64, error) {
 	if v_flt, ok := unk.(float64); ok {
 		return v_flt, nil
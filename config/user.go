@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"reflect"
+	"strconv"
 )
 
 type userAppConfig struct {
@@ -13,10 +14,24 @@ type userAppConfig struct {
 	storageAppConfig                StorageAppConfig
 	imageMagickRenderAgentAppConfig ImageMagickRenderAgentAppConfig
 	documentRenderAgentAppConfig    DocumentRenderAgentAppConfig
+	nativeRenderAgentAppConfig      NativeRenderAgentAppConfig
+	videoRenderAgentAppConfig       VideoRenderAgentAppConfig
+	textRenderAgentAppConfig        TextRenderAgentAppConfig
+	svgRenderAgentAppConfig         SvgRenderAgentAppConfig
+	archiveRenderAgentAppConfig     ArchiveRenderAgentAppConfig
+	audioRenderAgentAppConfig       AudioRenderAgentAppConfig
 	assetApiAppConfig               AssetApiAppConfig
+	adminApiAppConfig               AdminApiAppConfig
 	simpleApiAppConfig              SimpleApiAppConfig
 	uploaderAppConfig               UploaderAppConfig
 	downloaderAppConfig             DownloaderAppConfig
+	reaperAppConfig                 ReaperAppConfig
+	retryAppConfig                  RetryAppConfig
+	retentionAppConfig              RetentionAppConfig
+	signatureAppConfig              SignatureAppConfig
+	webhooksAppConfig               WebhooksAppConfig
+	templateAppConfigs              []TemplateAppConfig
+	routeAppConfigs                 []RouteAppConfig
 }
 
 type userCommonAppConfig struct {
@@ -25,6 +40,7 @@ type userCommonAppConfig struct {
 	nodeId                string
 	localAssetStoragePath string
 	workDispatcherEnabled bool
+	maxImagePixels        int
 }
 
 type userHttpAppConfig struct {
@@ -35,18 +51,70 @@ type userStorageAppConfig struct {
 	engine            string
 	cassandraNodes    []string
 	cassandraKeyspace string
+	boltPath          string
 }
 
 type userImageMagickRenderAgentAppConfig struct {
+	userCommandLimitsAppConfig
 	enabled            bool
 	count              int
 	supportedFileTypes map[string]int64
 }
 
 type userDocumentRenderAgentAppConfig struct {
-	enabled  bool
-	count    int
-	basePath string
+	userCommandLimitsAppConfig
+	enabled            bool
+	count              int
+	basePath           string
+	supportedFileTypes map[string]int64
+	pageLimits         map[string]int64
+}
+
+type userCommandLimitsAppConfig struct {
+	timeout       int
+	cpuLimit      int
+	memoryLimit   int64
+	fileSizeLimit int64
+}
+
+// userRenderAgentAppConfig holds the configuration shared by render agents with an optional config group.
+type userRenderAgentAppConfig struct {
+	enabled            bool
+	count              int
+	supportedFileTypes map[string]int64
+}
+
+type userNativeRenderAgentAppConfig struct {
+	userRenderAgentAppConfig
+}
+
+type userVideoRenderAgentAppConfig struct {
+	userRenderAgentAppConfig
+	userCommandLimitsAppConfig
+	ffmpegPath  string
+	ffprobePath string
+}
+
+type userTextRenderAgentAppConfig struct {
+	userRenderAgentAppConfig
+}
+
+type userSvgRenderAgentAppConfig struct {
+	userRenderAgentAppConfig
+	userCommandLimitsAppConfig
+	rsvgConvertPath string
+}
+
+type userArchiveRenderAgentAppConfig struct {
+	userRenderAgentAppConfig
+	imagePreviews bool
+}
+
+type userAudioRenderAgentAppConfig struct {
+	userRenderAgentAppConfig
+	userCommandLimitsAppConfig
+	ffmpegPath  string
+	ffprobePath string
 }
 
 type userSimpleApiAppConfig struct {
@@ -56,7 +124,9 @@ type userSimpleApiAppConfig struct {
 }
 
 type userAssetApiAppConfig struct {
-	enabled bool
+	enabled             bool
+	renderOnRead        bool
+	renderOnReadTimeout int
 }
 
 type userUploaderAppConfig struct {
@@ -67,10 +137,70 @@ type userUploaderAppConfig struct {
 	s3Buckets []string
 }
 
+type userReaperAppConfig struct {
+	enabled      bool
+	leaseTimeout int
+	maxAttempts  int
+	interval     int
+}
+
+type userRetryAppConfig struct {
+	enabled         bool
+	maxAttempts     int
+	backoff         int
+	maxBackoff      int
+	retryableErrors []string
+}
+
+type userRetentionAppConfig struct {
+	enabled   bool
+	interval  int
+	fileTypes map[string]int64
+}
+
+type userSignatureAppConfig struct {
+	verify    bool
+	keys      map[string]string
+	activeKey string
+	ttl       int
+}
+
+type userWebhooksAppConfig struct {
+	enabled       bool
+	secret        string
+	callbackHosts []string
+	maxAttempts   int
+	backoff       int
+	timeout       int
+	assetEvents   bool
+}
+
+type userTemplateAppConfig struct {
+	id         string
+	renderer   string
+	group      string
+	attributes map[string][]string
+	fileTypes  []string
+}
+
+type userRouteAppConfig struct {
+	fileTypes []string
+	mimeTypes []string
+	renderer  string
+	templates []string
+	maxSize   int64
+}
+
+type userAdminApiAppConfig struct {
+	token string
+}
+
 type userDownloaderAppConfig struct {
-	basePath    string
-	tramEnabled bool
-	tramHosts   []string
+	basePath        string
+	tramEnabled     bool
+	tramHosts       []string
+	detectFileTypes bool
+	detectTimeout   int
 }
 
 func NewUserAppConfig(content []byte) (AppConfig, error) {
@@ -110,6 +240,36 @@ func NewUserAppConfig(content []byte) (AppConfig, error) {
 		return nil, err
 	}
 
+	appConfig.nativeRenderAgentAppConfig, err = newUserNativeRenderAgentAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.videoRenderAgentAppConfig, err = newUserVideoRenderAgentAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.textRenderAgentAppConfig, err = newUserTextRenderAgentAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.svgRenderAgentAppConfig, err = newUserSvgRenderAgentAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.archiveRenderAgentAppConfig, err = newUserArchiveRenderAgentAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.audioRenderAgentAppConfig, err = newUserAudioRenderAgentAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
 	appConfig.simpleApiAppConfig, err = newUserSimpleApiAppConfig(m)
 	if err != nil {
 		return nil, err
@@ -120,6 +280,11 @@ func NewUserAppConfig(content []byte) (AppConfig, error) {
 		return nil, err
 	}
 
+	appConfig.adminApiAppConfig, err = newUserAdminApiAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
 	appConfig.uploaderAppConfig, err = newUserUploaderAppConfig(m)
 	if err != nil {
 		return nil, err
@@ -130,6 +295,41 @@ func NewUserAppConfig(content []byte) (AppConfig, error) {
 		return nil, err
 	}
 
+	appConfig.reaperAppConfig, err = newUserReaperAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.retryAppConfig, err = newUserRetryAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.retentionAppConfig, err = newUserRetentionAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.signatureAppConfig, err = newUserSignatureAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.webhooksAppConfig, err = newUserWebhooksAppConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.templateAppConfigs, err = newUserTemplateAppConfigs(m)
+	if err != nil {
+		return nil, err
+	}
+
+	appConfig.routeAppConfigs, err = newUserRouteAppConfigs(m)
+	if err != nil {
+		return nil, err
+	}
+
 	return appConfig, nil
 }
 
@@ -177,6 +377,14 @@ func newUserCommonAppConfig(m map[string]interface{}) (CommonAppConfig, error) {
 		return nil, err
 	}
 
+	config.maxImagePixels = 50000000
+	if _, hasMaxImagePixels := data["maxImagePixels"]; hasMaxImagePixels {
+		config.maxImagePixels, err = parseInt("common", "maxImagePixels", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	placeholderGroupsData, hasKey := data["placeholderGroups"]
 	if !hasKey {
 		return nil, appConfigError{"Invalid common config: placeholderGroups attribute missing"}
@@ -224,6 +432,13 @@ func newUserStorageAppConfig(m map[string]interface{}) (StorageAppConfig, error)
 		}
 	}
 
+	if config.engine == "bolt" {
+		config.boltPath, err = parseString("storage", "boltPath", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return config, nil
 }
 
@@ -243,23 +458,14 @@ func newUserImageMagickRenderAgentAppConfig(m map[string]interface{}) (ImageMagi
 		return nil, err
 	}
 
-	supportedFileTypesValue, hasKey := data["supportedFileTypes"]
-	if !hasKey {
-		return nil, appConfigError{"Invalid imageMagickRenderAgent config: supportedFileTypes attribute missing"}
-	}
-	supportedFileTypes, ok := supportedFileTypesValue.(map[string]interface{})
-	if !ok {
-		log.Println(reflect.TypeOf(supportedFileTypesValue).Kind())
-		return nil, appConfigError{"Invalid imageMagickRenderAgent config: supportedFileTypes attribute not a map of strings to ints"}
+	config.supportedFileTypes, err = parseFileSizeMap("imageMagickRenderAgent", "supportedFileTypes", data)
+	if err != nil {
+		return nil, err
 	}
-	config.supportedFileTypes = make(map[string]int64)
-	for fileType, fileSize := range supportedFileTypes {
-		val, err := getFloat(fileSize)
-		if err != nil {
-			log.Println(err.Error())
-		} else {
-			config.supportedFileTypes[fileType] = int64(val)
-		}
+
+	config.userCommandLimitsAppConfig, err = newUserCommandLimitsAppConfig("imageMagickRenderAgent", data)
+	if err != nil {
+		return nil, err
 	}
 
 	return config, nil
@@ -288,28 +494,103 @@ func newUserDocumentRenderAgentAppConfig(m map[string]interface{}) (DocumentRend
 		return nil, err
 	}
 
-	return config, nil
-}
+	// NKG: Older configuration files don't list the file types supported by
+	// the document render agent.
+	if _, hasSupportedFileTypes := data["supportedFileTypes"]; hasSupportedFileTypes {
+		config.supportedFileTypes, err = parseFileSizeMap("documentRenderAgent", "supportedFileTypes", data)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		config.supportedFileTypes = map[string]int64{"doc": 33554432, "docx": 33554432, "ppt": 33554432, "pptx": 33554432, "xls": 33554432, "xlsx": 33554432}
+	}
 
-func newUserSimpleApiAppConfig(m map[string]interface{}) (SimpleApiAppConfig, error) {
-	data, err := parseConfigGroup("simpleApi", m)
+	config.pageLimits = map[string]int64{"doc": 50, "docx": 50, "ppt": 20, "pptx": 20, "xls": 1, "xlsx": 1}
+	if _, hasPageLimits := data["pageLimits"]; hasPageLimits {
+		config.pageLimits, err = parseFileSizeMap("documentRenderAgent", "pageLimits", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.userCommandLimitsAppConfig, err = newUserCommandLimitsAppConfig("documentRenderAgent", data)
 	if err != nil {
 		return nil, err
 	}
 
-	config := new(userSimpleApiAppConfig)
+	return config, nil
+}
 
-	config.enabled, err = parseBool("simpleApi", "enabled", data)
+// newUserCommandLimitsAppConfig parses the optional command limit attributes of a render agent group. Commands time out after 300 seconds and have no resource limits unless configured otherwise.
+func newUserCommandLimitsAppConfig(group string, data map[string]interface{}) (userCommandLimitsAppConfig, error) {
+	config := userCommandLimitsAppConfig{timeout: 300}
+
+	var err error
+	if _, hasTimeout := data["timeout"]; hasTimeout {
+		config.timeout, err = parseInt(group, "timeout", data)
+		if err != nil {
+			return config, err
+		}
+	}
+	if _, hasCpuLimit := data["cpuLimit"]; hasCpuLimit {
+		config.cpuLimit, err = parseInt(group, "cpuLimit", data)
+		if err != nil {
+			return config, err
+		}
+	}
+	if _, hasMemoryLimit := data["memoryLimit"]; hasMemoryLimit {
+		memoryLimit, err := parseInt(group, "memoryLimit", data)
+		if err != nil {
+			return config, err
+		}
+		config.memoryLimit = int64(memoryLimit)
+	}
+	if _, hasFileSizeLimit := data["fileSizeLimit"]; hasFileSizeLimit {
+		fileSizeLimit, err := parseInt(group, "fileSizeLimit", data)
+		if err != nil {
+			return config, err
+		}
+		config.fileSizeLimit = int64(fileSizeLimit)
+	}
+
+	return config, nil
+}
+
+// newUserRenderAgentAppConfig parses the enabled, count and supportedFileTypes keys of the config group of a render agent. The group is optional and the render agent is disabled when it isn't present, in which case the returned group data is empty.
+func newUserRenderAgentAppConfig(group string, m map[string]interface{}, supportedFileTypes map[string]int64) (userRenderAgentAppConfig, map[string]interface{}, error) {
+	config := userRenderAgentAppConfig{enabled: false, count: 0, supportedFileTypes: supportedFileTypes}
+	if _, hasGroup := m[group]; !hasGroup {
+		return config, map[string]interface{}{}, nil
+	}
+
+	data, err := parseConfigGroup(group, m)
 	if err != nil {
-		return nil, err
+		return config, nil, err
 	}
 
-	config.edgeBaseUrl, err = parseString("simpleApi", "edgeBaseUrl", data)
+	config.enabled, err = parseBool(group, "enabled", data)
 	if err != nil {
-		return nil, err
+		return config, nil, err
+	}
+	config.count, err = parseInt(group, "count", data)
+	if err != nil {
+		return config, nil, err
+	}
+	if _, hasSupportedFileTypes := data["supportedFileTypes"]; hasSupportedFileTypes {
+		config.supportedFileTypes, err = parseFileSizeMap(group, "supportedFileTypes", data)
+		if err != nil {
+			return config, nil, err
+		}
 	}
 
-	config.baseUrl, err = parseString("simpleApi", "baseUrl", data)
+	return config, data, nil
+}
+
+func newUserNativeRenderAgentAppConfig(m map[string]interface{}) (NativeRenderAgentAppConfig, error) {
+	config := new(userNativeRenderAgentAppConfig)
+
+	var err error
+	config.userRenderAgentAppConfig, _, err = newUserRenderAgentAppConfig("nativeRenderAgent", m, map[string]int64{"jpg": 33554432, "jpeg": 33554432, "png": 33554432, "gif": 33554432, "bmp": 33554432, "tif": 33554432, "tiff": 33554432, "webp": 33554432})
 	if err != nil {
 		return nil, err
 	}
@@ -317,15 +598,31 @@ func newUserSimpleApiAppConfig(m map[string]interface{}) (SimpleApiAppConfig, er
 	return config, nil
 }
 
-func newUserAssetApiAppConfig(m map[string]interface{}) (AssetApiAppConfig, error) {
-	data, err := parseConfigGroup("assetApi", m)
+func newUserVideoRenderAgentAppConfig(m map[string]interface{}) (VideoRenderAgentAppConfig, error) {
+	config := new(userVideoRenderAgentAppConfig)
+	config.ffmpegPath = "ffmpeg"
+	config.ffprobePath = "ffprobe"
+
+	var data map[string]interface{}
+	var err error
+	config.userRenderAgentAppConfig, data, err = newUserRenderAgentAppConfig("videoRenderAgent", m, map[string]int64{"mp4": 268435456, "m4v": 268435456, "mov": 268435456, "webm": 268435456})
 	if err != nil {
 		return nil, err
 	}
+	if _, hasFfmpegPath := data["ffmpegPath"]; hasFfmpegPath {
+		config.ffmpegPath, err = parseString("videoRenderAgent", "ffmpegPath", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, hasFfprobePath := data["ffprobePath"]; hasFfprobePath {
+		config.ffprobePath, err = parseString("videoRenderAgent", "ffprobePath", data)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	config := new(userAssetApiAppConfig)
-
-	config.enabled, err = parseBool("assetApi", "enabled", data)
+	config.userCommandLimitsAppConfig, err = newUserCommandLimitsAppConfig("videoRenderAgent", data)
 	if err != nil {
 		return nil, err
 	}
@@ -333,60 +630,143 @@ func newUserAssetApiAppConfig(m map[string]interface{}) (AssetApiAppConfig, erro
 	return config, nil
 }
 
-func newUserUploaderAppConfig(m map[string]interface{}) (UploaderAppConfig, error) {
-	data, err := parseConfigGroup("uploader", m)
+func newUserTextRenderAgentAppConfig(m map[string]interface{}) (TextRenderAgentAppConfig, error) {
+	config := new(userTextRenderAgentAppConfig)
+
+	var err error
+	config.userRenderAgentAppConfig, _, err = newUserRenderAgentAppConfig("textRenderAgent", m, map[string]int64{"go": 1048576, "py": 1048576, "json": 1048576, "txt": 1048576, "md": 1048576, "csv": 1048576})
 	if err != nil {
 		return nil, err
 	}
 
-	config := new(userUploaderAppConfig)
+	return config, nil
+}
 
-	config.engine, err = parseString("uploader", "engine", data)
+func newUserSvgRenderAgentAppConfig(m map[string]interface{}) (SvgRenderAgentAppConfig, error) {
+	config := new(userSvgRenderAgentAppConfig)
+	config.rsvgConvertPath = ""
+
+	var data map[string]interface{}
+	var err error
+	config.userRenderAgentAppConfig, data, err = newUserRenderAgentAppConfig("svgRenderAgent", m, map[string]int64{"svg": 4194304})
 	if err != nil {
 		return nil, err
 	}
-
-	if config.engine == "s3" {
-		config.s3Key, err = parseString("uploader", "s3Key", data)
+	if _, hasRsvgConvertPath := data["rsvgConvertPath"]; hasRsvgConvertPath {
+		config.rsvgConvertPath, err = parseString("svgRenderAgent", "rsvgConvertPath", data)
 		if err != nil {
 			return nil, err
 		}
-		config.s3Secret, err = parseString("uploader", "s3Secret", data)
+	}
+
+	config.userCommandLimitsAppConfig, err = newUserCommandLimitsAppConfig("svgRenderAgent", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func newUserArchiveRenderAgentAppConfig(m map[string]interface{}) (ArchiveRenderAgentAppConfig, error) {
+	config := new(userArchiveRenderAgentAppConfig)
+	config.imagePreviews = false
+
+	var data map[string]interface{}
+	var err error
+	config.userRenderAgentAppConfig, data, err = newUserRenderAgentAppConfig("archiveRenderAgent", m, map[string]int64{"zip": 134217728, "tar": 134217728, "tgz": 134217728})
+	if err != nil {
+		return nil, err
+	}
+	if _, hasImagePreviews := data["imagePreviews"]; hasImagePreviews {
+		config.imagePreviews, err = parseBool("archiveRenderAgent", "imagePreviews", data)
 		if err != nil {
 			return nil, err
 		}
-		config.s3Host, err = parseString("uploader", "s3Host", data)
+	}
+
+	return config, nil
+}
+
+func newUserAudioRenderAgentAppConfig(m map[string]interface{}) (AudioRenderAgentAppConfig, error) {
+	config := new(userAudioRenderAgentAppConfig)
+	config.ffmpegPath = "ffmpeg"
+	config.ffprobePath = "ffprobe"
+
+	var data map[string]interface{}
+	var err error
+	config.userRenderAgentAppConfig, data, err = newUserRenderAgentAppConfig("audioRenderAgent", m, map[string]int64{"wav": 268435456, "flac": 134217728, "mp3": 67108864})
+	if err != nil {
+		return nil, err
+	}
+	if _, hasFfmpegPath := data["ffmpegPath"]; hasFfmpegPath {
+		config.ffmpegPath, err = parseString("audioRenderAgent", "ffmpegPath", data)
 		if err != nil {
 			return nil, err
 		}
-		config.s3Buckets, err = parseStringArray("uploader", "s3Buckets", data)
+	}
+	if _, hasFfprobePath := data["ffprobePath"]; hasFfprobePath {
+		config.ffprobePath, err = parseString("audioRenderAgent", "ffprobePath", data)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	config.userCommandLimitsAppConfig, err = newUserCommandLimitsAppConfig("audioRenderAgent", data)
+	if err != nil {
+		return nil, err
+	}
+
 	return config, nil
 }
 
-func newUserDownloaderAppConfig(m map[string]interface{}) (DownloaderAppConfig, error) {
-	data, err := parseConfigGroup("downloader", m)
+func newUserSimpleApiAppConfig(m map[string]interface{}) (SimpleApiAppConfig, error) {
+	data, err := parseConfigGroup("simpleApi", m)
 	if err != nil {
 		return nil, err
 	}
 
-	config := new(userDownloaderAppConfig)
+	config := new(userSimpleApiAppConfig)
 
-	config.basePath, err = parseString("downloader", "basePath", data)
+	config.enabled, err = parseBool("simpleApi", "enabled", data)
 	if err != nil {
 		return nil, err
 	}
 
-	config.tramEnabled, err = parseBool("downloader", "tramEnabled", data)
+	config.edgeBaseUrl, err = parseString("simpleApi", "edgeBaseUrl", data)
 	if err != nil {
 		return nil, err
 	}
-	if config.tramEnabled {
-		config.tramHosts, err = parseStringArray("downloader", "tramHosts", data)
+
+	config.baseUrl, err = parseString("simpleApi", "baseUrl", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func newUserAssetApiAppConfig(m map[string]interface{}) (AssetApiAppConfig, error) {
+	data, err := parseConfigGroup("assetApi", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(userAssetApiAppConfig)
+	config.renderOnRead = false
+	config.renderOnReadTimeout = 3
+
+	config.enabled, err = parseBool("assetApi", "enabled", data)
+	if err != nil {
+		return nil, err
+	}
+	if _, hasRenderOnRead := data["renderOnRead"]; hasRenderOnRead {
+		config.renderOnRead, err = parseBool("assetApi", "renderOnRead", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, hasRenderOnReadTimeout := data["renderOnReadTimeout"]; hasRenderOnReadTimeout {
+		config.renderOnReadTimeout, err = parseInt("assetApi", "renderOnReadTimeout", data)
 		if err != nil {
 			return nil, err
 		}
@@ -395,7 +775,462 @@ func newUserDownloaderAppConfig(m map[string]interface{}) (DownloaderAppConfig,
 	return config, nil
 }
 
-func (c *userAppConfig) Source() string {
+func newUserAdminApiAppConfig(m map[string]interface{}) (AdminApiAppConfig, error) {
+	config := new(userAdminApiAppConfig)
+	config.token = ""
+
+	// NKG: The adminApi group is optional. Without a token, admin resources
+	// that change state or expose configuration are disabled.
+	if _, hasGroup := m["adminApi"]; !hasGroup {
+		return config, nil
+	}
+
+	data, err := parseConfigGroup("adminApi", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config.token, err = parseString("adminApi", "token", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func newUserReaperAppConfig(m map[string]interface{}) (ReaperAppConfig, error) {
+	config := new(userReaperAppConfig)
+	config.enabled = true
+	config.leaseTimeout = 600
+	config.maxAttempts = 3
+	config.interval = 60
+
+	// NKG: The reaper group is optional and the defaults above are used
+	// when it isn't present.
+	if _, hasGroup := m["reaper"]; !hasGroup {
+		return config, nil
+	}
+
+	data, err := parseConfigGroup("reaper", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config.enabled, err = parseBool("reaper", "enabled", data)
+	if err != nil {
+		return nil, err
+	}
+	config.leaseTimeout, err = parseInt("reaper", "leaseTimeout", data)
+	if err != nil {
+		return nil, err
+	}
+	config.maxAttempts, err = parseInt("reaper", "maxAttempts", data)
+	if err != nil {
+		return nil, err
+	}
+	config.interval, err = parseInt("reaper", "interval", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func newUserRetryAppConfig(m map[string]interface{}) (RetryAppConfig, error) {
+	config := new(userRetryAppConfig)
+	config.enabled = true
+	config.maxAttempts = 5
+	config.backoff = 30
+	config.maxBackoff = 3600
+	config.retryableErrors = []string{}
+
+	// NKG: The retry group is optional and the defaults above are used
+	// when it isn't present.
+	if _, hasGroup := m["retry"]; !hasGroup {
+		return config, nil
+	}
+
+	data, err := parseConfigGroup("retry", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config.enabled, err = parseBool("retry", "enabled", data)
+	if err != nil {
+		return nil, err
+	}
+	config.maxAttempts, err = parseInt("retry", "maxAttempts", data)
+	if err != nil {
+		return nil, err
+	}
+	config.backoff, err = parseInt("retry", "backoff", data)
+	if err != nil {
+		return nil, err
+	}
+	config.maxBackoff, err = parseInt("retry", "maxBackoff", data)
+	if err != nil {
+		return nil, err
+	}
+	if _, hasRetryableErrors := data["retryableErrors"]; hasRetryableErrors {
+		config.retryableErrors, err = parseStringArray("retry", "retryableErrors", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func newUserRetentionAppConfig(m map[string]interface{}) (RetentionAppConfig, error) {
+	config := new(userRetentionAppConfig)
+	config.enabled = true
+	config.interval = 3600
+	config.fileTypes = make(map[string]int64)
+
+	// NKG: The retention group is optional and the defaults above are used
+	// when it isn't present. Without file types, source assets are only
+	// expired when the preview request includes an expiration.
+	if _, hasGroup := m["retention"]; !hasGroup {
+		return config, nil
+	}
+
+	data, err := parseConfigGroup("retention", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config.enabled, err = parseBool("retention", "enabled", data)
+	if err != nil {
+		return nil, err
+	}
+	config.interval, err = parseInt("retention", "interval", data)
+	if err != nil {
+		return nil, err
+	}
+	if _, hasFileTypes := data["fileTypes"]; hasFileTypes {
+		config.fileTypes, err = parseFileSizeMap("retention", "fileTypes", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func newUserSignatureAppConfig(m map[string]interface{}) (SignatureAppConfig, error) {
+	config := new(userSignatureAppConfig)
+	config.verify = false
+	config.keys = make(map[string]string)
+	config.ttl = 300
+
+	// NKG: The signature group is optional. Without keys, the signature
+	// manager creates a random key that is only valid on this node.
+	if _, hasGroup := m["signature"]; !hasGroup {
+		return config, nil
+	}
+
+	data, err := parseConfigGroup("signature", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config.verify, err = parseBool("signature", "verify", data)
+	if err != nil {
+		return nil, err
+	}
+	config.ttl, err = parseInt("signature", "ttl", data)
+	if err != nil {
+		return nil, err
+	}
+	config.keys, err = parseStringMap("signature", "keys", data)
+	if err != nil {
+		return nil, err
+	}
+	config.activeKey, err = parseString("signature", "activeKey", data)
+	if err != nil {
+		return nil, err
+	}
+	if _, hasKey := config.keys[config.activeKey]; !hasKey {
+		return nil, appConfigError{"Invalid signature config: activeKey is not one of the keys"}
+	}
+
+	return config, nil
+}
+
+func newUserWebhooksAppConfig(m map[string]interface{}) (WebhooksAppConfig, error) {
+	config := new(userWebhooksAppConfig)
+	config.enabled = false
+	config.secret = ""
+	config.callbackHosts = []string{}
+	config.maxAttempts = 5
+	config.backoff = 10
+	config.timeout = 10
+	config.assetEvents = false
+
+	// NKG: The webhooks group is optional and the defaults above are used
+	// when it isn't present.
+	if _, hasGroup := m["webhooks"]; !hasGroup {
+		return config, nil
+	}
+
+	data, err := parseConfigGroup("webhooks", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config.enabled, err = parseBool("webhooks", "enabled", data)
+	if err != nil {
+		return nil, err
+	}
+	config.secret, err = parseString("webhooks", "secret", data)
+	if err != nil {
+		return nil, err
+	}
+	// NKG: Callback urls are provided by clients, so notifications are
+	// always signed to let them verify where a notification came from.
+	if config.enabled && len(config.secret) == 0 {
+		return nil, appConfigError{"Invalid webhooks config: secret is required when enabled"}
+	}
+	if _, hasCallbackHosts := data["callbackHosts"]; hasCallbackHosts {
+		config.callbackHosts, err = parseStringArray("webhooks", "callbackHosts", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	config.maxAttempts, err = parseInt("webhooks", "maxAttempts", data)
+	if err != nil {
+		return nil, err
+	}
+	config.backoff, err = parseInt("webhooks", "backoff", data)
+	if err != nil {
+		return nil, err
+	}
+	config.timeout, err = parseInt("webhooks", "timeout", data)
+	if err != nil {
+		return nil, err
+	}
+	config.assetEvents, err = parseBool("webhooks", "assetEvents", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func newUserTemplateAppConfigs(m map[string]interface{}) ([]TemplateAppConfig, error) {
+	results := make([]TemplateAppConfig, 0, 0)
+
+	// NKG: The templates list is optional. Templates that are not defined
+	// in configuration can still be managed through the admin API.
+	rawTemplates, hasTemplates := m["templates"]
+	if !hasTemplates {
+		return results, nil
+	}
+	templates, ok := rawTemplates.([]interface{})
+	if !ok {
+		return nil, appConfigError{"Invalid templates config: not a list"}
+	}
+
+	for _, rawTemplate := range templates {
+		data, ok := rawTemplate.(map[string]interface{})
+		if !ok {
+			return nil, appConfigError{"Invalid templates config: template is not an object"}
+		}
+
+		var err error
+		config := new(userTemplateAppConfig)
+		config.id, err = parseString("templates", "id", data)
+		if err != nil {
+			return nil, err
+		}
+		config.renderer, err = parseString("templates", "renderer", data)
+		if err != nil {
+			return nil, err
+		}
+		config.group, err = parseString("templates", "group", data)
+		if err != nil {
+			return nil, err
+		}
+
+		config.fileTypes = make([]string, 0, 0)
+		if _, hasFileTypes := data["fileTypes"]; hasFileTypes {
+			config.fileTypes, err = parseStringArray("templates", "fileTypes", data)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		config.attributes = make(map[string][]string)
+		if rawAttributes, hasAttributes := data["attributes"]; hasAttributes {
+			attributes, ok := rawAttributes.(map[string]interface{})
+			if !ok {
+				return nil, appConfigError{"Invalid templates config: attributes attribute not an object"}
+			}
+			for key, value := range attributes {
+				switch typedValue := value.(type) {
+				case string:
+					config.attributes[key] = []string{typedValue}
+				case float64:
+					config.attributes[key] = []string{strconv.FormatFloat(typedValue, 'f', -1, 64)}
+				default:
+					values, err := getStringArray(value)
+					if err != nil {
+						return nil, appConfigError{"Invalid templates config: attribute " + key + " is not a string, number or list of strings"}
+					}
+					config.attributes[key] = values
+				}
+			}
+		}
+
+		results = append(results, config)
+	}
+
+	return results, nil
+}
+
+func newUserRouteAppConfigs(m map[string]interface{}) ([]RouteAppConfig, error) {
+	results := make([]RouteAppConfig, 0, 0)
+
+	// NKG: The routing list is optional. Routes are also created for the
+	// supported file types of each render agent.
+	rawRoutes, hasRoutes := m["routing"]
+	if !hasRoutes {
+		return results, nil
+	}
+	routes, ok := rawRoutes.([]interface{})
+	if !ok {
+		return nil, appConfigError{"Invalid routing config: not a list"}
+	}
+
+	for _, rawRoute := range routes {
+		data, ok := rawRoute.(map[string]interface{})
+		if !ok {
+			return nil, appConfigError{"Invalid routing config: route is not an object"}
+		}
+
+		var err error
+		config := new(userRouteAppConfig)
+		config.renderer, err = parseString("routing", "renderer", data)
+		if err != nil {
+			return nil, err
+		}
+
+		config.fileTypes = make([]string, 0, 0)
+		if _, hasFileTypes := data["fileTypes"]; hasFileTypes {
+			config.fileTypes, err = parseStringArray("routing", "fileTypes", data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		config.mimeTypes = make([]string, 0, 0)
+		if _, hasMimeTypes := data["mimeTypes"]; hasMimeTypes {
+			config.mimeTypes, err = parseStringArray("routing", "mimeTypes", data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(config.fileTypes) == 0 && len(config.mimeTypes) == 0 {
+			return nil, appConfigError{"Invalid routing config: route must have fileTypes or mimeTypes"}
+		}
+		config.templates = make([]string, 0, 0)
+		if _, hasTemplates := data["templates"]; hasTemplates {
+			config.templates, err = parseStringArray("routing", "templates", data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if _, hasMaxSize := data["maxSize"]; hasMaxSize {
+			maxSize, err := getFloat(data["maxSize"])
+			if err != nil {
+				return nil, appConfigError{"Invalid routing config: maxSize attribute not an int"}
+			}
+			config.maxSize = int64(maxSize)
+		}
+
+		results = append(results, config)
+	}
+
+	return results, nil
+}
+
+func newUserUploaderAppConfig(m map[string]interface{}) (UploaderAppConfig, error) {
+	data, err := parseConfigGroup("uploader", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(userUploaderAppConfig)
+
+	config.engine, err = parseString("uploader", "engine", data)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.engine == "s3" {
+		config.s3Key, err = parseString("uploader", "s3Key", data)
+		if err != nil {
+			return nil, err
+		}
+		config.s3Secret, err = parseString("uploader", "s3Secret", data)
+		if err != nil {
+			return nil, err
+		}
+		config.s3Host, err = parseString("uploader", "s3Host", data)
+		if err != nil {
+			return nil, err
+		}
+		config.s3Buckets, err = parseStringArray("uploader", "s3Buckets", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func newUserDownloaderAppConfig(m map[string]interface{}) (DownloaderAppConfig, error) {
+	data, err := parseConfigGroup("downloader", m)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(userDownloaderAppConfig)
+
+	config.basePath, err = parseString("downloader", "basePath", data)
+	if err != nil {
+		return nil, err
+	}
+
+	config.tramEnabled, err = parseBool("downloader", "tramEnabled", data)
+	if err != nil {
+		return nil, err
+	}
+	if config.tramEnabled {
+		config.tramHosts, err = parseStringArray("downloader", "tramHosts", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, hasDetectFileTypes := data["detectFileTypes"]; hasDetectFileTypes {
+		config.detectFileTypes, err = parseBool("downloader", "detectFileTypes", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config.detectTimeout = 2
+	if _, hasDetectTimeout := data["detectTimeout"]; hasDetectTimeout {
+		config.detectTimeout, err = parseInt("downloader", "detectTimeout", data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+func (c *userAppConfig) Source() string {
 	return c.source
 }
 
@@ -419,6 +1254,30 @@ func (c *userAppConfig) DocumentRenderAgent() DocumentRenderAgentAppConfig {
 	return c.documentRenderAgentAppConfig
 }
 
+func (c *userAppConfig) NativeRenderAgent() NativeRenderAgentAppConfig {
+	return c.nativeRenderAgentAppConfig
+}
+
+func (c *userAppConfig) VideoRenderAgent() VideoRenderAgentAppConfig {
+	return c.videoRenderAgentAppConfig
+}
+
+func (c *userAppConfig) TextRenderAgent() TextRenderAgentAppConfig {
+	return c.textRenderAgentAppConfig
+}
+
+func (c *userAppConfig) SvgRenderAgent() SvgRenderAgentAppConfig {
+	return c.svgRenderAgentAppConfig
+}
+
+func (c *userAppConfig) ArchiveRenderAgent() ArchiveRenderAgentAppConfig {
+	return c.archiveRenderAgentAppConfig
+}
+
+func (c *userAppConfig) AudioRenderAgent() AudioRenderAgentAppConfig {
+	return c.audioRenderAgentAppConfig
+}
+
 func (c *userAppConfig) SimpleApi() SimpleApiAppConfig {
 	return c.simpleApiAppConfig
 }
@@ -427,6 +1286,10 @@ func (c *userAppConfig) AssetApi() AssetApiAppConfig {
 	return c.assetApiAppConfig
 }
 
+func (c *userAppConfig) AdminApi() AdminApiAppConfig {
+	return c.adminApiAppConfig
+}
+
 func (c *userAppConfig) Uploader() UploaderAppConfig {
 	return c.uploaderAppConfig
 }
@@ -435,6 +1298,38 @@ func (c *userAppConfig) Downloader() DownloaderAppConfig {
 	return c.downloaderAppConfig
 }
 
+func (c *userAppConfig) Routing() []RouteAppConfig {
+	return c.routeAppConfigs
+}
+
+func (c *userAppConfig) Templates() []TemplateAppConfig {
+	return c.templateAppConfigs
+}
+
+func (c *userAppConfig) Reaper() ReaperAppConfig {
+	return c.reaperAppConfig
+}
+
+func (c *userAppConfig) Retry() RetryAppConfig {
+	return c.retryAppConfig
+}
+
+func (c *userAppConfig) Retention() RetentionAppConfig {
+	return c.retentionAppConfig
+}
+
+func (c *userAppConfig) Signature() SignatureAppConfig {
+	return c.signatureAppConfig
+}
+
+func (c *userAppConfig) Webhooks() WebhooksAppConfig {
+	return c.webhooksAppConfig
+}
+
+func (c *userAdminApiAppConfig) Token() string {
+	return c.token
+}
+
 func (c *userHttpAppConfig) Listen() string {
 	return c.listen
 }
@@ -457,6 +1352,13 @@ func (c *userStorageAppConfig) CassandraKeyspace() (string, error) {
 	return "", appConfigError{"Cassandra storage engine is not enabled."}
 }
 
+func (c *userStorageAppConfig) BoltPath() (string, error) {
+	if c.engine == "bolt" {
+		return c.boltPath, nil
+	}
+	return "", appConfigError{"Bolt storage engine is not enabled."}
+}
+
 func (c *userImageMagickRenderAgentAppConfig) Enabled() bool {
 	return c.enabled
 }
@@ -469,6 +1371,58 @@ func (c *userImageMagickRenderAgentAppConfig) SupportedFileTypes() map[string]in
 	return c.supportedFileTypes
 }
 
+func (c userCommandLimitsAppConfig) Timeout() int {
+	return c.timeout
+}
+
+func (c userCommandLimitsAppConfig) CpuLimit() int {
+	return c.cpuLimit
+}
+
+func (c userCommandLimitsAppConfig) MemoryLimit() int64 {
+	return c.memoryLimit
+}
+
+func (c userCommandLimitsAppConfig) FileSizeLimit() int64 {
+	return c.fileSizeLimit
+}
+
+func (c *userVideoRenderAgentAppConfig) FfmpegPath() string {
+	return c.ffmpegPath
+}
+
+func (c *userVideoRenderAgentAppConfig) FfprobePath() string {
+	return c.ffprobePath
+}
+
+func (c userRenderAgentAppConfig) Enabled() bool {
+	return c.enabled
+}
+
+func (c userRenderAgentAppConfig) Count() int {
+	return c.count
+}
+
+func (c userRenderAgentAppConfig) SupportedFileTypes() map[string]int64 {
+	return c.supportedFileTypes
+}
+
+func (c *userSvgRenderAgentAppConfig) RsvgConvertPath() string {
+	return c.rsvgConvertPath
+}
+
+func (c *userArchiveRenderAgentAppConfig) ImagePreviews() bool {
+	return c.imagePreviews
+}
+
+func (c *userAudioRenderAgentAppConfig) FfmpegPath() string {
+	return c.ffmpegPath
+}
+
+func (c *userAudioRenderAgentAppConfig) FfprobePath() string {
+	return c.ffprobePath
+}
+
 func (c *userDocumentRenderAgentAppConfig) Enabled() bool {
 	return c.enabled
 }
@@ -481,6 +1435,14 @@ func (c *userDocumentRenderAgentAppConfig) BasePath() string {
 	return c.basePath
 }
 
+func (c *userDocumentRenderAgentAppConfig) SupportedFileTypes() map[string]int64 {
+	return c.supportedFileTypes
+}
+
+func (c *userDocumentRenderAgentAppConfig) PageLimits() map[string]int64 {
+	return c.pageLimits
+}
+
 func (c *userSimpleApiAppConfig) Enabled() bool {
 	return c.enabled
 }
@@ -497,6 +1459,14 @@ func (c *userAssetApiAppConfig) Enabled() bool {
 	return c.enabled
 }
 
+func (c *userAssetApiAppConfig) RenderOnRead() bool {
+	return c.renderOnRead
+}
+
+func (c *userAssetApiAppConfig) RenderOnReadTimeout() int {
+	return c.renderOnReadTimeout
+}
+
 func (c *userUploaderAppConfig) Engine() string {
 	return c.engine
 }
@@ -543,6 +1513,14 @@ func (c *userDownloaderAppConfig) TramHosts() ([]string, error) {
 	return nil, appConfigError{"Tram support is not enabled."}
 }
 
+func (c *userDownloaderAppConfig) DetectFileTypes() bool {
+	return c.detectFileTypes
+}
+
+func (c *userDownloaderAppConfig) DetectTimeout() int {
+	return c.detectTimeout
+}
+
 func (c *userCommonAppConfig) NodeId() string {
 	return c.nodeId
 }
@@ -562,3 +1540,139 @@ func (c *userCommonAppConfig) PlaceholderGroups() map[string][]string {
 func (c *userCommonAppConfig) WorkDispatcherEnabled() bool {
 	return c.workDispatcherEnabled
 }
+
+func (c *userCommonAppConfig) MaxImagePixels() int {
+	return c.maxImagePixels
+}
+
+func (c *userRetryAppConfig) Enabled() bool {
+	return c.enabled
+}
+
+func (c *userRetryAppConfig) MaxAttempts() int {
+	return c.maxAttempts
+}
+
+func (c *userRetryAppConfig) Backoff() int {
+	return c.backoff
+}
+
+func (c *userRetryAppConfig) MaxBackoff() int {
+	return c.maxBackoff
+}
+
+func (c *userRetryAppConfig) RetryableErrors() []string {
+	return c.retryableErrors
+}
+
+func (c *userRetentionAppConfig) Enabled() bool {
+	return c.enabled
+}
+
+func (c *userRetentionAppConfig) Interval() int {
+	return c.interval
+}
+
+func (c *userRetentionAppConfig) FileTypes() map[string]int64 {
+	return c.fileTypes
+}
+
+func (c *userReaperAppConfig) Enabled() bool {
+	return c.enabled
+}
+
+func (c *userReaperAppConfig) LeaseTimeout() int {
+	return c.leaseTimeout
+}
+
+func (c *userReaperAppConfig) MaxAttempts() int {
+	return c.maxAttempts
+}
+
+func (c *userReaperAppConfig) Interval() int {
+	return c.interval
+}
+
+func (c *userSignatureAppConfig) Verify() bool {
+	return c.verify
+}
+
+func (c *userSignatureAppConfig) Keys() map[string]string {
+	return c.keys
+}
+
+func (c *userSignatureAppConfig) ActiveKey() string {
+	return c.activeKey
+}
+
+func (c *userSignatureAppConfig) Ttl() int {
+	return c.ttl
+}
+
+func (c *userWebhooksAppConfig) Enabled() bool {
+	return c.enabled
+}
+
+func (c *userWebhooksAppConfig) Secret() string {
+	return c.secret
+}
+
+func (c *userWebhooksAppConfig) CallbackHosts() []string {
+	return c.callbackHosts
+}
+
+func (c *userWebhooksAppConfig) MaxAttempts() int {
+	return c.maxAttempts
+}
+
+func (c *userWebhooksAppConfig) Backoff() int {
+	return c.backoff
+}
+
+func (c *userWebhooksAppConfig) Timeout() int {
+	return c.timeout
+}
+
+func (c *userWebhooksAppConfig) AssetEvents() bool {
+	return c.assetEvents
+}
+
+func (c *userTemplateAppConfig) Id() string {
+	return c.id
+}
+
+func (c *userTemplateAppConfig) Renderer() string {
+	return c.renderer
+}
+
+func (c *userTemplateAppConfig) Group() string {
+	return c.group
+}
+
+func (c *userTemplateAppConfig) Attributes() map[string][]string {
+	return c.attributes
+}
+
+func (c *userTemplateAppConfig) FileTypes() []string {
+	return c.fileTypes
+}
+
+func (c *userRouteAppConfig) FileTypes() []string {
+	return c.fileTypes
+}
+
+func (c *userRouteAppConfig) MimeTypes() []string {
+	return c.mimeTypes
+}
+
+func (c *userRouteAppConfig) Renderer() string {
+	return c.renderer
+}
+
+func (c *userRouteAppConfig) Templates() []string {
+	return c.templates
+}
+
+func (c *userRouteAppConfig) MaxSize() int64 {
+	return c.maxSize
+}
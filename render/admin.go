@@ -0,0 +1,232 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"log"
+)
+
+// GeneratedAssetFilter selects the generated assets that admin operations act on. Empty fields match every generated asset.
+type GeneratedAssetFilter struct {
+	SourceAssetId string
+	TemplateId    string
+	// ErrorCode is the code of the error that a failed generated asset failed with, such as "PRVCOM17".
+	ErrorCode string
+	// Since and Until limit generated assets to those last updated within the time range, in nanoseconds.
+	Since int64
+	Until int64
+}
+
+func (filter GeneratedAssetFilter) matches(generatedAsset *common.GeneratedAsset) bool {
+	if len(filter.SourceAssetId) > 0 && generatedAsset.SourceAssetId != filter.SourceAssetId {
+		return false
+	}
+	if len(filter.TemplateId) > 0 && generatedAsset.TemplateId != filter.TemplateId {
+		return false
+	}
+	if len(filter.ErrorCode) > 0 {
+		codedError, hasCodedError := common.ParseGeneratedAssetError(generatedAsset.Status)
+		if !hasCodedError || codedError.Error() != filter.ErrorCode {
+			return false
+		}
+	}
+	if filter.Since > 0 && generatedAsset.UpdatedAt < filter.Since {
+		return false
+	}
+	if filter.Until > 0 && generatedAsset.UpdatedAt > filter.Until {
+		return false
+	}
+	return true
+}
+
+// Requeue moves failed generated assets that match the filter back to the waiting state so that they are rendered again. Their attempts, not before and dead letter attributes are cleared. The ids of the requeued generated assets are returned.
+func (agentManager *RenderAgentManager) Requeue(filter GeneratedAssetFilter) ([]string, error) {
+	statuses := make([]string, 0, 0)
+	for _, err := range common.AllErrors {
+		if len(filter.ErrorCode) == 0 || err.Error() == filter.ErrorCode {
+			statuses = append(statuses, common.NewGeneratedAssetError(err))
+		}
+	}
+	generatedAssets, err := agentManager.findGeneratedAssets(filter, statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, 0)
+	for _, generatedAsset := range generatedAssets {
+		generatedAsset.Status = common.GeneratedAssetStatusWaiting
+		generatedAsset.Attributes = withoutAttributes(generatedAsset.Attributes, common.GeneratedAssetAttributeAttempts, common.GeneratedAssetAttributeRetryAttempts, common.GeneratedAssetAttributeNotBefore, common.GeneratedAssetAttributeDeadLetter)
+		err := agentManager.generatedAssetStorageManager.Update(generatedAsset)
+		if err != nil {
+			log.Println("Error requeuing generated asset", generatedAsset.Id, err)
+			continue
+		}
+		agentManager.notifyListeners(RenderStatus{GeneratedAssetId: generatedAsset.Id, SourceAssetId: generatedAsset.SourceAssetId, Status: generatedAsset.Status, Service: agentManager.renderer(generatedAsset)})
+		ids = append(ids, generatedAsset.Id)
+	}
+	log.Println("Requeued", len(ids), "generated assets")
+	return ids, nil
+}
+
+// Cancel marks waiting, scheduled and processing generated assets that match the filter as failed with the canceled error. Scheduled generated assets that have already been dispatched are skipped by the render agent, and render agents discard the files of processing generated assets instead of completing them. The ids of the canceled generated assets are returned.
+func (agentManager *RenderAgentManager) Cancel(filter GeneratedAssetFilter) ([]string, error) {
+	generatedAssets, err := agentManager.findGeneratedAssets(filter, []string{common.GeneratedAssetStatusWaiting, common.GeneratedAssetStatusScheduled, common.GeneratedAssetStatusProcessing})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, 0)
+	for _, generatedAsset := range generatedAssets {
+		status := common.NewGeneratedAssetError(common.ErrorGeneratedAssetCanceled)
+		err := agentManager.generatedAssetStorageManager.UpdateStatus(generatedAsset.Id, generatedAsset.Status, status)
+		if err != nil {
+			log.Println("Error canceling generated asset", generatedAsset.Id, err)
+			continue
+		}
+		generatedAsset.Status = status
+		agentManager.removeActiveWork(generatedAsset.Id)
+		agentManager.notifyListeners(RenderStatus{GeneratedAssetId: generatedAsset.Id, SourceAssetId: generatedAsset.SourceAssetId, Status: generatedAsset.Status, Service: agentManager.renderer(generatedAsset)})
+		ids = append(ids, generatedAsset.Id)
+	}
+	log.Println("Canceled", len(ids), "generated assets")
+	return ids, nil
+}
+
+// Purge deletes a source asset, all of its generated assets and all of the files uploaded for them, including resized images and additional output formats. The ids of the deleted generated assets are returned.
+func (agentManager *RenderAgentManager) Purge(sourceAssetId string) ([]string, error) {
+	generatedAssets, err := agentManager.generatedAssetStorageManager.FindBySourceAssetId(sourceAssetId)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, 0)
+	for _, generatedAsset := range generatedAssets {
+		if generatedAsset.Status == common.GeneratedAssetStatusComplete {
+			locations := []string{generatedAsset.Location}
+			for index, output := range generatedAsset.GetAttribute(common.GeneratedAssetAttributeOutputs) {
+				if index > 0 {
+					locations = append(locations, common.OutputLocation(generatedAsset.Location, output))
+				}
+			}
+			for _, location := range locations {
+				err := agentManager.uploader.Delete(location)
+				if err != nil {
+					log.Println("Error deleting uploaded file", location, err)
+				}
+			}
+		}
+		err := agentManager.generatedAssetStorageManager.Delete(generatedAsset.Id)
+		if err != nil {
+			return ids, err
+		}
+		agentManager.removeActiveWork(generatedAsset.Id)
+		ids = append(ids, generatedAsset.Id)
+	}
+
+	sourceAssets, err := agentManager.sourceAssetStorageManager.FindBySourceAssetId(sourceAssetId)
+	if err != nil {
+		return ids, err
+	}
+	for _, sourceAsset := range sourceAssets {
+		for _, location := range sourceAsset.GetAttribute(common.SourceAssetAttributeDerivatives) {
+			err := agentManager.uploader.Delete(location)
+			if err != nil {
+				log.Println("Error deleting resized file", location, err)
+			}
+		}
+	}
+
+	err = agentManager.sourceAssetStorageManager.Delete(sourceAssetId)
+	if err != nil {
+		return ids, err
+	}
+	log.Println("Purged source asset", sourceAssetId, "and", len(ids), "generated assets")
+	return ids, nil
+}
+
+func (agentManager *RenderAgentManager) findGeneratedAssets(filter GeneratedAssetFilter, statuses []string) ([]*common.GeneratedAsset, error) {
+	candidates := make([]*common.GeneratedAsset, 0, 0)
+	if len(filter.SourceAssetId) > 0 {
+		generatedAssets, err := agentManager.generatedAssetStorageManager.FindBySourceAssetId(filter.SourceAssetId)
+		if err != nil {
+			return nil, err
+		}
+		for _, generatedAsset := range generatedAssets {
+			for _, status := range statuses {
+				if generatedAsset.Status == status {
+					candidates = append(candidates, generatedAsset)
+				}
+			}
+		}
+	} else {
+		for _, status := range statuses {
+			generatedAssets, err := agentManager.generatedAssetStorageManager.FindByStatus(status)
+			if err != nil {
+				return nil, err
+			}
+			candidates = append(candidates, generatedAssets...)
+		}
+	}
+
+	results := make([]*common.GeneratedAsset, 0, 0)
+	for _, generatedAsset := range candidates {
+		if filter.matches(generatedAsset) {
+			results = append(results, generatedAsset)
+		}
+	}
+	return results, nil
+}
+
+// isCanceled returns true if a generated asset has been canceled or purged since it was dispatched. Render agents check this before and after uploading so that files are not kept for purged source assets.
+func isCanceled(generatedAssetStorageManager common.GeneratedAssetStorageManager, id string) bool {
+	generatedAsset, err := generatedAssetStorageManager.FindById(id)
+	if err != nil {
+		return true
+	}
+	return generatedAsset.Status == common.NewGeneratedAssetError(common.ErrorGeneratedAssetCanceled)
+}
+
+// discardIfCanceled deletes the files uploaded for a generated asset and returns true if it was canceled or purged while they were being uploaded.
+func discardIfCanceled(generatedAssetStorageManager common.GeneratedAssetStorageManager, uploader common.Uploader, id string, locations map[string]string) bool {
+	if !isCanceled(generatedAssetStorageManager, id) {
+		return false
+	}
+	for _, location := range locations {
+		if err := uploader.Delete(location); err != nil {
+			log.Println("Error deleting uploaded file", location, "of canceled generated asset", id, err)
+		}
+	}
+	return true
+}
+
+func (agentManager *RenderAgentManager) removeActiveWork(generatedAssetId string) {
+	agentManager.mu.Lock()
+	defer agentManager.mu.Unlock()
+
+	for name, activeWork := range agentManager.activeWork {
+		agentManager.activeWork[name] = listWithout(activeWork, generatedAssetId)
+	}
+}
+
+func (agentManager *RenderAgentManager) renderer(generatedAsset *common.GeneratedAsset) string {
+	templates, err := agentManager.templateManager.FindByIds([]string{generatedAsset.TemplateId})
+	if err == nil && len(templates) > 0 {
+		return templates[0].Renderer
+	}
+	return ""
+}
+
+func withoutAttributes(attributes []common.Attribute, keys ...string) []common.Attribute {
+	results := make([]common.Attribute, 0, 0)
+	for _, attribute := range attributes {
+		keep := true
+		for _, key := range keys {
+			if attribute.Key == key {
+				keep = false
+			}
+		}
+		if keep {
+			results = append(results, attribute)
+		}
+	}
+	return results
+}
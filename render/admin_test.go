@@ -0,0 +1,279 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRequeueAndCancel(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	statuses := map[*common.Template]string{
+		common.DefaultTemplateSmall:  common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset),
+		common.DefaultTemplateMedium: common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage),
+		common.DefaultTemplateLarge:  common.GeneratedAssetStatusWaiting,
+		common.DefaultTemplateJumbo:  common.GeneratedAssetStatusComplete,
+	}
+	for template, status := range statuses {
+		generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, template, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/"+template.Id)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		generatedAsset.Status = status
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeAttempts, []string{"5"})
+		gasm.Store(generatedAsset)
+	}
+
+	ids, err := rm.Requeue(GeneratedAssetFilter{ErrorCode: common.ErrorCouldNotUploadAsset.Error()})
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(ids) != 1 {
+		t.Error("One requeued generated asset expected:", ids)
+		return
+	}
+	generatedAsset, err := gasm.FindById(ids[0])
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if generatedAsset.Status != common.GeneratedAssetStatusWaiting || generatedAsset.TemplateId != common.DefaultTemplateSmall.Id {
+		t.Errorf("Unexpected requeued generated asset: (%+v)", generatedAsset)
+	}
+	if len(generatedAsset.GetAttribute(common.GeneratedAssetAttributeAttempts)) != 0 {
+		t.Error("Attempts attribute expected to be cleared", generatedAsset.Attributes)
+	}
+
+	ids, err = rm.Requeue(GeneratedAssetFilter{TemplateId: common.DefaultTemplateJumbo.Id})
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(ids) != 0 {
+		t.Error("Complete generated assets are not expected to be requeued:", ids)
+	}
+
+	ids, err = rm.Cancel(GeneratedAssetFilter{SourceAssetId: sourceAsset.Id})
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(ids) != 2 {
+		t.Error("Two canceled generated assets expected:", ids)
+		return
+	}
+	for _, id := range ids {
+		generatedAsset, err := gasm.FindById(id)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		if generatedAsset.Status != common.NewGeneratedAssetError(common.ErrorGeneratedAssetCanceled) {
+			t.Errorf("Unexpected canceled generated asset: (%+v)", generatedAsset)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	uploader := common.NewLocalUploader(dm.Path)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), uploader, true)
+	defer rm.Stop()
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sasm.Store(sourceAsset)
+
+	source := filepath.Join(dm.Path, "source.jpg")
+	err = ioutil.WriteFile(source, []byte("not really a jpg"), 0644)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	location := uploader.Url(sourceAsset.Id, common.DefaultTemplateSmall.Id, "small", 0)
+	err = uploader.Upload(location, source)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	uploaded := filepath.Join(dm.Path, sourceAsset.Id, "small", "0")
+	if _, err := os.Stat(uploaded); err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, location)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset.Status = common.GeneratedAssetStatusComplete
+	gasm.Store(generatedAsset)
+
+	ids, err := rm.Purge(sourceAsset.Id)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(ids) != 1 || ids[0] != generatedAsset.Id {
+		t.Error("Purged generated asset expected:", ids)
+	}
+
+	if _, err := os.Stat(uploaded); !os.IsNotExist(err) {
+		t.Error("Uploaded file expected to be deleted", err)
+	}
+	sourceAssets, _ := sasm.FindBySourceAssetId(sourceAsset.Id)
+	if len(sourceAssets) != 0 {
+		t.Error("No source assets expected:", sourceAssets)
+	}
+	generatedAssets, _ := gasm.FindBySourceAssetId(sourceAsset.Id)
+	if len(generatedAssets) != 0 {
+		t.Error("No generated assets expected:", generatedAssets)
+	}
+}
+
+func TestDiscardIfCanceled(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	uploader := common.NewLocalUploader(dm.Path)
+
+	sourceAsset, err := common.NewSourceAsset("7C1E9A42-5B3D-4F86-A0E2-9D4B6C8F1A35", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///7C1E9A42-5B3D-4F86-A0E2-9D4B6C8F1A35/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset.Status = common.GeneratedAssetStatusProcessing
+	gasm.Store(generatedAsset)
+
+	renderedPath := filepath.Join(dm.Path, "rendered.jpg")
+	ioutil.WriteFile(renderedPath, []byte("rendered"), 0644)
+	locations := map[string]string{"jpg": generatedAsset.Location}
+	uploadedPath := filepath.Join(dm.Path, "7C1E9A42-5B3D-4F86-A0E2-9D4B6C8F1A35", "small", "0")
+
+	if err := uploader.Upload(generatedAsset.Location, renderedPath); err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if discardIfCanceled(gasm, uploader, generatedAsset.Id, locations) {
+		t.Error("Generated asset that is still processing was discarded")
+	}
+	if _, err := os.Stat(uploadedPath); err != nil {
+		t.Error("Uploaded file was removed:", err)
+	}
+
+	// NKG: The source asset is purged while the file is being uploaded.
+	gasm.Delete(generatedAsset.Id)
+	if !discardIfCanceled(gasm, uploader, generatedAsset.Id, locations) {
+		t.Error("Purged generated asset was not discarded")
+	}
+	if _, err := os.Stat(uploadedPath); !os.IsNotExist(err) {
+		t.Error("Uploaded file of a purged generated asset was not removed:", err)
+	}
+}
+
+// cancelingGeneratedAssetStorageManager cancels each generated asset right after it is read, like a cancel made while a render agent is starting to render it.
+type cancelingGeneratedAssetStorageManager struct {
+	common.GeneratedAssetStorageManager
+}
+
+func (gasm cancelingGeneratedAssetStorageManager) FindById(id string) (*common.GeneratedAsset, error) {
+	generatedAsset, err := gasm.GeneratedAssetStorageManager.FindById(id)
+	if err != nil {
+		return nil, err
+	}
+	found := *generatedAsset
+	gasm.GeneratedAssetStorageManager.UpdateStatus(id, generatedAsset.Status, common.NewGeneratedAssetError(common.ErrorGeneratedAssetCanceled))
+	return &found, nil
+}
+
+func TestCancelWhileRendering(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	uploader := common.NewLocalUploader(dm.Path)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), uploader, true)
+	defer rm.Stop()
+
+	sourceAsset, err := common.NewSourceAsset("3F8D2B61-9C4E-4A17-B5D0-6E1A7C9F2B48", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sasm.Store(sourceAsset)
+	scheduled, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///3F8D2B61-9C4E-4A17-B5D0-6E1A7C9F2B48/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	scheduled.Status = common.GeneratedAssetStatusScheduled
+	gasm.Store(scheduled)
+	processing, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateLarge, "local:///3F8D2B61-9C4E-4A17-B5D0-6E1A7C9F2B48/large/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	processing.Status = common.GeneratedAssetStatusProcessing
+	gasm.Store(processing)
+
+	rendered := false
+	renderAgent := rm.newBaseRenderAgent(common.RenderAgentImageMagick, common.NewDownloader(dm.Path, dm.Path, common.NewTemporaryFileManager(), false, nil, nil), uploader)
+	renderAgent.gasm = cancelingGeneratedAssetStorageManager{gasm}
+	renderAgent.workProcessed = metrics.NewMeter()
+	renderAgent.render = func(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+		rendered = true
+	}
+	renderAgent.renderGeneratedAsset(scheduled.Id)
+	if rendered {
+		t.Error("Generated asset canceled before it was processing was rendered")
+	}
+	if scheduled.Status != common.NewGeneratedAssetError(common.ErrorGeneratedAssetCanceled) {
+		t.Errorf("Cancel was overwritten: (%+v)", scheduled)
+	}
+
+	ids, err := rm.Cancel(GeneratedAssetFilter{SourceAssetId: sourceAsset.Id})
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(ids) != 1 || ids[0] != processing.Id {
+		t.Error("Processing generated asset expected to be canceled:", ids)
+	}
+}
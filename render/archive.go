@@ -0,0 +1,479 @@
+package render
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/rcrowley/go-metrics"
+	"image"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxArchiveEntries is the number of entries read from an archive. Larger archives are listed using their first entries.
+	maxArchiveEntries = 10000
+	// maxStoredArchiveEntries is the number of entry names and sizes recorded with the source asset.
+	maxStoredArchiveEntries = 1000
+	// maxTarBytes is the number of decompressed bytes of a compressed tar file that are read looking for entries.
+	maxTarBytes = 2 << 30
+	// maxArchiveImageBytes is the size of the largest image in an archive that is decoded for an image preview.
+	maxArchiveImageBytes = 32 * 1024 * 1024
+)
+
+var (
+	zipSignatures = [][]byte{[]byte("PK\x03\x04"), []byte("PK\x05\x06")}
+	gzipSignature = []byte{0x1f, 0x8b}
+
+	archiveImageFileTypes = map[string]bool{"jpg": true, "jpeg": true, "png": true, "gif": true, "bmp": true, "tif": true, "tiff": true, "webp": true}
+)
+
+// archiveRenderAgent renders a listing of the files and directories in zip and tar files without extracting them.
+type archiveRenderAgent struct {
+	*baseRenderAgent
+	metrics        *archiveRenderAgentMetrics
+	imagePreviews  bool
+	maxImagePixels int
+}
+
+type archiveRenderAgentMetrics struct {
+	workProcessed metrics.Meter
+	convertTime   metrics.Timer
+}
+
+type archiveEntry struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+// archiveNode is a file or directory in the listing of an archive. Directories that only appear in the paths of other entries are included.
+type archiveNode struct {
+	path  []string
+	size  int64
+	isDir bool
+}
+
+type archiveNodes []*archiveNode
+
+func newArchiveRenderAgent(
+	base *baseRenderAgent,
+	metrics *archiveRenderAgentMetrics,
+	imagePreviews bool,
+	maxImagePixels int) RenderAgent {
+
+	renderAgent := new(archiveRenderAgent)
+	renderAgent.baseRenderAgent = base
+	renderAgent.metrics = metrics
+	renderAgent.imagePreviews = imagePreviews
+	renderAgent.maxImagePixels = maxImagePixels
+
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
+
+	return renderAgent
+}
+
+func newArchiveRenderAgentMetrics(registry metrics.Registry) *archiveRenderAgentMetrics {
+	archiveMetrics := new(archiveRenderAgentMetrics)
+	archiveMetrics.workProcessed = metrics.NewMeter()
+	archiveMetrics.convertTime = metrics.NewTimer()
+
+	registry.Register("archiveRenderAgent.workProcessed", archiveMetrics.workProcessed)
+	registry.Register("archiveRenderAgent.convertTime", archiveMetrics.convertTime)
+
+	return archiveMetrics
+}
+
+func (renderAgent *archiveRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+	width, height, err := renderAgent.getSize(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+	fit, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(nativeOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork), nil}
+		return
+	}
+	defer sourceFile.Release()
+
+	entries, truncated, err := readArchive(sourceFile.Path())
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotReadArchive), nil}
+		return
+	}
+	renderAgent.recordArchiveEntries(sourceAsset, entries, truncated)
+
+	// NKG: Archives without an image that can be decoded are previewed
+	// with a listing of their entries.
+	var preview image.Image
+	if renderAgent.imagePreviews {
+		if name, hasImage := firstArchiveImage(entries); hasImage {
+			preview, err = readArchiveImage(sourceFile.Path(), name, renderAgent.maxImagePixels)
+			if err != nil {
+				log.Println("Could not decode image", name, "of source asset", sourceAsset.Id, err)
+				preview = nil
+			}
+		}
+	}
+	lines := archiveListing(entries, truncated)
+	if maxLines := templateLines(template); len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+
+	quality := templateQuality(template)
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
+
+	imageWidth, imageHeight := width, height
+	renderAgent.metrics.convertTime.Time(func() {
+		var listing image.Image
+		if preview == nil {
+			listing, err = renderText(lines, width, height, background)
+			if err != nil {
+				return
+			}
+		}
+		for _, output := range outputs {
+			rendered := listing
+			if preview != nil {
+				rendered = fitImage(preview, width, height, fit, background, output)
+			}
+			imageWidth, imageHeight = rendered.Bounds().Dx(), rendered.Bounds().Dy()
+			err = encodeImage(rendered, destinations[output], output, quality)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
+
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
+		return
+	}
+
+	destination := destinations[outputs[0]]
+	generatedAssetFileSize, err := util.FileSize(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileSize), nil}
+		return
+	}
+
+	newAttributes := []common.Attribute{
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(imageHeight)}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(imageWidth)}),
+		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
+	}
+
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
+}
+
+// recordArchiveEntries adds the entryCount, entries and entrySizes attributes to the source asset if it doesn't already have them.
+func (renderAgent *archiveRenderAgent) recordArchiveEntries(sourceAsset *common.SourceAsset, entries []archiveEntry, truncated bool) {
+	if sourceAsset.HasAttribute(common.SourceAssetAttributeEntryCount) {
+		return
+	}
+	names := make([]string, 0, 0)
+	sizes := make([]string, 0, 0)
+	for i, entry := range entries {
+		if i == maxStoredArchiveEntries {
+			break
+		}
+		names = append(names, entry.name)
+		sizes = append(sizes, strconv.FormatInt(entry.size, 10))
+	}
+	// NKG: Each template of an archive is rendered separately, so the
+	// source asset is looked up again to avoid replacing attributes added
+	// since it was first looked up.
+	sourceAssets, err := renderAgent.sasm.FindBySourceAssetId(sourceAsset.Id)
+	if err != nil {
+		return
+	}
+	for _, current := range sourceAssets {
+		if current.IdType != sourceAsset.IdType || current.HasAttribute(common.SourceAssetAttributeEntryCount) {
+			continue
+		}
+		current.SetAttribute(common.SourceAssetAttributeEntryCount, []string{strconv.Itoa(len(entries))})
+		current.SetAttribute(common.SourceAssetAttributeEntries, names)
+		current.SetAttribute(common.SourceAssetAttributeEntrySizes, sizes)
+		if truncated {
+			current.SetAttribute(common.SourceAssetAttributeTruncated, []string{"true"})
+		}
+		err = renderAgent.sasm.Store(current)
+		if err != nil {
+			log.Println("Error recording archive attributes of source asset", current.Id, err)
+		}
+	}
+}
+
+// walkArchive calls visit with each entry of a zip file, tar file or gzip compressed tar file until it returns false. The contents of an entry can only be opened before visit returns.
+func walkArchive(archivePath string, visit func(entry archiveEntry, open func() (io.ReadCloser, error)) bool) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	signature := make([]byte, 4)
+	n, err := io.ReadFull(file, signature)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	signature = signature[:n]
+
+	for _, zipSignature := range zipSignatures {
+		if bytes.Equal(signature, zipSignature) {
+			return walkZip(archivePath, visit)
+		}
+	}
+
+	_, err = file.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	var reader io.Reader = file
+	if bytes.HasPrefix(signature, gzipSignature) {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		// NKG: Listing a compressed tar file means decompressing all of it,
+		// so a small file can't be used to keep an agent busy for long.
+		reader = io.LimitReader(gzipReader, maxTarBytes)
+	}
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		entry := archiveEntry{header.Name, header.Size, header.Typeflag == tar.TypeDir}
+		open := func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(tarReader), nil
+		}
+		if !visit(entry, open) {
+			return nil
+		}
+	}
+}
+
+func walkZip(archivePath string, visit func(entry archiveEntry, open func() (io.ReadCloser, error)) bool) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+	for _, file := range zipReader.File {
+		entry := archiveEntry{file.Name, int64(file.UncompressedSize64), file.FileInfo().IsDir()}
+		if !visit(entry, file.Open) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readArchive returns the entries of an archive in the order they are stored, and whether the archive has more entries than were read. Archives that are damaged after their first entries are listed using the entries that could be read.
+func readArchive(archivePath string) ([]archiveEntry, bool, error) {
+	entries := make([]archiveEntry, 0, 0)
+	truncated := false
+	err := walkArchive(archivePath, func(entry archiveEntry, open func() (io.ReadCloser, error)) bool {
+		if len(entries) == maxArchiveEntries {
+			truncated = true
+			return false
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	if err != nil {
+		if len(entries) == 0 {
+			return nil, false, err
+		}
+		truncated = true
+	}
+	return entries, truncated, nil
+}
+
+// firstArchiveImage returns the name of the first image in an archive that is small enough to be decoded.
+func firstArchiveImage(entries []archiveEntry) (string, bool) {
+	for _, entry := range entries {
+		extension := strings.ToLower(strings.TrimPrefix(path.Ext(entry.name), "."))
+		if !entry.isDir && archiveImageFileTypes[extension] && entry.size > 0 && entry.size <= maxArchiveImageBytes {
+			return entry.name, true
+		}
+	}
+	return "", false
+}
+
+// readArchiveImage decodes the image with the given name in an archive, as long as it has no more than maxPixels pixels.
+func readArchiveImage(archivePath, name string, maxPixels int) (image.Image, error) {
+	var decoded image.Image
+	var decodeErr error = common.ErrorCouldNotDecodeImage
+	err := walkArchive(archivePath, func(entry archiveEntry, open func() (io.ReadCloser, error)) bool {
+		if entry.name != name {
+			return true
+		}
+		reader, err := open()
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		defer reader.Close()
+		// NKG: Entries can't be read twice, so the image is read into
+		// memory to check its size before it is decoded.
+		data, err := ioutil.ReadAll(io.LimitReader(reader, maxArchiveImageBytes))
+		if err != nil {
+			decodeErr = err
+			return false
+		}
+		decoded, err = decodeImageReader(bytes.NewReader(data), maxPixels)
+		if err != nil {
+			decodeErr = err
+		}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if decoded == nil {
+		return nil, decodeErr
+	}
+	return decoded, nil
+}
+
+// archiveListing renders the entries of an archive as a tree, with a summary of the number of files and directories and their total size on the first line.
+func archiveListing(entries []archiveEntry, truncated bool) [][]textSpan {
+	nodes := make(map[string]*archiveNode)
+	for _, entry := range entries {
+		name := cleanEntryName(entry.name)
+		if name == "" {
+			continue
+		}
+		parts := strings.Split(name, "/")
+		for i := 1; i < len(parts); i++ {
+			parent := strings.Join(parts[:i], "/")
+			if _, hasParent := nodes[parent]; !hasParent {
+				nodes[parent] = &archiveNode{parts[:i], 0, true}
+			}
+		}
+		if entry.isDir {
+			if _, hasNode := nodes[name]; !hasNode {
+				nodes[name] = &archiveNode{parts, 0, true}
+			}
+			continue
+		}
+		nodes[name] = &archiveNode{parts, entry.size, false}
+	}
+
+	sorted := make(archiveNodes, 0, len(nodes))
+	files, directories := 0, 0
+	var totalSize int64
+	for _, node := range nodes {
+		sorted = append(sorted, node)
+		if node.isDir {
+			directories++
+		} else {
+			files++
+			totalSize += node.size
+		}
+	}
+	sort.Sort(sorted)
+
+	summary := fmt.Sprintf("%d files, %d folders, %s", files, directories, formatSize(totalSize))
+	if truncated {
+		summary += fmt.Sprintf(" in the first %d entries", len(entries))
+	}
+	lines := [][]textSpan{[]textSpan{textSpan{summary, tokenHeading}}}
+	for _, node := range sorted {
+		indent := strings.Repeat("  ", len(node.path)-1)
+		name := node.path[len(node.path)-1]
+		if node.isDir {
+			lines = append(lines, []textSpan{textSpan{indent + name + "/", tokenKeyword}})
+			continue
+		}
+		lines = append(lines, []textSpan{textSpan{indent + name, tokenPlain}, textSpan{"  " + formatSize(node.size), tokenComment}})
+	}
+	return lines
+}
+
+// cleanEntryName returns the path of an entry relative to the root of the archive, without a trailing slash.
+func cleanEntryName(name string) string {
+	name = path.Clean(strings.Replace(name, "\\", "/", -1))
+	name = strings.TrimLeft(name, "/")
+	if name == "." {
+		return ""
+	}
+	return name
+}
+
+// formatSize returns a number of bytes using the largest unit that it is at least one of.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	for _, unit := range []string{"KB", "MB", "GB"} {
+		value = value / 1024
+		if value < 1024 {
+			return fmt.Sprintf("%.1f %s", value, unit)
+		}
+	}
+	return fmt.Sprintf("%.1f TB", value/1024)
+}
+
+func (nodes archiveNodes) Len() int {
+	return len(nodes)
+}
+
+func (nodes archiveNodes) Swap(i, j int) {
+	nodes[i], nodes[j] = nodes[j], nodes[i]
+}
+
+// Less orders nodes so that each directory is followed by its contents.
+func (nodes archiveNodes) Less(i, j int) bool {
+	a, b := nodes[i].path, nodes[j].path
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,200 @@
+package render
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testArchiveFiles(t *testing.T) map[string][]byte {
+	var pngData bytes.Buffer
+	pixels := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	pixels.Set(0, 0, color.RGBA{0xff, 0, 0, 0xff})
+	if err := png.Encode(&pngData, pixels); err != nil {
+		t.Fatal(err)
+	}
+	return map[string][]byte{
+		"docs/README.md":         []byte("# Readme\n"),
+		"docs/images/banner.png": pngData.Bytes(),
+		"main.go":                []byte("package main\n"),
+	}
+}
+
+func writeTestZip(t *testing.T, path string, files map[string][]byte) {
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for _, name := range []string{"main.go", "docs/README.md", "docs/images/banner.png"} {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Write(files[name])
+	}
+	writer.Close()
+	ioutil.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+func writeTestTar(t *testing.T, path string, files map[string][]byte, compress bool) {
+	var buffer bytes.Buffer
+	var writer io.Writer = &buffer
+	gzipWriter := gzip.NewWriter(&buffer)
+	if compress {
+		writer = gzipWriter
+	}
+	tarWriter := tar.NewWriter(writer)
+	tarWriter.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, name := range []string{"main.go", "docs/README.md", "docs/images/banner.png"} {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tarWriter.Write(files[name])
+	}
+	tarWriter.Close()
+	if compress {
+		gzipWriter.Close()
+	}
+	ioutil.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+func TestReadArchive(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	files := testArchiveFiles(t)
+	writeTestZip(t, filepath.Join(dm.Path, "test.zip"), files)
+	writeTestTar(t, filepath.Join(dm.Path, "test.tar"), files, false)
+	writeTestTar(t, filepath.Join(dm.Path, "test.tgz"), files, true)
+	ioutil.WriteFile(filepath.Join(dm.Path, "test.txt"), bytes.Repeat([]byte("not an archive\n"), 100), 0644)
+
+	expectedCounts := map[string]int{"test.zip": 3, "test.tar": 4, "test.tgz": 4}
+	for name, expectedCount := range expectedCounts {
+		entries, truncated, err := readArchive(filepath.Join(dm.Path, name))
+		if err != nil || truncated || len(entries) != expectedCount {
+			t.Error("Unexpected entries for", name, entries, truncated, err)
+			continue
+		}
+		imageName, hasImage := firstArchiveImage(entries)
+		if !hasImage || imageName != "docs/images/banner.png" {
+			t.Error("Unexpected first image for", name, imageName)
+			continue
+		}
+		decoded, err := readArchiveImage(filepath.Join(dm.Path, name), imageName, 50000000)
+		if err != nil || decoded.Bounds().Dx() != 40 || decoded.Bounds().Dy() != 20 {
+			t.Error("Unexpected image for", name, err)
+		}
+		if _, err := readArchiveImage(filepath.Join(dm.Path, name), imageName, 799); err != common.ErrorCouldNotDecodeImage {
+			t.Error("Expected an error decoding an image with too many pixels for", name, err)
+		}
+	}
+
+	if _, _, err := readArchive(filepath.Join(dm.Path, "test.txt")); err == nil {
+		t.Error("Expected an error reading a file that isn't an archive")
+	}
+}
+
+func TestArchiveListing(t *testing.T) {
+	entries := []archiveEntry{
+		archiveEntry{"./src/main.go", 2048, false},
+		archiveEntry{"README.md", 100, false},
+		archiveEntry{"src/lib/", 0, true},
+		archiveEntry{"src-old/main.go", 10, false},
+	}
+	lines := archiveListing(entries, false)
+	expected := [][]textSpan{
+		[]textSpan{textSpan{"3 files, 3 folders, 2.1 KB", tokenHeading}},
+		[]textSpan{textSpan{"README.md", tokenPlain}, textSpan{"  100 B", tokenComment}},
+		[]textSpan{textSpan{"src/", tokenKeyword}},
+		[]textSpan{textSpan{"  lib/", tokenKeyword}},
+		[]textSpan{textSpan{"  main.go", tokenPlain}, textSpan{"  2.0 KB", tokenComment}},
+		[]textSpan{textSpan{"src-old/", tokenKeyword}},
+		[]textSpan{textSpan{"  main.go", tokenPlain}, textSpan{"  10 B", tokenComment}},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Error("Unexpected listing", lines)
+	}
+
+	lines = archiveListing(entries[:1], true)
+	if lines[0][0].text != "1 files, 1 folders, 2.0 KB in the first 1 entries" {
+		t.Error("Unexpected truncated summary", lines[0])
+	}
+}
+
+func TestArchiveRenderAgent(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "test.zip")
+	writeTestZip(t, sourcePath, testArchiveFiles(t))
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, uploader, true)
+	defer rm.Stop()
+	rm.AddArchiveRenderAgent(downloader, uploader, false, 50000000, 5)
+	rm.AddRoute(NewRendererRoute(common.RenderAgentArchive, "zip", 0))
+
+	rm.CreateWork("E2A94C17-5B3D-4F86-A0C9-7D1E3B6F2A58", "file://"+sourcePath, "zip", 1024, []common.Attribute{})
+
+	var generatedAssets []*common.GeneratedAsset
+	var err error
+	for i := 0; i < 100; i++ {
+		generatedAssets, err = gasm.FindBySourceAssetId("E2A94C17-5B3D-4F86-A0C9-7D1E3B6F2A58")
+		if err == nil && common.IsGeneratedAssetsFinal(generatedAssets) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(generatedAssets) != 4 || common.GeneratedAssetsState(generatedAssets) != common.GeneratedAssetsStateComplete {
+		t.Error("Generated assets were not rendered", generatedAssets)
+		return
+	}
+
+	sourceAssets, err := sasm.FindBySourceAssetId("E2A94C17-5B3D-4F86-A0C9-7D1E3B6F2A58")
+	if err != nil || len(sourceAssets) != 1 {
+		t.Error("Unexpected source assets", sourceAssets, err)
+		return
+	}
+	if count, _ := common.GetFirstAttribute(sourceAssets[0], common.SourceAssetAttributeEntryCount); count != "3" {
+		t.Error("Unexpected entry count", count)
+	}
+	if entries := sourceAssets[0].GetAttribute(common.SourceAssetAttributeEntries); !reflect.DeepEqual(entries, []string{"main.go", "docs/README.md", "docs/images/banner.png"}) {
+		t.Error("Unexpected entries", entries)
+	}
+
+	reader, err := os.Open(filepath.Join(dm.Path, "assets", "E2A94C17-5B3D-4F86-A0C9-7D1E3B6F2A58", common.PlaceholderSizeSmall, "0"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer reader.Close()
+	rendered, err := jpeg.Decode(reader)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if rendered.Bounds().Dx() != 250 || rendered.Bounds().Dy() != 188 {
+		t.Error("Unexpected rendered bounds", rendered.Bounds())
+	}
+}
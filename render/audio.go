@@ -0,0 +1,443 @@
+package render
+
+import (
+	"encoding/binary"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	wavFormatPcm        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+	// decodedSampleRate is the sample rate that audio files are decoded to by ffmpeg. It is plenty for the width of a waveform.
+	decodedSampleRate = 8000
+	// maxWavChannels and maxWavSampleRate are the largest number of channels and sample rate of WAV files that are decoded.
+	maxWavChannels   = 32
+	maxWavSampleRate = 384000
+)
+
+var waveformColor = color.RGBA{0x03, 0x66, 0xd6, 0xff}
+
+// audioRenderAgent renders peak waveforms of audio files. WAV files are decoded in process and other files are decoded with ffmpeg.
+type audioRenderAgent struct {
+	*baseRenderAgent
+	metrics     *audioRenderAgentMetrics
+	ffmpegPath  string
+	ffprobePath string
+	limits      CommandLimits
+}
+
+type audioRenderAgentMetrics struct {
+	workProcessed metrics.Meter
+	convertTime   metrics.Timer
+	decodeCount   metrics.Counter
+}
+
+type audioInfo struct {
+	duration   float64
+	sampleRate int
+	channels   int
+}
+
+// wavFormat describes the samples in the data chunk of a WAV file.
+type wavFormat struct {
+	encoding      int
+	channels      int
+	sampleRate    int
+	bitsPerSample int
+	dataOffset    int64
+	dataSize      int64
+}
+
+func newAudioRenderAgent(
+	base *baseRenderAgent,
+	metrics *audioRenderAgentMetrics,
+	ffmpegPath, ffprobePath string,
+	limits CommandLimits) RenderAgent {
+
+	renderAgent := new(audioRenderAgent)
+	renderAgent.baseRenderAgent = base
+	renderAgent.metrics = metrics
+	renderAgent.ffmpegPath = ffmpegPath
+	renderAgent.ffprobePath = ffprobePath
+	renderAgent.limits = limits
+
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
+
+	return renderAgent
+}
+
+func newAudioRenderAgentMetrics(registry metrics.Registry) *audioRenderAgentMetrics {
+	audioMetrics := new(audioRenderAgentMetrics)
+	audioMetrics.workProcessed = metrics.NewMeter()
+	audioMetrics.convertTime = metrics.NewTimer()
+	audioMetrics.decodeCount = metrics.NewCounter()
+
+	registry.Register("audioRenderAgent.workProcessed", audioMetrics.workProcessed)
+	registry.Register("audioRenderAgent.convertTime", audioMetrics.convertTime)
+	registry.Register("audioRenderAgent.decodeCount", audioMetrics.decodeCount)
+
+	return audioMetrics
+}
+
+func (renderAgent *audioRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+	width, height, err := renderAgent.getSize(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+	_, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(nativeOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork), nil}
+		return
+	}
+	defer sourceFile.Release()
+
+	var peaks []float64
+	var info *audioInfo
+	renderAgent.metrics.convertTime.Time(func() {
+		peaks, info, err = wavPeaks(sourceFile.Path(), width)
+		if err == nil {
+			return
+		}
+		// NKG: WAV files with compressed samples are decoded by ffmpeg
+		// along with every other format.
+		decoded := sourceFile.Path() + "-" + template.Id + ".wav"
+		decodedTemporaryFile := renderAgent.temporaryFileManager.Create(decoded)
+		defer decodedTemporaryFile.Release()
+		info, err = renderAgent.probe(sourceFile.Path())
+		if err != nil {
+			return
+		}
+		err = renderAgent.decode(sourceFile.Path(), decoded)
+		if err != nil {
+			return
+		}
+		renderAgent.metrics.decodeCount.Inc(1)
+		var decodedInfo *audioInfo
+		peaks, decodedInfo, err = wavPeaks(decoded, width)
+		if err == nil && info.duration <= 0 {
+			info.duration = decodedInfo.duration
+		}
+	})
+	if err != nil {
+		if err == common.ErrorRenderTimedOut {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
+			return
+		}
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDecodeAudio), nil}
+		return
+	}
+	renderAgent.recordAudioInfo(sourceAsset, info)
+
+	quality := templateQuality(template)
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
+
+	rendered := renderWaveform(peaks, width, height, background)
+	for _, output := range outputs {
+		err = encodeImage(rendered, destinations[output], output, quality)
+		if err != nil {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+			return
+		}
+	}
+
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
+		return
+	}
+
+	destination := destinations[outputs[0]]
+	generatedAssetFileSize, err := util.FileSize(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileSize), nil}
+		return
+	}
+
+	newAttributes := []common.Attribute{
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(height)}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(width)}),
+		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
+	}
+
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
+}
+
+// probe returns the duration, sample rate and channels of the first audio stream of a file.
+func (renderAgent *audioRenderAgent) probe(path string) (*audioInfo, error) {
+	output, err := runCommand(renderAgent.limits, renderAgent.ffprobePath, "-v", "error", "-select_streams", "a:0", "-show_entries", "stream=sample_rate,channels:format=duration", "-of", "default=noprint_wrappers=1", path)
+	if err != nil {
+		log.Println("error running command", err)
+		return nil, err
+	}
+	return parseAudioProbeOutput(string(output))
+}
+
+// decode writes the first audio stream of a file to a mono, 16 bit WAV file.
+func (renderAgent *audioRenderAgent) decode(source, destination string) error {
+	output, err := runCommand(renderAgent.limits, renderAgent.ffmpegPath, "-v", "error", "-y", "-i", source, "-vn", "-ac", "1", "-ar", strconv.Itoa(decodedSampleRate), "-acodec", "pcm_s16le", "-f", "wav", destination)
+	log.Println(string(output))
+	if err != nil {
+		log.Println("error running command", err)
+		return err
+	}
+	return nil
+}
+
+// recordAudioInfo adds the duration, sampleRate and channels attributes to the source asset if it doesn't already have them.
+func (renderAgent *audioRenderAgent) recordAudioInfo(sourceAsset *common.SourceAsset, info *audioInfo) {
+	if sourceAsset.HasAttribute(common.SourceAssetAttributeSampleRate) {
+		return
+	}
+	// NKG: Each template of an audio file is rendered separately, so the
+	// source asset is looked up again to avoid replacing attributes added
+	// since it was first looked up.
+	sourceAssets, err := renderAgent.sasm.FindBySourceAssetId(sourceAsset.Id)
+	if err != nil {
+		return
+	}
+	for _, current := range sourceAssets {
+		if current.IdType != sourceAsset.IdType || current.HasAttribute(common.SourceAssetAttributeSampleRate) {
+			continue
+		}
+		current.SetAttribute(common.SourceAssetAttributeDuration, []string{strconv.FormatFloat(info.duration, 'f', 3, 64)})
+		current.SetAttribute(common.SourceAssetAttributeSampleRate, []string{strconv.Itoa(info.sampleRate)})
+		current.SetAttribute(common.SourceAssetAttributeChannels, []string{strconv.Itoa(info.channels)})
+		err = renderAgent.sasm.Store(current)
+		if err != nil {
+			log.Println("Error recording audio attributes of source asset", current.Id, err)
+		}
+	}
+}
+
+// parseAudioProbeOutput parses the key=value lines written by ffprobe for the duration, sample rate and channels of an audio file.
+func parseAudioProbeOutput(output string) (*audioInfo, error) {
+	info := new(audioInfo)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "sample_rate":
+			info.sampleRate, _ = strconv.Atoi(parts[1])
+		case "channels":
+			info.channels, _ = strconv.Atoi(parts[1])
+		case "duration":
+			info.duration, _ = strconv.ParseFloat(parts[1], 64)
+		}
+	}
+	if info.sampleRate <= 0 || info.channels <= 0 {
+		return nil, common.ErrorCouldNotDecodeAudio
+	}
+	return info, nil
+}
+
+// readWavFormat reads the format chunk of a WAV file and finds its data chunk. Only PCM and floating point samples are supported.
+func readWavFormat(reader io.ReadSeeker, fileSize int64) (*wavFormat, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, common.ErrorCouldNotDecodeAudio
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, common.ErrorCouldNotDecodeAudio
+	}
+
+	var format *wavFormat
+	offset := int64(12)
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, chunkHeader); err != nil {
+			return nil, common.ErrorCouldNotDecodeAudio
+		}
+		offset += 8
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+		switch string(chunkHeader[0:4]) {
+		case "fmt ":
+			if chunkSize < 16 || chunkSize > 1024 {
+				return nil, common.ErrorCouldNotDecodeAudio
+			}
+			chunk := make([]byte, chunkSize)
+			if _, err := io.ReadFull(reader, chunk); err != nil {
+				return nil, common.ErrorCouldNotDecodeAudio
+			}
+			format = &wavFormat{
+				encoding:      int(binary.LittleEndian.Uint16(chunk[0:2])),
+				channels:      int(binary.LittleEndian.Uint16(chunk[2:4])),
+				sampleRate:    int(binary.LittleEndian.Uint32(chunk[4:8])),
+				bitsPerSample: int(binary.LittleEndian.Uint16(chunk[14:16])),
+			}
+			// NKG: The encoding of extensible WAV files is the first two
+			// bytes of the sub format GUID.
+			if format.encoding == wavFormatExtensible && chunkSize >= 26 {
+				format.encoding = int(binary.LittleEndian.Uint16(chunk[24:26]))
+			}
+			if format.channels > maxWavChannels || format.sampleRate > maxWavSampleRate {
+				return nil, common.ErrorCouldNotDecodeAudio
+			}
+			if _, err := reader.Seek(chunkSize%2, 1); err != nil {
+				return nil, common.ErrorCouldNotDecodeAudio
+			}
+		case "data":
+			if format == nil || !format.isSupported() {
+				return nil, common.ErrorCouldNotDecodeAudio
+			}
+			format.dataOffset = offset
+			// NKG: Streamed WAV files can have a data size of 0 or one
+			// that is larger than the file.
+			format.dataSize = chunkSize
+			if chunkSize == 0 || offset+chunkSize > fileSize {
+				format.dataSize = fileSize - offset
+			}
+			return format, nil
+		default:
+			if _, err := reader.Seek(chunkSize+chunkSize%2, 1); err != nil {
+				return nil, common.ErrorCouldNotDecodeAudio
+			}
+		}
+		offset += chunkSize + chunkSize%2
+	}
+}
+
+func (format *wavFormat) isSupported() bool {
+	if format.channels < 1 || format.sampleRate < 1 {
+		return false
+	}
+	switch format.encoding {
+	case wavFormatPcm:
+		return format.bitsPerSample == 8 || format.bitsPerSample == 16 || format.bitsPerSample == 24 || format.bitsPerSample == 32
+	case wavFormatFloat:
+		return format.bitsPerSample == 32 || format.bitsPerSample == 64
+	}
+	return false
+}
+
+// sample returns the magnitude, from 0 to 1, of a sample.
+func (format *wavFormat) sample(data []byte) float64 {
+	var value float64
+	switch {
+	case format.encoding == wavFormatFloat && format.bitsPerSample == 32:
+		value = float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+	case format.encoding == wavFormatFloat:
+		value = math.Float64frombits(binary.LittleEndian.Uint64(data))
+	case format.bitsPerSample == 8:
+		value = (float64(data[0]) - 128) / 128
+	case format.bitsPerSample == 16:
+		value = float64(int16(binary.LittleEndian.Uint16(data))) / 32768
+	case format.bitsPerSample == 24:
+		value = float64(int32(uint32(data[0])<<8|uint32(data[1])<<16|uint32(data[2])<<24)>>8) / 8388608
+	default:
+		value = float64(int32(binary.LittleEndian.Uint32(data))) / 2147483648
+	}
+	return math.Min(math.Abs(value), 1)
+}
+
+// wavPeaks reads the samples of a WAV file and returns the loudest sample of any channel in each of the given number of evenly sized spans of the file, along with its duration, sample rate and channels.
+func wavPeaks(path string, buckets int) ([]float64, *audioInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	format, err := readWavFormat(file, stat.Size())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sampleSize := format.bitsPerSample / 8
+	frameSize := sampleSize * format.channels
+	frames := format.dataSize / int64(frameSize)
+	info := &audioInfo{float64(frames) / float64(format.sampleRate), format.sampleRate, format.channels}
+
+	peaks := make([]float64, buckets)
+	if frames == 0 {
+		return peaks, info, nil
+	}
+	if _, err = file.Seek(format.dataOffset, 0); err != nil {
+		return nil, nil, err
+	}
+	block := make([]byte, frameSize*4096)
+	for i := int64(0); i < frames; {
+		blockFrames := int64(len(block) / frameSize)
+		if frames-i < blockFrames {
+			blockFrames = frames - i
+		}
+		if _, err = io.ReadFull(file, block[:blockFrames*int64(frameSize)]); err != nil {
+			return nil, nil, common.ErrorCouldNotDecodeAudio
+		}
+		for offset := 0; offset < int(blockFrames)*frameSize; offset += frameSize {
+			bucket := int(i * int64(buckets) / frames)
+			for channel := 0; channel < format.channels; channel++ {
+				value := format.sample(block[offset+channel*sampleSize:])
+				if value > peaks[bucket] {
+					peaks[bucket] = value
+				}
+			}
+			i++
+		}
+	}
+	return peaks, info, nil
+}
+
+// renderWaveform draws a peak in each column of an image, mirrored around its middle. Peaks are scaled so that the loudest reaches the top and bottom padding.
+func renderWaveform(peaks []float64, width, height int, background color.Color) image.Image {
+	if background == nil {
+		background = color.White
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	loudest := 0.0
+	for _, peak := range peaks {
+		loudest = math.Max(loudest, peak)
+	}
+	middle := height / 2
+	amplitude := float64(height/2 - maxInt(1, height/10))
+	for x, peak := range peaks {
+		if x >= width {
+			break
+		}
+		extent := 0
+		if loudest > 0 {
+			extent = int(peak/loudest*amplitude + 0.5)
+		}
+		for y := middle - extent; y <= middle+extent; y++ {
+			canvas.SetRGBA(x, y, waveformColor)
+		}
+	}
+	return canvas
+}
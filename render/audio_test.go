@@ -0,0 +1,215 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestWav writes a WAV file with a sine wave that gets louder over a second, with an extra chunk before the format chunk.
+func writeTestWav(t *testing.T, path string, encoding, bitsPerSample, channels, sampleRate int) {
+	var data bytes.Buffer
+	for i := 0; i < sampleRate; i++ {
+		value := math.Sin(float64(i)/4) * float64(i) / float64(sampleRate)
+		for channel := 0; channel < channels; channel++ {
+			switch {
+			case encoding == wavFormatFloat:
+				binary.Write(&data, binary.LittleEndian, float32(value))
+			case bitsPerSample == 16:
+				binary.Write(&data, binary.LittleEndian, int16(value*32767))
+			case bitsPerSample == 24:
+				sample := int32(value * 8388607)
+				data.Write([]byte{byte(sample), byte(sample >> 8), byte(sample >> 16)})
+			}
+		}
+	}
+
+	var file bytes.Buffer
+	blockAlign := channels * bitsPerSample / 8
+	file.WriteString("RIFF")
+	binary.Write(&file, binary.LittleEndian, uint32(4+8+3+1+8+16+8+data.Len()))
+	file.WriteString("WAVE")
+	file.WriteString("LIST")
+	binary.Write(&file, binary.LittleEndian, uint32(3))
+	file.Write([]byte{'a', 'b', 'c', 0})
+	file.WriteString("fmt ")
+	binary.Write(&file, binary.LittleEndian, uint32(16))
+	binary.Write(&file, binary.LittleEndian, uint16(encoding))
+	binary.Write(&file, binary.LittleEndian, uint16(channels))
+	binary.Write(&file, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&file, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	binary.Write(&file, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&file, binary.LittleEndian, uint16(bitsPerSample))
+	file.WriteString("data")
+	binary.Write(&file, binary.LittleEndian, uint32(data.Len()))
+	file.Write(data.Bytes())
+	if err := ioutil.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWavPeaks(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	formats := map[string][]int{
+		"pcm16.wav": []int{wavFormatPcm, 16, 2, 8000},
+		"pcm24.wav": []int{wavFormatPcm, 24, 1, 11025},
+		"float.wav": []int{wavFormatFloat, 32, 1, 8000},
+	}
+	for name, format := range formats {
+		path := filepath.Join(dm.Path, name)
+		writeTestWav(t, path, format[0], format[1], format[2], format[3])
+		peaks, info, err := wavPeaks(path, 10)
+		if err != nil {
+			t.Error("Unexpected error decoding", name, err)
+			continue
+		}
+		if info.sampleRate != format[3] || info.channels != format[2] || math.Abs(info.duration-1) > 0.001 {
+			t.Error("Unexpected audio info for", name, info)
+		}
+		if len(peaks) != 10 || peaks[0] > 0.11 || peaks[9] < 0.95 || peaks[9] > 1 || peaks[4] > peaks[5] {
+			t.Error("Unexpected peaks for", name, peaks)
+		}
+	}
+
+	notWav := filepath.Join(dm.Path, "test.mp3")
+	ioutil.WriteFile(notWav, []byte("ID3\x03\x00\x00\x00\x00\x00\x00"), 0644)
+	if _, _, err := wavPeaks(notWav, 10); err != common.ErrorCouldNotDecodeAudio {
+		t.Error("Expected an error decoding a file that isn't a WAV file", err)
+	}
+}
+
+func TestWavFormatLimits(t *testing.T) {
+	formats := map[string][]int{
+		"channels":   []int{maxWavChannels + 1, 8000},
+		"sampleRate": []int{2, maxWavSampleRate + 1},
+		"both":       []int{65535, 4294967295},
+	}
+	for name, format := range formats {
+		var file bytes.Buffer
+		file.WriteString("RIFF")
+		binary.Write(&file, binary.LittleEndian, uint32(4+8+16+8+4))
+		file.WriteString("WAVE")
+		file.WriteString("fmt ")
+		binary.Write(&file, binary.LittleEndian, uint32(16))
+		binary.Write(&file, binary.LittleEndian, uint16(wavFormatPcm))
+		binary.Write(&file, binary.LittleEndian, uint16(format[0]))
+		binary.Write(&file, binary.LittleEndian, uint32(format[1]))
+		binary.Write(&file, binary.LittleEndian, uint32(0))
+		binary.Write(&file, binary.LittleEndian, uint16(0))
+		binary.Write(&file, binary.LittleEndian, uint16(16))
+		file.WriteString("data")
+		binary.Write(&file, binary.LittleEndian, uint32(4))
+		file.Write([]byte{0, 0, 0, 0})
+		if _, err := readWavFormat(bytes.NewReader(file.Bytes()), int64(file.Len())); err != common.ErrorCouldNotDecodeAudio {
+			t.Error("Expected an error decoding a WAV file with too many", name, err)
+		}
+	}
+}
+
+func TestParseAudioProbeOutput(t *testing.T) {
+	info, err := parseAudioProbeOutput("sample_rate=44100\nchannels=2\nduration=192.340000\n")
+	if err != nil || info.sampleRate != 44100 || info.channels != 2 || info.duration != 192.34 {
+		t.Error("Unexpected audio info", info, err)
+	}
+	if _, err := parseAudioProbeOutput("duration=N/A\n"); err != common.ErrorCouldNotDecodeAudio {
+		t.Error("Expected an error without an audio stream", err)
+	}
+}
+
+func TestRenderWaveform(t *testing.T) {
+	rendered := renderWaveform([]float64{0, 0.5, 1}, 3, 20, nil)
+	if rendered.Bounds().Dx() != 3 || rendered.Bounds().Dy() != 20 {
+		t.Error("Unexpected bounds", rendered.Bounds())
+	}
+	// NKG: The loudest peak reaches the padding of 2 pixels, the quietest
+	// is only drawn at the middle.
+	expected := map[[2]int]color.Color{
+		[2]int{0, 10}: waveformColor,
+		[2]int{0, 9}:  color.RGBA{0xff, 0xff, 0xff, 0xff},
+		[2]int{1, 6}:  waveformColor,
+		[2]int{1, 5}:  color.RGBA{0xff, 0xff, 0xff, 0xff},
+		[2]int{2, 2}:  waveformColor,
+		[2]int{2, 18}: waveformColor,
+		[2]int{2, 1}:  color.RGBA{0xff, 0xff, 0xff, 0xff},
+	}
+	for point, expectedColor := range expected {
+		if rendered.At(point[0], point[1]) != expectedColor {
+			t.Error("Unexpected colour at", point, rendered.At(point[0], point[1]))
+		}
+	}
+}
+
+func TestAudioRenderAgent(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "test.wav")
+	writeTestWav(t, sourcePath, wavFormatPcm, 16, 2, 8000)
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, uploader, true)
+	defer rm.Stop()
+	rm.AddAudioRenderAgent(downloader, uploader, "ffmpeg", "ffprobe", CommandLimits{Timeout: time.Minute}, 5)
+	rm.AddRoute(NewRendererRoute(common.RenderAgentAudio, "wav", 0))
+
+	rm.CreateWork("9F3B6D21-4A8C-4E57-B0D9-2C7E5A1F8B63", "file://"+sourcePath, "wav", 1024, []common.Attribute{})
+
+	var generatedAssets []*common.GeneratedAsset
+	var err error
+	for i := 0; i < 100; i++ {
+		generatedAssets, err = gasm.FindBySourceAssetId("9F3B6D21-4A8C-4E57-B0D9-2C7E5A1F8B63")
+		if err == nil && common.IsGeneratedAssetsFinal(generatedAssets) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(generatedAssets) != 4 || common.GeneratedAssetsState(generatedAssets) != common.GeneratedAssetsStateComplete {
+		t.Error("Generated assets were not rendered", generatedAssets)
+		return
+	}
+
+	sourceAssets, err := sasm.FindBySourceAssetId("9F3B6D21-4A8C-4E57-B0D9-2C7E5A1F8B63")
+	if err != nil || len(sourceAssets) != 1 {
+		t.Error("Unexpected source assets", sourceAssets, err)
+		return
+	}
+	for attribute, expected := range map[string]string{common.SourceAssetAttributeDuration: "1.000", common.SourceAssetAttributeSampleRate: "8000", common.SourceAssetAttributeChannels: "2"} {
+		if value, _ := common.GetFirstAttribute(sourceAssets[0], attribute); value != expected {
+			t.Error("Unexpected", attribute, "attribute", value)
+		}
+	}
+
+	reader, err := os.Open(filepath.Join(dm.Path, "assets", "9F3B6D21-4A8C-4E57-B0D9-2C7E5A1F8B63", common.PlaceholderSizeSmall, "0"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer reader.Close()
+	rendered, err := jpeg.Decode(reader)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if rendered.Bounds().Dx() != 250 || rendered.Bounds().Dy() != 188 {
+		t.Error("Unexpected rendered bounds", rendered.Bounds())
+	}
+}
@@ -0,0 +1,283 @@
+package render
+
+import (
+	"github.com/ngerakines/codederror"
+	"github.com/ngerakines/preview/common"
+	"github.com/rcrowley/go-metrics"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// renderFunc renders a generated asset using the source asset and template it was created from, sending the outcome to the status callback.
+type renderFunc func(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate)
+
+type generatedAssetUpdate struct {
+	status     string
+	attributes []common.Attribute
+}
+
+// baseRenderAgent takes work from the work and priority channels of a render agent and does everything that isn't specific to the files it renders: looking up generated assets along with their source assets and templates, downloading source files, uploading rendered files and committing the status of generated assets.
+type baseRenderAgent struct {
+	renderer             string
+	sasm                 common.SourceAssetStorageManager
+	gasm                 common.GeneratedAssetStorageManager
+	templateManager      common.TemplateManager
+	downloader           common.Downloader
+	uploader             common.Uploader
+	workChannel          RenderAgentWorkChannel
+	priorityChannel      RenderAgentWorkChannel
+	statusListeners      []RenderStatusChannel
+	temporaryFileManager common.TemporaryFileManager
+	retryPolicy          *RetryPolicy
+	workProcessed        metrics.Meter
+	render               renderFunc
+	stop                 chan (chan bool)
+}
+
+func (agentManager *RenderAgentManager) newBaseRenderAgent(renderer string, downloader common.Downloader, uploader common.Uploader) *baseRenderAgent {
+	renderAgent := new(baseRenderAgent)
+	renderAgent.renderer = renderer
+	renderAgent.sasm = agentManager.sourceAssetStorageManager
+	renderAgent.gasm = agentManager.generatedAssetStorageManager
+	renderAgent.templateManager = agentManager.templateManager
+	renderAgent.temporaryFileManager = agentManager.temporaryFileManager
+	renderAgent.downloader = downloader
+	renderAgent.uploader = uploader
+	renderAgent.workChannel = agentManager.workChannels[renderer]
+	renderAgent.priorityChannel = agentManager.priorityChannels[renderer]
+	renderAgent.retryPolicy = agentManager.retryPolicy
+	renderAgent.statusListeners = make([]RenderStatusChannel, 0, 0)
+	renderAgent.stop = make(chan (chan bool))
+	return renderAgent
+}
+
+// start has the render agent take work, rendering each generated asset with the given render func.
+func (renderAgent *baseRenderAgent) start(workProcessed metrics.Meter, render renderFunc) {
+	renderAgent.workProcessed = workProcessed
+	renderAgent.render = render
+	go renderAgent.run()
+}
+
+func (renderAgent *baseRenderAgent) run() {
+	for {
+		// NKG: Promoted work is rendered before any work waiting in the
+		// work channel.
+		select {
+		case id, ok := <-renderAgent.priorityChannel:
+			{
+				if !ok {
+					return
+				}
+				log.Println("Received priority dispatch message", id)
+				renderAgent.renderGeneratedAsset(id)
+				continue
+			}
+		default:
+		}
+		select {
+		case ch, ok := <-renderAgent.stop:
+			{
+				log.Println("Stopping")
+				if !ok {
+					return
+				}
+				ch <- true
+				return
+			}
+		case id, ok := <-renderAgent.priorityChannel:
+			{
+				if !ok {
+					return
+				}
+				log.Println("Received priority dispatch message", id)
+				renderAgent.renderGeneratedAsset(id)
+			}
+		case id, ok := <-renderAgent.workChannel:
+			{
+				if !ok {
+					return
+				}
+				log.Println("Received dispatch message", id)
+				renderAgent.renderGeneratedAsset(id)
+			}
+		}
+	}
+}
+
+func (renderAgent *baseRenderAgent) Stop() {
+	callback := make(chan bool)
+	renderAgent.stop <- callback
+	select {
+	case <-callback:
+	case <-time.After(5 * time.Second):
+	}
+	close(renderAgent.stop)
+}
+
+func (renderAgent *baseRenderAgent) AddStatusListener(listener RenderStatusChannel) {
+	renderAgent.statusListeners = append(renderAgent.statusListeners, listener)
+}
+
+func (renderAgent *baseRenderAgent) Dispatch() RenderAgentWorkChannel {
+	return renderAgent.workChannel
+}
+
+func (renderAgent *baseRenderAgent) renderGeneratedAsset(id string) {
+	renderAgent.workProcessed.Mark(1)
+
+	generatedAsset, err := renderAgent.gasm.FindById(id)
+	if err != nil {
+		log.Println("No Generated Asset with that ID can be retreived from storage: ", id)
+		return
+	}
+	// NKG: Work can be canceled after it has been dispatched. The status
+	// is only changed to processing if it hasn't changed since it was
+	// read, so that a cancel made in the meantime isn't overwritten.
+	if strings.HasPrefix(generatedAsset.Status, common.GeneratedAssetStatusFailed) {
+		log.Println("Generated asset", id, "is no longer being rendered:", generatedAsset.Status)
+		return
+	}
+	err = renderAgent.gasm.UpdateStatus(generatedAsset.Id, generatedAsset.Status, common.GeneratedAssetStatusProcessing)
+	if err != nil {
+		log.Println("Generated asset", id, "is no longer being rendered:", err)
+		return
+	}
+	generatedAsset.Status = common.GeneratedAssetStatusProcessing
+
+	statusCallback := renderAgent.commitStatus(generatedAsset.Id, generatedAsset.Attributes)
+	defer func() { close(statusCallback) }()
+
+	sourceAsset, err := renderAgent.getSourceAsset(generatedAsset)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnableToFindSourceAssetsById), nil}
+		return
+	}
+
+	templates, err := renderAgent.templateManager.FindByIds([]string{generatedAsset.TemplateId})
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnableToFindTemplatesById), nil}
+		return
+	}
+	if len(templates) == 0 {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoTemplatesFoundForId), nil}
+		return
+	}
+
+	renderAgent.render(generatedAsset, sourceAsset, templates[0], statusCallback)
+}
+
+func (renderAgent *baseRenderAgent) getSourceAsset(generatedAsset *common.GeneratedAsset) (*common.SourceAsset, error) {
+	sourceAssets, err := renderAgent.sasm.FindBySourceAssetId(generatedAsset.SourceAssetId)
+	if err != nil {
+		return nil, err
+	}
+	for _, sourceAsset := range sourceAssets {
+		if sourceAsset.IdType == generatedAsset.SourceAssetType {
+			return sourceAsset, nil
+		}
+	}
+	return nil, common.ErrorNoSourceAssetsFoundForId
+}
+
+func (renderAgent *baseRenderAgent) tryDownload(urls []string, source string) (common.TemporaryFile, error) {
+	for _, url := range urls {
+		tempFile, err := renderAgent.downloader.Download(url, source)
+		if err == nil {
+			return tempFile, nil
+		}
+	}
+	return nil, common.ErrorNoDownloadUrlsWork
+}
+
+// getSize returns the width and height of the box that rendered images fit in. Templates without a width use a square box.
+func (renderAgent *baseRenderAgent) getSize(template *common.Template) (int, int, error) {
+	rawHeight, err := common.GetFirstAttribute(template, common.TemplateAttributeHeight)
+	if err != nil {
+		return 0, 0, err
+	}
+	height, err := strconv.Atoi(rawHeight)
+	if err != nil {
+		return 0, 0, err
+	}
+	width := height
+	rawWidth, err := common.GetFirstAttribute(template, common.TemplateAttributeWidth)
+	if err == nil {
+		width, err = strconv.Atoi(rawWidth)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return width, height, nil
+}
+
+// upload uploads the rendered file of each output format of a generated asset. Nothing is uploaded for a generated asset that has been canceled or purged, and the uploaded files are deleted if it is canceled or purged while they are being uploaded.
+func (renderAgent *baseRenderAgent) upload(generatedAsset *common.GeneratedAsset, outputs []string, destinations map[string]string) codederror.CodedError {
+	if isCanceled(renderAgent.gasm, generatedAsset.Id) {
+		return common.ErrorGeneratedAssetCanceled
+	}
+	locations := outputLocations(generatedAsset.Location, outputs)
+	for output, location := range locations {
+		err := renderAgent.uploader.Upload(location, destinations[output])
+		if err != nil {
+			return common.ErrorCouldNotUploadAsset
+		}
+	}
+	if discardIfCanceled(renderAgent.gasm, renderAgent.uploader, generatedAsset.Id, locations) {
+		return common.ErrorGeneratedAssetCanceled
+	}
+	return nil
+}
+
+func (renderAgent *baseRenderAgent) commitStatus(id string, existingAttributes []common.Attribute) chan generatedAssetUpdate {
+	commitChannel := make(chan generatedAssetUpdate, 10)
+
+	go func() {
+		status := common.NewGeneratedAssetError(common.ErrorUnknownError)
+		attributes := make([]common.Attribute, 0, 0)
+		for _, attribute := range existingAttributes {
+			attributes = append(attributes, attribute)
+		}
+		for {
+			select {
+			case message, ok := <-commitChannel:
+				{
+					if !ok {
+						generatedAsset, err := renderAgent.gasm.FindById(id)
+						if err != nil {
+							// NKG: The generated asset may have been purged while
+							// it was being rendered.
+							log.Println("Generated asset", id, "could not be found:", err)
+							return
+						}
+						// NKG: A generated asset that was canceled while it
+						// was being rendered stays canceled.
+						if generatedAsset.Status == common.NewGeneratedAssetError(common.ErrorGeneratedAssetCanceled) {
+							log.Println("Generated asset", id, "was canceled while it was being rendered")
+							return
+						}
+						generatedAsset.Status = status
+						generatedAsset.Attributes = attributes
+						renderAgent.retryPolicy.apply(generatedAsset)
+						renderAgent.gasm.Update(generatedAsset)
+						// NKG: Listeners are told about the new status after
+						// it has been stored so that they see it when they
+						// look up the generated asset.
+						for _, listener := range renderAgent.statusListeners {
+							listener <- RenderStatus{id, generatedAsset.SourceAssetId, generatedAsset.Status, renderAgent.renderer}
+						}
+						return
+					}
+					status = message.status
+					if message.attributes != nil {
+						for _, attribute := range message.attributes {
+							attributes = append(attributes, attribute)
+						}
+					}
+				}
+			}
+		}
+	}()
+	return commitChannel
+}
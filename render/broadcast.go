@@ -0,0 +1,110 @@
+package render
+
+import (
+	"sync"
+	"time"
+)
+
+// StatusFilter returns true if a render status should be sent to a subscriber.
+type StatusFilter func(renderStatus RenderStatus) bool
+
+type statusSubscriber struct {
+	filter  StatusFilter
+	channel RenderStatusChannel
+}
+
+// StatusBroadcaster listens for render status updates and sends them to any number of subscribers. Subscribers that can't keep up miss updates instead of slowing down render agents.
+type StatusBroadcaster struct {
+	listener    RenderStatusChannel
+	subscribers map[int]*statusSubscriber
+	nextId      int
+
+	stop chan (chan bool)
+	mu   sync.Mutex
+}
+
+// NewStatusBroadcaster creates and starts a new status broadcaster.
+func NewStatusBroadcaster() *StatusBroadcaster {
+	broadcaster := new(StatusBroadcaster)
+	broadcaster.listener = make(RenderStatusChannel, 100)
+	broadcaster.subscribers = make(map[int]*statusSubscriber)
+	broadcaster.stop = make(chan (chan bool))
+	go broadcaster.run()
+	return broadcaster
+}
+
+// Listener returns the channel that should be added as a listener to the render agent manager.
+func (broadcaster *StatusBroadcaster) Listener() RenderStatusChannel {
+	return broadcaster.listener
+}
+
+// Subscribe returns a channel that is sent the render status updates that pass the filter, and a function that must be called once the subscriber is done.
+func (broadcaster *StatusBroadcaster) Subscribe(filter StatusFilter) (RenderStatusChannel, func()) {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+
+	id := broadcaster.nextId
+	broadcaster.nextId = broadcaster.nextId + 1
+	subscriber := &statusSubscriber{filter, make(RenderStatusChannel, 100)}
+	broadcaster.subscribers[id] = subscriber
+
+	return subscriber.channel, func() {
+		broadcaster.mu.Lock()
+		defer broadcaster.mu.Unlock()
+		delete(broadcaster.subscribers, id)
+	}
+}
+
+// SubscriberCount returns the number of active subscribers.
+func (broadcaster *StatusBroadcaster) SubscriberCount() int {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	return len(broadcaster.subscribers)
+}
+
+func (broadcaster *StatusBroadcaster) Stop() {
+	callback := make(chan bool)
+	broadcaster.stop <- callback
+	select {
+	case <-callback:
+	case <-time.After(5 * time.Second):
+	}
+	close(broadcaster.stop)
+}
+
+func (broadcaster *StatusBroadcaster) run() {
+	for {
+		select {
+		case ch, ok := <-broadcaster.stop:
+			{
+				if !ok {
+					return
+				}
+				ch <- true
+				return
+			}
+		case renderStatus, ok := <-broadcaster.listener:
+			{
+				if !ok {
+					return
+				}
+				broadcaster.broadcast(renderStatus)
+			}
+		}
+	}
+}
+
+func (broadcaster *StatusBroadcaster) broadcast(renderStatus RenderStatus) {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+
+	for _, subscriber := range broadcaster.subscribers {
+		if subscriber.filter != nil && !subscriber.filter(renderStatus) {
+			continue
+		}
+		select {
+		case subscriber.channel <- renderStatus:
+		default:
+		}
+	}
+}
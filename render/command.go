@@ -0,0 +1,80 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ngerakines/preview/common"
+	"log"
+	"os/exec"
+	"time"
+)
+
+// CommandLimits describes the limits placed on the external commands run by render agents. A zero value disables the limit.
+type CommandLimits struct {
+	// Timeout is the amount of time a command can run before it and any processes it started are killed.
+	Timeout time.Duration
+	// CpuSeconds is the amount of CPU time, in seconds, a command can use.
+	CpuSeconds int
+	// MemoryBytes is the amount of virtual memory a command can use.
+	MemoryBytes int64
+	// FileSizeBytes is the size of the largest file a command can write.
+	FileSizeBytes int64
+}
+
+// runCommand runs an external command within the given limits and returns its combined output. If the command does not complete before the timeout, common.ErrorRenderTimedOut is returned.
+func runCommand(limits CommandLimits, name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		log.Println(name, "command not found")
+		return nil, err
+	}
+
+	cmd := newLimitedCommand(limits, path, args...)
+	log.Println(cmd.Args)
+
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	cmd.Stderr = &buf
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if limits.Timeout > 0 {
+		timeout = time.After(limits.Timeout)
+	}
+
+	select {
+	case err = <-done:
+		return buf.Bytes(), err
+	case <-timeout:
+		log.Println("Command timed out after", limits.Timeout, cmd.Args)
+		killCommand(cmd)
+		<-done
+		return buf.Bytes(), common.ErrorRenderTimedOut
+	}
+}
+
+// ulimitCommands returns the shell ulimit builtin commands that apply the resource limits. Each limit is set with its own command because some shells only accept one option at a time.
+func (limits CommandLimits) ulimitCommands() []string {
+	commands := make([]string, 0, 0)
+	if limits.CpuSeconds > 0 {
+		commands = append(commands, fmt.Sprintf("ulimit -t %d", limits.CpuSeconds))
+	}
+	if limits.MemoryBytes > 0 {
+		// NKG: ulimit -v is in kilobytes.
+		commands = append(commands, fmt.Sprintf("ulimit -v %d", limits.MemoryBytes/1024))
+	}
+	if limits.FileSizeBytes > 0 {
+		// NKG: ulimit -f is in 512 byte blocks when run by a POSIX shell.
+		commands = append(commands, fmt.Sprintf("ulimit -f %d", limits.FileSizeBytes/512))
+	}
+	return commands
+}
@@ -0,0 +1,62 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommand(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh command not found")
+	}
+
+	output, err := runCommand(CommandLimits{Timeout: 5 * time.Second}, "sh", "-c", "echo hello")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if strings.TrimSpace(string(output)) != "hello" {
+		t.Error("Unexpected output", string(output))
+	}
+
+	_, err = runCommand(CommandLimits{Timeout: 5 * time.Second}, "sh", "-c", "exit 1")
+	if err == nil || err == common.ErrorRenderTimedOut {
+		t.Error("Expected exit error", err)
+	}
+}
+
+func TestRunCommandTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh command not found")
+	}
+
+	start := time.Now()
+	// NKG: The child sleep process keeps the output open, so the command
+	// only returns quickly if the whole process group is killed.
+	_, err := runCommand(CommandLimits{Timeout: 500 * time.Millisecond}, "sh", "-c", "sleep 30 & sleep 30")
+	if err != common.ErrorRenderTimedOut {
+		t.Error("Expected timeout error", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("Command was not killed when it timed out", time.Since(start))
+	}
+}
+
+func TestRunCommandLimits(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh command not found")
+	}
+
+	output, err := runCommand(CommandLimits{Timeout: 5 * time.Second, CpuSeconds: 10, FileSizeBytes: 1048576}, "sh", "-c", "ulimit -t; ulimit -f")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	lines := strings.Fields(string(output))
+	if len(lines) != 2 || lines[0] != "10" || lines[1] != "2048" {
+		t.Error("Unexpected limits", lines)
+	}
+}
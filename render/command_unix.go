@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package render
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// newLimitedCommand creates a command that runs in its own process group so that it, and any processes it starts, can be killed together. Resource limits are applied by running the command through the shell ulimit builtin.
+func newLimitedCommand(limits CommandLimits, path string, args ...string) *exec.Cmd {
+	var cmd *exec.Cmd
+	ulimitCommands := limits.ulimitCommands()
+	if len(ulimitCommands) > 0 {
+		script := strings.Join(append(ulimitCommands, "exec \"$0\" \"$@\""), " && ")
+		shellArgs := append([]string{"-c", script, path}, args...)
+		cmd = exec.Command("/bin/sh", shellArgs...)
+	} else {
+		cmd = exec.Command(path, args...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return cmd
+}
+
+func killCommand(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	// NKG: A negative pid sends the signal to every process in the group.
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if err != nil {
+		cmd.Process.Kill()
+	}
+}
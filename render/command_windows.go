@@ -0,0 +1,16 @@
+package render
+
+import (
+	"os/exec"
+)
+
+// newLimitedCommand creates a command. Resource limits are not supported on Windows, so only the timeout applies.
+func newLimitedCommand(limits CommandLimits, path string, args ...string) *exec.Cmd {
+	return exec.Command(path, args...)
+}
+
+func killCommand(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
@@ -12,6 +12,7 @@ type RenderStatusChannel chan RenderStatus
 
 type RenderStatus struct {
 	GeneratedAssetId string
+	SourceAssetId    string
 	Status           string
 	Service          string
 }
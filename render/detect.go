@@ -0,0 +1,21 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"time"
+)
+
+// EnableFileTypeDetection has new work routed using the file type detected from the contents of the file instead of the file type given when the preview was requested. Only the start of each file is read with the given downloader when work is created, and files that are too large for the route of their declared file type aren't read at all. Work is created when the preview is requested, so reading the start of a file gives up after timeout and the declared file type is used instead.
+func (agentManager *RenderAgentManager) EnableFileTypeDetection(downloader common.Downloader, timeout time.Duration) {
+	agentManager.fileTypeDownloader = downloader
+	agentManager.fileTypeTimeout = timeout
+}
+
+// detectFileType reads the start of a file and returns the file type detected from it.
+func (agentManager *RenderAgentManager) detectFileType(url, source string) (string, error) {
+	header, err := agentManager.fileTypeDownloader.DownloadHeader(url, source, common.FileTypeHeaderLength, agentManager.fileTypeTimeout)
+	if err != nil {
+		return "", err
+	}
+	return common.DetectFileType(header)
+}
@@ -0,0 +1,80 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateWorkDetectsFileType(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "source.jpg")
+	ioutil.WriteFile(sourcePath, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+	rm.EnableFileTypeDetection(downloader, time.Second)
+
+	rm.CreateWork("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", "file://"+sourcePath, "jpg", 16, []common.Attribute{})
+
+	sourceAssets, err := sasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil || len(sourceAssets) != 1 {
+		t.Error("Source asset expected to be created", sourceAssets, err)
+		return
+	}
+	for key, expected := range map[string]string{
+		common.SourceAssetAttributeType:         "png",
+		common.SourceAssetAttributeDeclaredType: "jpg",
+		common.SourceAssetAttributeDetectedType: "png",
+	} {
+		value, err := common.GetFirstAttribute(sourceAssets[0], key)
+		if err != nil || value != expected {
+			t.Error("Unexpected", key, value, err)
+		}
+	}
+}
+
+func TestCreateWorkSkipsDetectionOfLargeFiles(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "source.jpg")
+	ioutil.WriteFile(sourcePath, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), 0644)
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+	rm.EnableFileTypeDetection(downloader, time.Second)
+	rm.AddRoute(NewRendererRoute(common.RenderAgentImageMagick, "jpg", 8))
+
+	rm.CreateWork("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", "file://"+sourcePath, "jpg", 16, []common.Attribute{})
+
+	sourceAssets, err := sasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+	if err != nil || len(sourceAssets) != 1 {
+		t.Error("Source asset expected to be created", sourceAssets, err)
+		return
+	}
+	if sourceAssets[0].HasAttribute(common.SourceAssetAttributeDetectedType) {
+		t.Error("File type of a file that is too large expected to not be detected")
+	}
+	if fileType, _ := common.GetFirstAttribute(sourceAssets[0], common.SourceAssetAttributeType); fileType != "jpg" {
+		t.Error("Unexpected file type", fileType)
+	}
+}
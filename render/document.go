@@ -1,34 +1,25 @@
 package render
 
 import (
-	"bytes"
 	"github.com/ngerakines/preview/common"
 	"github.com/ngerakines/preview/util"
 	"github.com/rcrowley/go-metrics"
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
 type documentRenderAgent struct {
-	metrics              *documentRenderAgentMetrics
-	sasm                 common.SourceAssetStorageManager
-	gasm                 common.GeneratedAssetStorageManager
-	templateManager      common.TemplateManager
-	downloader           common.Downloader
-	uploader             common.Uploader
-	workChannel          RenderAgentWorkChannel
-	statusListeners      []RenderStatusChannel
-	temporaryFileManager common.TemporaryFileManager
-	agentManager         *RenderAgentManager
-	tempFileBasePath     string
-	stop                 chan (chan bool)
+	*baseRenderAgent
+	metrics          *documentRenderAgentMetrics
+	agentManager     *RenderAgentManager
+	tempFileBasePath string
+	pageLimits       map[string]int
+	limits           CommandLimits
 }
 
 type documentRenderAgentMetrics struct {
@@ -38,35 +29,27 @@ type documentRenderAgentMetrics struct {
 	docxCount     metrics.Counter
 	pptCount      metrics.Counter
 	pptxCount     metrics.Counter
+	xlsCount      metrics.Counter
+	xlsxCount     metrics.Counter
 }
 
 func newDocumentRenderAgent(
+	base *baseRenderAgent,
 	metrics *documentRenderAgentMetrics,
 	agentManager *RenderAgentManager,
-	sasm common.SourceAssetStorageManager,
-	gasm common.GeneratedAssetStorageManager,
-	templateManager common.TemplateManager,
-	temporaryFileManager common.TemporaryFileManager,
-	downloader common.Downloader,
-	uploader common.Uploader,
 	tempFileBasePath string,
-	workChannel RenderAgentWorkChannel) RenderAgent {
+	pageLimits map[string]int,
+	limits CommandLimits) RenderAgent {
 
 	renderAgent := new(documentRenderAgent)
+	renderAgent.baseRenderAgent = base
 	renderAgent.metrics = metrics
 	renderAgent.agentManager = agentManager
-	renderAgent.sasm = sasm
-	renderAgent.gasm = gasm
-	renderAgent.templateManager = templateManager
-	renderAgent.temporaryFileManager = temporaryFileManager
-	renderAgent.downloader = downloader
-	renderAgent.uploader = uploader
-	renderAgent.workChannel = workChannel
 	renderAgent.tempFileBasePath = tempFileBasePath
-	renderAgent.statusListeners = make([]RenderStatusChannel, 0, 0)
-	renderAgent.stop = make(chan (chan bool))
+	renderAgent.pageLimits = pageLimits
+	renderAgent.limits = limits
 
-	go renderAgent.start()
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
 
 	return renderAgent
 }
@@ -79,6 +62,8 @@ func newDocumentRenderAgentMetrics(registry metrics.Registry) *documentRenderAge
 	documentMetrics.docxCount = metrics.NewCounter()
 	documentMetrics.pptCount = metrics.NewCounter()
 	documentMetrics.pptxCount = metrics.NewCounter()
+	documentMetrics.xlsCount = metrics.NewCounter()
+	documentMetrics.xlsxCount = metrics.NewCounter()
 
 	registry.Register("documentRenderAgent.workProcessed", documentMetrics.workProcessed)
 	registry.Register("documentRenderAgent.convertTime", documentMetrics.convertTime)
@@ -86,52 +71,12 @@ func newDocumentRenderAgentMetrics(registry metrics.Registry) *documentRenderAge
 	registry.Register("documentRenderAgent.docxCount", documentMetrics.docxCount)
 	registry.Register("documentRenderAgent.pptCount", documentMetrics.pptCount)
 	registry.Register("documentRenderAgent.pptxCount", documentMetrics.pptxCount)
+	registry.Register("documentRenderAgent.xlsCount", documentMetrics.xlsCount)
+	registry.Register("documentRenderAgent.xlsxCount", documentMetrics.xlsxCount)
 
 	return documentMetrics
 }
 
-func (renderAgent *documentRenderAgent) start() {
-	for {
-		select {
-		case ch, ok := <-renderAgent.stop:
-			{
-				log.Println("Stopping")
-				if !ok {
-					return
-				}
-				ch <- true
-				return
-			}
-		case id, ok := <-renderAgent.workChannel:
-			{
-				if !ok {
-					return
-				}
-				log.Println("Received dispatch message", id)
-				renderAgent.renderGeneratedAsset(id)
-			}
-		}
-	}
-}
-
-func (renderAgent *documentRenderAgent) Stop() {
-	callback := make(chan bool)
-	renderAgent.stop <- callback
-	select {
-	case <-callback:
-	case <-time.After(5 * time.Second):
-	}
-	close(renderAgent.stop)
-}
-
-func (renderAgent *documentRenderAgent) AddStatusListener(listener RenderStatusChannel) {
-	renderAgent.statusListeners = append(renderAgent.statusListeners, listener)
-}
-
-func (renderAgent *documentRenderAgent) Dispatch() RenderAgentWorkChannel {
-	return renderAgent.workChannel
-}
-
 /*
 1. Get the generated asset
 2. Get the source asset
@@ -142,32 +87,10 @@ func (renderAgent *documentRenderAgent) Dispatch() RenderAgentWorkChannel {
 7. Given a file in that directory exists, determine how many pages it contains.
 8. Create a new source asset record for the pdf.
 9. Upload the new source asset pdf file.
-10. For each page in the pdf, create a generated asset record for each of the default templates.
+10. For each page in the pdf, up to the page limit of the file type, create a generated asset record for each of the default templates.
 11. Update the status of the generated asset as complete.
 */
-func (renderAgent *documentRenderAgent) renderGeneratedAsset(id string) {
-	renderAgent.metrics.workProcessed.Mark(1)
-
-	// 1. Get the generated asset
-	generatedAsset, err := renderAgent.gasm.FindById(id)
-	if err != nil {
-		log.Fatal("No Generated Asset with that ID can be retreived from storage: ", id)
-		return
-	}
-
-	statusCallback := renderAgent.commitStatus(generatedAsset.Id, generatedAsset.Attributes)
-	defer func() { close(statusCallback) }()
-
-	generatedAsset.Status = common.GeneratedAssetStatusProcessing
-	renderAgent.gasm.Update(generatedAsset)
-
-	// 2. Get the source asset
-	sourceAsset, err := renderAgent.getSourceAsset(generatedAsset)
-	if err != nil {
-		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnableToFindSourceAssetsById), nil}
-		return
-	}
-
+func (renderAgent *documentRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
 	fileType, err := common.GetFirstAttribute(sourceAsset, common.SourceAssetAttributeType)
 	if err == nil {
 		switch fileType {
@@ -179,11 +102,13 @@ func (renderAgent *documentRenderAgent) renderGeneratedAsset(id string) {
 			renderAgent.metrics.pptCount.Inc(1)
 		case "pptx":
 			renderAgent.metrics.pptxCount.Inc(1)
+		case "xls":
+			renderAgent.metrics.xlsCount.Inc(1)
+		case "xlsx":
+			renderAgent.metrics.xlsxCount.Inc(1)
 		}
 	}
 
-	// 3. Get the template... not needed yet
-
 	// 4. Fetch the source asset file
 	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
 	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
@@ -203,12 +128,16 @@ func (renderAgent *documentRenderAgent) renderGeneratedAsset(id string) {
 	defer destinationTemporaryFile.Release()
 
 	renderAgent.metrics.convertTime.Time(func() {
-		err = renderAgent.createPdf(sourceFile.Path(), destination)
-		if err != nil {
-			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		err = renderAgent.createPdf(sourceFile.Path(), destination, fileType)
+	})
+	if err != nil {
+		if err == common.ErrorRenderTimedOut {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
 			return
 		}
-	})
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
 
 	files, err := renderAgent.getRenderedFiles(destination)
 	if err != nil {
@@ -221,14 +150,17 @@ func (renderAgent *documentRenderAgent) renderGeneratedAsset(id string) {
 	}
 
 	pages, err := renderAgent.getPdfPageCount(files[0])
+	if err == common.ErrorRenderTimedOut {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
+		return
+	}
 	if err != nil {
 		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNotImplemented), nil}
 		return
 	}
 
-	err = renderAgent.uploader.Upload(generatedAsset.Location, files[0])
-	if err != nil {
-		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset), nil}
+	if err := renderAgent.upload(generatedAsset, []string{"pdf"}, map[string]string{"pdf": files[0]}); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
 		return
 	}
 
@@ -248,17 +180,31 @@ func (renderAgent *documentRenderAgent) renderGeneratedAsset(id string) {
 	pdfSourceAsset.AddAttribute(common.SourceAssetAttributePages, []string{strconv.Itoa(pages)})
 	pdfSourceAsset.AddAttribute(common.SourceAssetAttributeSource, []string{generatedAsset.Location})
 	pdfSourceAsset.AddAttribute(common.SourceAssetAttributeType, []string{"pdf"})
+
+	renderedPages, truncated := limitPages(pages, renderAgent.pageLimits[fileType])
+	var attributes []common.Attribute
+	if truncated {
+		attributes = append(attributes, pdfSourceAsset.AddAttribute(common.SourceAssetAttributeTruncated, []string{"true"}))
+		attributes = append(attributes, pdfSourceAsset.AddAttribute(common.SourceAssetAttributeRenderedPages, []string{strconv.Itoa(renderedPages)}))
+		for _, attribute := range attributes {
+			sourceAsset.SetAttribute(attribute.Key, attribute.Value)
+		}
+		renderAgent.sasm.Store(sourceAsset)
+	}
 	// TODO: Add support for the expiration attribute.
 
 	log.Println("pdfSourceAsset", pdfSourceAsset)
 	renderAgent.sasm.Store(pdfSourceAsset)
-	legacyDefaultTemplates, err := renderAgent.templateManager.FindByIds(common.LegacyDefaultTemplates)
+	pdfTemplates, err := common.FindTemplatesForFileType(renderAgent.templateManager, "pdf")
+	if err == nil && len(pdfTemplates) == 0 {
+		pdfTemplates, err = renderAgent.templateManager.FindByIds(common.LegacyDefaultTemplates)
+	}
 	if err != nil {
 		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNotImplemented), nil}
 		return
 	}
 
-	renderAgent.agentManager.CreateDerivedWork(sourceAsset, pdfSourceAsset, legacyDefaultTemplates, pages)
+	renderAgent.agentManager.CreateDerivedWork(sourceAsset, pdfSourceAsset, pdfTemplates, renderedPages)
 
 	/*
 		// TODO: Have the new source asset and generated assets be created in batch in the storage managers.
@@ -284,39 +230,27 @@ func (renderAgent *documentRenderAgent) renderGeneratedAsset(id string) {
 		}
 	*/
 
-	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, nil}
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, attributes}
 }
 
-func (renderAgent *documentRenderAgent) getSourceAsset(generatedAsset *common.GeneratedAsset) (*common.SourceAsset, error) {
-	sourceAssets, err := renderAgent.sasm.FindBySourceAssetId(generatedAsset.SourceAssetId)
-	if err != nil {
-		return nil, err
-	}
-	for _, sourceAsset := range sourceAssets {
-		if sourceAsset.IdType == generatedAsset.SourceAssetType {
-			return sourceAsset, nil
-		}
+// limitPages returns the number of pages of a document that are rendered and whether that is fewer than the document has. A limit of 0 renders every page.
+func limitPages(pages, limit int) (int, bool) {
+	if limit > 0 && pages > limit {
+		return limit, true
 	}
-	return nil, common.ErrorNoSourceAssetsFoundForId
+	return pages, false
 }
 
-func (renderAgent *documentRenderAgent) createPdf(source, destination string) error {
-	_, err := exec.LookPath("soffice")
-	if err != nil {
-		log.Println("soffice command not found")
-		return err
+func (renderAgent *documentRenderAgent) createPdf(source, destination, fileType string) error {
+	filter := "pdf"
+	// NKG: Spreadsheets are exported with each sheet on a single page so
+	// that page limits apply to sheets rather than printed pages.
+	if fileType == "xls" || fileType == "xlsx" {
+		filter = `pdf:calc_pdf_Export:{"SinglePageSheets":{"type":"boolean","value":"true"}}`
 	}
-
 	// TODO: Make this path configurable.
-	cmd := exec.Command("soffice", "--headless", "--nologo", "--nofirststartwizard", "--convert-to", "pdf", source, "--outdir", destination)
-	log.Println(cmd)
-
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-
-	err = cmd.Run()
-	log.Println(buf.String())
+	output, err := runCommand(renderAgent.limits, "soffice", "--headless", "--nologo", "--nofirststartwizard", "--convert-to", filter, source, "--outdir", destination)
+	log.Println(string(output))
 	if err != nil {
 		log.Println("error running command", err)
 		return err
@@ -329,14 +263,9 @@ var pdfPageCount = regexp.MustCompile(`Pages:\s+(\d+)`)
 
 // pdfinfo ~/Desktop/ChefConf2014schedule.pdf
 func (renderAgent *documentRenderAgent) getPdfPageCount(file string) (int, error) {
-	_, err := exec.LookPath("pdfinfo")
-	if err != nil {
-		log.Println("pdfinfo command not found")
-		return 0, err
-	}
-	out, err := exec.Command("pdfinfo", file).Output()
+	out, err := runCommand(renderAgent.limits, "pdfinfo", file)
 	if err != nil {
-		log.Fatal(err)
+		log.Println("error running command", err)
 		return 0, err
 	}
 	matches := pdfPageCount.FindStringSubmatch(string(out))
@@ -346,57 +275,6 @@ func (renderAgent *documentRenderAgent) getPdfPageCount(file string) (int, error
 	return 0, nil
 }
 
-func (renderAgent *documentRenderAgent) tryDownload(urls []string, source string) (common.TemporaryFile, error) {
-	for _, url := range urls {
-		tempFile, err := renderAgent.downloader.Download(url, source)
-		if err == nil {
-			return tempFile, nil
-		}
-	}
-	return nil, common.ErrorNoDownloadUrlsWork
-}
-
-func (renderAgent *documentRenderAgent) commitStatus(id string, existingAttributes []common.Attribute) chan generatedAssetUpdate {
-	commitChannel := make(chan generatedAssetUpdate, 10)
-
-	go func() {
-		status := common.NewGeneratedAssetError(common.ErrorUnknownError)
-		attributes := make([]common.Attribute, 0, 0)
-		for _, attribute := range existingAttributes {
-			attributes = append(attributes, attribute)
-		}
-		for {
-			select {
-			case message, ok := <-commitChannel:
-				{
-					if !ok {
-						for _, listener := range renderAgent.statusListeners {
-							listener <- RenderStatus{id, status, common.RenderAgentDocument}
-						}
-						generatedAsset, err := renderAgent.gasm.FindById(id)
-						if err != nil {
-							panic(err)
-							return
-						}
-						generatedAsset.Status = status
-						generatedAsset.Attributes = attributes
-						log.Println("Updating", generatedAsset)
-						renderAgent.gasm.Update(generatedAsset)
-						return
-					}
-					status = message.status
-					if message.attributes != nil {
-						for _, attribute := range message.attributes {
-							attributes = append(attributes, attribute)
-						}
-					}
-				}
-			}
-		}
-	}()
-	return commitChannel
-}
-
 func (renderAgent *documentRenderAgent) createTemporaryDestinationDirectory() (string, error) {
 	uuid, err := util.NewUuid()
 	if err != nil {
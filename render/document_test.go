@@ -67,3 +67,22 @@ func TestConvertDocxToPdf(t *testing.T) {
 		return
 	}
 }
+
+func TestLimitPages(t *testing.T) {
+	tests := []struct {
+		pages, limit, expected int
+		truncated              bool
+	}{
+		{900, 50, 50, true},
+		{12, 20, 12, false},
+		{20, 20, 20, false},
+		{3, 1, 1, true},
+		{900, 0, 900, false},
+	}
+	for _, test := range tests {
+		pages, truncated := limitPages(test.pages, test.limit)
+		if pages != test.expected || truncated != test.truncated {
+			t.Errorf("limitPages(%d, %d) = %d, %t, expected %d, %t", test.pages, test.limit, pages, truncated, test.expected, test.truncated)
+		}
+	}
+}
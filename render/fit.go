@@ -0,0 +1,57 @@
+package render
+
+import (
+	"fmt"
+	"github.com/ngerakines/preview/common"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// templateFit returns the fit mode and background colour of a template. Templates without a fit attribute use the contain fit mode, and templates without a background attribute return a nil background.
+func templateFit(template *common.Template) (string, color.Color, error) {
+	fit, err := common.GetFirstAttribute(template, common.TemplateAttributeFit)
+	if err != nil {
+		fit = common.FitModeContain
+	}
+	if !listContains(common.FitModes, fit) {
+		return "", nil, common.ErrorInvalidTemplateFit
+	}
+	rawBackground, err := common.GetFirstAttribute(template, common.TemplateAttributeBackground)
+	if err != nil {
+		return fit, nil, nil
+	}
+	background, err := parseBackground(rawBackground)
+	if err != nil {
+		return "", nil, err
+	}
+	return fit, background, nil
+}
+
+// parseBackground parses a "#rgb", "#rrggbb" or "#rrggbbaa" hex colour, or "transparent".
+func parseBackground(value string) (color.Color, error) {
+	if value == "transparent" {
+		return color.NRGBA{0, 0, 0, 0}, nil
+	}
+	hex := strings.TrimPrefix(value, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) == 6 {
+		hex = hex + "ff"
+	}
+	if len(hex) != 8 {
+		return nil, common.ErrorInvalidTemplateFit
+	}
+	rgba, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, common.ErrorInvalidTemplateFit
+	}
+	return color.NRGBA{uint8(rgba >> 24), uint8(rgba >> 16), uint8(rgba >> 8), uint8(rgba)}, nil
+}
+
+// backgroundHex formats a colour as a "#rrggbbaa" hex colour that can be given to ImageMagick.
+func backgroundHex(background color.Color) string {
+	c := color.NRGBAModel.Convert(background).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
@@ -0,0 +1,51 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateFit(t *testing.T) {
+	template := &common.Template{Renderer: common.RenderAgentImageMagick, Attributes: []common.Attribute{}}
+	fit, background, err := templateFit(template)
+	if err != nil || fit != common.FitModeContain || background != nil {
+		t.Error("Unexpected default fit", fit, background, err)
+	}
+
+	template.AddAttribute(common.TemplateAttributeFit, []string{common.FitModePad})
+	template.AddAttribute(common.TemplateAttributeBackground, []string{"#f00"})
+	fit, background, err = templateFit(template)
+	if err != nil || fit != common.FitModePad || background != (color.NRGBA{R: 255, A: 255}) {
+		t.Error("Unexpected pad fit", fit, background, err)
+	}
+
+	invalid := &common.Template{Renderer: common.RenderAgentImageMagick, Attributes: []common.Attribute{}}
+	invalid.AddAttribute(common.TemplateAttributeFit, []string{"zoom"})
+	if _, _, err = templateFit(invalid); err != common.ErrorInvalidTemplateFit {
+		t.Error("Invalid fit expected to be rejected", err)
+	}
+	if _, err = parseBackground("#12345"); err != common.ErrorInvalidTemplateFit {
+		t.Error("Invalid background expected to be rejected", err)
+	}
+}
+
+func TestImageMagickFitArgs(t *testing.T) {
+	expected := map[string][]string{
+		common.FitModeContain: []string{"-resize", "200x100>"},
+		common.FitModeCover:   []string{"-resize", "200x100^", "-gravity", "center", "-extent", "200x100"},
+		common.FitModeFill:    []string{"-resize", "200x100!"},
+		common.FitModePad:     []string{"-background", "white", "-resize", "200x100>", "-gravity", "center", "-extent", "200x100"},
+	}
+	for fit, args := range expected {
+		if value := imageMagickFitArgs(200, 100, fit, nil); !reflect.DeepEqual(value, args) {
+			t.Error("Unexpected args for", fit, value)
+		}
+	}
+
+	args := imageMagickFitArgs(200, 100, common.FitModePad, color.NRGBA{A: 255})
+	if !reflect.DeepEqual(args, []string{"-background", "#000000ff", "-flatten", "-resize", "200x100>", "-gravity", "center", "-extent", "200x100"}) {
+		t.Error("Unexpected args for background", args)
+	}
+}
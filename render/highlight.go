@@ -0,0 +1,296 @@
+package render
+
+import (
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenPlain tokenKind = iota
+	tokenKeyword
+	tokenString
+	tokenComment
+	tokenNumber
+	tokenKey
+	tokenHeading
+)
+
+// textSpan is a run of text on a line that is drawn in a single colour.
+type textSpan struct {
+	text string
+	kind tokenKind
+}
+
+// textSyntax describes the parts of a language that are highlighted.
+type textSyntax struct {
+	lineComments []string
+	blockComment []string
+	quotes       string
+	rawQuote     rune
+	tripleQuotes bool
+	keys         bool
+	keywords     map[string]bool
+}
+
+var (
+	goSyntax = &textSyntax{
+		lineComments: []string{"//"},
+		blockComment: []string{"/*", "*/"},
+		quotes:       "\"'",
+		rawQuote:     '`',
+		keywords: keywordSet(
+			"break", "case", "chan", "const", "continue", "default", "defer", "else", "fallthrough", "for", "func", "go", "goto", "if", "import",
+			"interface", "map", "package", "range", "return", "select", "struct", "switch", "type", "var",
+			"bool", "byte", "error", "float32", "float64", "int", "int8", "int16", "int32", "int64", "rune", "string", "uint", "uint8", "uint16", "uint32", "uint64",
+			"true", "false", "nil", "iota"),
+	}
+	pythonSyntax = &textSyntax{
+		lineComments: []string{"#"},
+		quotes:       "\"'",
+		tripleQuotes: true,
+		keywords: keywordSet(
+			"and", "as", "assert", "async", "await", "break", "class", "continue", "def", "del", "elif", "else", "except", "finally", "for", "from",
+			"global", "if", "import", "in", "is", "lambda", "nonlocal", "not", "or", "pass", "raise", "return", "try", "while", "with", "yield",
+			"True", "False", "None", "self"),
+	}
+	jsonSyntax = &textSyntax{
+		quotes:   "\"",
+		keys:     true,
+		keywords: keywordSet("true", "false", "null"),
+	}
+
+	// NKG: Markdown and CSV files are highlighted a line at a time instead
+	// of with a textSyntax, see highlightMarkdown and highlightCsv.
+	textSyntaxes = map[string]*textSyntax{
+		"go":   goSyntax,
+		"py":   pythonSyntax,
+		"json": jsonSyntax,
+	}
+	csvColumnKinds = []tokenKind{tokenPlain, tokenKeyword, tokenString, tokenNumber, tokenKey}
+)
+
+func keywordSet(keywords ...string) map[string]bool {
+	set := make(map[string]bool)
+	for _, keyword := range keywords {
+		set[keyword] = true
+	}
+	return set
+}
+
+// highlight splits text into lines of spans using the syntax of the language with the given file type. Text of unknown languages is not highlighted.
+func highlight(fileType, text string) [][]textSpan {
+	switch strings.ToLower(fileType) {
+	case "md":
+		return highlightMarkdown(text)
+	case "csv":
+		return highlightCsv(text)
+	}
+	syntax, hasSyntax := textSyntaxes[strings.ToLower(fileType)]
+	if !hasSyntax {
+		lines := make([][]textSpan, 0, 0)
+		for _, line := range strings.Split(text, "\n") {
+			lines = append(lines, []textSpan{textSpan{line, tokenPlain}})
+		}
+		return lines
+	}
+	return splitSpans(syntax.tokenize([]rune(text)))
+}
+
+// tokenize splits text into spans. Spans may contain newlines, such as block comments and raw strings.
+func (syntax *textSyntax) tokenize(text []rune) []textSpan {
+	spans := make([]textSpan, 0, 0)
+	emit := func(start, end int, kind tokenKind) {
+		if end > start {
+			spans = append(spans, textSpan{string(text[start:end]), kind})
+		}
+	}
+	plainStart := 0
+	i := 0
+	for i < len(text) {
+		end, kind, matched := syntax.match(text, i)
+		if !matched {
+			i++
+			continue
+		}
+		emit(plainStart, i, tokenPlain)
+		emit(i, end, kind)
+		i = end
+		plainStart = i
+	}
+	emit(plainStart, len(text), tokenPlain)
+	return spans
+}
+
+// match returns the end and kind of the token starting at an offset, or false if plain text starts there.
+func (syntax *textSyntax) match(text []rune, i int) (int, tokenKind, bool) {
+	if len(syntax.blockComment) == 2 && hasRunePrefix(text[i:], syntax.blockComment[0]) {
+		return indexAfter(text, i+len(syntax.blockComment[0]), syntax.blockComment[1]), tokenComment, true
+	}
+	for _, lineComment := range syntax.lineComments {
+		if hasRunePrefix(text[i:], lineComment) {
+			end := i
+			for end < len(text) && text[end] != '\n' {
+				end++
+			}
+			return end, tokenComment, true
+		}
+	}
+	r := text[i]
+	if syntax.tripleQuotes && strings.ContainsRune(syntax.quotes, r) && hasRunePrefix(text[i:], strings.Repeat(string(r), 3)) {
+		return indexAfter(text, i+3, strings.Repeat(string(r), 3)), tokenString, true
+	}
+	if syntax.rawQuote != 0 && r == syntax.rawQuote {
+		return indexAfter(text, i+1, string(r)), tokenString, true
+	}
+	if strings.ContainsRune(syntax.quotes, r) {
+		end := quotedEnd(text, i)
+		if syntax.keys && isFollowedBy(text, end, ':') {
+			return end, tokenKey, true
+		}
+		return end, tokenString, true
+	}
+	previousIsIdentifier := i > 0 && isIdentifierRune(text[i-1])
+	if unicode.IsDigit(r) && !previousIsIdentifier {
+		end := i
+		for end < len(text) && (isIdentifierRune(text[end]) || text[end] == '.') {
+			end++
+		}
+		return end, tokenNumber, true
+	}
+	if (unicode.IsLetter(r) || r == '_') && !previousIsIdentifier {
+		end := i
+		for end < len(text) && isIdentifierRune(text[end]) {
+			end++
+		}
+		if syntax.keywords[string(text[i:end])] {
+			return end, tokenKeyword, true
+		}
+		return end, tokenPlain, true
+	}
+	return 0, tokenPlain, false
+}
+
+// highlightMarkdown highlights headings, block quotes, list markers and code blocks.
+func highlightMarkdown(text string) [][]textSpan {
+	lines := make([][]textSpan, 0, 0)
+	inCode := false
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			inCode = !inCode
+			lines = append(lines, []textSpan{textSpan{line, tokenComment}})
+		case inCode:
+			lines = append(lines, []textSpan{textSpan{line, tokenString}})
+		case strings.HasPrefix(trimmed, "#"):
+			lines = append(lines, []textSpan{textSpan{line, tokenHeading}})
+		case strings.HasPrefix(trimmed, ">"):
+			lines = append(lines, []textSpan{textSpan{line, tokenComment}})
+		case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "), strings.HasPrefix(trimmed, "+ "):
+			marker := len(line) - len(trimmed) + 1
+			lines = append(lines, []textSpan{textSpan{line[:marker], tokenKeyword}, textSpan{line[marker:], tokenPlain}})
+		default:
+			lines = append(lines, []textSpan{textSpan{line, tokenPlain}})
+		}
+	}
+	return lines
+}
+
+// highlightCsv gives each column of a CSV file its own colour, with the first row highlighted as a header.
+func highlightCsv(text string) [][]textSpan {
+	lines := make([][]textSpan, 0, 0)
+	for row, line := range strings.Split(text, "\n") {
+		if row == 0 {
+			lines = append(lines, []textSpan{textSpan{line, tokenHeading}})
+			continue
+		}
+		spans := make([]textSpan, 0, 0)
+		column, start, quoted := 0, 0, false
+		for i, r := range line {
+			switch {
+			case r == '"':
+				quoted = !quoted
+			case r == ',' && !quoted:
+				spans = append(spans, textSpan{line[start:i], csvColumnKinds[column%len(csvColumnKinds)]}, textSpan{",", tokenComment})
+				column++
+				start = i + 1
+			}
+		}
+		spans = append(spans, textSpan{line[start:], csvColumnKinds[column%len(csvColumnKinds)]})
+		lines = append(lines, spans)
+	}
+	return lines
+}
+
+// splitSpans splits spans that contain newlines into lines of spans.
+func splitSpans(spans []textSpan) [][]textSpan {
+	lines := [][]textSpan{[]textSpan{}}
+	for _, span := range spans {
+		for i, part := range strings.Split(span.text, "\n") {
+			if i > 0 {
+				lines = append(lines, []textSpan{})
+			}
+			if part != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], textSpan{part, span.kind})
+			}
+		}
+	}
+	return lines
+}
+
+func hasRunePrefix(text []rune, prefix string) bool {
+	prefixRunes := []rune(prefix)
+	if len(text) < len(prefixRunes) {
+		return false
+	}
+	for i, r := range prefixRunes {
+		if text[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+// indexAfter returns the offset following the first occurrence of a delimiter at or after an offset, or the length of the text if it doesn't occur.
+func indexAfter(text []rune, start int, delimiter string) int {
+	for i := start; i < len(text); i++ {
+		if hasRunePrefix(text[i:], delimiter) {
+			return i + len([]rune(delimiter))
+		}
+	}
+	return len(text)
+}
+
+// quotedEnd returns the offset following a quoted string that starts at an offset. Unterminated strings end at the end of the line.
+func quotedEnd(text []rune, start int) int {
+	quote := text[start]
+	for i := start + 1; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '\n':
+			return i
+		case quote:
+			return i + 1
+		}
+	}
+	return len(text)
+}
+
+func isFollowedBy(text []rune, start int, r rune) bool {
+	for i := start; i < len(text); i++ {
+		if text[i] == r {
+			return true
+		}
+		if !unicode.IsSpace(text[i]) || text[i] == '\n' {
+			return false
+		}
+	}
+	return false
+}
+
+func isIdentifierRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
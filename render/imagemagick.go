@@ -1,31 +1,21 @@
 package render
 
 import (
-	"bytes"
 	"fmt"
 	"github.com/ngerakines/preview/common"
 	"github.com/ngerakines/preview/util"
 	"github.com/rcrowley/go-metrics"
 	"image"
-	"image/jpeg"
+	"image/color"
 	"log"
 	"os"
-	"os/exec"
 	"strconv"
-	"time"
 )
 
 type imageMagickRenderAgent struct {
-	metrics              *imageMagickRenderAgentMetrics
-	sasm                 common.SourceAssetStorageManager
-	gasm                 common.GeneratedAssetStorageManager
-	templateManager      common.TemplateManager
-	downloader           common.Downloader
-	uploader             common.Uploader
-	workChannel          RenderAgentWorkChannel
-	statusListeners      []RenderStatusChannel
-	temporaryFileManager common.TemporaryFileManager
-	stop                 chan (chan bool)
+	*baseRenderAgent
+	metrics *imageMagickRenderAgentMetrics
+	limits  CommandLimits
 }
 
 type imageMagickRenderAgentMetrics struct {
@@ -38,28 +28,16 @@ type imageMagickRenderAgentMetrics struct {
 }
 
 func newImageMagickRenderAgent(
+	base *baseRenderAgent,
 	metrics *imageMagickRenderAgentMetrics,
-	sasm common.SourceAssetStorageManager,
-	gasm common.GeneratedAssetStorageManager,
-	templateManager common.TemplateManager,
-	temporaryFileManager common.TemporaryFileManager,
-	downloader common.Downloader,
-	uploader common.Uploader,
-	workChannel RenderAgentWorkChannel) RenderAgent {
+	limits CommandLimits) RenderAgent {
 
 	renderAgent := new(imageMagickRenderAgent)
+	renderAgent.baseRenderAgent = base
 	renderAgent.metrics = metrics
-	renderAgent.sasm = sasm
-	renderAgent.gasm = gasm
-	renderAgent.templateManager = templateManager
-	renderAgent.temporaryFileManager = temporaryFileManager
-	renderAgent.downloader = downloader
-	renderAgent.uploader = uploader
-	renderAgent.workChannel = workChannel
-	renderAgent.statusListeners = make([]RenderStatusChannel, 0, 0)
-	renderAgent.stop = make(chan (chan bool))
+	renderAgent.limits = limits
 
-	go renderAgent.start()
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
 
 	return renderAgent
 }
@@ -84,70 +62,7 @@ func newImageMagickRenderAgentMetrics(registry metrics.Registry) *imageMagickRen
 	return imageMagickMetrics
 }
 
-func (renderAgent *imageMagickRenderAgent) start() {
-	for {
-		select {
-		case ch, ok := <-renderAgent.stop:
-			{
-				log.Println("Stopping")
-				if !ok {
-					return
-				}
-				ch <- true
-				return
-			}
-		case id, ok := <-renderAgent.workChannel:
-			{
-				if !ok {
-					return
-				}
-				log.Println("Received dispatch message", id)
-				renderAgent.renderGeneratedAsset(id)
-			}
-		}
-	}
-}
-
-func (renderAgent *imageMagickRenderAgent) Stop() {
-	callback := make(chan bool)
-	renderAgent.stop <- callback
-	select {
-	case <-callback:
-	case <-time.After(5 * time.Second):
-	}
-	close(renderAgent.stop)
-}
-
-func (renderAgent *imageMagickRenderAgent) AddStatusListener(listener RenderStatusChannel) {
-	renderAgent.statusListeners = append(renderAgent.statusListeners, listener)
-}
-
-func (renderAgent *imageMagickRenderAgent) Dispatch() RenderAgentWorkChannel {
-	return renderAgent.workChannel
-}
-
-func (renderAgent *imageMagickRenderAgent) renderGeneratedAsset(id string) {
-
-	renderAgent.metrics.workProcessed.Mark(1)
-
-	generatedAsset, err := renderAgent.gasm.FindById(id)
-	if err != nil {
-		log.Fatal("No Generated Asset with that ID can be retreived from storage: ", id)
-		return
-	}
-
-	statusCallback := renderAgent.commitStatus(generatedAsset.Id, generatedAsset.Attributes)
-	defer func() { close(statusCallback) }()
-
-	generatedAsset.Status = common.GeneratedAssetStatusProcessing
-	renderAgent.gasm.Update(generatedAsset)
-
-	sourceAsset, err := renderAgent.getSourceAsset(generatedAsset)
-	if err != nil {
-		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnableToFindSourceAssetsById), nil}
-		return
-	}
-
+func (renderAgent *imageMagickRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
 	fileType, err := renderAgent.getSourceAssetFileType(sourceAsset)
 	if err != nil {
 		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileType), nil}
@@ -167,17 +82,6 @@ func (renderAgent *imageMagickRenderAgent) renderGeneratedAsset(id string) {
 		renderAgent.metrics.pdfCount.Inc(1)
 	}
 
-	templates, err := renderAgent.templateManager.FindByIds([]string{generatedAsset.TemplateId})
-	if err != nil {
-		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnableToFindTemplatesById), nil}
-		return
-	}
-	if len(templates) == 0 {
-		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoTemplatesFoundForId), nil}
-		return
-	}
-	template := templates[0]
-
 	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
 	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
 	if err != nil {
@@ -186,37 +90,65 @@ func (renderAgent *imageMagickRenderAgent) renderGeneratedAsset(id string) {
 	}
 	defer sourceFile.Release()
 
-	destination := sourceFile.Path() + "-" + template.Id + ".jpg"
-	destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
-	defer destinationTemporaryFile.Release()
-
-	size, err := renderAgent.getSize(template)
+	width, height, err := renderAgent.getSize(template)
 	if err != nil {
 		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
 		return
 	}
+	fit, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(imageMagickOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+	quality := templateQuality(template)
+	progressive := templateProgressive(template)
+
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
 
 	renderAgent.metrics.convertTime.Time(func() {
-		if fileType == "pdf" {
-			page, _ := renderAgent.getGeneratedAssetPage(generatedAsset)
-			err = renderAgent.imageFromPdf(sourceFile.Path(), destination, size, page)
-		} else if fileType == "gif" {
-			err = renderAgent.firstGifFrame(sourceFile.Path(), destination, size)
-		} else {
-			err = renderAgent.resize(sourceFile.Path(), destination, size)
+		for _, output := range outputs {
+			// NKG: ImageMagick uses the extension of the destination to
+			// determine the format that is written.
+			resizeArgs := append(imageMagickFitArgs(width, height, fit, background), imageMagickOutputArgs(output, quality, progressive)...)
+			if fileType == "pdf" {
+				page, _ := renderAgent.getGeneratedAssetPage(generatedAsset)
+				err = renderAgent.imageFromPdf(sourceFile.Path(), destinations[output], resizeArgs, page)
+			} else if fileType == "gif" {
+				err = renderAgent.firstGifFrame(sourceFile.Path(), destinations[output], resizeArgs)
+			} else {
+				err = renderAgent.resize(sourceFile.Path(), destinations[output], resizeArgs)
+			}
+			if err != nil {
+				return
+			}
 		}
-		if err != nil {
-			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+	})
+	if err != nil {
+		if err == common.ErrorRenderTimedOut {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
 			return
 		}
-	})
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
 
-	err = renderAgent.uploader.Upload(generatedAsset.Location, destination)
-	if err != nil {
-		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset), nil}
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
 		return
 	}
 
+	destination := destinations[outputs[0]]
 	bounds, err := renderAgent.getBounds(destination)
 	if err != nil {
 		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
@@ -230,38 +162,17 @@ func (renderAgent *imageMagickRenderAgent) renderGeneratedAsset(id string) {
 	}
 
 	newAttributes := []common.Attribute{
-		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(bounds.Max.X)}),
-		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(bounds.Max.Y)}),
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(bounds.Dy())}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(bounds.Dx())}),
 		// NKG: I'm sure this is going to break something.
 		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
 	}
 
 	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
 }
 
-func (renderAgent *imageMagickRenderAgent) getSourceAsset(generatedAsset *common.GeneratedAsset) (*common.SourceAsset, error) {
-	sourceAssets, err := renderAgent.sasm.FindBySourceAssetId(generatedAsset.SourceAssetId)
-	if err != nil {
-		return nil, err
-	}
-	for _, sourceAsset := range sourceAssets {
-		if sourceAsset.IdType == generatedAsset.SourceAssetType {
-			return sourceAsset, nil
-		}
-	}
-	return nil, common.ErrorNoSourceAssetsFoundForId
-}
-
-func (renderAgent *imageMagickRenderAgent) tryDownload(urls []string, source string) (common.TemporaryFile, error) {
-	for _, url := range urls {
-		tempFile, err := renderAgent.downloader.Download(url, source)
-		if err == nil {
-			return tempFile, nil
-		}
-	}
-	return nil, common.ErrorNoDownloadUrlsWork
-}
-
+// getBounds returns the bounds of a rendered image. Images that can't be decoded by the image decoders registered in this package, like AVIF images, are measured with identify.
 func (renderAgent *imageMagickRenderAgent) getBounds(path string) (*image.Rectangle, error) {
 	reader, err := os.Open(path)
 	if err != nil {
@@ -269,94 +180,66 @@ func (renderAgent *imageMagickRenderAgent) getBounds(path string) (*image.Rectan
 		return nil, err
 	}
 	defer reader.Close()
-	image, err := jpeg.Decode(reader)
-	if err != nil {
-		log.Println("jpeg.Decode error", err)
-		return nil, err
+	config, _, err := image.DecodeConfig(reader)
+	if err == nil {
+		bounds := image.Rect(0, 0, config.Width, config.Height)
+		return &bounds, nil
 	}
-	bounds := image.Bounds()
-	return &bounds, nil
-}
 
-func (renderAgent *imageMagickRenderAgent) resize(source, destination string, size int) error {
-	_, err := exec.LookPath("convert")
+	output, err := runCommand(renderAgent.limits, "identify", "-format", "%w %h", path+"[0]")
 	if err != nil {
-		log.Println("convert command not found")
-		return err
+		log.Println("identify error", err)
+		return nil, err
 	}
-
-	cmd := exec.Command("convert", source, "-resize", strconv.Itoa(size), destination)
-	log.Println(cmd)
-
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-
-	err = cmd.Run()
+	var width, height int
+	_, err = fmt.Sscanf(string(output), "%d %d", &width, &height)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Println(buf.String())
-
-	return nil
+	bounds := image.Rect(0, 0, width, height)
+	return &bounds, nil
 }
 
-func (renderAgent *imageMagickRenderAgent) imageFromPdf(source, destination string, size, page int) error {
-	_, err := exec.LookPath("convert")
-	if err != nil {
-		log.Println("convert command not found")
-		return err
-	}
-
-	cmd := exec.Command("convert", "-colorspace", "RGB", fmt.Sprintf("%s[%d]", source, page), "-resize", strconv.Itoa(size), "+adjoin", destination)
-	log.Println(cmd)
-
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-
-	err = cmd.Run()
-	if err != nil {
-		return err
-	}
-	log.Println(buf.String())
-
-	return nil
+func (renderAgent *imageMagickRenderAgent) resize(source, destination string, resizeArgs []string) error {
+	args := append([]string{source}, resizeArgs...)
+	output, err := runCommand(renderAgent.limits, "convert", append(args, destination)...)
+	log.Println(string(output))
+	return err
 }
 
-func (renderAgent *imageMagickRenderAgent) firstGifFrame(source, destination string, size int) error {
-	_, err := exec.LookPath("convert")
-	if err != nil {
-		log.Println("convert command not found")
-		return err
-	}
-
-	cmd := exec.Command("convert", fmt.Sprintf("%s[0]", source), "-resize", strconv.Itoa(size), destination)
-	log.Println(cmd)
-
-	var buf bytes.Buffer
-	cmd.Stdout = &buf
-	cmd.Stderr = &buf
-
-	err = cmd.Run()
-	if err != nil {
-		return err
-	}
-	log.Println(buf.String())
+func (renderAgent *imageMagickRenderAgent) imageFromPdf(source, destination string, resizeArgs []string, page int) error {
+	args := append([]string{"-colorspace", "RGB", fmt.Sprintf("%s[%d]", source, page)}, resizeArgs...)
+	output, err := runCommand(renderAgent.limits, "convert", append(args, "+adjoin", destination)...)
+	log.Println(string(output))
+	return err
+}
 
-	return nil
+func (renderAgent *imageMagickRenderAgent) firstGifFrame(source, destination string, resizeArgs []string) error {
+	args := append([]string{fmt.Sprintf("%s[0]", source)}, resizeArgs...)
+	output, err := runCommand(renderAgent.limits, "convert", append(args, destination)...)
+	log.Println(string(output))
+	return err
 }
 
-func (renderAgent *imageMagickRenderAgent) getSize(template *common.Template) (int, error) {
-	rawSize, err := common.GetFirstAttribute(template, common.TemplateAttributeHeight)
-	if err == nil {
-		sizeValue, err := strconv.Atoi(rawSize)
-		if err == nil {
-			return sizeValue, nil
+// imageMagickFitArgs returns the convert arguments that scale an image to the given width and height using a fit mode, matching fitImage. Without a background colour, padded images are given a white background.
+func imageMagickFitArgs(width, height int, fit string, background color.Color) []string {
+	geometry := fmt.Sprintf("%dx%d", width, height)
+	args := []string{}
+	if background != nil {
+		args = append(args, "-background", backgroundHex(background), "-flatten")
+	}
+	switch fit {
+	case common.FitModeCover:
+		return append(args, "-resize", geometry+"^", "-gravity", "center", "-extent", geometry)
+	case common.FitModeFill:
+		return append(args, "-resize", geometry+"!")
+	case common.FitModePad:
+		if background == nil {
+			args = append(args, "-background", "white")
 		}
-		return 0, err
+		return append(args, "-resize", geometry+">", "-gravity", "center", "-extent", geometry)
 	}
-	return 0, err
+	return append(args, "-resize", geometry+">")
 }
 
 func (renderAgent *imageMagickRenderAgent) getGeneratedAssetPage(generatedAsset *common.GeneratedAsset) (int, error) {
@@ -378,48 +261,3 @@ func (renderAgent *imageMagickRenderAgent) getSourceAssetFileType(sourceAsset *c
 	}
 	return "unknown", err
 }
-
-type generatedAssetUpdate struct {
-	status     string
-	attributes []common.Attribute
-}
-
-func (renderAgent *imageMagickRenderAgent) commitStatus(id string, existingAttributes []common.Attribute) chan generatedAssetUpdate {
-	commitChannel := make(chan generatedAssetUpdate, 10)
-
-	go func() {
-		status := common.NewGeneratedAssetError(common.ErrorUnknownError)
-		attributes := make([]common.Attribute, 0, 0)
-		for _, attribute := range existingAttributes {
-			attributes = append(attributes, attribute)
-		}
-		for {
-			select {
-			case message, ok := <-commitChannel:
-				{
-					if !ok {
-						for _, listener := range renderAgent.statusListeners {
-							listener <- RenderStatus{id, status, common.RenderAgentImageMagick}
-						}
-						generatedAsset, err := renderAgent.gasm.FindById(id)
-						if err != nil {
-							log.Fatal(err.Error())
-							return
-						}
-						generatedAsset.Status = status
-						generatedAsset.Attributes = attributes
-						renderAgent.gasm.Update(generatedAsset)
-						return
-					}
-					status = message.status
-					if message.attributes != nil {
-						for _, attribute := range message.attributes {
-							attributes = append(attributes, attribute)
-						}
-					}
-				}
-			}
-		}
-	}()
-	return commitChannel
-}
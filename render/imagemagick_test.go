@@ -128,8 +128,8 @@ func setupTest(path string) (*RenderAgentManager, common.SourceAssetStorageManag
 	registry := metrics.NewRegistry()
 	rm := NewRenderAgentManager(registry, sourceAssetStorageManager, generatedAssetStorageManager, tm, tfm, uploader, true)
 
-	rm.AddImageMagickRenderAgent(downloader, uploader, 5)
-	rm.AddDocumentRenderAgent(downloader, uploader, filepath.Join(path, "doc-cache"), 5)
+	rm.AddImageMagickRenderAgent(downloader, uploader, CommandLimits{Timeout: time.Minute}, 5)
+	rm.AddDocumentRenderAgent(downloader, uploader, filepath.Join(path, "doc-cache"), map[string]int{}, CommandLimits{Timeout: time.Minute}, 5)
 
 	return rm, sourceAssetStorageManager, generatedAssetStorageManager, tm
 }
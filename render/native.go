@@ -0,0 +1,301 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// nativeRenderAgent renders images using the Go image packages instead of external commands.
+type nativeRenderAgent struct {
+	*baseRenderAgent
+	metrics        *nativeRenderAgentMetrics
+	maxImagePixels int
+}
+
+type nativeRenderAgentMetrics struct {
+	workProcessed metrics.Meter
+	convertTime   metrics.Timer
+	jpgCount      metrics.Counter
+	pngCount      metrics.Counter
+	gifCount      metrics.Counter
+	bmpCount      metrics.Counter
+	tiffCount     metrics.Counter
+	webpCount     metrics.Counter
+}
+
+func newNativeRenderAgent(
+	base *baseRenderAgent,
+	metrics *nativeRenderAgentMetrics,
+	maxImagePixels int) RenderAgent {
+
+	renderAgent := new(nativeRenderAgent)
+	renderAgent.baseRenderAgent = base
+	renderAgent.metrics = metrics
+	renderAgent.maxImagePixels = maxImagePixels
+
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
+
+	return renderAgent
+}
+
+func newNativeRenderAgentMetrics(registry metrics.Registry) *nativeRenderAgentMetrics {
+	nativeMetrics := new(nativeRenderAgentMetrics)
+	nativeMetrics.workProcessed = metrics.NewMeter()
+	nativeMetrics.convertTime = metrics.NewTimer()
+
+	nativeMetrics.jpgCount = metrics.NewCounter()
+	nativeMetrics.pngCount = metrics.NewCounter()
+	nativeMetrics.gifCount = metrics.NewCounter()
+	nativeMetrics.bmpCount = metrics.NewCounter()
+	nativeMetrics.tiffCount = metrics.NewCounter()
+	nativeMetrics.webpCount = metrics.NewCounter()
+
+	registry.Register("nativeRenderAgent.workProcessed", nativeMetrics.workProcessed)
+	registry.Register("nativeRenderAgent.convertTime", nativeMetrics.convertTime)
+	registry.Register("nativeRenderAgent.jpgCount", nativeMetrics.jpgCount)
+	registry.Register("nativeRenderAgent.pngCount", nativeMetrics.pngCount)
+	registry.Register("nativeRenderAgent.gifCount", nativeMetrics.gifCount)
+	registry.Register("nativeRenderAgent.bmpCount", nativeMetrics.bmpCount)
+	registry.Register("nativeRenderAgent.tiffCount", nativeMetrics.tiffCount)
+	registry.Register("nativeRenderAgent.webpCount", nativeMetrics.webpCount)
+
+	return nativeMetrics
+}
+
+func (renderAgent *nativeRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+	fileType, err := common.GetFirstAttribute(sourceAsset, common.SourceAssetAttributeType)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileType), nil}
+		return
+	}
+
+	switch fileType {
+	case "jpg", "jpeg":
+		renderAgent.metrics.jpgCount.Inc(1)
+	case "png":
+		renderAgent.metrics.pngCount.Inc(1)
+	case "gif":
+		renderAgent.metrics.gifCount.Inc(1)
+	case "bmp":
+		renderAgent.metrics.bmpCount.Inc(1)
+	case "tif", "tiff":
+		renderAgent.metrics.tiffCount.Inc(1)
+	case "webp":
+		renderAgent.metrics.webpCount.Inc(1)
+	}
+
+	width, height, err := renderAgent.getSize(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+	fit, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(nativeOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork), nil}
+		return
+	}
+	defer sourceFile.Release()
+
+	source, err := decodeImage(sourceFile.Path(), renderAgent.maxImagePixels)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDecodeImage), nil}
+		return
+	}
+
+	quality := templateQuality(template)
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
+
+	var bounds image.Rectangle
+	renderAgent.metrics.convertTime.Time(func() {
+		for _, output := range outputs {
+			destination := destinations[output]
+			// NKG: Each output format is fit separately because formats
+			// that don't support transparency are given a background.
+			resized := fitImage(source, width, height, fit, background, output)
+			bounds = resized.Bounds()
+			err = encodeImage(resized, destination, output, quality)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
+
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
+		return
+	}
+
+	destination := destinations[outputs[0]]
+	generatedAssetFileSize, err := util.FileSize(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileSize), nil}
+		return
+	}
+
+	newAttributes := []common.Attribute{
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(bounds.Dy())}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(bounds.Dx())}),
+		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
+	}
+
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
+}
+
+// decodeImage decodes the image at the given path. Only the first frame of animated images is decoded.
+func decodeImage(path string, maxPixels int) (image.Image, error) {
+	reader, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return decodeImageReader(reader, maxPixels)
+}
+
+// decodeImageReader decodes an image, returning an error without decoding it when its width multiplied by its height is more than maxPixels. The size of an image is read from its header, which can describe a much larger image than the file it is in.
+func decodeImageReader(reader io.ReadSeeker, maxPixels int) (image.Image, error) {
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width <= 0 || config.Height <= 0 || int64(config.Width)*int64(config.Height) > int64(maxPixels) {
+		return nil, common.ErrorCouldNotDecodeImage
+	}
+	if _, err := reader.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	source, _, err := image.Decode(reader)
+	if err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// resizeImage scales an image to fit in the given width and height, keeping its aspect ratio. Images that already fit are not enlarged. Formats that don't support transparency are given a white background.
+func resizeImage(source image.Image, width, height int, output string) image.Image {
+	return fitImage(source, width, height, common.FitModeContain, nil, output)
+}
+
+// fitImage scales an image to the given width and height using a fit mode. Contained and padded images that already fit are not enlarged, while covered, filled and padded images are always exactly the given size. Without a background colour, formats that don't support transparency are given a white background.
+func fitImage(source image.Image, width, height int, fit string, background color.Color, output string) image.Image {
+	bounds := source.Bounds()
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+	sourceRect := bounds
+	canvas := image.Rect(0, 0, width, height)
+	destinationRect := canvas
+
+	switch fit {
+	case common.FitModeCover:
+		{
+			// NKG: The largest part of the source with the aspect ratio of
+			// the box, centered, is scaled to the size of the box.
+			cropWidth, cropHeight := sourceWidth, sourceHeight
+			if sourceWidth*height > sourceHeight*width {
+				cropWidth = maxInt(1, sourceHeight*width/height)
+			} else {
+				cropHeight = maxInt(1, sourceWidth*height/width)
+			}
+			x := bounds.Min.X + (sourceWidth-cropWidth)/2
+			y := bounds.Min.Y + (sourceHeight-cropHeight)/2
+			sourceRect = image.Rect(x, y, x+cropWidth, y+cropHeight)
+		}
+	case common.FitModeFill:
+	case common.FitModePad:
+		{
+			targetWidth, targetHeight := containedSize(sourceWidth, sourceHeight, width, height)
+			x := (width - targetWidth) / 2
+			y := (height - targetHeight) / 2
+			destinationRect = image.Rect(x, y, x+targetWidth, y+targetHeight)
+		}
+	default:
+		{
+			targetWidth, targetHeight := containedSize(sourceWidth, sourceHeight, width, height)
+			canvas = image.Rect(0, 0, targetWidth, targetHeight)
+			destinationRect = canvas
+		}
+	}
+
+	destination := image.NewRGBA(canvas)
+	if background == nil && output != "png" && output != "gif" {
+		background = color.White
+	}
+	if background != nil {
+		draw.Draw(destination, canvas, image.NewUniform(background), image.Point{}, draw.Src)
+	}
+	draw.CatmullRom.Scale(destination, destinationRect, source, sourceRect, draw.Over, nil)
+	return destination
+}
+
+// containedSize returns the size of an image scaled to fit within the given width and height, keeping its aspect ratio. Images that already fit are not enlarged.
+func containedSize(sourceWidth, sourceHeight, width, height int) (int, int) {
+	if sourceWidth <= width && sourceHeight <= height {
+		return sourceWidth, sourceHeight
+	}
+	if sourceWidth*height > sourceHeight*width {
+		return width, maxInt(1, sourceHeight*width/sourceWidth)
+	}
+	return maxInt(1, sourceWidth*height/sourceHeight), height
+}
+
+// encodeImage writes an image to the given path using the given output format. JPEG images are written with a quality of 90 when the quality is 0.
+func encodeImage(source image.Image, path, output string, quality int) error {
+	writer, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	switch output {
+	case "png":
+		return png.Encode(writer, source)
+	case "gif":
+		return gif.Encode(writer, source, nil)
+	}
+	if quality == 0 {
+		quality = 90
+	}
+	return jpeg.Encode(writer, source, &jpeg.Options{Quality: quality})
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,235 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResizeImage(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	resized := resizeImage(source, 250, 188, "jpg")
+	if resized.Bounds().Dx() != 250 || resized.Bounds().Dy() != 125 {
+		t.Error("Unexpected resized bounds", resized.Bounds())
+	}
+
+	resized = resizeImage(source, 1040, 780, "jpg")
+	if resized.Bounds().Dx() != 800 || resized.Bounds().Dy() != 400 {
+		t.Error("Images should not be enlarged", resized.Bounds())
+	}
+
+	// NKG: Transparent pixels are given a white background for formats
+	// that don't support transparency.
+	r, g, b, _ := resizeImage(source, 250, 188, "jpg").At(0, 0).RGBA()
+	if r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Error("Expected a white background", r, g, b)
+	}
+	_, _, _, a := resizeImage(source, 250, 188, "png").At(0, 0).RGBA()
+	if a != 0 {
+		t.Error("Expected a transparent background", a)
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	var data bytes.Buffer
+	if err := png.Encode(&data, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dm.Path, "small.png")
+	ioutil.WriteFile(path, data.Bytes(), 0644)
+	decoded, err := decodeImage(path, 800)
+	if err != nil || decoded.Bounds().Dx() != 40 || decoded.Bounds().Dy() != 20 {
+		t.Error("Unexpected decoded image", err)
+	}
+	if _, err := decodeImage(path, 799); err != common.ErrorCouldNotDecodeImage {
+		t.Error("Expected an error decoding an image with too many pixels", err)
+	}
+
+	// NKG: The header of the image claims that it is 100000 by 100000
+	// pixels, which would need 40GB to decode.
+	crafted := data.Bytes()
+	binary.BigEndian.PutUint32(crafted[16:20], 100000)
+	binary.BigEndian.PutUint32(crafted[20:24], 100000)
+	binary.BigEndian.PutUint32(crafted[29:33], crc32.ChecksumIEEE(crafted[12:29]))
+	path = filepath.Join(dm.Path, "crafted.png")
+	ioutil.WriteFile(path, crafted, 0644)
+	if _, err := decodeImage(path, 50000000); err != common.ErrorCouldNotDecodeImage {
+		t.Error("Expected an error decoding an image with a crafted header", err)
+	}
+}
+
+func TestFitImage(t *testing.T) {
+	source := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	fitted := fitImage(source, 300, 300, common.FitModeCover, nil, "jpg")
+	if fitted.Bounds().Dx() != 300 || fitted.Bounds().Dy() != 300 {
+		t.Error("Unexpected covered bounds", fitted.Bounds())
+	}
+
+	fitted = fitImage(source, 1600, 100, common.FitModeFill, nil, "jpg")
+	if fitted.Bounds().Dx() != 1600 || fitted.Bounds().Dy() != 100 {
+		t.Error("Unexpected filled bounds", fitted.Bounds())
+	}
+
+	fitted = fitImage(source, 300, 300, common.FitModeContain, nil, "jpg")
+	if fitted.Bounds().Dx() != 300 || fitted.Bounds().Dy() != 150 {
+		t.Error("Unexpected contained bounds", fitted.Bounds())
+	}
+
+	fitted = fitImage(source, 300, 300, common.FitModePad, color.Black, "png")
+	if fitted.Bounds().Dx() != 300 || fitted.Bounds().Dy() != 300 {
+		t.Error("Unexpected padded bounds", fitted.Bounds())
+	}
+	r, g, b, a := fitted.At(0, 0).RGBA()
+	if r != 0 || g != 0 || b != 0 || a != 0xffff {
+		t.Error("Padded area expected to be the background colour", r, g, b, a)
+	}
+}
+
+func TestNativeRenderAgent(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "source.png")
+	source := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for x := 0; x < 1000; x++ {
+		for y := 0; y < 500; y++ {
+			source.Set(x, y, color.RGBA{uint8(x % 256), uint8(y % 256), 128, 255})
+		}
+	}
+	file, err := os.Create(sourcePath)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	png.Encode(file, source)
+	file.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, uploader, true)
+	defer rm.Stop()
+	rm.AddNativeRenderAgent(downloader, uploader, 50000000, 5)
+	rm.AddRoute(NewRendererRoute(common.RenderAgentNative, "png", 0))
+
+	rm.CreateWork("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", "file://"+sourcePath, "png", 1024, []common.Attribute{})
+
+	var generatedAssets []*common.GeneratedAsset
+	for i := 0; i < 100; i++ {
+		generatedAssets, err = gasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+		if err == nil && common.IsGeneratedAssetsFinal(generatedAssets) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(generatedAssets) != 4 || common.GeneratedAssetsState(generatedAssets) != common.GeneratedAssetsStateComplete {
+		t.Error("Generated assets were not rendered", generatedAssets)
+		return
+	}
+
+	reader, err := os.Open(filepath.Join(dm.Path, "assets", "4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.PlaceholderSizeSmall, "0"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer reader.Close()
+	rendered, err := jpeg.Decode(reader)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if rendered.Bounds().Dx() != 250 || rendered.Bounds().Dy() != 125 {
+		t.Error("Unexpected rendered bounds", rendered.Bounds())
+	}
+}
+
+func TestNativeRenderAgentOutputs(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "source.png")
+	file, err := os.Create(sourcePath)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	png.Encode(file, image.NewRGBA(image.Rect(0, 0, 400, 200)))
+	file.Close()
+
+	tm := common.NewTemplateManager()
+	template := &common.Template{Id: "F1D9A6E0-3C36-4E0B-9A51-7C4B1D2E8A90", Renderer: common.RenderAgentNative, Group: "7A1E", Attributes: []common.Attribute{}}
+	template.AddAttribute(common.TemplateAttributeWidth, []string{"100"})
+	template.AddAttribute(common.TemplateAttributeHeight, []string{"100"})
+	template.AddAttribute(common.TemplateAttributeOutput, []string{"png", "jpg"})
+	template.AddAttribute(common.TemplateAttributeQuality, []string{"70"})
+	template.AddAttribute(common.TemplateAttributePlaceholderSize, []string{common.PlaceholderSizeSmall})
+	tm.Store(template)
+
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, uploader, true)
+	defer rm.Stop()
+	rm.AddNativeRenderAgent(downloader, uploader, 50000000, 5)
+	rm.AddRoute(&Route{[]string{"png"}, []string{}, common.RenderAgentNative, []string{template.Id}, 0})
+
+	rm.CreateWork("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", "file://"+sourcePath, "png", 1024, []common.Attribute{})
+
+	var generatedAssets []*common.GeneratedAsset
+	for i := 0; i < 100; i++ {
+		generatedAssets, err = gasm.FindBySourceAssetId("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3")
+		if err == nil && common.IsGeneratedAssetsFinal(generatedAssets) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(generatedAssets) != 1 || generatedAssets[0].Status != common.GeneratedAssetStatusComplete {
+		t.Error("Generated asset was not rendered", generatedAssets)
+		return
+	}
+	outputs := generatedAssets[0].GetAttribute(common.GeneratedAssetAttributeOutputs)
+	if len(outputs) != 2 || outputs[0] != "png" || outputs[1] != "jpg" {
+		t.Error("Unexpected outputs", outputs)
+	}
+
+	path := filepath.Join(dm.Path, "assets", "4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.PlaceholderSizeSmall, "0")
+	for _, expected := range []struct {
+		path   string
+		format string
+	}{{path, "png"}, {path + ".jpg", "jpeg"}} {
+		reader, err := os.Open(expected.path)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		_, format, err := image.DecodeConfig(reader)
+		reader.Close()
+		if err != nil || format != expected.format {
+			t.Error("Unexpected format for", expected.path, format, err)
+		}
+	}
+}
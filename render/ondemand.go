@@ -0,0 +1,144 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"log"
+	"strings"
+	"time"
+)
+
+// RenderNow dispatches a waiting generated asset to a render agent ahead of work that has not yet been dispatched and waits up to the timeout for it to be rendered. Generated assets that are already scheduled or processing are waited on. The generated asset is returned with true if it is complete. Waiting relies on the status updates handled by the work dispatcher, so it always times out when the work dispatcher is disabled.
+func (agentManager *RenderAgentManager) RenderNow(generatedAssetId string, timeout time.Duration) (*common.GeneratedAsset, bool) {
+	// NKG: The waiter is added before the generated asset is looked up so
+	// that a status update sent in between isn't missed.
+	waiter := make(chan string, 1)
+	agentManager.addWaiter(generatedAssetId, waiter)
+	defer agentManager.removeWaiter(generatedAssetId, waiter)
+	deadline := time.After(timeout)
+
+	generatedAsset, err := agentManager.generatedAssetStorageManager.FindById(generatedAssetId)
+	if err != nil {
+		return nil, false
+	}
+	if generatedAsset.Status == common.GeneratedAssetStatusComplete {
+		return generatedAsset, true
+	}
+	if strings.HasPrefix(generatedAsset.Status, common.GeneratedAssetStatusFailed) {
+		return generatedAsset, false
+	}
+	if generatedAsset.Status == common.GeneratedAssetStatusWaiting {
+		if !agentManager.promote(generatedAsset, deadline) {
+			log.Println("Timed out dispatching generated asset", generatedAssetId)
+			return nil, false
+		}
+	}
+
+	select {
+	case status := <-waiter:
+		{
+			if status != common.GeneratedAssetStatusComplete {
+				return nil, false
+			}
+		}
+	case <-deadline:
+		{
+			log.Println("Timed out waiting for generated asset", generatedAssetId, "to be rendered")
+			return nil, false
+		}
+	}
+
+	generatedAsset, err = agentManager.generatedAssetStorageManager.FindById(generatedAssetId)
+	if err != nil {
+		return nil, false
+	}
+	return generatedAsset, generatedAsset.Status == common.GeneratedAssetStatusComplete
+}
+
+// promote schedules a waiting generated asset and dispatches it to a render agent, regardless of how much work the render agent already has. It returns false if the generated asset could not be dispatched before the deadline, in which case it is left waiting.
+func (agentManager *RenderAgentManager) promote(generatedAsset *common.GeneratedAsset, deadline <-chan time.Time) bool {
+	renderer := agentManager.renderer(generatedAsset)
+
+	agentManager.mu.Lock()
+	renderAgents, hasRenderAgents := agentManager.renderAgents[renderer]
+	if !hasRenderAgents || len(renderAgents) == 0 {
+		agentManager.mu.Unlock()
+		return true
+	}
+
+	// NKG: The work dispatcher may have scheduled the generated asset since
+	// it was looked up, so it is checked again while holding the lock.
+	current, err := agentManager.generatedAssetStorageManager.FindById(generatedAsset.Id)
+	if err != nil || current.Status != common.GeneratedAssetStatusWaiting {
+		agentManager.mu.Unlock()
+		return true
+	}
+	current.Status = common.GeneratedAssetStatusScheduled
+	err = agentManager.generatedAssetStorageManager.Update(current)
+	if err != nil {
+		agentManager.mu.Unlock()
+		log.Println("Error scheduling generated asset", current.Id, err)
+		return true
+	}
+	agentManager.activeWork[renderer] = uniqueListWith(agentManager.activeWork[renderer], current.Id)
+	priorityChannel := agentManager.priorityChannels[renderer]
+	workChannel := agentManager.workChannels[renderer]
+	agentManager.mu.Unlock()
+
+	// NKG: Render agents take work from the priority channel first. When
+	// it is full, the generated asset joins the rest of the dispatched work.
+	select {
+	case priorityChannel <- current.Id:
+		log.Println("Promoted generated asset", current.Id, "to", renderer)
+		return true
+	default:
+	}
+	select {
+	case workChannel <- current.Id:
+		log.Println("Dispatched generated asset", current.Id, "to", renderer)
+		return true
+	case <-deadline:
+	}
+
+	// NKG: Nothing was dispatched, so the generated asset is returned to
+	// the work dispatcher.
+	current.Status = common.GeneratedAssetStatusWaiting
+	if err := agentManager.generatedAssetStorageManager.Update(current); err != nil {
+		log.Println("Error returning generated asset", current.Id, "to waiting", err)
+	}
+	agentManager.removeActiveWork(current.Id)
+	return false
+}
+
+func (agentManager *RenderAgentManager) addWaiter(generatedAssetId string, waiter chan string) {
+	agentManager.mu.Lock()
+	defer agentManager.mu.Unlock()
+
+	agentManager.waiters[generatedAssetId] = append(agentManager.waiters[generatedAssetId], waiter)
+}
+
+func (agentManager *RenderAgentManager) removeWaiter(generatedAssetId string, waiter chan string) {
+	agentManager.mu.Lock()
+	defer agentManager.mu.Unlock()
+
+	waiters := make([]chan string, 0, 0)
+	for _, value := range agentManager.waiters[generatedAssetId] {
+		if value != waiter {
+			waiters = append(waiters, value)
+		}
+	}
+	if len(waiters) == 0 {
+		delete(agentManager.waiters, generatedAssetId)
+		return
+	}
+	agentManager.waiters[generatedAssetId] = waiters
+}
+
+// notifyWaiters sends the status of a generated asset to anything waiting for it to be rendered. The caller must hold the lock.
+func (agentManager *RenderAgentManager) notifyWaiters(renderStatus RenderStatus) {
+	for _, waiter := range agentManager.waiters[renderStatus.GeneratedAssetId] {
+		select {
+		case waiter <- renderStatus.Status:
+		default:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"testing"
+	"time"
+)
+
+type completingRenderAgent struct {
+	gasm      common.GeneratedAssetStorageManager
+	work      RenderAgentWorkChannel
+	priority  RenderAgentWorkChannel
+	listeners []RenderStatusChannel
+}
+
+func (renderAgent *completingRenderAgent) Stop() {
+}
+
+func (renderAgent *completingRenderAgent) AddStatusListener(listener RenderStatusChannel) {
+	renderAgent.listeners = append(renderAgent.listeners, listener)
+}
+
+func (renderAgent *completingRenderAgent) Dispatch() RenderAgentWorkChannel {
+	return renderAgent.work
+}
+
+func (renderAgent *completingRenderAgent) run() {
+	for {
+		var id string
+		var ok bool
+		select {
+		case id, ok = <-renderAgent.priority:
+		case id, ok = <-renderAgent.work:
+		}
+		if !ok {
+			return
+		}
+		generatedAsset, err := renderAgent.gasm.FindById(id)
+		if err != nil {
+			continue
+		}
+		generatedAsset.Status = common.GeneratedAssetStatusComplete
+		renderAgent.gasm.Update(generatedAsset)
+		for _, listener := range renderAgent.listeners {
+			listener <- RenderStatus{generatedAsset.Id, generatedAsset.SourceAssetId, generatedAsset.Status, common.RenderAgentImageMagick}
+		}
+	}
+}
+
+func TestRenderNow(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	renderAgent := &completingRenderAgent{gasm: gasm, work: rm.workChannels[common.RenderAgentImageMagick], priority: rm.priorityChannels[common.RenderAgentImageMagick]}
+	renderAgent.AddStatusListener(rm.workStatus)
+	rm.AddRenderAgent(common.RenderAgentImageMagick, renderAgent, 1)
+	go renderAgent.run()
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	gasm.Store(generatedAsset)
+
+	rendered, ok := rm.RenderNow(generatedAsset.Id, 5*time.Second)
+	if !ok || rendered.Status != common.GeneratedAssetStatusComplete {
+		t.Error("Generated asset expected to be rendered:", rendered)
+		return
+	}
+	if rm.isActiveWork(generatedAsset.Id) {
+		t.Error("Rendered generated asset expected to not be active work")
+	}
+
+	failed, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateMedium, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/medium/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	failed.Status = common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage)
+	gasm.Store(failed)
+
+	_, ok = rm.RenderNow(failed.Id, 5*time.Second)
+	if ok {
+		t.Error("Failed generated asset expected to not be rendered")
+	}
+}
+
+func TestRenderNowDispatchTimeout(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	// NKG: The render agent never takes work, so both of its channels fill up.
+	renderAgent := &completingRenderAgent{gasm: gasm, work: rm.workChannels[common.RenderAgentImageMagick], priority: rm.priorityChannels[common.RenderAgentImageMagick]}
+	rm.AddRenderAgent(common.RenderAgentImageMagick, renderAgent, 1)
+	for len(renderAgent.priority) < cap(renderAgent.priority) {
+		renderAgent.priority <- "busy"
+	}
+	for len(renderAgent.work) < cap(renderAgent.work) {
+		renderAgent.work <- "busy"
+	}
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	gasm.Store(generatedAsset)
+
+	started := time.Now()
+	if _, ok := rm.RenderNow(generatedAsset.Id, 100*time.Millisecond); ok {
+		t.Error("Generated asset expected to not be rendered")
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Error("Dispatching took longer than the timeout:", elapsed)
+	}
+
+	waiting, err := gasm.FindById(generatedAsset.Id)
+	if err != nil || waiting.Status != common.GeneratedAssetStatusWaiting {
+		t.Error("Generated asset expected to be waiting:", waiting, err)
+	}
+	if rm.isActiveWork(generatedAsset.Id) {
+		t.Error("Generated asset expected to not be active work")
+	}
+}
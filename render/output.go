@@ -0,0 +1,65 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"strconv"
+)
+
+var (
+	imageMagickOutputs = []string{"jpg", "jpeg", "png", "gif", "webp", "avif"}
+	nativeOutputs      = []string{"jpg", "jpeg", "png", "gif"}
+)
+
+// templateQuality returns the quality of lossy output formats of a template, or 0 if the template doesn't have a valid quality attribute.
+func templateQuality(template *common.Template) int {
+	rawQuality, err := common.GetFirstAttribute(template, common.TemplateAttributeQuality)
+	if err != nil {
+		return 0
+	}
+	quality, err := strconv.Atoi(rawQuality)
+	if err != nil || quality < 1 || quality > 100 {
+		return 0
+	}
+	return quality
+}
+
+// templateProgressive returns true if the template renders progressive images.
+func templateProgressive(template *common.Template) bool {
+	progressive, err := common.GetFirstAttribute(template, common.TemplateAttributeProgressive)
+	return err == nil && progressive == "true"
+}
+
+// supportsOutputs returns true if all of the output formats are in the list of supported output formats.
+func supportsOutputs(supported, outputs []string) bool {
+	for _, output := range outputs {
+		if !listContains(supported, output) {
+			return false
+		}
+	}
+	return true
+}
+
+// outputLocations returns the location each output format of a generated asset is uploaded to.
+func outputLocations(location string, outputs []string) map[string]string {
+	results := make(map[string]string)
+	for index, output := range outputs {
+		if index == 0 {
+			results[output] = location
+			continue
+		}
+		results[output] = common.OutputLocation(location, output)
+	}
+	return results
+}
+
+// imageMagickOutputArgs returns the convert arguments that set the quality and interlacing of an output format.
+func imageMagickOutputArgs(output string, quality int, progressive bool) []string {
+	args := []string{}
+	if quality > 0 {
+		args = append(args, "-quality", strconv.Itoa(quality))
+	}
+	if progressive && (output == "jpg" || output == "jpeg" || output == "png") {
+		args = append(args, "-interlace", "Plane")
+	}
+	return args
+}
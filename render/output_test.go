@@ -0,0 +1,40 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"reflect"
+	"testing"
+)
+
+func TestTemplateOutputSettings(t *testing.T) {
+	template := &common.Template{Renderer: common.RenderAgentImageMagick, Attributes: []common.Attribute{}}
+	if templateQuality(template) != 0 || templateProgressive(template) {
+		t.Error("Template without output settings expected to use defaults")
+	}
+	template.AddAttribute(common.TemplateAttributeQuality, []string{"75"})
+	template.AddAttribute(common.TemplateAttributeProgressive, []string{"true"})
+	if templateQuality(template) != 75 || !templateProgressive(template) {
+		t.Error("Unexpected output settings", templateQuality(template), templateProgressive(template))
+	}
+
+	invalid := &common.Template{Renderer: common.RenderAgentImageMagick, Attributes: []common.Attribute{}}
+	invalid.AddAttribute(common.TemplateAttributeQuality, []string{"101"})
+	if templateQuality(invalid) != 0 {
+		t.Error("Invalid quality expected to be ignored")
+	}
+
+	if !reflect.DeepEqual(imageMagickOutputArgs("jpg", 75, true), []string{"-quality", "75", "-interlace", "Plane"}) {
+		t.Error("Unexpected jpg args", imageMagickOutputArgs("jpg", 75, true))
+	}
+	if !reflect.DeepEqual(imageMagickOutputArgs("webp", 0, true), []string{}) {
+		t.Error("Unexpected webp args", imageMagickOutputArgs("webp", 0, true))
+	}
+
+	locations := outputLocations("local:///4C96/small/0", []string{"webp", "jpg"})
+	if locations["webp"] != "local:///4C96/small/0" || locations["jpg"] != "local:///4C96/small/0.jpg" {
+		t.Error("Unexpected output locations", locations)
+	}
+	if supportsOutputs(nativeOutputs, []string{"jpg", "webp"}) {
+		t.Error("Native render agent expected to not support webp")
+	}
+}
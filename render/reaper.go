@@ -0,0 +1,208 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxReaperActions = 100
+
+// ReaperAction records a single generated asset that was recovered by the reaper.
+type ReaperAction struct {
+	GeneratedAssetId string `json:"generatedAssetId"`
+	SourceAssetId    string `json:"sourceAssetId"`
+	PreviousStatus   string `json:"previousStatus"`
+	Status           string `json:"status"`
+	Owner            string `json:"owner"`
+	Attempts         int    `json:"attempts"`
+	Timestamp        int64  `json:"timestamp"`
+}
+
+type reaper struct {
+	agentManager *RenderAgentManager
+	nodeId       string
+	leaseTimeout time.Duration
+	maxAttempts  int
+	interval     time.Duration
+	actions      []ReaperAction
+
+	stop chan (chan bool)
+	mu   sync.Mutex
+}
+
+// EnableReaper starts a background process that periodically renews the lease of the generated assets this node is rendering and looks for generated assets that have been scheduled or processing for longer than the lease timeout. Recovered assets are moved back to the waiting state or failed once they have been recovered maxAttempts times.
+func (agentManager *RenderAgentManager) EnableReaper(nodeId string, leaseTimeout, interval time.Duration, maxAttempts int) {
+	r := new(reaper)
+	r.agentManager = agentManager
+	r.nodeId = nodeId
+	r.leaseTimeout = leaseTimeout
+	r.maxAttempts = maxAttempts
+	r.interval = interval
+	r.actions = make([]ReaperAction, 0, 0)
+	r.stop = make(chan (chan bool))
+	agentManager.reaper = r
+	go r.run()
+}
+
+// ReaperActions returns the most recent actions taken by the reaper, if enabled.
+func (agentManager *RenderAgentManager) ReaperActions() []ReaperAction {
+	if agentManager.reaper == nil {
+		return []ReaperAction{}
+	}
+	return agentManager.reaper.recentActions()
+}
+
+// Reap performs a single reaper pass and returns the actions taken.
+func (agentManager *RenderAgentManager) Reap() []ReaperAction {
+	if agentManager.reaper == nil {
+		return []ReaperAction{}
+	}
+	return agentManager.reaper.reap()
+}
+
+func (r *reaper) run() {
+	for {
+		select {
+		case ch, ok := <-r.stop:
+			{
+				if !ok {
+					return
+				}
+				ch <- true
+				return
+			}
+		case <-time.After(r.interval):
+			{
+				r.reap()
+			}
+		}
+	}
+}
+
+func (r *reaper) Stop() {
+	callback := make(chan bool)
+	r.stop <- callback
+	select {
+	case <-callback:
+	case <-time.After(5 * time.Second):
+	}
+	close(r.stop)
+}
+
+func (r *reaper) reap() []ReaperAction {
+	r.renewLeases()
+
+	generatedAssets, err := r.agentManager.generatedAssetStorageManager.FindActive()
+	if err != nil {
+		log.Println("Error finding active generated assets", err)
+		return []ReaperAction{}
+	}
+
+	r.agentManager.mu.Lock()
+
+	expiredBefore := time.Now().Add(-r.leaseTimeout).UnixNano()
+	actions := make([]ReaperAction, 0, 0)
+	statuses := make([]RenderStatus, 0, 0)
+	for _, generatedAsset := range generatedAssets {
+		if generatedAsset.Status != common.GeneratedAssetStatusScheduled && generatedAsset.Status != common.GeneratedAssetStatusProcessing {
+			continue
+		}
+		if generatedAsset.UpdatedAt > expiredBefore {
+			continue
+		}
+		// NKG: Work owned by this node that is still tracked as active is
+		// being rendered and is left alone, regardless of how long it has
+		// been running. Work owned by another node has its lease renewed
+		// by that node's reaper, so it is only recovered once that node
+		// has stopped renewing it. The in-memory storage manager doesn't
+		// record which node updated a generated asset, so work without an
+		// owner belongs to this node.
+		owner := generatedAsset.UpdatedBy
+		if (owner == "" || owner == r.nodeId) && r.agentManager.isActiveWork(generatedAsset.Id) {
+			continue
+		}
+
+		attempts := 0
+		if value, err := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributeAttempts); err == nil {
+			attempts, _ = strconv.Atoi(value)
+		}
+		attempts = attempts + 1
+
+		previousStatus := generatedAsset.Status
+		generatedAsset.SetAttribute(common.GeneratedAssetAttributeAttempts, []string{strconv.Itoa(attempts)})
+		if attempts >= r.maxAttempts {
+			generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorGeneratedAssetLeaseExpired)
+		} else {
+			generatedAsset.Status = common.GeneratedAssetStatusWaiting
+		}
+		err := r.agentManager.generatedAssetStorageManager.Update(generatedAsset)
+		if err != nil {
+			log.Println("Error updating reaped generated asset", generatedAsset.Id, err)
+			continue
+		}
+		log.Println("Reaped generated asset", generatedAsset.Id, "from", owner, previousStatus, "->", generatedAsset.Status)
+		if generatedAsset.Status != common.GeneratedAssetStatusWaiting {
+			statuses = append(statuses, RenderStatus{generatedAsset.Id, generatedAsset.SourceAssetId, generatedAsset.Status, r.agentManager.renderer(generatedAsset)})
+		}
+		actions = append(actions, ReaperAction{generatedAsset.Id, generatedAsset.SourceAssetId, previousStatus, generatedAsset.Status, owner, attempts, time.Now().UnixNano()})
+	}
+
+	r.agentManager.mu.Unlock()
+
+	// NKG: Listeners are notified after the lock is released because they
+	// may be waiting on it.
+	for _, status := range statuses {
+		r.agentManager.notifyListeners(status)
+	}
+
+	r.record(actions)
+	return actions
+}
+
+// renewLeases updates the generated assets that this node is rendering so that they aren't recovered by the reapers of other nodes.
+func (r *reaper) renewLeases() {
+	r.agentManager.mu.Lock()
+	ids := make([]string, 0, 0)
+	for _, activeWork := range r.agentManager.activeWork {
+		ids = append(ids, activeWork...)
+	}
+	r.agentManager.mu.Unlock()
+
+	for _, id := range ids {
+		generatedAsset, err := r.agentManager.generatedAssetStorageManager.FindById(id)
+		if err != nil {
+			continue
+		}
+		if generatedAsset.Status != common.GeneratedAssetStatusScheduled && generatedAsset.Status != common.GeneratedAssetStatusProcessing {
+			continue
+		}
+		// NKG: The status is set to itself so that a status committed by a
+		// render agent in the meantime isn't overwritten.
+		err = r.agentManager.generatedAssetStorageManager.UpdateStatus(id, generatedAsset.Status, generatedAsset.Status)
+		if err != nil {
+			log.Println("Error renewing the lease of generated asset", id, err)
+		}
+	}
+}
+
+func (r *reaper) record(actions []ReaperAction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.actions = append(r.actions, actions...)
+	if len(r.actions) > maxReaperActions {
+		r.actions = r.actions[len(r.actions)-maxReaperActions:]
+	}
+}
+
+func (r *reaper) recentActions() []ReaperAction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]ReaperAction, len(r.actions))
+	copy(results, r.actions)
+	return results
+}
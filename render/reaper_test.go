@@ -0,0 +1,162 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"testing"
+	"time"
+)
+
+func TestReaper(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	rm.EnableReaper("E876F147E331", time.Minute, time.Hour, 2)
+	defer rm.Stop()
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset.Status = common.GeneratedAssetStatusProcessing
+	gasm.Store(generatedAsset)
+
+	actions := rm.Reap()
+	if len(actions) != 0 {
+		t.Error("No actions expected for generated asset within its lease:", actions)
+		return
+	}
+
+	generatedAsset.UpdatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	actions = rm.Reap()
+	if len(actions) != 1 || actions[0].Status != common.GeneratedAssetStatusWaiting || actions[0].Attempts != 1 {
+		t.Error("Generated asset should have been moved back to waiting:", actions)
+		return
+	}
+
+	generatedAsset.Status = common.GeneratedAssetStatusScheduled
+	generatedAsset.UpdatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	actions = rm.Reap()
+	if len(actions) != 1 || actions[0].Status != common.NewGeneratedAssetError(common.ErrorGeneratedAssetLeaseExpired) {
+		t.Error("Generated asset should have been marked as failed:", actions)
+		return
+	}
+
+	if len(rm.ReaperActions()) != 2 {
+		t.Error("Two reaper actions expected:", rm.ReaperActions())
+	}
+}
+
+func TestReaperSkipsActiveWork(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	rm.EnableReaper("E876F147E331", time.Minute, time.Hour, 2)
+	defer rm.Stop()
+
+	sourceAsset, err := common.NewSourceAsset("7C1D3E85-2B6F-4A09-9E4D-5F8A0B2C6D13", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///7C1D3E85-2B6F-4A09-9E4D-5F8A0B2C6D13/small")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	// NKG: The in-memory storage manager never sets UpdatedBy, so long
+	// running work has to be recognized by the active work alone.
+	generatedAsset.Status = common.GeneratedAssetStatusProcessing
+	generatedAsset.UpdatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	gasm.Store(generatedAsset)
+	rm.activeWork[common.RenderAgentImageMagick] = []string{generatedAsset.Id}
+
+	actions := rm.Reap()
+	if len(actions) != 0 || generatedAsset.Status != common.GeneratedAssetStatusProcessing {
+		t.Error("No actions expected for generated asset that is being rendered:", actions)
+		return
+	}
+	if generatedAsset.UpdatedAt < time.Now().Add(-time.Minute).UnixNano() {
+		t.Error("Lease of generated asset that is being rendered was not renewed:", generatedAsset.UpdatedAt)
+		return
+	}
+
+	rm.activeWork[common.RenderAgentImageMagick] = []string{}
+	generatedAsset.UpdatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	actions = rm.Reap()
+	if len(actions) != 1 || actions[0].Status != common.GeneratedAssetStatusWaiting {
+		t.Error("Generated asset should have been moved back to waiting:", actions)
+	}
+}
+
+func TestReaperLeaseOwnership(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	rm.EnableReaper("E876F147E331", time.Minute, time.Hour, 1)
+	defer rm.Stop()
+
+	listener := make(RenderStatusChannel)
+	rm.AddListener(listener)
+
+	sourceAsset, err := common.NewSourceAsset("9E2A6C14-7B3F-4D58-A1C9-0F5E8B2D4A67", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///9E2A6C14-7B3F-4D58-A1C9-0F5E8B2D4A67/small")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	// NKG: Another node started rendering the generated asset and stopped
+	// renewing its lease.
+	generatedAsset.Status = common.GeneratedAssetStatusProcessing
+	generatedAsset.UpdatedBy = "5A0C3E9B7D21"
+	generatedAsset.UpdatedAt = time.Now().Add(-2 * time.Minute).UnixNano()
+	gasm.Store(generatedAsset)
+
+	// NKG: Listeners are notified after the reaper releases the lock of the
+	// render agent manager, so a listener that needs it doesn't block the
+	// reaper.
+	statuses := make(chan RenderStatus, 1)
+	go func() {
+		status := <-listener
+		rm.mu.Lock()
+		rm.mu.Unlock()
+		statuses <- status
+	}()
+
+	actions := rm.Reap()
+	if len(actions) != 1 || actions[0].Owner != "5A0C3E9B7D21" || actions[0].Status != common.NewGeneratedAssetError(common.ErrorGeneratedAssetLeaseExpired) {
+		t.Error("Generated asset of a node that stopped renewing its lease should have been recovered:", actions)
+		return
+	}
+	select {
+	case status := <-statuses:
+		if status.GeneratedAssetId != generatedAsset.Id {
+			t.Error("Unexpected status:", status)
+		}
+	case <-time.After(time.Second):
+		t.Error("Listener was not notified")
+	}
+}
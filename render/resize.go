@@ -0,0 +1,271 @@
+package render
+
+import (
+	"fmt"
+	"github.com/ngerakines/preview/common"
+	"image"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const maxResizeDimension = 4096
+
+var resizeSourceTypes = []string{"jpg", "jpeg", "png", "gif", "bmp", "tif", "tiff", "webp"}
+
+// Resizer creates images of arbitrary sizes from the rendered images or the source of a file. Resized images are uploaded and their locations recorded on the source asset, so repeat requests are served the uploaded image and resized images are deleted when the source asset is purged.
+type Resizer struct {
+	sourceAssetStorageManager    common.SourceAssetStorageManager
+	generatedAssetStorageManager common.GeneratedAssetStorageManager
+	templateManager              common.TemplateManager
+	temporaryFileManager         common.TemporaryFileManager
+	downloader                   common.Downloader
+	uploader                     common.Uploader
+	maxImagePixels               int
+	outputs                      []string
+	imageMagickLimits            CommandLimits
+
+	mu sync.Mutex
+}
+
+type resizeCandidate struct {
+	location string
+	width    int
+	height   int
+}
+
+// NewResizer creates a new resizer. Images with more than maxImagePixels pixels are not resized. Images are resized to the output formats of the native render agent until ImageMagick is enabled.
+func NewResizer(
+	sourceAssetStorageManager common.SourceAssetStorageManager,
+	generatedAssetStorageManager common.GeneratedAssetStorageManager,
+	templateManager common.TemplateManager,
+	temporaryFileManager common.TemporaryFileManager,
+	downloader common.Downloader,
+	uploader common.Uploader,
+	maxImagePixels int) *Resizer {
+
+	resizer := new(Resizer)
+	resizer.sourceAssetStorageManager = sourceAssetStorageManager
+	resizer.generatedAssetStorageManager = generatedAssetStorageManager
+	resizer.templateManager = templateManager
+	resizer.temporaryFileManager = temporaryFileManager
+	resizer.downloader = downloader
+	resizer.uploader = uploader
+	resizer.maxImagePixels = maxImagePixels
+	resizer.outputs = nativeOutputs
+	return resizer
+}
+
+// EnableImageMagick has images also resized to the output formats of the ImageMagick render agent, like "webp" and "avif". Those formats are converted from a resized PNG image by ImageMagick, run within the given limits.
+func (resizer *Resizer) EnableImageMagick(limits CommandLimits) {
+	resizer.outputs = imageMagickOutputs
+	resizer.imageMagickLimits = limits
+}
+
+// IsValidResize returns true if the width, height, fit mode and output format can be used to resize an image.
+func (resizer *Resizer) IsValidResize(width, height int, fit, output string) bool {
+	if width < 1 || width > maxResizeDimension || height < 1 || height > maxResizeDimension {
+		return false
+	}
+	return listContains(common.FitModes, fit) && listContains(resizer.outputs, output)
+}
+
+// Resize returns the location of an image of a page of the file resized to the given width and height using a fit mode and output format. The image is created and uploaded the first time it is requested.
+func (resizer *Resizer) Resize(sourceAssetId string, page int32, width, height int, fit, output string) (string, error) {
+	if !resizer.IsValidResize(width, height, fit, output) {
+		return "", common.ErrorInvalidResizeParameters
+	}
+
+	sourceAsset, err := resizer.originSourceAsset(sourceAssetId)
+	if err != nil {
+		return "", err
+	}
+	if common.IsSourceAssetExpired(sourceAsset, time.Now().UnixNano()) {
+		return "", common.ErrorSourceAssetExpired
+	}
+
+	location := resizer.uploader.Url(sourceAssetId, "", fmt.Sprintf("%dx%d-%s.%s", width, height, fit, output), page)
+	if listContains(sourceAsset.GetAttribute(common.SourceAssetAttributeDerivatives), location) {
+		return location, nil
+	}
+
+	url, err := resizer.input(sourceAsset, page, width, height)
+	if err != nil {
+		return "", err
+	}
+	sourceFile, err := resizer.downloader.Download(url, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		return "", common.ErrorNoDownloadUrlsWork
+	}
+	defer sourceFile.Release()
+
+	source, err := decodeImage(sourceFile.Path(), resizer.maxImagePixels)
+	if err != nil {
+		return "", common.ErrorCouldNotDecodeImage
+	}
+
+	destination := fmt.Sprintf("%s-%dx%d-%s.%s", sourceFile.Path(), width, height, fit, output)
+	destinationTemporaryFile := resizer.temporaryFileManager.Create(destination)
+	defer destinationTemporaryFile.Release()
+
+	err = resizer.encode(fitImage(source, width, height, fit, nil, resizer.encodedOutput(output)), destination, output)
+	if err != nil {
+		return "", common.ErrorCouldNotResizeImage
+	}
+	err = resizer.uploader.Upload(location, destination)
+	if err != nil {
+		return "", common.ErrorCouldNotUploadAsset
+	}
+
+	err = resizer.record(sourceAssetId, location)
+	if err != nil {
+		return "", err
+	}
+	log.Println("Resized", url, "to", location)
+	return location, nil
+}
+
+// encodedOutput returns the format that a resized image is encoded to before it is written in the output format.
+func (resizer *Resizer) encodedOutput(output string) string {
+	if listContains(nativeOutputs, output) {
+		return output
+	}
+	return "png"
+}
+
+// encode writes a resized image to the destination in the output format. Formats that can't be encoded natively are written as a PNG image first and converted by ImageMagick.
+func (resizer *Resizer) encode(resized image.Image, destination, output string) error {
+	encodedOutput := resizer.encodedOutput(output)
+	if encodedOutput == output {
+		return encodeImage(resized, destination, output, 0)
+	}
+
+	intermediate := destination + "." + encodedOutput
+	intermediateTemporaryFile := resizer.temporaryFileManager.Create(intermediate)
+	defer intermediateTemporaryFile.Release()
+
+	err := encodeImage(resized, intermediate, encodedOutput, 0)
+	if err != nil {
+		return err
+	}
+	commandOutput, err := runCommand(resizer.imageMagickLimits, "convert", intermediate, destination)
+	if err != nil {
+		log.Println("Error converting resized image", string(commandOutput), err)
+	}
+	return err
+}
+
+// input returns the url of the image that is resized. The smallest rendered image of the page that is at least as large as the requested size is preferred, followed by the source when it is an image, followed by the largest rendered image.
+func (resizer *Resizer) input(sourceAsset *common.SourceAsset, page int32, width, height int) (string, error) {
+	candidates, err := resizer.candidates(sourceAsset.Id, page)
+	if err != nil {
+		return "", err
+	}
+
+	var best, largest *resizeCandidate
+	for _, candidate := range candidates {
+		if candidate.width >= width && candidate.height >= height {
+			if best == nil || candidate.width*candidate.height < best.width*best.height {
+				best = candidate
+			}
+		}
+		if largest == nil || candidate.width*candidate.height > largest.width*largest.height {
+			largest = candidate
+		}
+	}
+	if best != nil {
+		return best.location, nil
+	}
+
+	fileType, _ := common.GetFirstAttribute(sourceAsset, common.SourceAssetAttributeType)
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	if page == 0 && len(urls) > 0 && listContains(resizeSourceTypes, fileType) {
+		return urls[0], nil
+	}
+
+	if largest != nil {
+		return largest.location, nil
+	}
+	return "", common.ErrorNoResizeSource
+}
+
+// candidates returns the complete rendered images of a page of the file along with the size of their templates.
+func (resizer *Resizer) candidates(sourceAssetId string, page int32) ([]*resizeCandidate, error) {
+	generatedAssets, err := resizer.generatedAssetStorageManager.FindBySourceAssetId(sourceAssetId)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*resizeCandidate, 0, 0)
+	for _, generatedAsset := range generatedAssets {
+		if generatedAsset.Status != common.GeneratedAssetStatusComplete {
+			continue
+		}
+		pageValue, err := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributePage)
+		if err != nil {
+			pageValue = "0"
+		}
+		if pageValue != strconv.Itoa(int(page)) {
+			continue
+		}
+		templates, err := resizer.templateManager.FindByIds([]string{generatedAsset.TemplateId})
+		if err != nil || len(templates) == 0 {
+			continue
+		}
+		output, err := common.GetFirstAttribute(templates[0], common.TemplateAttributeOutput)
+		if err != nil || !listContains(nativeOutputs, output) {
+			continue
+		}
+		// NKG: Storyboards are sprite sheets of many frames and can't be
+		// resized into a single image.
+		if _, isStoryboard := common.GetFirstAttribute(templates[0], common.TemplateAttributeFrames); isStoryboard == nil {
+			continue
+		}
+		templateWidth, templateHeight := templateSize(templates[0])
+		results = append(results, &resizeCandidate{generatedAsset.Location, templateWidth, templateHeight})
+	}
+	return results, nil
+}
+
+// record adds the location of a resized image to the derivatives attribute of the source asset.
+func (resizer *Resizer) record(sourceAssetId, location string) error {
+	resizer.mu.Lock()
+	defer resizer.mu.Unlock()
+
+	sourceAsset, err := resizer.originSourceAsset(sourceAssetId)
+	if err != nil {
+		return err
+	}
+	derivatives := sourceAsset.GetAttribute(common.SourceAssetAttributeDerivatives)
+	if listContains(derivatives, location) {
+		return nil
+	}
+	sourceAsset.SetAttribute(common.SourceAssetAttributeDerivatives, append(derivatives, location))
+	return resizer.sourceAssetStorageManager.Store(sourceAsset)
+}
+
+func (resizer *Resizer) originSourceAsset(sourceAssetId string) (*common.SourceAsset, error) {
+	sourceAssets, err := resizer.sourceAssetStorageManager.FindBySourceAssetId(sourceAssetId)
+	if err != nil {
+		return nil, err
+	}
+	for _, sourceAsset := range sourceAssets {
+		if sourceAsset.IdType == common.SourceAssetTypeOrigin {
+			return sourceAsset, nil
+		}
+	}
+	return nil, common.ErrorNoSourceAssetsFoundForId
+}
+
+// templateSize returns the width and height of the images rendered by a template. Templates without a width render square images.
+func templateSize(template *common.Template) (int, int) {
+	rawHeight, _ := common.GetFirstAttribute(template, common.TemplateAttributeHeight)
+	height, _ := strconv.Atoi(rawHeight)
+	rawWidth, err := common.GetFirstAttribute(template, common.TemplateAttributeWidth)
+	if err != nil {
+		return height, height
+	}
+	width, _ := strconv.Atoi(rawWidth)
+	return width, height
+}
@@ -0,0 +1,88 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResizer(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "source.png")
+	file, err := os.Create(sourcePath)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	png.Encode(file, image.NewRGBA(image.Rect(0, 0, 1000, 500)))
+	file.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+	resizer := NewResizer(sasm, gasm, tm, tfm, downloader, uploader, 50000000)
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sourceAsset.AddAttribute(common.SourceAssetAttributeSource, []string{"file://" + sourcePath})
+	sourceAsset.AddAttribute(common.SourceAssetAttributeType, []string{"png"})
+	sasm.Store(sourceAsset)
+
+	_, err = resizer.Resize(sourceAsset.Id, 0, 0, 100, common.FitModeCover, "jpg")
+	if err != common.ErrorInvalidResizeParameters {
+		t.Error("Invalid resize parameters error expected:", err)
+	}
+
+	// NKG: Formats that can't be encoded natively need ImageMagick.
+	if resizer.IsValidResize(200, 200, common.FitModeCover, "webp") {
+		t.Error("Resizing to webp expected to require ImageMagick")
+	}
+	resizer.EnableImageMagick(CommandLimits{})
+	if !resizer.IsValidResize(200, 200, common.FitModeCover, "webp") || !resizer.IsValidResize(200, 200, common.FitModeCover, "avif") {
+		t.Error("Resizing to webp and avif expected to be valid with ImageMagick")
+	}
+
+	location, err := resizer.Resize(sourceAsset.Id, 0, 200, 200, common.FitModeCover, "png")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	resizedFile, err := os.Open(filepath.Join(dm.Path, "assets", location[8:]))
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	resized, err := png.Decode(resizedFile)
+	resizedFile.Close()
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if resized.Bounds().Dx() != 200 || resized.Bounds().Dy() != 200 {
+		t.Error("Unexpected resized bounds", resized.Bounds())
+	}
+
+	// NKG: Once created, the resized image is served without the source.
+	os.Remove(sourcePath)
+	cached, err := resizer.Resize(sourceAsset.Id, 0, 200, 200, common.FitModeCover, "png")
+	if err != nil || cached != location {
+		t.Error("Resized image expected to be cached:", cached, err)
+	}
+	sourceAssets, _ := sasm.FindBySourceAssetId(sourceAsset.Id)
+	if len(sourceAssets) != 1 || len(sourceAssets[0].GetAttribute(common.SourceAssetAttributeDerivatives)) != 1 {
+		t.Error("One source asset with one derivative expected:", sourceAssets)
+	}
+}
@@ -0,0 +1,87 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"log"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy determines which failed generated assets are rendered again and when. Generated assets that fail with a retryable error are moved back to the waiting state with a not before time that doubles with each attempt. Once a generated asset has been attempted maxAttempts times, it is left failed and marked as a dead letter.
+type RetryPolicy struct {
+	maxAttempts     int
+	backoff         time.Duration
+	maxBackoff      time.Duration
+	retryableErrors []string
+}
+
+// NewRetryPolicy creates a new retry policy. The retryable errors are error codes, such as "PRVCOM6". When no retryable errors are given, common.RetryableErrors is used.
+func NewRetryPolicy(maxAttempts int, backoff, maxBackoff time.Duration, retryableErrors []string) *RetryPolicy {
+	retryPolicy := new(RetryPolicy)
+	retryPolicy.maxAttempts = maxAttempts
+	retryPolicy.backoff = backoff
+	retryPolicy.maxBackoff = maxBackoff
+	if len(retryableErrors) == 0 {
+		retryableErrors = make([]string, 0, 0)
+		for _, err := range common.RetryableErrors {
+			retryableErrors = append(retryableErrors, err.Error())
+		}
+	}
+	retryPolicy.retryableErrors = retryableErrors
+	return retryPolicy
+}
+
+// apply updates the status and attributes of a generated asset that has failed. Nothing is changed if the generated asset has not failed or if the policy is nil.
+func (retryPolicy *RetryPolicy) apply(generatedAsset *common.GeneratedAsset) {
+	if retryPolicy == nil {
+		return
+	}
+	codedError, hasCodedError := common.ParseGeneratedAssetError(generatedAsset.Status)
+	if !hasCodedError || !retryPolicy.isRetryable(codedError.Error()) {
+		return
+	}
+
+	attempts := 0
+	// NKG: Recoveries by the reaper are counted separately, so that work
+	// with expired leases doesn't use up the attempts for retryable errors.
+	if value, err := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributeRetryAttempts); err == nil {
+		attempts, _ = strconv.Atoi(value)
+	}
+	attempts = attempts + 1
+	generatedAsset.SetAttribute(common.GeneratedAssetAttributeRetryAttempts, []string{strconv.Itoa(attempts)})
+
+	if attempts >= retryPolicy.maxAttempts {
+		log.Println("Generated asset", generatedAsset.Id, "failed", attempts, "times and will not be retried:", generatedAsset.Status)
+		generatedAsset.SetAttribute(common.GeneratedAssetAttributeDeadLetter, []string{"true"})
+		return
+	}
+
+	notBefore := time.Now().Add(retryPolicy.delay(attempts))
+	log.Println("Generated asset", generatedAsset.Id, "failed with", codedError.Error(), "and will be retried after", notBefore)
+	generatedAsset.Status = common.GeneratedAssetStatusWaiting
+	generatedAsset.SetAttribute(common.GeneratedAssetAttributeNotBefore, []string{strconv.FormatInt(notBefore.UnixNano(), 10)})
+}
+
+// delay returns the amount of time to wait before the next attempt, doubling the backoff for each previous attempt.
+func (retryPolicy *RetryPolicy) delay(attempts int) time.Duration {
+	delay := retryPolicy.backoff
+	for i := 1; i < attempts; i++ {
+		delay = delay * 2
+		if delay >= retryPolicy.maxBackoff {
+			return retryPolicy.maxBackoff
+		}
+	}
+	if delay > retryPolicy.maxBackoff {
+		return retryPolicy.maxBackoff
+	}
+	return delay
+}
+
+func (retryPolicy *RetryPolicy) isRetryable(code string) bool {
+	for _, retryableError := range retryPolicy.retryableErrors {
+		if retryableError == code {
+			return true
+		}
+	}
+	return false
+}
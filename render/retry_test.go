@@ -0,0 +1,113 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	retryPolicy := NewRetryPolicy(3, time.Minute, time.Hour, nil)
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+
+	generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset)
+	retryPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.GeneratedAssetStatusWaiting {
+		t.Error("Retryable failure expected to be waiting:", generatedAsset.Status)
+		return
+	}
+	if common.IsGeneratedAssetReady(generatedAsset, time.Now().UnixNano()) {
+		t.Error("Generated asset expected to have a not before time in the future", generatedAsset.Attributes)
+	}
+	if !common.IsGeneratedAssetReady(generatedAsset, time.Now().Add(2*time.Minute).UnixNano()) {
+		t.Error("Generated asset expected to be ready after the backoff", generatedAsset.Attributes)
+	}
+
+	generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork)
+	retryPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.GeneratedAssetStatusWaiting {
+		t.Error("Retryable failure expected to be waiting:", generatedAsset.Status)
+		return
+	}
+
+	generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork)
+	retryPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork) {
+		t.Error("Generated asset expected to remain failed after max attempts:", generatedAsset.Status)
+	}
+	if attempts, _ := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributeRetryAttempts); attempts != strconv.Itoa(3) {
+		t.Error("Unexpected attempts:", attempts)
+	}
+	if deadLetter, _ := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributeDeadLetter); deadLetter != "true" {
+		t.Error("Generated asset expected to be a dead letter", generatedAsset.Attributes)
+	}
+}
+
+func TestRetryPolicyPermanentFailure(t *testing.T) {
+	retryPolicy := NewRetryPolicy(3, time.Minute, time.Hour, []string{common.ErrorCouldNotUploadAsset.Error()})
+
+	generatedAsset := new(common.GeneratedAsset)
+	generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork)
+	retryPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork) {
+		t.Error("Failure not configured as retryable expected to remain failed:", generatedAsset.Status)
+	}
+	if len(generatedAsset.Attributes) != 0 {
+		t.Error("No attributes expected for a permanent failure", generatedAsset.Attributes)
+	}
+
+	generatedAsset.Status = common.GeneratedAssetStatusComplete
+	retryPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.GeneratedAssetStatusComplete {
+		t.Error("Complete generated asset expected to be unchanged:", generatedAsset.Status)
+	}
+
+	var nilPolicy *RetryPolicy
+	generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset)
+	nilPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset) {
+		t.Error("Nil retry policy expected to leave generated asset unchanged:", generatedAsset.Status)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	retryPolicy := NewRetryPolicy(10, 30*time.Second, 5*time.Minute, nil)
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for index, value := range expected {
+		delay := retryPolicy.delay(index + 1)
+		if delay != value {
+			t.Error("Unexpected delay for attempt", index+1, delay, value)
+		}
+	}
+}
+
+func TestRetryPolicyIgnoresReaperAttempts(t *testing.T) {
+	retryPolicy := NewRetryPolicy(3, time.Minute, time.Hour, nil)
+
+	// NKG: Recoveries by the reaper are recorded in the attempts attribute
+	// and don't use up the attempts of the retry policy.
+	generatedAsset := new(common.GeneratedAsset)
+	generatedAsset.AddAttribute(common.GeneratedAssetAttributeAttempts, []string{"5"})
+	generatedAsset.Status = common.NewGeneratedAssetError(common.ErrorCouldNotUploadAsset)
+	retryPolicy.apply(generatedAsset)
+	if generatedAsset.Status != common.GeneratedAssetStatusWaiting {
+		t.Error("Retryable failure expected to be waiting:", generatedAsset.Status)
+	}
+	if attempts, _ := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributeRetryAttempts); attempts != "1" {
+		t.Error("Unexpected retry attempts:", attempts)
+	}
+	if attempts, _ := common.GetFirstAttribute(generatedAsset, common.GeneratedAssetAttributeAttempts); attempts != "5" {
+		t.Error("Unexpected attempts:", attempts)
+	}
+}
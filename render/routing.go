@@ -0,0 +1,88 @@
+package render
+
+import (
+	"mime"
+	"sync"
+)
+
+// Route describes which render agent and templates are used for a set of file types or MIME types.
+type Route struct {
+	FileTypes   []string
+	MimeTypes   []string
+	Renderer    string
+	TemplateIds []string
+	// MaxSize is the largest file, in bytes, that can be rendered. A value of 0 allows files of any size.
+	MaxSize int64
+}
+
+// RoutingTable is an ordered list of routes. The first route that matches a file type is used.
+type RoutingTable struct {
+	routes []*Route
+	mu     sync.Mutex
+}
+
+// NewRoutingTable creates an empty routing table.
+func NewRoutingTable() *RoutingTable {
+	routingTable := new(RoutingTable)
+	routingTable.routes = make([]*Route, 0, 0)
+	return routingTable
+}
+
+// NewRendererRoute creates a route for a single file type, including the MIME type associated with the file type extension if one is known.
+func NewRendererRoute(renderer, fileType string, maxSize int64) *Route {
+	mimeTypes := make([]string, 0, 0)
+	mimeType := mime.TypeByExtension("." + fileType)
+	if mimeType != "" {
+		mimeTypes = append(mimeTypes, mimeType)
+	}
+	return &Route{[]string{fileType}, mimeTypes, renderer, []string{}, maxSize}
+}
+
+// AddRoute appends a route to the routing table.
+func (routingTable *RoutingTable) AddRoute(route *Route) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	routingTable.routes = append(routingTable.routes, route)
+}
+
+// Routes returns all of the routes in the routing table.
+func (routingTable *RoutingTable) Routes() []*Route {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	results := make([]*Route, len(routingTable.routes))
+	copy(results, routingTable.routes)
+	return results
+}
+
+// Match returns the first route that has the given value as a file type or MIME type.
+func (routingTable *RoutingTable) Match(fileType string) (*Route, bool) {
+	routingTable.mu.Lock()
+	defer routingTable.mu.Unlock()
+	for _, route := range routingTable.routes {
+		if route.matches(fileType) {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+func (route *Route) matches(fileType string) bool {
+	if route.matchesFileType(fileType) {
+		return true
+	}
+	for _, mimeType := range route.MimeTypes {
+		if mimeType == fileType {
+			return true
+		}
+	}
+	return false
+}
+
+func (route *Route) matchesFileType(fileType string) bool {
+	for _, routeFileType := range route.FileTypes {
+		if routeFileType == fileType {
+			return true
+		}
+	}
+	return false
+}
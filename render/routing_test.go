@@ -0,0 +1,72 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"testing"
+)
+
+func TestRoutingTableMatch(t *testing.T) {
+	routingTable := NewRoutingTable()
+	routingTable.AddRoute(&Route{[]string{"docx"}, []string{}, common.RenderAgentDocument, []string{}, 0})
+	routingTable.AddRoute(NewRendererRoute(common.RenderAgentImageMagick, "png", 1024))
+
+	route, hasRoute := routingTable.Match("docx")
+	if !hasRoute || route.Renderer != common.RenderAgentDocument {
+		t.Errorf("Unexpected route returned: (%+v)", route)
+	}
+	route, hasRoute = routingTable.Match("image/png")
+	if !hasRoute || route.Renderer != common.RenderAgentImageMagick {
+		t.Errorf("Unexpected route returned: (%+v)", route)
+	}
+	_, hasRoute = routingTable.Match("exe")
+	if hasRoute {
+		t.Error("No route expected for exe")
+	}
+}
+
+func TestCreateWorkRouting(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	rm.AddRoute(NewRendererRoute(common.RenderAgentImageMagick, "jpg", 1024))
+	rm.AddRoute(NewRendererRoute(common.RenderAgentDocument, "ppt", 1024))
+
+	tests := []struct {
+		id       string
+		fileType string
+		size     int64
+		count    int
+		status   string
+	}{
+		{"6D7E8C7A-2A3B-4E0B-9B5C-1A1E5D0C9F01", "jpg", 512, len(common.LegacyDefaultTemplates), common.GeneratedAssetStatusWaiting},
+		{"6D7E8C7A-2A3B-4E0B-9B5C-1A1E5D0C9F02", "ppt", 512, 1, common.GeneratedAssetStatusWaiting},
+		{"6D7E8C7A-2A3B-4E0B-9B5C-1A1E5D0C9F03", "jpg", 2048, len(common.LegacyDefaultTemplates), common.NewGeneratedAssetError(common.ErrorFileTooLarge)},
+		{"6D7E8C7A-2A3B-4E0B-9B5C-1A1E5D0C9F04", "exe", 512, len(common.LegacyDefaultTemplates), common.NewGeneratedAssetError(common.ErrorNoRenderersSupportFileType)},
+	}
+
+	for _, test := range tests {
+		rm.CreateWork(test.id, "file:///tmp/"+test.id, test.fileType, test.size, []common.Attribute{})
+		generatedAssets, err := gasm.FindBySourceAssetId(test.id)
+		if err != nil {
+			t.Errorf("Unexpected error returned: %s", err)
+			return
+		}
+		if len(generatedAssets) != test.count {
+			t.Errorf("Expected %d generated assets for %s, got %d", test.count, test.fileType, len(generatedAssets))
+			continue
+		}
+		for _, generatedAsset := range generatedAssets {
+			if generatedAsset.Status != test.status {
+				t.Errorf("Expected status %s for %s, got %s", test.status, test.fileType, generatedAsset.Status)
+			}
+		}
+	}
+}
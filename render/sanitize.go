@@ -0,0 +1,185 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"github.com/ngerakines/preview/common"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// NKG: Scripts can't run in a rasterizer, but they are removed along
+	// with foreign objects, which embed HTML that may load other files.
+	svgRemovedElements = map[string]bool{
+		"script":        true,
+		"foreignobject": true,
+	}
+	svgReferenceAttributes = map[string]bool{
+		"href": true,
+		"src":  true,
+	}
+	svgUrlPattern    = regexp.MustCompile(`(?i)url\(\s*['"]?\s*([^'")\s]*)`)
+	svgImportPattern = regexp.MustCompile(`(?i)@import`)
+	svgLengthPattern = regexp.MustCompile(`^\s*([0-9]*\.?[0-9]+)\s*(px)?\s*$`)
+)
+
+// sanitizeSvg removes everything from an SVG image that could make a renderer read another file or fetch a url: document type declarations and the entities they define, processing instructions, scripts, foreign objects, references to anything other than a fragment of the image or a data url, and styles that import or reference other files. It returns the sanitized image along with its width and height.
+func sanitizeSvg(data []byte) ([]byte, float64, float64, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// NKG: Entities aren't expanded when the decoder isn't strict, so
+	// references to entities defined by a document type declaration are
+	// left as text.
+	decoder.Strict = false
+
+	var buffer bytes.Buffer
+	var width, height float64
+	depth := 0
+	skipDepth := 0
+	inStyle := false
+	hasRoot := false
+	// NKG: Comments and CDATA sections split the text of a style element
+	// into many tokens, so it is checked as a whole before it is written.
+	var style bytes.Buffer
+	flushStyle := func() {
+		if style.Len() > 0 && !hasExternalReference(style.String()) {
+			xml.EscapeText(&buffer, style.Bytes())
+		}
+		style.Reset()
+	}
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, common.ErrorInvalidSvg
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if skipDepth > 0 {
+				continue
+			}
+			name := strings.ToLower(t.Name.Local)
+			if !hasRoot {
+				if name != "svg" {
+					return nil, 0, 0, common.ErrorInvalidSvg
+				}
+				hasRoot = true
+				width, height = svgSize(t.Attr)
+			}
+			flushStyle()
+			if svgRemovedElements[name] {
+				skipDepth = depth
+				continue
+			}
+			inStyle = name == "style"
+			buffer.WriteString("<" + qualifiedName(t.Name))
+			for _, attr := range t.Attr {
+				if !isSafeSvgAttribute(attr) {
+					continue
+				}
+				buffer.WriteString(" " + qualifiedName(attr.Name) + "=\"")
+				xml.EscapeText(&buffer, []byte(attr.Value))
+				buffer.WriteString("\"")
+			}
+			buffer.WriteString(">")
+		case xml.EndElement:
+			depth--
+			if skipDepth > 0 {
+				if depth < skipDepth {
+					skipDepth = 0
+				}
+				continue
+			}
+			flushStyle()
+			inStyle = false
+			buffer.WriteString("</" + qualifiedName(t.Name) + ">")
+		case xml.CharData:
+			if skipDepth > 0 || !hasRoot {
+				continue
+			}
+			if inStyle {
+				style.Write(t)
+				continue
+			}
+			xml.EscapeText(&buffer, t)
+		}
+	}
+	if !hasRoot {
+		return nil, 0, 0, common.ErrorInvalidSvg
+	}
+	return buffer.Bytes(), width, height, nil
+}
+
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// isSafeSvgAttribute returns false for event handlers, base urls and attributes that reference other files.
+func isSafeSvgAttribute(attr xml.Attr) bool {
+	name := strings.ToLower(attr.Name.Local)
+	if strings.HasPrefix(name, "on") || (strings.ToLower(attr.Name.Space) == "xml" && name == "base") {
+		return false
+	}
+	if svgReferenceAttributes[name] && !isLocalReference(attr.Value) {
+		return false
+	}
+	return !hasExternalReference(attr.Value)
+}
+
+// hasExternalReference returns true if a style imports another file or has a url that isn't a fragment of the image or a data url.
+func hasExternalReference(value string) bool {
+	if svgImportPattern.MatchString(value) {
+		return true
+	}
+	for _, match := range svgUrlPattern.FindAllStringSubmatch(value, -1) {
+		if !isLocalReference(match[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLocalReference(value string) bool {
+	value = strings.TrimSpace(value)
+	return strings.HasPrefix(value, "#") || strings.HasPrefix(strings.ToLower(value), "data:")
+}
+
+// svgSize returns the width and height of an SVG image from the attributes of its root element, preferring the view box. A size of 0 is returned when it can't be determined.
+func svgSize(attrs []xml.Attr) (float64, float64) {
+	var width, height float64
+	for _, attr := range attrs {
+		switch attr.Name.Local {
+		case "viewBox":
+			fields := strings.Fields(strings.Replace(attr.Value, ",", " ", -1))
+			if len(fields) == 4 {
+				viewBoxWidth, widthErr := strconv.ParseFloat(fields[2], 64)
+				viewBoxHeight, heightErr := strconv.ParseFloat(fields[3], 64)
+				if widthErr == nil && heightErr == nil && viewBoxWidth > 0 && viewBoxHeight > 0 {
+					return viewBoxWidth, viewBoxHeight
+				}
+			}
+		case "width":
+			width = svgLength(attr.Value)
+		case "height":
+			height = svgLength(attr.Value)
+		}
+	}
+	return width, height
+}
+
+func svgLength(value string) float64 {
+	match := svgLengthPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0
+	}
+	length, _ := strconv.ParseFloat(match[1], 64)
+	return length
+}
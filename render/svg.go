@@ -0,0 +1,200 @@
+package render
+
+import (
+	"bytes"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/rcrowley/go-metrics"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"image"
+	"io/ioutil"
+	"log"
+	"math"
+	"strconv"
+)
+
+// maxSvgRasterSize is the largest width or height that SVG images are rasterized at before they are fit to a template.
+const maxSvgRasterSize = 4096
+
+// svgRenderAgent renders SVG images. Images are sanitized before they are rasterized, either in process or with rsvg-convert when a path to it is configured.
+type svgRenderAgent struct {
+	*baseRenderAgent
+	metrics         *svgRenderAgentMetrics
+	rsvgConvertPath string
+	limits          CommandLimits
+}
+
+type svgRenderAgentMetrics struct {
+	workProcessed metrics.Meter
+	convertTime   metrics.Timer
+}
+
+func newSvgRenderAgent(
+	base *baseRenderAgent,
+	metrics *svgRenderAgentMetrics,
+	rsvgConvertPath string,
+	limits CommandLimits) RenderAgent {
+
+	renderAgent := new(svgRenderAgent)
+	renderAgent.baseRenderAgent = base
+	renderAgent.metrics = metrics
+	renderAgent.rsvgConvertPath = rsvgConvertPath
+	renderAgent.limits = limits
+
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
+
+	return renderAgent
+}
+
+func newSvgRenderAgentMetrics(registry metrics.Registry) *svgRenderAgentMetrics {
+	svgMetrics := new(svgRenderAgentMetrics)
+	svgMetrics.workProcessed = metrics.NewMeter()
+	svgMetrics.convertTime = metrics.NewTimer()
+
+	registry.Register("svgRenderAgent.workProcessed", svgMetrics.workProcessed)
+	registry.Register("svgRenderAgent.convertTime", svgMetrics.convertTime)
+
+	return svgMetrics
+}
+
+func (renderAgent *svgRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+	width, height, err := renderAgent.getSize(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+	fit, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(nativeOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork), nil}
+		return
+	}
+	defer sourceFile.Release()
+
+	data, err := ioutil.ReadFile(sourceFile.Path())
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidSvg), nil}
+		return
+	}
+	sanitized, svgWidth, svgHeight, err := sanitizeSvg(data)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidSvg), nil}
+		return
+	}
+	rasterWidth, rasterHeight := svgRasterSize(svgWidth, svgHeight, width, height)
+
+	quality := templateQuality(template)
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
+
+	var bounds image.Rectangle
+	renderAgent.metrics.convertTime.Time(func() {
+		var raster image.Image
+		raster, err = renderAgent.rasterize(sanitized, sourceFile.Path()+"-"+template.Id, rasterWidth, rasterHeight)
+		if err != nil {
+			return
+		}
+		for _, output := range outputs {
+			resized := fitImage(raster, width, height, fit, background, output)
+			bounds = resized.Bounds()
+			err = encodeImage(resized, destinations[output], output, quality)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		if err == common.ErrorRenderTimedOut {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
+			return
+		}
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
+
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
+		return
+	}
+
+	destination := destinations[outputs[0]]
+	generatedAssetFileSize, err := util.FileSize(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileSize), nil}
+		return
+	}
+
+	newAttributes := []common.Attribute{
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(bounds.Dy())}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(bounds.Dx())}),
+		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
+	}
+
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
+}
+
+// rasterize draws a sanitized SVG image at the given size. When rsvg-convert is configured, the sanitized image is written next to the source file, at a path starting with the given prefix, and converted to a PNG image.
+func (renderAgent *svgRenderAgent) rasterize(sanitized []byte, prefix string, width, height int) (image.Image, error) {
+	if renderAgent.rsvgConvertPath == "" {
+		return rasterizeSvg(sanitized, width, height)
+	}
+
+	sanitizedPath := prefix + "-sanitized.svg"
+	sanitizedFile := renderAgent.temporaryFileManager.Create(sanitizedPath)
+	defer sanitizedFile.Release()
+	err := ioutil.WriteFile(sanitizedPath, sanitized, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	rasterPath := prefix + "-raster.png"
+	rasterFile := renderAgent.temporaryFileManager.Create(rasterPath)
+	defer rasterFile.Release()
+	output, err := runCommand(renderAgent.limits, renderAgent.rsvgConvertPath, "--width", strconv.Itoa(width), "--height", strconv.Itoa(height), "--format", "png", "--output", rasterPath, sanitizedPath)
+	log.Println(string(output))
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(rasterPath, width*height)
+}
+
+// rasterizeSvg draws a sanitized SVG image at the given size using a pure Go rasterizer.
+func rasterizeSvg(sanitized []byte, width, height int) (image.Image, error) {
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(sanitized), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+	icon.SetTarget(0, 0, float64(width), float64(height))
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, canvas, canvas.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1)
+	return canvas, nil
+}
+
+// svgRasterSize returns the size that an SVG image is rasterized at so that it covers the box of a template without being enlarged as a raster image. Images without a size are rasterized at the size of the box.
+func svgRasterSize(svgWidth, svgHeight float64, width, height int) (int, int) {
+	if svgWidth <= 0 || svgHeight <= 0 {
+		return width, height
+	}
+	scale := math.Max(float64(width)/svgWidth, float64(height)/svgHeight)
+	scale = math.Min(scale, maxSvgRasterSize/math.Max(svgWidth, svgHeight))
+	return maxInt(1, int(math.Ceil(svgWidth*scale))), maxInt(1, int(math.Ceil(svgHeight*scale)))
+}
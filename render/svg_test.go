@@ -0,0 +1,147 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSanitizeSvg(t *testing.T) {
+	source := `<?xml version="1.0"?>
+<!DOCTYPE svg [<!ENTITY xxe SYSTEM "file:///etc/passwd">]>
+<?xml-stylesheet href="http://example.com/style.css"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 48 24" onload="alert(1)">
+<style>@import url(http://example.com/style.css);</style>
+<style>.a { fill: url(#gradient); }</style>
+<script>alert(1)</script>
+<foreignObject><iframe src="http://example.com/"></iframe></foreignObject>
+<image xlink:href="file:///etc/passwd" width="10" height="10"/>
+<image href="data:image/png;base64,AAAA" width="10" height="10"/>
+<use xlink:href="#shape"/>
+<rect id="shape" width="10" height="10" fill="url(http://example.com/paint)" stroke="red">&xxe;</rect>
+</svg>`
+	sanitized, width, height, err := sanitizeSvg([]byte(source))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if width != 48 || height != 24 {
+		t.Error("Unexpected size", width, height)
+	}
+	result := string(sanitized)
+	for _, removed := range []string{"DOCTYPE", "ENTITY", "xml-stylesheet", "onload", "@import", "<script", "alert", "foreignObject", "iframe", "file://", "http://example.com"} {
+		if strings.Contains(result, removed) {
+			t.Error("Sanitized svg expected to not contain", removed, result)
+		}
+	}
+	for _, kept := range []string{"url(#gradient)", "data:image/png", `xlink:href="#shape"`, `stroke="red"`, "&amp;xxe;"} {
+		if !strings.Contains(result, kept) {
+			t.Error("Sanitized svg expected to contain", kept, result)
+		}
+	}
+
+	splitStyles := []string{
+		`<svg><style>.a { fill: u<!---->rl(http://example.com/paint); }</style></svg>`,
+		`<svg><style>.a { fill: u<![CDATA[rl(http://example.com/paint)]]>; }</style></svg>`,
+		`<svg><style>@im<!---->port "http://example.com/style.css";</style></svg>`,
+	}
+	for _, splitStyle := range splitStyles {
+		sanitized, _, _, err = sanitizeSvg([]byte(splitStyle))
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		if strings.Contains(string(sanitized), "example.com") {
+			t.Error("Sanitized svg expected to not contain a split reference", string(sanitized))
+		}
+	}
+	sanitized, _, _, err = sanitizeSvg([]byte(`<svg><style>.a { fill: <![CDATA[url(#gradient)]]>; }</style></svg>`))
+	if err != nil || !strings.Contains(string(sanitized), ".a { fill: url(#gradient); }") {
+		t.Error("Sanitized svg expected to keep a local style", string(sanitized), err)
+	}
+
+	if _, _, _, err = sanitizeSvg([]byte("<html><body></body></html>")); err != common.ErrorInvalidSvg {
+		t.Error("Documents other than svg images expected to be rejected", err)
+	}
+	if _, _, _, err = sanitizeSvg([]byte("not xml")); err != common.ErrorInvalidSvg {
+		t.Error("Text expected to be rejected", err)
+	}
+	if _, width, height, _ = sanitizeSvg([]byte(`<svg width="100px" height="50"></svg>`)); width != 100 || height != 50 {
+		t.Error("Unexpected size without a view box", width, height)
+	}
+}
+
+func TestSvgRasterSize(t *testing.T) {
+	if width, height := svgRasterSize(24, 12, 520, 390); width != 780 || height != 390 {
+		t.Error("Unexpected covering size", width, height)
+	}
+	if width, height := svgRasterSize(1, 100000, 520, 390); width != 1 || height != maxSvgRasterSize {
+		t.Error("Unexpected capped size", width, height)
+	}
+	if width, height := svgRasterSize(0, 0, 520, 390); width != 520 || height != 390 {
+		t.Error("Unexpected size without a size", width, height)
+	}
+}
+
+func TestSvgRenderAgent(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "icon.svg")
+	ioutil.WriteFile(sourcePath, []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 20 10"><rect width="20" height="10" fill="#ff0000"/></svg>`), 0644)
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, uploader, true)
+	defer rm.Stop()
+	rm.AddSvgRenderAgent(downloader, uploader, "", CommandLimits{}, 5)
+	rm.AddRoute(NewRendererRoute(common.RenderAgentSvg, "svg", 0))
+
+	rm.CreateWork("8D2F6B19-3E7C-4A05-B1D8-9C4E2A6F0B73", "file://"+sourcePath, "svg", 1024, []common.Attribute{})
+
+	var generatedAssets []*common.GeneratedAsset
+	var err error
+	for i := 0; i < 100; i++ {
+		generatedAssets, err = gasm.FindBySourceAssetId("8D2F6B19-3E7C-4A05-B1D8-9C4E2A6F0B73")
+		if err == nil && common.IsGeneratedAssetsFinal(generatedAssets) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(generatedAssets) != 4 || common.GeneratedAssetsState(generatedAssets) != common.GeneratedAssetsStateComplete {
+		t.Error("Generated assets were not rendered", generatedAssets)
+		return
+	}
+
+	reader, err := os.Open(filepath.Join(dm.Path, "assets", "8D2F6B19-3E7C-4A05-B1D8-9C4E2A6F0B73", common.PlaceholderSizeSmall, "0"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer reader.Close()
+	rendered, err := jpeg.Decode(reader)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if rendered.Bounds().Dx() != 250 || rendered.Bounds().Dy() != 125 {
+		t.Error("Unexpected rendered bounds", rendered.Bounds())
+	}
+	r, g, b, _ := rendered.At(125, 62).RGBA()
+	if r < 0xe000 || g > 0x2000 || b > 0x2000 {
+		t.Error("Expected the image to be red", r, g, b)
+	}
+}
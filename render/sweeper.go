@@ -0,0 +1,90 @@
+package render
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+type sweeper struct {
+	agentManager *RenderAgentManager
+	interval     time.Duration
+
+	stop chan (chan bool)
+}
+
+// SetRetention sets how long source assets of each file type are kept for. Source assets created without an expires attribute are given one using the retention of their file type. File types without a retention are kept until they are deleted.
+func (agentManager *RenderAgentManager) SetRetention(retention map[string]time.Duration) {
+	agentManager.retention = retention
+}
+
+// EnableSweeper starts a background process that periodically deletes expired source assets, their generated assets and the files uploaded for them.
+func (agentManager *RenderAgentManager) EnableSweeper(interval time.Duration) {
+	s := new(sweeper)
+	s.agentManager = agentManager
+	s.interval = interval
+	s.stop = make(chan (chan bool))
+	agentManager.sweeper = s
+	go s.run()
+}
+
+// Sweep deletes all of the source assets that have expired and returns their ids.
+func (agentManager *RenderAgentManager) Sweep() []string {
+	sourceAssetIds, err := agentManager.sourceAssetStorageManager.FindExpired(time.Now().UnixNano())
+	if err != nil {
+		log.Println("Error finding expired source assets", err)
+		return []string{}
+	}
+
+	results := make([]string, 0, 0)
+	for _, sourceAssetId := range sourceAssetIds {
+		_, err := agentManager.Purge(sourceAssetId)
+		if err != nil {
+			log.Println("Error purging expired source asset", sourceAssetId, err)
+			continue
+		}
+		results = append(results, sourceAssetId)
+	}
+	if len(results) > 0 {
+		log.Println("Swept", len(results), "expired source assets")
+	}
+	return results
+}
+
+// expires returns the value of the expires attribute for a new source asset of the given file type.
+func (agentManager *RenderAgentManager) expires(fileType string) (string, bool) {
+	retention, hasRetention := agentManager.retention[fileType]
+	if !hasRetention || retention <= 0 {
+		return "", false
+	}
+	return strconv.FormatInt(time.Now().Add(retention).UnixNano(), 10), true
+}
+
+func (s *sweeper) run() {
+	for {
+		select {
+		case ch, ok := <-s.stop:
+			{
+				if !ok {
+					return
+				}
+				ch <- true
+				return
+			}
+		case <-time.After(s.interval):
+			{
+				s.agentManager.Sweep()
+			}
+		}
+	}
+}
+
+func (s *sweeper) Stop() {
+	callback := make(chan bool)
+	s.stop <- callback
+	select {
+	case <-callback:
+	case <-time.After(5 * time.Second):
+	}
+	close(s.stop)
+}
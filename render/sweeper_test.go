@@ -0,0 +1,66 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSweeper(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	rm.SetRetention(map[string]time.Duration{"jpg": time.Hour})
+	rm.EnableSweeper(time.Hour)
+	defer rm.Stop()
+
+	rm.CreateWork("E876F147-E331-4B5B-8B1A-7D6D5C1C2A3E", "file:///tmp/image.jpg", "jpg", 12345, []common.Attribute{})
+	sourceAssets, err := sasm.FindBySourceAssetId("E876F147-E331-4B5B-8B1A-7D6D5C1C2A3E")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	if len(sourceAssets) != 1 || !sourceAssets[0].HasAttribute(common.SourceAssetAttributeExpires) {
+		t.Error("Source asset with expires attribute expected:", sourceAssets)
+		return
+	}
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sourceAsset.AddAttribute(common.SourceAssetAttributeExpires, []string{strconv.FormatInt(time.Now().Add(-time.Minute).UnixNano(), 10)})
+	sasm.Store(sourceAsset)
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7-A48E-45E4-A5E1-4533E50BBDA3/small/0")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	gasm.Store(generatedAsset)
+
+	swept := rm.Sweep()
+	if len(swept) != 1 || swept[0] != sourceAsset.Id {
+		t.Error("Expired source asset expected to be swept:", swept)
+		return
+	}
+	sourceAssets, _ = sasm.FindBySourceAssetId(sourceAsset.Id)
+	if len(sourceAssets) != 0 {
+		t.Error("No source assets expected:", sourceAssets)
+	}
+	generatedAssets, _ := gasm.FindBySourceAssetId(sourceAsset.Id)
+	if len(generatedAssets) != 0 {
+		t.Error("No generated assets expected:", generatedAssets)
+	}
+	sourceAssets, _ = sasm.FindBySourceAssetId("E876F147-E331-4B5B-8B1A-7D6D5C1C2A3E")
+	if len(sourceAssets) != 1 {
+		t.Error("Unexpired source asset expected to be kept:", sourceAssets)
+	}
+}
@@ -0,0 +1,353 @@
+package render
+
+import (
+	"bytes"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/rcrowley/go-metrics"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	// maxTextBytes is the number of bytes read from the start of a text file.
+	maxTextBytes = 64 * 1024
+	// defaultTextLines is the number of lines rendered when a template doesn't have a lines attribute.
+	defaultTextLines = 60
+	// minTextColumns and maxTextColumns bound the number of columns that the font is sized to fit in the width of a template.
+	minTextColumns = 40
+	maxTextColumns = 100
+	textTabWidth   = 4
+)
+
+var (
+	textColors = map[tokenKind]color.Color{
+		tokenPlain:   color.RGBA{0x24, 0x29, 0x2e, 0xff},
+		tokenKeyword: color.RGBA{0xd7, 0x3a, 0x49, 0xff},
+		tokenString:  color.RGBA{0x03, 0x2f, 0x62, 0xff},
+		tokenComment: color.RGBA{0x6a, 0x73, 0x7d, 0xff},
+		tokenNumber:  color.RGBA{0x00, 0x5c, 0xc5, 0xff},
+		tokenKey:     color.RGBA{0x6f, 0x42, 0xc1, 0xff},
+		tokenHeading: color.RGBA{0xe3, 0x62, 0x09, 0xff},
+	}
+
+	// NKG: The characters 0x80 to 0x9F of Windows-1252 that differ from
+	// ISO-8859-1, which maps bytes directly to code points.
+	windows1252 = map[byte]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„', 0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ', 0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ', 0x8E: 'Ž',
+		0x91: '‘', 0x92: '’', 0x93: '“', 0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—', 0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›', 0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+	}
+
+	monospaceFont     *opentype.Font
+	monospaceFontErr  error
+	monospaceFontOnce sync.Once
+)
+
+// textRenderAgent renders the first lines of text files, with syntax highlighting, using a monospace font embedded in the binary.
+type textRenderAgent struct {
+	*baseRenderAgent
+	metrics *textRenderAgentMetrics
+}
+
+type textRenderAgentMetrics struct {
+	workProcessed metrics.Meter
+	convertTime   metrics.Timer
+}
+
+func newTextRenderAgent(
+	base *baseRenderAgent,
+	metrics *textRenderAgentMetrics) RenderAgent {
+
+	renderAgent := new(textRenderAgent)
+	renderAgent.baseRenderAgent = base
+	renderAgent.metrics = metrics
+
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
+
+	return renderAgent
+}
+
+func newTextRenderAgentMetrics(registry metrics.Registry) *textRenderAgentMetrics {
+	textMetrics := new(textRenderAgentMetrics)
+	textMetrics.workProcessed = metrics.NewMeter()
+	textMetrics.convertTime = metrics.NewTimer()
+
+	registry.Register("textRenderAgent.workProcessed", textMetrics.workProcessed)
+	registry.Register("textRenderAgent.convertTime", textMetrics.convertTime)
+
+	return textMetrics
+}
+
+func (renderAgent *textRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+	fileType, err := common.GetFirstAttribute(sourceAsset, common.SourceAssetAttributeType)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileType), nil}
+		return
+	}
+
+	width, height, err := renderAgent.getSize(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+	_, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(nativeOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork), nil}
+		return
+	}
+	defer sourceFile.Release()
+
+	text, err := readText(sourceFile.Path())
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDecodeText), nil}
+		return
+	}
+	lines := highlight(fileType, text)
+	if maxLines := templateLines(template); len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+
+	quality := templateQuality(template)
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
+
+	renderAgent.metrics.convertTime.Time(func() {
+		var rendered image.Image
+		rendered, err = renderText(lines, width, height, background)
+		if err != nil {
+			return
+		}
+		for _, output := range outputs {
+			err = encodeImage(rendered, destinations[output], output, quality)
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
+
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
+		return
+	}
+
+	destination := destinations[outputs[0]]
+	generatedAssetFileSize, err := util.FileSize(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileSize), nil}
+		return
+	}
+
+	newAttributes := []common.Attribute{
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(height)}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(width)}),
+		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
+	}
+
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
+}
+
+// templateLines returns the number of lines of text files rendered by a template.
+func templateLines(template *common.Template) int {
+	rawLines, err := common.GetFirstAttribute(template, common.TemplateAttributeLines)
+	if err != nil {
+		return defaultTextLines
+	}
+	lines, err := strconv.Atoi(rawLines)
+	if err != nil || lines < 1 {
+		return defaultTextLines
+	}
+	return lines
+}
+
+// readText reads and decodes the start of a text file.
+func readText(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxTextBytes))
+	if err != nil {
+		return "", err
+	}
+	return decodeText(data)
+}
+
+// decodeText decodes UTF-8 and UTF-16 text, using the byte order mark to tell them apart, and falls back to Windows-1252 for text that isn't valid UTF-8. Line endings are normalized and tabs are expanded. Data that contains NUL bytes without a UTF-16 byte order mark is rejected as binary.
+func decodeText(data []byte) (string, error) {
+	var text string
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		text = string(trimPartialRune(data[3:]))
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		text = decodeUtf16(data[2:], false)
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		text = decodeUtf16(data[2:], true)
+	case bytes.IndexByte(data, 0) != -1:
+		return "", common.ErrorCouldNotDecodeText
+	case utf8.Valid(trimPartialRune(data)):
+		text = string(trimPartialRune(data))
+	default:
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			if r, isWindows1252 := windows1252[b]; isWindows1252 {
+				runes[i] = r
+			} else {
+				runes[i] = rune(b)
+			}
+		}
+		text = string(runes)
+	}
+
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func decodeUtf16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		} else {
+			units[i] = uint16(data[2*i+1])<<8 | uint16(data[2*i])
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// trimPartialRune removes an incomplete UTF-8 sequence from the end of data, which happens when a file is only partially read.
+func trimPartialRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			return data
+		}
+	}
+	return data
+}
+
+// expandTabs replaces tabs with spaces and removes other control characters.
+func expandTabs(line string) string {
+	var buffer bytes.Buffer
+	column := 0
+	for _, r := range line {
+		switch {
+		case r == '\t':
+			spaces := textTabWidth - column%textTabWidth
+			buffer.WriteString(strings.Repeat(" ", spaces))
+			column += spaces
+		case r < ' ' || r == 0x7F:
+			continue
+		default:
+			buffer.WriteRune(r)
+			column++
+		}
+	}
+	return buffer.String()
+}
+
+// renderText draws lines of highlighted text on an image of the given size. The font is sized so that the longest line, bounded by minTextColumns and maxTextColumns, fits the width of the image, and lines that don't fit are clipped.
+func renderText(lines [][]textSpan, width, height int, background color.Color) (image.Image, error) {
+	monospaceFontOnce.Do(func() {
+		monospaceFont, monospaceFontErr = opentype.Parse(gomono.TTF)
+	})
+	if monospaceFontErr != nil {
+		return nil, monospaceFontErr
+	}
+
+	columns := minTextColumns
+	for _, line := range lines {
+		length := 0
+		for _, span := range line {
+			length += utf8.RuneCountInString(span.text)
+		}
+		if length > columns {
+			columns = length
+		}
+	}
+	if columns > maxTextColumns {
+		columns = maxTextColumns
+	}
+	padding := maxInt(2, width/40)
+
+	// NKG: The advance of a monospace font is proportional to its size, so
+	// the advance at a known size is used to find the size that fits.
+	size := 100.0
+	measure, err := opentype.NewFace(monospaceFont, &opentype.FaceOptions{Size: size, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	advance, _ := measure.GlyphAdvance('M')
+	measure.Close()
+	size = size * float64(width-2*padding) / float64(columns) / (float64(advance) / 64)
+
+	face, err := opentype.NewFace(monospaceFont, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	if background == nil {
+		background = color.White
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	faceMetrics := face.Metrics()
+	drawer := &font.Drawer{Dst: canvas, Face: face}
+	y := fixed.I(padding) + faceMetrics.Ascent
+	for _, line := range lines {
+		if (y + faceMetrics.Descent).Ceil() > height-padding {
+			break
+		}
+		drawer.Dot = fixed.Point26_6{X: fixed.I(padding), Y: y}
+		for _, span := range line {
+			drawer.Src = image.NewUniform(textColors[span.kind])
+			drawer.DrawString(span.text)
+		}
+		y += faceMetrics.Height
+	}
+	return canvas, nil
+}
@@ -0,0 +1,125 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecodeText(t *testing.T) {
+	expected := map[string][]byte{
+		"héllo\n    world": []byte("h\xc3\xa9llo\r\n\tworld"),
+		"héllo":            []byte("\xef\xbb\xbfh\xc3\xa9llo"),
+		"hé":               []byte{0xFF, 0xFE, 'h', 0x00, 0xE9, 0x00},
+		"hi":               []byte{0xFE, 0xFF, 0x00, 'h', 0x00, 'i'},
+		"café “quoted”":    []byte("caf\xe9 \x93quoted\x94"),
+		"trimmed":          []byte("trimmed\xc3"),
+	}
+	for text, data := range expected {
+		decoded, err := decodeText(data)
+		if err != nil || decoded != text {
+			t.Error("Unexpected decoded text", text, decoded, err)
+		}
+	}
+	if _, err := decodeText([]byte{0x89, 'P', 'N', 'G', 0x00, 0x00}); err != common.ErrorCouldNotDecodeText {
+		t.Error("Binary data expected to be rejected", err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	lines := highlight("go", "func main() {\n\t/* a\nb */ return \"x\" // done\n}")
+	expected := [][]textSpan{
+		[]textSpan{textSpan{"func", tokenKeyword}, textSpan{" ", tokenPlain}, textSpan{"main", tokenPlain}, textSpan{"() {", tokenPlain}},
+		[]textSpan{textSpan{"\t", tokenPlain}, textSpan{"/* a", tokenComment}},
+		[]textSpan{textSpan{"b */", tokenComment}, textSpan{" ", tokenPlain}, textSpan{"return", tokenKeyword}, textSpan{" ", tokenPlain}, textSpan{"\"x\"", tokenString}, textSpan{" ", tokenPlain}, textSpan{"// done", tokenComment}},
+		[]textSpan{textSpan{"}", tokenPlain}},
+	}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Error("Unexpected go spans", lines)
+	}
+
+	lines = highlight("json", "{\"a\": 1, \"b\": \"c\", \"d\": null}")
+	kinds := make([]tokenKind, 0, 0)
+	for _, span := range lines[0] {
+		if span.kind != tokenPlain {
+			kinds = append(kinds, span.kind)
+		}
+	}
+	if !reflect.DeepEqual(kinds, []tokenKind{tokenKey, tokenNumber, tokenKey, tokenString, tokenKey, tokenKeyword}) {
+		t.Error("Unexpected json spans", lines)
+	}
+
+	lines = highlight("py", "x = '''a\nb''' # c")
+	if len(lines) != 2 || lines[1][0] != (textSpan{"b'''", tokenString}) || lines[1][2] != (textSpan{"# c", tokenComment}) {
+		t.Error("Unexpected python spans", lines)
+	}
+
+	lines = highlight("csv", "a,b\n1,\"x,y\"")
+	if len(lines) != 2 || lines[0][0].kind != tokenHeading || len(lines[1]) != 3 || lines[1][2].text != "\"x,y\"" {
+		t.Error("Unexpected csv spans", lines)
+	}
+
+	lines = highlight("md", "# Title\n- item")
+	if lines[0][0].kind != tokenHeading || lines[1][0] != (textSpan{"-", tokenKeyword}) {
+		t.Error("Unexpected markdown spans", lines)
+	}
+}
+
+func TestTextRenderAgent(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	sourcePath := filepath.Join(dm.Path, "main.go")
+	ioutil.WriteFile(sourcePath, []byte("package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n"), 0644)
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	tfm := common.NewTemporaryFileManager()
+	uploader := common.NewLocalUploader(filepath.Join(dm.Path, "assets"))
+	downloader := common.NewDownloader(filepath.Join(dm.Path, "cache"), filepath.Join(dm.Path, "assets"), tfm, false, []string{}, nil)
+	os.MkdirAll(filepath.Join(dm.Path, "cache"), 0777)
+
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, tfm, uploader, true)
+	defer rm.Stop()
+	rm.AddTextRenderAgent(downloader, uploader, 5)
+	rm.AddRoute(NewRendererRoute(common.RenderAgentText, "go", 0))
+
+	rm.CreateWork("5B9E2C71-0D4A-4F38-9C6B-2A7E1F3D8B04", "file://"+sourcePath, "go", 1024, []common.Attribute{})
+
+	var generatedAssets []*common.GeneratedAsset
+	var err error
+	for i := 0; i < 100; i++ {
+		generatedAssets, err = gasm.FindBySourceAssetId("5B9E2C71-0D4A-4F38-9C6B-2A7E1F3D8B04")
+		if err == nil && common.IsGeneratedAssetsFinal(generatedAssets) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(generatedAssets) != 4 || common.GeneratedAssetsState(generatedAssets) != common.GeneratedAssetsStateComplete {
+		t.Error("Generated assets were not rendered", generatedAssets)
+		return
+	}
+
+	reader, err := os.Open(filepath.Join(dm.Path, "assets", "5B9E2C71-0D4A-4F38-9C6B-2A7E1F3D8B04", common.PlaceholderSizeSmall, "0"))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer reader.Close()
+	rendered, err := jpeg.Decode(reader)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if rendered.Bounds().Dx() != 250 || rendered.Bounds().Dy() != 188 {
+		t.Error("Unexpected rendered bounds", rendered.Bounds())
+	}
+}
@@ -0,0 +1,336 @@
+package render
+
+import (
+	"fmt"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/rcrowley/go-metrics"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var videoOutputs = []string{"jpg", "jpeg", "png", "webp"}
+
+// videoRenderAgent renders poster frames and storyboards of videos using ffmpeg.
+type videoRenderAgent struct {
+	*baseRenderAgent
+	metrics     *videoRenderAgentMetrics
+	ffmpegPath  string
+	ffprobePath string
+	limits      CommandLimits
+}
+
+type videoRenderAgentMetrics struct {
+	workProcessed   metrics.Meter
+	convertTime     metrics.Timer
+	posterCount     metrics.Counter
+	storyboardCount metrics.Counter
+}
+
+type videoInfo struct {
+	duration float64
+	width    int
+	height   int
+}
+
+func newVideoRenderAgent(
+	base *baseRenderAgent,
+	metrics *videoRenderAgentMetrics,
+	ffmpegPath, ffprobePath string,
+	limits CommandLimits) RenderAgent {
+
+	renderAgent := new(videoRenderAgent)
+	renderAgent.baseRenderAgent = base
+	renderAgent.metrics = metrics
+	renderAgent.ffmpegPath = ffmpegPath
+	renderAgent.ffprobePath = ffprobePath
+	renderAgent.limits = limits
+
+	renderAgent.start(metrics.workProcessed, renderAgent.renderGeneratedAsset)
+
+	return renderAgent
+}
+
+func newVideoRenderAgentMetrics(registry metrics.Registry) *videoRenderAgentMetrics {
+	videoMetrics := new(videoRenderAgentMetrics)
+	videoMetrics.workProcessed = metrics.NewMeter()
+	videoMetrics.convertTime = metrics.NewTimer()
+	videoMetrics.posterCount = metrics.NewCounter()
+	videoMetrics.storyboardCount = metrics.NewCounter()
+
+	registry.Register("videoRenderAgent.workProcessed", videoMetrics.workProcessed)
+	registry.Register("videoRenderAgent.convertTime", videoMetrics.convertTime)
+	registry.Register("videoRenderAgent.posterCount", videoMetrics.posterCount)
+	registry.Register("videoRenderAgent.storyboardCount", videoMetrics.storyboardCount)
+
+	return videoMetrics
+}
+
+func (renderAgent *videoRenderAgent) renderGeneratedAsset(generatedAsset *common.GeneratedAsset, sourceAsset *common.SourceAsset, template *common.Template, statusCallback chan generatedAssetUpdate) {
+	width, height, err := renderAgent.getSize(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+	fit, background, err := templateFit(template)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorInvalidTemplateFit), nil}
+		return
+	}
+	outputs := common.TemplateOutputs(template)
+	if !supportsOutputs(videoOutputs, outputs) {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorUnsupportedOutputFormat), nil}
+		return
+	}
+	frames, columns, isStoryboard := templateStoryboard(template)
+
+	urls := sourceAsset.GetAttribute(common.SourceAssetAttributeSource)
+	sourceFile, err := renderAgent.tryDownload(urls, common.SourceAssetSource(sourceAsset))
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorNoDownloadUrlsWork), nil}
+		return
+	}
+	defer sourceFile.Release()
+
+	info, err := renderAgent.probe(sourceFile.Path())
+	if err != nil {
+		if err == common.ErrorRenderTimedOut {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
+			return
+		}
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotProbeVideo), nil}
+		return
+	}
+	renderAgent.recordVideoInfo(sourceAsset, info)
+
+	quality := templateQuality(template)
+	destinations := make(map[string]string)
+	for _, output := range outputs {
+		destination := sourceFile.Path() + "-" + template.Id + "." + output
+		destinationTemporaryFile := renderAgent.temporaryFileManager.Create(destination)
+		defer destinationTemporaryFile.Release()
+		destinations[output] = destination
+	}
+
+	renderAgent.metrics.convertTime.Time(func() {
+		for _, output := range outputs {
+			var args []string
+			if isStoryboard {
+				args = storyboardArgs(sourceFile.Path(), info.duration, frames, columns, width, height, fit, background)
+			} else {
+				args = posterArgs(sourceFile.Path(), info.duration, width, height, fit, background)
+			}
+			args = append(args, videoOutputArgs(output, quality)...)
+			var commandOutput []byte
+			commandOutput, err = runCommand(renderAgent.limits, renderAgent.ffmpegPath, append(args, destinations[output])...)
+			log.Println(string(commandOutput))
+			if err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		if err == common.ErrorRenderTimedOut {
+			statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorRenderTimedOut), nil}
+			return
+		}
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotResizeImage), nil}
+		return
+	}
+	if isStoryboard {
+		renderAgent.metrics.storyboardCount.Inc(1)
+	} else {
+		renderAgent.metrics.posterCount.Inc(1)
+	}
+
+	if err := renderAgent.upload(generatedAsset, outputs, destinations); err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(err), nil}
+		return
+	}
+
+	destination := destinations[outputs[0]]
+	bounds, err := imageBounds(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineRenderSize), nil}
+		return
+	}
+
+	generatedAssetFileSize, err := util.FileSize(destination)
+	if err != nil {
+		statusCallback <- generatedAssetUpdate{common.NewGeneratedAssetError(common.ErrorCouldNotDetermineFileSize), nil}
+		return
+	}
+
+	newAttributes := []common.Attribute{
+		generatedAsset.AddAttribute("imageHeight", []string{strconv.Itoa(bounds.Dy())}),
+		generatedAsset.AddAttribute("imageWidth", []string{strconv.Itoa(bounds.Dx())}),
+		generatedAsset.AddAttribute("fileSize", []string{strconv.FormatInt(generatedAssetFileSize, 10)}),
+		generatedAsset.AddAttribute(common.GeneratedAssetAttributeOutputs, outputs),
+	}
+	if isStoryboard {
+		newAttributes = append(newAttributes,
+			generatedAsset.AddAttribute(common.TemplateAttributeFrames, []string{strconv.Itoa(frames)}),
+			generatedAsset.AddAttribute(common.TemplateAttributeColumns, []string{strconv.Itoa(columns)}))
+	}
+
+	statusCallback <- generatedAssetUpdate{common.GeneratedAssetStatusComplete, newAttributes}
+}
+
+// probe returns the duration and resolution of the first video stream of a file.
+func (renderAgent *videoRenderAgent) probe(path string) (*videoInfo, error) {
+	output, err := runCommand(renderAgent.limits, renderAgent.ffprobePath, "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=width,height:format=duration", "-of", "default=noprint_wrappers=1", path)
+	if err != nil {
+		log.Println("error running command", err)
+		return nil, err
+	}
+	return parseProbeOutput(string(output))
+}
+
+// recordVideoInfo adds the duration and resolution attributes to the source asset if it doesn't already have them.
+func (renderAgent *videoRenderAgent) recordVideoInfo(sourceAsset *common.SourceAsset, info *videoInfo) {
+	if sourceAsset.HasAttribute(common.SourceAssetAttributeDuration) {
+		return
+	}
+	// NKG: Each template of a video is rendered separately, so the source
+	// asset is looked up again to avoid replacing attributes added since
+	// it was first looked up.
+	sourceAssets, err := renderAgent.sasm.FindBySourceAssetId(sourceAsset.Id)
+	if err != nil {
+		return
+	}
+	for _, current := range sourceAssets {
+		if current.IdType != sourceAsset.IdType || current.HasAttribute(common.SourceAssetAttributeDuration) {
+			continue
+		}
+		current.SetAttribute(common.SourceAssetAttributeDuration, []string{strconv.FormatFloat(info.duration, 'f', 3, 64)})
+		current.SetAttribute(common.SourceAssetAttributeResolution, []string{fmt.Sprintf("%dx%d", info.width, info.height)})
+		err = renderAgent.sasm.Store(current)
+		if err != nil {
+			log.Println("Error recording video attributes of source asset", current.Id, err)
+		}
+	}
+}
+
+// parseProbeOutput parses the key=value lines written by ffprobe for the duration and resolution of a video.
+func parseProbeOutput(output string) (*videoInfo, error) {
+	info := new(videoInfo)
+	for _, line := range strings.Split(output, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "width":
+			info.width, _ = strconv.Atoi(parts[1])
+		case "height":
+			info.height, _ = strconv.Atoi(parts[1])
+		case "duration":
+			info.duration, _ = strconv.ParseFloat(parts[1], 64)
+		}
+	}
+	if info.width <= 0 || info.height <= 0 {
+		return nil, common.ErrorCouldNotProbeVideo
+	}
+	return info, nil
+}
+
+// templateStoryboard returns the number of frames and columns of a storyboard template. Templates without a frames attribute render poster frames.
+func templateStoryboard(template *common.Template) (int, int, bool) {
+	rawFrames, err := common.GetFirstAttribute(template, common.TemplateAttributeFrames)
+	if err != nil {
+		return 0, 0, false
+	}
+	frames, err := strconv.Atoi(rawFrames)
+	if err != nil || frames < 1 {
+		return 0, 0, false
+	}
+	columns := int(math.Ceil(math.Sqrt(float64(frames))))
+	rawColumns, err := common.GetFirstAttribute(template, common.TemplateAttributeColumns)
+	if err == nil {
+		value, err := strconv.Atoi(rawColumns)
+		if err == nil && value > 0 {
+			columns = value
+		}
+	}
+	if columns > frames {
+		columns = frames
+	}
+	return frames, columns, true
+}
+
+// posterOffset returns the number of seconds into a video that the poster frame is looked for. Skipping the start of a video avoids the black or title frames that videos often open with.
+func posterOffset(duration float64) float64 {
+	if duration <= 1 {
+		return 0
+	}
+	return math.Min(duration*0.1, 10)
+}
+
+// posterArgs returns the ffmpeg arguments that render a poster frame of a video. The thumbnail filter picks the most representative of the frames following the poster offset.
+func posterArgs(source string, duration float64, width, height int, fit string, background color.Color) []string {
+	offset := strconv.FormatFloat(posterOffset(duration), 'f', 3, 64)
+	return []string{"-y", "-ss", offset, "-i", source, "-an", "-vf", "thumbnail=50," + videoScaleFilter(width, height, fit, background), "-frames:v", "1"}
+}
+
+// storyboardArgs returns the ffmpeg arguments that render a sprite sheet of evenly spaced frames of a video, with each frame scaled to the width and height.
+func storyboardArgs(source string, duration float64, frames, columns, width, height int, fit string, background color.Color) []string {
+	rows := (frames + columns - 1) / columns
+	fps := "1"
+	if duration > 0 {
+		fps = strconv.FormatFloat(float64(frames)/duration, 'f', 6, 64)
+	}
+	filter := fmt.Sprintf("fps=%s,%s,tile=%dx%d", fps, videoScaleFilter(width, height, fit, background), columns, rows)
+	return []string{"-y", "-i", source, "-an", "-vf", filter, "-frames:v", "1"}
+}
+
+// videoScaleFilter returns the ffmpeg filter that scales frames to the given width and height using a fit mode, matching fitImage.
+func videoScaleFilter(width, height int, fit string, background color.Color) string {
+	switch fit {
+	case common.FitModeCover:
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d", width, height, width, height)
+	case common.FitModeFill:
+		return fmt.Sprintf("scale=%d:%d", width, height)
+	case common.FitModePad:
+		if background == nil {
+			background = color.White
+		}
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=%s", width, height, width, height, backgroundHex(background))
+	}
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", width, height)
+}
+
+// videoOutputArgs returns the ffmpeg arguments that set the quality of an output format.
+func videoOutputArgs(output string, quality int) []string {
+	if quality == 0 {
+		return []string{}
+	}
+	switch output {
+	case "jpg", "jpeg":
+		// NKG: The JPEG quality scale of ffmpeg runs from 2, the best, to
+		// 31, the worst.
+		return []string{"-q:v", strconv.Itoa(2 + (100-quality)*29/100)}
+	case "webp":
+		return []string{"-quality", strconv.Itoa(quality)}
+	}
+	return []string{}
+}
+
+// imageBounds returns the bounds of an image file.
+func imageBounds(path string) (*image.Rectangle, error) {
+	reader, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, err
+	}
+	bounds := image.Rect(0, 0, config.Width, config.Height)
+	return &bounds, nil
+}
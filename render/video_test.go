@@ -0,0 +1,62 @@
+package render
+
+import (
+	"github.com/ngerakines/preview/common"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestParseProbeOutput(t *testing.T) {
+	info, err := parseProbeOutput("width=1920\nheight=1080\nduration=93.512000\n")
+	if err != nil || info.width != 1920 || info.height != 1080 || info.duration != 93.512 {
+		t.Error("Unexpected video info", info, err)
+	}
+	if _, err = parseProbeOutput("duration=12.0\n"); err != common.ErrorCouldNotProbeVideo {
+		t.Error("Output without a video stream expected to be rejected", err)
+	}
+}
+
+func TestPosterOffset(t *testing.T) {
+	expected := map[float64]float64{0: 0, 1: 0, 30: 3, 600: 10}
+	for duration, offset := range expected {
+		if value := posterOffset(duration); value != offset {
+			t.Error("Unexpected poster offset", duration, value)
+		}
+	}
+}
+
+func TestVideoScaleFilter(t *testing.T) {
+	expected := map[string]string{
+		common.FitModeContain: "scale=200:100:force_original_aspect_ratio=decrease",
+		common.FitModeCover:   "scale=200:100:force_original_aspect_ratio=increase,crop=200:100",
+		common.FitModeFill:    "scale=200:100",
+		common.FitModePad:     "scale=200:100:force_original_aspect_ratio=decrease,pad=200:100:(ow-iw)/2:(oh-ih)/2:color=#ffffffff",
+	}
+	for fit, filter := range expected {
+		if value := videoScaleFilter(200, 100, fit, nil); value != filter {
+			t.Error("Unexpected scale filter", fit, value)
+		}
+	}
+	if value := videoScaleFilter(200, 100, common.FitModePad, color.NRGBA{A: 255}); value != "scale=200:100:force_original_aspect_ratio=decrease,pad=200:100:(ow-iw)/2:(oh-ih)/2:color=#000000ff" {
+		t.Error("Unexpected pad background", value)
+	}
+}
+
+func TestStoryboard(t *testing.T) {
+	template := &common.Template{Renderer: common.RenderAgentVideo, Attributes: []common.Attribute{}}
+	if _, _, isStoryboard := templateStoryboard(template); isStoryboard {
+		t.Error("Template without frames expected to render a poster")
+	}
+	template.AddAttribute(common.TemplateAttributeFrames, []string{"10"})
+	frames, columns, isStoryboard := templateStoryboard(template)
+	if !isStoryboard || frames != 10 || columns != 4 {
+		t.Error("Unexpected storyboard", frames, columns, isStoryboard)
+	}
+
+	args := storyboardArgs("video.mp4", 20, 10, 4, 160, 90, common.FitModeFill, nil)
+	expected := []string{"-y", "-i", "video.mp4", "-an", "-vf", "fps=0.500000,scale=160:90,tile=4x3", "-frames:v", "1"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Error("Unexpected storyboard args", args)
+	}
+}
@@ -0,0 +1,407 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	maxWebhookDeliveries = 100
+
+	// WebhookEventGeneratedAsset is the event of notifications sent when a single generated asset is completed or failed.
+	WebhookEventGeneratedAsset = "generatedAsset"
+	// WebhookEventSourceAsset is the event of notifications sent when all of the generated assets of a source asset are completed or failed.
+	WebhookEventSourceAsset = "sourceAsset"
+
+	// WebhookSignatureHeader is the header that contains the signature of the notification body.
+	WebhookSignatureHeader = "X-Preview-Signature"
+	// WebhookEventHeader is the header that contains the event of the notification.
+	WebhookEventHeader = "X-Preview-Event"
+)
+
+// WebhookDelivery records a single attempt to deliver a notification to a callback url.
+type WebhookDelivery struct {
+	Url        string `json:"url"`
+	Event      string `json:"event"`
+	FileId     string `json:"fileId"`
+	Attempt    int    `json:"attempt"`
+	StatusCode int    `json:"statusCode"`
+	Error      string `json:"error,omitempty"`
+	Delivered  bool   `json:"delivered"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// NKG: Callback urls are provided by clients, so notifications aren't sent
+// to addresses that are only reachable from inside the network unless the
+// host has been explicitly allowed.
+var privateNetworks = parseNetworks("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16", "::/128", "::1/128", "fc00::/7", "fe80::/10")
+
+type webhookNotification struct {
+	Event           string                  `json:"event"`
+	FileId          string                  `json:"file_id"`
+	State           string                  `json:"state"`
+	GeneratedAssets []webhookGeneratedAsset `json:"generated_assets"`
+}
+
+type webhookGeneratedAsset struct {
+	Id         string `json:"id"`
+	TemplateId string `json:"template_id"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// WebhookManager listens for generated asset status changes and sends notifications to the callback urls of source assets.
+type WebhookManager struct {
+	sourceAssetStorageManager    common.SourceAssetStorageManager
+	generatedAssetStorageManager common.GeneratedAssetStorageManager
+	secret                       string
+	callbackHosts                []string
+	maxAttempts                  int
+	backoff                      time.Duration
+	assetEvents                  bool
+	client                       *http.Client
+	listener                     RenderStatusChannel
+	deliveries                   []WebhookDelivery
+
+	stop chan (chan bool)
+	quit chan bool
+	mu   sync.Mutex
+}
+
+// NewWebhookManager creates and starts a new webhook manager. Notifications are signed with secret and are only sent to callback urls that are valid for callbackHosts. Failed deliveries are retried up to maxAttempts times, waiting twice as long as the previous attempt, starting with backoff. When assetEvents is true, a notification is also sent for each generated asset.
+func NewWebhookManager(
+	sourceAssetStorageManager common.SourceAssetStorageManager,
+	generatedAssetStorageManager common.GeneratedAssetStorageManager,
+	secret string,
+	callbackHosts []string,
+	maxAttempts int,
+	backoff, timeout time.Duration,
+	assetEvents bool) *WebhookManager {
+
+	webhookManager := new(WebhookManager)
+	webhookManager.sourceAssetStorageManager = sourceAssetStorageManager
+	webhookManager.generatedAssetStorageManager = generatedAssetStorageManager
+	webhookManager.secret = secret
+	webhookManager.callbackHosts = callbackHosts
+	webhookManager.maxAttempts = maxAttempts
+	webhookManager.backoff = backoff
+	webhookManager.assetEvents = assetEvents
+	webhookManager.client = newWebhookClient(callbackHosts, timeout)
+	webhookManager.listener = make(RenderStatusChannel, 100)
+	webhookManager.deliveries = make([]WebhookDelivery, 0, 0)
+	webhookManager.stop = make(chan (chan bool))
+	webhookManager.quit = make(chan bool)
+
+	go webhookManager.run()
+
+	return webhookManager
+}
+
+// Listener returns the channel that should be added as a listener to the render agent manager.
+func (webhookManager *WebhookManager) Listener() RenderStatusChannel {
+	return webhookManager.listener
+}
+
+// Deliveries returns the most recent delivery attempts.
+func (webhookManager *WebhookManager) Deliveries() []WebhookDelivery {
+	webhookManager.mu.Lock()
+	defer webhookManager.mu.Unlock()
+
+	results := make([]WebhookDelivery, len(webhookManager.deliveries))
+	copy(results, webhookManager.deliveries)
+	return results
+}
+
+func (webhookManager *WebhookManager) Stop() {
+	callback := make(chan bool)
+	webhookManager.stop <- callback
+	select {
+	case <-callback:
+	case <-time.After(5 * time.Second):
+	}
+	close(webhookManager.stop)
+	close(webhookManager.quit)
+}
+
+func (webhookManager *WebhookManager) run() {
+	for {
+		select {
+		case ch, ok := <-webhookManager.stop:
+			{
+				if !ok {
+					return
+				}
+				ch <- true
+				return
+			}
+		case renderStatus, ok := <-webhookManager.listener:
+			{
+				if !ok {
+					return
+				}
+				webhookManager.handleStatus(renderStatus)
+			}
+		}
+	}
+}
+
+func (webhookManager *WebhookManager) handleStatus(renderStatus RenderStatus) {
+	if renderStatus.Status != common.GeneratedAssetStatusComplete && !strings.HasPrefix(renderStatus.Status, common.GeneratedAssetStatusFailed) {
+		return
+	}
+
+	sourceAsset, err := webhookManager.originSourceAsset(renderStatus.SourceAssetId)
+	if err != nil {
+		return
+	}
+	callbackUrl, err := common.GetFirstAttribute(sourceAsset, common.SourceAssetAttributeCallbackUrl)
+	if err != nil || len(callbackUrl) == 0 {
+		return
+	}
+
+	generatedAssets, err := webhookManager.generatedAssetStorageManager.FindBySourceAssetId(renderStatus.SourceAssetId)
+	if err != nil {
+		log.Println("Error finding generated assets for webhook", renderStatus.SourceAssetId, err)
+		return
+	}
+
+	if webhookManager.assetEvents {
+		for _, generatedAsset := range generatedAssets {
+			if generatedAsset.Id == renderStatus.GeneratedAssetId {
+				notification := webhookManager.newNotification(WebhookEventGeneratedAsset, renderStatus.SourceAssetId, []*common.GeneratedAsset{generatedAsset})
+				go webhookManager.deliver(callbackUrl, notification)
+			}
+		}
+	}
+
+	if common.IsGeneratedAssetsFinal(generatedAssets) && webhookManager.markNotified(renderStatus.SourceAssetId, generatedAssets) {
+		notification := webhookManager.newNotification(WebhookEventSourceAsset, renderStatus.SourceAssetId, generatedAssets)
+		go webhookManager.deliver(callbackUrl, notification)
+	}
+}
+
+func (webhookManager *WebhookManager) originSourceAsset(sourceAssetId string) (*common.SourceAsset, error) {
+	sourceAssets, err := webhookManager.sourceAssetStorageManager.FindBySourceAssetId(sourceAssetId)
+	if err != nil {
+		return nil, err
+	}
+	for _, sourceAsset := range sourceAssets {
+		if sourceAsset.IdType == common.SourceAssetTypeOrigin {
+			return sourceAsset, nil
+		}
+	}
+	return nil, common.ErrorNoSourceAssetsFoundForId
+}
+
+// markNotified returns true if a source asset notification has not already been sent for the current state of the generated assets, and records it on the source asset. When several generated assets are completed at the same time, each of them can see every generated asset in a final state.
+func (webhookManager *WebhookManager) markNotified(sourceAssetId string, generatedAssets []*common.GeneratedAsset) bool {
+	webhookManager.mu.Lock()
+	defer webhookManager.mu.Unlock()
+
+	// NKG: The notification is identified by the time that the generated
+	// assets were last updated, so that requeued generated assets are
+	// notified again when they are completed.
+	var updatedAt int64
+	for _, generatedAsset := range generatedAssets {
+		if generatedAsset.UpdatedAt > updatedAt {
+			updatedAt = generatedAsset.UpdatedAt
+		}
+	}
+	completion := strconv.FormatInt(updatedAt, 10)
+
+	sourceAsset, err := webhookManager.originSourceAsset(sourceAssetId)
+	if err != nil {
+		return false
+	}
+	notified := sourceAsset.GetAttribute(common.SourceAssetAttributeNotified)
+	for _, value := range notified {
+		if value == completion {
+			return false
+		}
+	}
+	sourceAsset.SetAttribute(common.SourceAssetAttributeNotified, append(notified, completion))
+	err = webhookManager.sourceAssetStorageManager.Store(sourceAsset)
+	if err != nil {
+		log.Println("Error recording webhook notification", sourceAssetId, err)
+		return false
+	}
+	return true
+}
+
+func (webhookManager *WebhookManager) newNotification(event, sourceAssetId string, generatedAssets []*common.GeneratedAsset) webhookNotification {
+	notification := webhookNotification{event, sourceAssetId, common.GeneratedAssetsState(generatedAssets), make([]webhookGeneratedAsset, 0, 0)}
+	for _, generatedAsset := range generatedAssets {
+		element := webhookGeneratedAsset{Id: generatedAsset.Id, TemplateId: generatedAsset.TemplateId, Status: generatedAsset.Status}
+		if codedError, hasCodedError := common.ParseGeneratedAssetError(generatedAsset.Status); hasCodedError {
+			element.Status = common.GeneratedAssetStatusFailed
+			element.Error = codedError.Error()
+		}
+		notification.GeneratedAssets = append(notification.GeneratedAssets, element)
+	}
+	return notification
+}
+
+func (webhookManager *WebhookManager) deliver(callbackUrl string, notification webhookNotification) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		log.Println("Error encoding webhook notification", err)
+		return
+	}
+
+	wait := webhookManager.backoff
+	for attempt := 1; attempt <= webhookManager.maxAttempts; attempt++ {
+		delivery := webhookManager.post(callbackUrl, notification, body)
+		delivery.Attempt = attempt
+		webhookManager.record(delivery)
+		if delivery.Delivered {
+			return
+		}
+		select {
+		case <-webhookManager.quit:
+			return
+		case <-time.After(wait):
+		}
+		wait = wait * 2
+	}
+	log.Println("Giving up on webhook notification", notification.Event, "for", notification.FileId, "to", callbackUrl)
+}
+
+func (webhookManager *WebhookManager) post(callbackUrl string, notification webhookNotification, body []byte) WebhookDelivery {
+	delivery := WebhookDelivery{Url: callbackUrl, Event: notification.Event, FileId: notification.FileId, Timestamp: time.Now().UnixNano()}
+
+	// NKG: The callback hosts may have changed since the request was
+	// accepted.
+	if err := ValidateCallbackUrl(callbackUrl, webhookManager.callbackHosts); err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	req, err := http.NewRequest("POST", callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, notification.Event)
+	req.Header.Set(WebhookSignatureHeader, util.ComputeHmac256(string(body), webhookManager.secret))
+
+	resp, err := webhookManager.client.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	resp.Body.Close()
+
+	delivery.StatusCode = resp.StatusCode
+	delivery.Delivered = resp.StatusCode >= 200 && resp.StatusCode < 300
+	return delivery
+}
+
+func (webhookManager *WebhookManager) record(delivery WebhookDelivery) {
+	webhookManager.mu.Lock()
+	defer webhookManager.mu.Unlock()
+
+	webhookManager.deliveries = append(webhookManager.deliveries, delivery)
+	if len(webhookManager.deliveries) > maxWebhookDeliveries {
+		webhookManager.deliveries = webhookManager.deliveries[len(webhookManager.deliveries)-maxWebhookDeliveries:]
+	}
+}
+
+// ValidateCallbackUrl returns an error if the callback url doesn't use the http or https scheme, or if its host can't be used. When callbackHosts is not empty, the host must be one of them. Otherwise, the host can't be a loopback or private address.
+func ValidateCallbackUrl(callbackUrl string, callbackHosts []string) error {
+	parsedUrl, err := url.Parse(callbackUrl)
+	if err != nil {
+		return common.ErrorInvalidCallbackUrl
+	}
+	if parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https" {
+		return common.ErrorInvalidCallbackUrl
+	}
+	host := strings.ToLower(callbackHost(parsedUrl))
+	if len(host) == 0 {
+		return common.ErrorInvalidCallbackUrl
+	}
+	if len(callbackHosts) > 0 {
+		if isCallbackHostAllowed(host, callbackHosts) {
+			return nil
+		}
+		return common.ErrorInvalidCallbackUrl
+	}
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return common.ErrorInvalidCallbackUrl
+	}
+	if ip := net.ParseIP(host); ip != nil && isPrivateAddress(ip) {
+		return common.ErrorInvalidCallbackUrl
+	}
+	return nil
+}
+
+// newWebhookClient returns the client that sends notifications. When no callback hosts are configured, the client refuses to connect to loopback and private addresses. The address is checked when each connection is made, after the host has been resolved, so that a host can't resolve to a public address when it is checked and a private address when it is used. Redirects are not followed.
+func newWebhookClient(callbackHosts []string, timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if len(callbackHosts) == 0 {
+		dialer.Control = func(network, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivateAddress(ip) {
+				return common.ErrorInvalidCallbackUrl
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout}
+	return &http.Client{Timeout: timeout, Transport: transport, CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return common.ErrorInvalidCallbackUrl
+	}}
+}
+
+func callbackHost(parsedUrl *url.URL) string {
+	host := parsedUrl.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+func isCallbackHostAllowed(host string, callbackHosts []string) bool {
+	for _, callbackHost := range callbackHosts {
+		if strings.ToLower(callbackHost) == host {
+			return true
+		}
+	}
+	return false
+}
+
+func isPrivateAddress(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return ip.IsMulticast()
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
@@ -0,0 +1,227 @@
+package render
+
+import (
+	"encoding/json"
+	"github.com/ngerakines/preview/common"
+	"github.com/ngerakines/preview/util"
+	"github.com/ngerakines/testutils"
+	"github.com/rcrowley/go-metrics"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookManager(t *testing.T) {
+	dm := testutils.NewDirectoryManager()
+	defer dm.Close()
+
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		requests <- req
+		bodies <- body
+		res.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+	rm := NewRenderAgentManager(metrics.NewRegistry(), sasm, gasm, tm, common.NewTemporaryFileManager(), common.NewLocalUploader(dm.Path), true)
+	defer rm.Stop()
+
+	wm := NewWebhookManager(sasm, gasm, "foo", []string{"127.0.0.1"}, 3, time.Millisecond, time.Second, false)
+	defer wm.Stop()
+	rm.AddListener(wm.Listener())
+
+	// NKG: No routes support the "xyz" file type, so every generated asset
+	// fails when it is created.
+	callbackUrl := []common.Attribute{common.Attribute{Key: common.SourceAssetAttributeCallbackUrl, Value: []string{server.URL}}}
+	rm.CreateWork("4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", "file:///tmp/4AE594A7-A48E-45E4-A5E1-4533E50BBDA3", "xyz", 1024, callbackUrl)
+
+	select {
+	case req := <-requests:
+		body := <-bodies
+		if req.Header.Get(WebhookEventHeader) != WebhookEventSourceAsset {
+			t.Error("Unexpected event", req.Header.Get(WebhookEventHeader))
+		}
+		if req.Header.Get(WebhookSignatureHeader) != util.ComputeHmac256(string(body), "foo") {
+			t.Error("Invalid signature", req.Header.Get(WebhookSignatureHeader))
+		}
+		var notification webhookNotification
+		err := json.Unmarshal(body, &notification)
+		if err != nil {
+			t.Error("Unexpected error decoding notification", err)
+			return
+		}
+		if notification.FileId != "4AE594A7-A48E-45E4-A5E1-4533E50BBDA3" || notification.State != common.GeneratedAssetsStateFailed || len(notification.GeneratedAssets) != len(common.LegacyDefaultTemplates) {
+			t.Error("Unexpected notification", notification)
+		}
+		if notification.GeneratedAssets[0].Error != common.ErrorNoRenderersSupportFileType.Error() {
+			t.Error("Unexpected generated asset error", notification.GeneratedAssets[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("No notification was sent")
+		return
+	}
+
+	select {
+	case <-requests:
+		t.Error("Only one source asset notification should be sent")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	deliveries := wm.Deliveries()
+	if len(deliveries) != 1 || !deliveries[0].Delivered || deliveries[0].StatusCode != 204 {
+		t.Error("Unexpected deliveries", deliveries)
+	}
+}
+
+func TestWebhookManagerRetry(t *testing.T) {
+	attempts := make(chan bool, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		attempts <- true
+		res.WriteHeader(500)
+	}))
+	defer server.Close()
+
+	tm := common.NewTemplateManager()
+	wm := NewWebhookManager(common.NewSourceAssetStorageManager(), common.NewGeneratedAssetStorageManager(tm), "foo", []string{"127.0.0.1"}, 3, time.Millisecond, time.Second, false)
+	defer wm.Stop()
+
+	wm.deliver(server.URL, webhookNotification{WebhookEventSourceAsset, "4AE594A7", common.GeneratedAssetsStateComplete, []webhookGeneratedAsset{}})
+	if len(attempts) != 3 {
+		t.Error("Expected 3 attempts, got", len(attempts))
+	}
+	deliveries := wm.Deliveries()
+	if len(deliveries) != 3 || deliveries[2].Attempt != 3 || deliveries[2].Delivered {
+		t.Error("Unexpected deliveries", deliveries)
+	}
+}
+
+func TestWebhookManagerNotified(t *testing.T) {
+	tm := common.NewTemplateManager()
+	sasm := common.NewSourceAssetStorageManager()
+	gasm := common.NewGeneratedAssetStorageManager(tm)
+
+	sourceAsset, err := common.NewSourceAsset("4AE594A7", common.SourceAssetTypeOrigin)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	sasm.Store(sourceAsset)
+	generatedAsset, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, common.DefaultTemplateSmall, "local:///4AE594A7/small")
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	generatedAsset.Status = common.GeneratedAssetStatusComplete
+	gasm.Store(generatedAsset)
+
+	wm := NewWebhookManager(sasm, gasm, "foo", []string{"127.0.0.1"}, 1, time.Millisecond, time.Second, false)
+	defer wm.Stop()
+
+	if !wm.markNotified("4AE594A7", []*common.GeneratedAsset{generatedAsset}) {
+		t.Error("The first notification should be sent")
+	}
+	if !sourceAsset.HasAttribute(common.SourceAssetAttributeNotified) {
+		t.Error("The notification should be recorded on the source asset")
+	}
+
+	// NKG: Another node, or this node after a restart, sees the recorded
+	// notification.
+	other := NewWebhookManager(sasm, gasm, "foo", []string{"127.0.0.1"}, 1, time.Millisecond, time.Second, false)
+	defer other.Stop()
+	if other.markNotified("4AE594A7", []*common.GeneratedAsset{generatedAsset}) {
+		t.Error("The notification should only be sent once")
+	}
+
+	// NKG: A requeued generated asset is notified again once it completes.
+	generatedAsset.UpdatedAt = generatedAsset.UpdatedAt + 1
+	if !other.markNotified("4AE594A7", []*common.GeneratedAsset{generatedAsset}) {
+		t.Error("The notification should be sent after the generated asset is completed again")
+	}
+}
+
+func TestWebhookManagerPrivateAddress(t *testing.T) {
+	attempts := make(chan bool, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		attempts <- true
+		res.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	tm := common.NewTemplateManager()
+	wm := NewWebhookManager(common.NewSourceAssetStorageManager(), common.NewGeneratedAssetStorageManager(tm), "foo", []string{}, 1, time.Millisecond, time.Second, false)
+	defer wm.Stop()
+
+	wm.deliver(server.URL, webhookNotification{WebhookEventSourceAsset, "4AE594A7", common.GeneratedAssetsStateComplete, []webhookGeneratedAsset{}})
+	if len(attempts) != 0 {
+		t.Error("Notifications should not be sent to loopback addresses")
+	}
+	deliveries := wm.Deliveries()
+	if len(deliveries) != 1 || deliveries[0].Delivered || deliveries[0].Error != common.ErrorInvalidCallbackUrl.Error() {
+		t.Error("Unexpected deliveries", deliveries)
+	}
+}
+
+func TestWebhookClient(t *testing.T) {
+	attempts := make(chan bool, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		attempts <- true
+		res.WriteHeader(204)
+	}))
+	defer server.Close()
+
+	// NKG: The address is checked when the client connects, so a url that
+	// passed validation can't be used to reach a private address.
+	_, err := newWebhookClient([]string{}, time.Second).Post(server.URL, "application/json", nil)
+	if err == nil || len(attempts) != 0 {
+		t.Error("Client should not connect to loopback addresses", err)
+	}
+
+	resp, err := newWebhookClient([]string{"127.0.0.1"}, time.Second).Post(server.URL, "application/json", nil)
+	if err != nil {
+		t.Errorf("Unexpected error returned: %s", err)
+		return
+	}
+	resp.Body.Close()
+	if len(attempts) != 1 {
+		t.Error("Client should connect to allowed callback hosts")
+	}
+}
+
+func TestValidateCallbackUrl(t *testing.T) {
+	valid := map[string][]string{
+		"http://example.com/callback":       []string{},
+		"https://93.184.216.34:8443/":       []string{},
+		"http://localhost:9090/callback":    []string{"localhost"},
+		"https://HOOKS.example.com/preview": []string{"hooks.example.com"},
+	}
+	for callbackUrl, callbackHosts := range valid {
+		if err := ValidateCallbackUrl(callbackUrl, callbackHosts); err != nil {
+			t.Error("Expected callback url to be valid", callbackUrl, callbackHosts, err)
+		}
+	}
+
+	invalid := map[string][]string{
+		"ftp://example.com/callback":     []string{},
+		"file:///etc/passwd":             []string{},
+		"http://localhost:9090/callback": []string{},
+		"http://127.0.0.1/callback":      []string{},
+		"http://10.1.2.3/callback":       []string{},
+		"http://169.254.169.254/latest":  []string{},
+		"http://[::1]:8080/callback":     []string{},
+		"http://[fd00::1]/callback":      []string{},
+		"http://example.com/callback":    []string{"hooks.example.com"},
+		"http:///callback":               []string{},
+	}
+	for callbackUrl, callbackHosts := range invalid {
+		if err := ValidateCallbackUrl(callbackUrl, callbackHosts); err != common.ErrorInvalidCallbackUrl {
+			t.Error("Expected callback url to be invalid", callbackUrl, callbackHosts, err)
+		}
+	}
+}
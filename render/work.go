@@ -18,14 +18,31 @@ type RenderAgentManager struct {
 	uploader                     common.Uploader
 	workStatus                   RenderStatusChannel
 	workChannels                 map[string]RenderAgentWorkChannel
+	priorityChannels             map[string]RenderAgentWorkChannel
 	renderAgents                 map[string][]RenderAgent
 	activeWork                   map[string][]string
 	maxWork                      map[string]int
 	enabledRenderAgents          map[string]bool
 	renderAgentCount             map[string]int
+	routingTable                 *RoutingTable
+	statusListeners              []RenderStatusChannel
+	retryPolicy                  *RetryPolicy
+	retention                    map[string]time.Duration
+	fileTypeDownloader           common.Downloader
+	fileTypeTimeout              time.Duration
+	waiters                      map[string][]chan string
 
 	documentMetrics    *documentRenderAgentMetrics
 	imageMagickMetrics *imageMagickRenderAgentMetrics
+	nativeMetrics      *nativeRenderAgentMetrics
+	videoMetrics       *videoRenderAgentMetrics
+	textMetrics        *textRenderAgentMetrics
+	svgMetrics         *svgRenderAgentMetrics
+	archiveMetrics     *archiveRenderAgentMetrics
+	audioMetrics       *audioRenderAgentMetrics
+
+	reaper  *reaper
+	sweeper *sweeper
 
 	stop chan (chan bool)
 	mu   sync.Mutex
@@ -52,14 +69,28 @@ func NewRenderAgentManager(
 	for _, renderAgent := range common.RenderAgents {
 		agentManager.workChannels[renderAgent] = make(RenderAgentWorkChannel, 200)
 	}
+	agentManager.priorityChannels = make(map[string]RenderAgentWorkChannel)
+	for _, renderAgent := range common.RenderAgents {
+		agentManager.priorityChannels[renderAgent] = make(RenderAgentWorkChannel, 20)
+	}
 	agentManager.renderAgents = make(map[string][]RenderAgent)
 	agentManager.activeWork = make(map[string][]string)
 	agentManager.maxWork = make(map[string]int)
 	agentManager.enabledRenderAgents = make(map[string]bool)
 	agentManager.renderAgentCount = make(map[string]int)
+	agentManager.routingTable = NewRoutingTable()
+	agentManager.statusListeners = make([]RenderStatusChannel, 0, 0)
+	agentManager.retention = make(map[string]time.Duration)
+	agentManager.waiters = make(map[string][]chan string)
 
 	agentManager.documentMetrics = newDocumentRenderAgentMetrics(registry)
 	agentManager.imageMagickMetrics = newImageMagickRenderAgentMetrics(registry)
+	agentManager.nativeMetrics = newNativeRenderAgentMetrics(registry)
+	agentManager.videoMetrics = newVideoRenderAgentMetrics(registry)
+	agentManager.textMetrics = newTextRenderAgentMetrics(registry)
+	agentManager.svgMetrics = newSvgRenderAgentMetrics(registry)
+	agentManager.archiveMetrics = newArchiveRenderAgentMetrics(registry)
+	agentManager.audioMetrics = newAudioRenderAgentMetrics(registry)
 
 	agentManager.stop = make(chan (chan bool))
 	if workDispatcherEnabled {
@@ -98,30 +129,66 @@ func (agentManager *RenderAgentManager) getRenderAgentCount(name string) int {
 	return 0
 }
 
-func (agentManager *RenderAgentManager) CreateWork(sourceAssetId, url, fileType string, size int64) {
+// AddRoute adds a route used to determine which templates are used when creating work.
+func (agentManager *RenderAgentManager) AddRoute(route *Route) {
+	agentManager.routingTable.AddRoute(route)
+}
+
+// Routes returns the routes used to determine which templates are used when creating work.
+func (agentManager *RenderAgentManager) Routes() []*Route {
+	return agentManager.routingTable.Routes()
+}
+
+// CreateWork creates a source asset and the generated assets for it. The given attributes are added to the source asset. When the attributes don't include an expires attribute, one is added using the retention of the file type. When file type detection is enabled, the file is routed using the file type detected from its contents and both the declared and detected file types are recorded.
+func (agentManager *RenderAgentManager) CreateWork(sourceAssetId, url, fileType string, size int64, attributes []common.Attribute) {
 	sourceAsset, err := common.NewSourceAsset(sourceAssetId, common.SourceAssetTypeOrigin)
 	if err != nil {
 		return
 	}
+	sourceAsset.AddAttribute(common.SourceAssetAttributeDeclaredType, []string{fileType})
+
+	route, hasRoute, fileType := agentManager.matchRoute(fileType)
+	// NKG: Files that are too large for the route of their declared file
+	// type fail without being read.
+	tooLarge := hasRoute && route.MaxSize > 0 && size > route.MaxSize
+	if agentManager.fileTypeDownloader != nil && !tooLarge {
+		detectedType, err := agentManager.detectFileType(url, common.SourceAssetSource(sourceAsset))
+		if err == nil {
+			sourceAsset.AddAttribute(common.SourceAssetAttributeDetectedType, []string{detectedType})
+			// NKG: Zip files are containers for many file types, so a
+			// declared type is only replaced by a more specific one.
+			if detectedType != "zip" && !common.IsSameFileType(detectedType, fileType) {
+				log.Println("Source asset", sourceAssetId, "declared as", fileType, "was detected as", detectedType)
+				route, hasRoute, fileType = agentManager.matchRoute(detectedType)
+			}
+		}
+	}
+
 	sourceAsset.AddAttribute(common.SourceAssetAttributeSize, []string{strconv.FormatInt(size, 10)})
 	sourceAsset.AddAttribute(common.SourceAssetAttributeSource, []string{url})
 	sourceAsset.AddAttribute(common.SourceAssetAttributeType, []string{fileType})
+	for _, attribute := range attributes {
+		sourceAsset.AddAttribute(attribute.Key, attribute.Value)
+	}
+	if !sourceAsset.HasAttribute(common.SourceAssetAttributeExpires) {
+		if expires, hasExpires := agentManager.expires(fileType); hasExpires {
+			sourceAsset.AddAttribute(common.SourceAssetAttributeExpires, []string{expires})
+		}
+	}
 
 	agentManager.sourceAssetStorageManager.Store(sourceAsset)
 
-	templates, status, err := agentManager.whichRenderAgent(fileType)
+	templates, status, err := agentManager.whichRenderAgent(route, hasRoute, fileType, size)
 	if err != nil {
 		log.Println("error determining which render agent to use", err)
 		return
 	}
 
+	// NKG: Templates that produce intermediate assets, like the document
+	// conversion template, don't have a placeholder size.
 	placeholderSizes := make(map[string]string)
 	for _, template := range templates {
-		placeholderSize, err := common.GetFirstAttribute(template, common.TemplateAttributePlaceholderSize)
-		if err != nil {
-			log.Println("error getting placeholder size from template", err)
-			return
-		}
+		placeholderSize, _ := common.GetFirstAttribute(template, common.TemplateAttributePlaceholderSize)
 		placeholderSizes[template.Id] = placeholderSize
 	}
 
@@ -131,14 +198,22 @@ func (agentManager *RenderAgentManager) CreateWork(sourceAssetId, url, fileType
 
 		ga, err := common.NewGeneratedAssetFromSourceAsset(sourceAsset, template, location)
 		if err == nil {
-			status, dispatchFunc := agentManager.canDispatch(ga.Id, status, template)
-			if status != ga.Status {
-				ga.Status = status
-			}
-			if dispatchFunc != nil {
-				defer dispatchFunc()
+			ga.Status = status
+			if status == common.GeneratedAssetStatusWaiting {
+				status, dispatchFunc := agentManager.canDispatch(ga.Id, status, template)
+				if status != ga.Status {
+					ga.Status = status
+				}
+				if dispatchFunc != nil {
+					defer dispatchFunc()
+				}
 			}
 			agentManager.generatedAssetStorageManager.Store(ga)
+			if strings.HasPrefix(ga.Status, common.GeneratedAssetStatusFailed) {
+				// NKG: Generated assets that fail when they are created are
+				// never seen by a render agent, so listeners are told here.
+				defer agentManager.notifyListeners(RenderStatus{ga.Id, ga.SourceAssetId, ga.Status, template.Renderer})
+			}
 		} else {
 			log.Println("error creating generated asset from source asset", err)
 			return
@@ -146,6 +221,17 @@ func (agentManager *RenderAgentManager) CreateWork(sourceAssetId, url, fileType
 	}
 }
 
+// matchRoute returns the route for a file type or MIME type along with the file type used for the source asset.
+func (agentManager *RenderAgentManager) matchRoute(fileType string) (*Route, bool, string) {
+	route, hasRoute := agentManager.routingTable.Match(fileType)
+	if hasRoute && len(route.FileTypes) > 0 && !route.matchesFileType(fileType) {
+		// NKG: The route was matched using a MIME type, so the file type
+		// of the route is used for the source asset.
+		fileType = route.FileTypes[0]
+	}
+	return route, hasRoute, fileType
+}
+
 func (agentManager *RenderAgentManager) CreateDerivedWork(sourceAsset *common.SourceAsset, derivedSourceAsset *common.SourceAsset, templates []*common.Template, pages int) error {
 
 	placeholderSizes := make(map[string]string)
@@ -178,20 +264,46 @@ func (agentManager *RenderAgentManager) CreateDerivedWork(sourceAsset *common.So
 	return nil
 }
 
-func (agentManager *RenderAgentManager) whichRenderAgent(fileType string) ([]*common.Template, string, error) {
-	var templateIds []string
-	if fileType == "doc" || fileType == "docx" || fileType == "pptx" {
-		templateIds = []string{common.DocumentConversionTemplateId}
-	} else {
-		templateIds = common.LegacyDefaultTemplates
+// whichRenderAgent returns the templates and initial status of the generated assets created for a file. Files that no route supports, or that are too large for the matching route, are given a failed status so that clients are told immediately.
+func (agentManager *RenderAgentManager) whichRenderAgent(route *Route, hasRoute bool, fileType string, size int64) ([]*common.Template, string, error) {
+	if !hasRoute {
+		templates, err := agentManager.templateManager.FindByIds(common.LegacyDefaultTemplates)
+		if err != nil {
+			return nil, common.GeneratedAssetStatusFailed, err
+		}
+		return templates, common.NewGeneratedAssetError(common.ErrorNoRenderersSupportFileType), nil
 	}
-	templates, err := agentManager.templateManager.FindByIds(templateIds)
+
+	templates, err := agentManager.routeTemplates(route, fileType)
 	if err != nil {
 		return nil, common.GeneratedAssetStatusFailed, err
 	}
+	if route.MaxSize > 0 && size > route.MaxSize {
+		return templates, common.NewGeneratedAssetError(common.ErrorFileTooLarge), nil
+	}
 	return templates, common.DefaultGeneratedAssetStatus, nil
 }
 
+func (agentManager *RenderAgentManager) routeTemplates(route *Route, fileType string) ([]*common.Template, error) {
+	if len(route.TemplateIds) > 0 {
+		return agentManager.templateManager.FindByIds(route.TemplateIds)
+	}
+	templates, err := common.FindTemplatesForFileType(agentManager.templateManager, fileType)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*common.Template, 0, 0)
+	for _, template := range templates {
+		if template.Renderer == route.Renderer {
+			results = append(results, template)
+		}
+	}
+	if len(results) > 0 {
+		return results, nil
+	}
+	return agentManager.templateManager.FindByRenderService(route.Renderer)
+}
+
 func (agentManager *RenderAgentManager) canDispatch(generatedAssetId, status string, template *common.Template) (string, func()) {
 	agentManager.mu.Lock()
 	defer agentManager.mu.Unlock()
@@ -224,7 +336,9 @@ func (agentManager *RenderAgentManager) canDispatch(generatedAssetId, status str
 	}
 }
 
+// AddListener adds a listener that is sent the status of generated assets as they are completed or failed, both by render agents and by the render agent manager itself.
 func (agentManager *RenderAgentManager) AddListener(listener RenderStatusChannel) {
+	agentManager.statusListeners = append(agentManager.statusListeners, listener)
 	for _, renderAgents := range agentManager.renderAgents {
 		for _, renderAgent := range renderAgents {
 			renderAgent.AddStatusListener(listener)
@@ -232,7 +346,19 @@ func (agentManager *RenderAgentManager) AddListener(listener RenderStatusChannel
 	}
 }
 
+func (agentManager *RenderAgentManager) notifyListeners(renderStatus RenderStatus) {
+	for _, listener := range agentManager.statusListeners {
+		listener <- renderStatus
+	}
+}
+
 func (agentManager *RenderAgentManager) Stop() {
+	if agentManager.reaper != nil {
+		agentManager.reaper.Stop()
+	}
+	if agentManager.sweeper != nil {
+		agentManager.sweeper.Stop()
+	}
 	for _, renderAgents := range agentManager.renderAgents {
 		for _, renderAgent := range renderAgents {
 			renderAgent.Stop()
@@ -241,6 +367,9 @@ func (agentManager *RenderAgentManager) Stop() {
 	for _, workChannel := range agentManager.workChannels {
 		close(workChannel)
 	}
+	for _, priorityChannel := range agentManager.priorityChannels {
+		close(priorityChannel)
+	}
 
 	callback := make(chan bool)
 	agentManager.stop <- callback
@@ -251,20 +380,67 @@ func (agentManager *RenderAgentManager) Stop() {
 	close(agentManager.stop)
 }
 
-func (agentManager *RenderAgentManager) AddImageMagickRenderAgent(downloader common.Downloader, uploader common.Uploader, maxWorkIncrease int) RenderAgent {
-	renderAgent := newImageMagickRenderAgent(agentManager.imageMagickMetrics, agentManager.sourceAssetStorageManager, agentManager.generatedAssetStorageManager, agentManager.templateManager, agentManager.temporaryFileManager, downloader, uploader, agentManager.workChannels[common.RenderAgentImageMagick])
+// SetRetryPolicy sets the policy used by render agents to retry generated assets that fail. It must be called before render agents are added.
+func (agentManager *RenderAgentManager) SetRetryPolicy(retryPolicy *RetryPolicy) {
+	agentManager.retryPolicy = retryPolicy
+}
+
+func (agentManager *RenderAgentManager) AddImageMagickRenderAgent(downloader common.Downloader, uploader common.Uploader, limits CommandLimits, maxWorkIncrease int) RenderAgent {
+	renderAgent := newImageMagickRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentImageMagick, downloader, uploader), agentManager.imageMagickMetrics, limits)
 	renderAgent.AddStatusListener(agentManager.workStatus)
 	agentManager.AddRenderAgent(common.RenderAgentImageMagick, renderAgent, maxWorkIncrease)
 	return renderAgent
 }
 
-func (agentManager *RenderAgentManager) AddDocumentRenderAgent(downloader common.Downloader, uploader common.Uploader, docCachePath string, maxWorkIncrease int) RenderAgent {
-	renderAgent := newDocumentRenderAgent(agentManager.documentMetrics, agentManager, agentManager.sourceAssetStorageManager, agentManager.generatedAssetStorageManager, agentManager.templateManager, agentManager.temporaryFileManager, downloader, uploader, docCachePath, agentManager.workChannels[common.RenderAgentDocument])
+func (agentManager *RenderAgentManager) AddDocumentRenderAgent(downloader common.Downloader, uploader common.Uploader, docCachePath string, pageLimits map[string]int, limits CommandLimits, maxWorkIncrease int) RenderAgent {
+	renderAgent := newDocumentRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentDocument, downloader, uploader), agentManager.documentMetrics, agentManager, docCachePath, pageLimits, limits)
 	renderAgent.AddStatusListener(agentManager.workStatus)
 	agentManager.AddRenderAgent(common.RenderAgentDocument, renderAgent, maxWorkIncrease)
 	return renderAgent
 }
 
+func (agentManager *RenderAgentManager) AddNativeRenderAgent(downloader common.Downloader, uploader common.Uploader, maxImagePixels, maxWorkIncrease int) RenderAgent {
+	renderAgent := newNativeRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentNative, downloader, uploader), agentManager.nativeMetrics, maxImagePixels)
+	renderAgent.AddStatusListener(agentManager.workStatus)
+	agentManager.AddRenderAgent(common.RenderAgentNative, renderAgent, maxWorkIncrease)
+	return renderAgent
+}
+
+func (agentManager *RenderAgentManager) AddVideoRenderAgent(downloader common.Downloader, uploader common.Uploader, ffmpegPath, ffprobePath string, limits CommandLimits, maxWorkIncrease int) RenderAgent {
+	renderAgent := newVideoRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentVideo, downloader, uploader), agentManager.videoMetrics, ffmpegPath, ffprobePath, limits)
+	renderAgent.AddStatusListener(agentManager.workStatus)
+	agentManager.AddRenderAgent(common.RenderAgentVideo, renderAgent, maxWorkIncrease)
+	return renderAgent
+}
+
+func (agentManager *RenderAgentManager) AddTextRenderAgent(downloader common.Downloader, uploader common.Uploader, maxWorkIncrease int) RenderAgent {
+	renderAgent := newTextRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentText, downloader, uploader), agentManager.textMetrics)
+	renderAgent.AddStatusListener(agentManager.workStatus)
+	agentManager.AddRenderAgent(common.RenderAgentText, renderAgent, maxWorkIncrease)
+	return renderAgent
+}
+
+func (agentManager *RenderAgentManager) AddSvgRenderAgent(downloader common.Downloader, uploader common.Uploader, rsvgConvertPath string, limits CommandLimits, maxWorkIncrease int) RenderAgent {
+	renderAgent := newSvgRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentSvg, downloader, uploader), agentManager.svgMetrics, rsvgConvertPath, limits)
+	renderAgent.AddStatusListener(agentManager.workStatus)
+	agentManager.AddRenderAgent(common.RenderAgentSvg, renderAgent, maxWorkIncrease)
+	return renderAgent
+}
+
+func (agentManager *RenderAgentManager) AddArchiveRenderAgent(downloader common.Downloader, uploader common.Uploader, imagePreviews bool, maxImagePixels, maxWorkIncrease int) RenderAgent {
+	renderAgent := newArchiveRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentArchive, downloader, uploader), agentManager.archiveMetrics, imagePreviews, maxImagePixels)
+	renderAgent.AddStatusListener(agentManager.workStatus)
+	agentManager.AddRenderAgent(common.RenderAgentArchive, renderAgent, maxWorkIncrease)
+	return renderAgent
+}
+
+func (agentManager *RenderAgentManager) AddAudioRenderAgent(downloader common.Downloader, uploader common.Uploader, ffmpegPath, ffprobePath string, limits CommandLimits, maxWorkIncrease int) RenderAgent {
+	renderAgent := newAudioRenderAgent(agentManager.newBaseRenderAgent(common.RenderAgentAudio, downloader, uploader), agentManager.audioMetrics, ffmpegPath, ffprobePath, limits)
+	renderAgent.AddStatusListener(agentManager.workStatus)
+	agentManager.AddRenderAgent(common.RenderAgentAudio, renderAgent, maxWorkIncrease)
+	return renderAgent
+}
+
 func (agentManager *RenderAgentManager) AddRenderAgent(name string, renderAgent RenderAgent, maxWorkIncrease int) {
 	agentManager.mu.Lock()
 	defer agentManager.mu.Unlock()
@@ -347,12 +523,28 @@ func (agentManager *RenderAgentManager) handleStatus(renderStatus RenderStatus)
 	agentManager.mu.Lock()
 	defer agentManager.mu.Unlock()
 
-	if renderStatus.Status == common.GeneratedAssetStatusComplete || strings.HasPrefix(renderStatus.Status, common.GeneratedAssetStatusFailed) {
+	// NKG: Generated assets that are going to be retried are moved back to
+	// the waiting state and are no longer active work.
+	if renderStatus.Status == common.GeneratedAssetStatusComplete || renderStatus.Status == common.GeneratedAssetStatusWaiting || strings.HasPrefix(renderStatus.Status, common.GeneratedAssetStatusFailed) {
 		activeWork, hasActiveWork := agentManager.activeWork[renderStatus.Service]
 		if hasActiveWork {
 			agentManager.activeWork[renderStatus.Service] = listWithout(activeWork, renderStatus.GeneratedAssetId)
 		}
 	}
+	if renderStatus.Status == common.GeneratedAssetStatusComplete || strings.HasPrefix(renderStatus.Status, common.GeneratedAssetStatusFailed) {
+		agentManager.notifyWaiters(renderStatus)
+	}
+}
+
+func (agentManager *RenderAgentManager) isActiveWork(generatedAssetId string) bool {
+	for _, activeWork := range agentManager.activeWork {
+		for _, id := range activeWork {
+			if id == generatedAssetId {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (agentManager *RenderAgentManager) workToDispatchCount(name string) int {
@@ -390,3 +582,12 @@ func uniqueListWith(values []string, value string) []string {
 	}
 	return append(values, value)
 }
+
+func listContains(values []string, value string) bool {
+	for _, listValue := range values {
+		if listValue == value {
+			return true
+		}
+	}
+	return false
+}